@@ -4,10 +4,23 @@ import (
 	"database/sql"
 	"github.com/pkg/errors"
 	"github.com/svetlyi/gdriveapp/contracts"
+	"time"
 )
 
 const NextChangeToken = "next_change_token"
 
+// LastSyncTime is the setting with the time (RFC 3339) the files were synchronized last time
+const LastSyncTime = "last_sync_time"
+
+// GetNextChangeTokenKey returns the setting with the change token of "My Drive"
+// (driveId is empty) or of a shared drive
+func GetNextChangeTokenKey(driveId string) string {
+	if "" == driveId {
+		return NextChangeToken
+	}
+	return NextChangeToken + ":" + driveId
+}
+
 // Store is a storage for application settings,
 // that is stored in db
 type Store struct {
@@ -20,17 +33,15 @@ func New(db *sql.DB, log contracts.Logger) Store {
 }
 
 func (fr Store) createSetting(setting string, value string) error {
-	fr.log.Debug("creating setting", struct {
-		setting string
-		value   string
-	}{setting, value})
+	fr.log.With(contracts.Fields{"setting": setting, "value": value}).Debug("creating setting")
 	query := `
 	INSERT INTO 
 	app_state(
 		'setting',
-		'value'
+		'value',
+		'updated'
 	)
-	VALUES (?,?)
+	VALUES (?,?,?)
 	`
 	insertStmt, err := fr.db.Prepare(query)
 	if err == nil {
@@ -38,6 +49,7 @@ func (fr Store) createSetting(setting string, value string) error {
 		_, err = insertStmt.Exec(
 			setting,
 			value,
+			time.Now(),
 		)
 		return err
 	}
@@ -62,11 +74,19 @@ func (fr *Store) Get(setting string) (string, error) {
 	return token, nil
 }
 
+// GetUpdated returns the time the setting was set last time. The time is zero
+// for the settings set before the time was saved
+func (fr *Store) GetUpdated(setting string) (time.Time, error) {
+	var updated sql.NullTime
+	err := fr.db.QueryRow(`SELECT app_state.updated FROM app_state WHERE app_state.setting = ? LIMIT 1`, setting).Scan(&updated)
+	if err != nil {
+		return time.Time{}, errors.Wrap(err, "could not scan update time of setting "+setting)
+	}
+	return updated.Time, nil
+}
+
 func (fr *Store) Set(setting string, value string) error {
-	fr.log.Debug("updating setting", struct {
-		setting string
-		value   string
-	}{setting, value})
+	fr.log.With(contracts.Fields{"setting": setting, "value": value}).Debug("updating setting")
 	// check if the setting exists. If it does, we create it, otherwise, update it
 	_, err := fr.Get(setting)
 	if errors.Cause(err) == sql.ErrNoRows {
@@ -75,12 +95,17 @@ func (fr *Store) Set(setting string, value string) error {
 		return err
 	}
 
-	query := `UPDATE app_state SET 'value' = ? WHERE app_state.setting = ?`
+	query := `UPDATE app_state SET 'value' = ?, 'updated' = ? WHERE app_state.setting = ?`
 	updateStmt, err := fr.db.Prepare(query)
 	if err == nil {
 		defer updateStmt.Close()
-		_, err = updateStmt.Exec(value, setting)
+		_, err = updateStmt.Exec(value, time.Now(), setting)
 	}
 
 	return err
 }
+
+func (fr *Store) Delete(setting string) error {
+	_, err := fr.db.Exec(`DELETE FROM app_state WHERE app_state.setting = ?`, setting)
+	return errors.Wrapf(err, "could not delete setting %s", setting)
+}
@@ -10,6 +10,7 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 )
 
 var appName = "svetlyi_gdriveapp_test"
@@ -34,6 +35,9 @@ func TestSet(t *testing.T) {
 	if actualToken != expectedToken {
 		t.Error("actual and expected tokens aren't equal")
 	}
+	if updated, err := store.GetUpdated(NextChangeToken); nil != err || time.Since(updated) > time.Minute {
+		t.Error("wrong update time of NextChangeToken", updated, err)
+	}
 }
 
 func setup() (error, *sql.DB, contracts.Logger) {
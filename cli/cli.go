@@ -0,0 +1,226 @@
+// Package cli parses the command line and runs the commands of the application.
+// Each command has its own flags, the ones before the command are common for all of them:
+//
+//	gdriveapp [-profile name] [command] [flags] [arguments]
+//
+// Without a command the files are synchronized in both directions.
+package cli
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/svetlyi/gdriveapp/config"
+	"github.com/svetlyi/gdriveapp/logger"
+	"github.com/svetlyi/gdriveapp/runner"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Version is the version of the application. It is set, when the application is built:
+//
+//	go build -ldflags "-X github.com/svetlyi/gdriveapp/cli.Version=1.0.0"
+var Version = "dev"
+
+// The exit codes of the application
+const (
+	ExitOk    = 0
+	ExitError = 1
+	// ExitUsage means wrong arguments or flags
+	ExitUsage = 2
+	// ExitConfig means the configuration is missing or invalid
+	ExitConfig = 3
+	// ExitAuth means the application could not get access to Google Drive
+	ExitAuth = 4
+)
+
+const defaultCommand = "sync"
+
+type command struct {
+	name        string
+	args        string
+	description string
+	run         func(c *cli, name string, args []string) int
+}
+
+// commands are filled in init, as the commands themselves print the usage from here
+var commands []command
+
+func init() {
+	commands = []command{
+		{"init", "[-drive-path path]", "create the configuration of the profile", runInit},
+		{"auth", "[-force] [-manual | -device] | status | logout | rekey", "authorize the access to Google Drive, show the authorization, revoke it or change the passphrase of the token", runAuth},
+		{"sync", syncArgs, "synchronize the files in both directions (the default command)", runSync},
+		{"pull", syncArgs, "apply just the remote changes to the local files", runSync},
+		{"push", syncArgs, "apply just the local changes to the remote files", runSync},
+		{"daemon", "", "synchronize the files and keep them synchronized", runDaemon},
+		{"status", "[-fetch]", "print the last sync time and the changes the next synchronization would apply", runStatus},
+		{"reset-db", "[-yes]", "remove the database, so that the next synchronization starts from scratch", runResetDb},
+		{"drives", "", "list the shared drives, that can be added to shared_drives in the config", runDrives},
+		{"trash", "list | restore <path|id> | empty", "list, restore or delete for good the files in the remote trash", runTrash},
+		{"restore", "<path>", "put the file removed locally because of a remote change back from the recycle bin", runRestore},
+		{"profiles", "list | add <name> [-drive-path path] | remove <name>", "manage the profiles, for example, for other accounts", runProfiles},
+		{"version", "", "print the version of the application and of the database schema", runVersion},
+	}
+}
+
+func findCommand(name string) (command, bool) {
+	for _, cmd := range commands {
+		if name == cmd.name {
+			return cmd, true
+		}
+	}
+	return command{}, false
+}
+
+// cli is the state of a command being run
+type cli struct {
+	// stdin is buffered once, so that the answers to several questions are not lost
+	stdin *bufio.Reader
+	// terminal is stdin, if it is a terminal, so that the echo of the secrets can be turned off
+	terminal *os.File
+	stdout   io.Writer
+	stderr   io.Writer
+	cfg      config.Cfg
+	log      logger.Logger
+	// verbose means the log is printed to stdout along with the log file
+	verbose bool
+	runner  *runner.Runner
+}
+
+// Run runs the command with the arguments (without the name of the application)
+// and returns the exit code
+func Run(args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) int {
+	c := &cli{stdin: bufio.NewReader(stdin), stdout: stdout, stderr: stderr}
+	if f, ok := stdin.(*os.File); ok {
+		if stat, err := f.Stat(); nil == err && 0 != stat.Mode()&os.ModeCharDevice {
+			c.terminal = f
+		}
+	}
+	fs := flag.NewFlagSet("gdriveapp", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() { printUsage(stderr, fs) }
+	profile := fs.String("profile", config.DefaultProfile, "name of the profile with its own config, token, database and drive path")
+	if err := fs.Parse(args); nil != err {
+		return exitCodeOfParseErr(err)
+	}
+	if err := config.SetProfile(*profile); nil != err {
+		fmt.Fprintln(stderr, err)
+		return ExitUsage
+	}
+	name := defaultCommand
+	args = fs.Args()
+	if len(args) > 0 {
+		name, args = args[0], args[1:]
+	}
+	cmd, ok := findCommand(name)
+	if !ok {
+		fmt.Fprintf(stderr, "unknown command %q\n", name)
+		fs.Usage()
+		return ExitUsage
+	}
+	defer func() {
+		if nil != c.runner {
+			if err := c.runner.Close(); nil != err {
+				c.log.Error(err)
+			}
+		}
+	}()
+	return cmd.run(c, name, args)
+}
+
+// newFlagSet creates the flag set of the command
+func (c *cli) newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(c.stderr)
+	fs.Usage = func() {
+		cmd, _ := findCommand(name)
+		fmt.Fprintf(c.stderr, "Usage: gdriveapp [-profile name] %s\n", strings.TrimSpace(name+" "+cmd.args))
+		fmt.Fprintf(c.stderr, "  %s\n", cmd.description)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// load reads the configuration and creates the logger and the runner
+func (c *cli) load(verbose bool) int {
+	initialized, err := config.IsInitialized()
+	if nil != err {
+		fmt.Fprintln(c.stderr, "could not read config", err)
+		return ExitConfig
+	}
+	if !initialized {
+		fmt.Fprintf(c.stderr, "profile %s is not initialized, run \"gdriveapp -profile %[1]s init\" first\n", config.GetProfile())
+		return ExitConfig
+	}
+	if c.cfg, err = config.Read(); nil != err {
+		fmt.Fprintln(c.stderr, "could not read config", err)
+		return ExitConfig
+	}
+	c.verbose = verbose
+	if c.log, err = logger.NewFromConfig(config.GetAppName(), c.cfg, verbose); nil != err {
+		fmt.Fprintln(c.stderr, "could not create logger", err)
+		return ExitError
+	}
+	c.log.Info("directory to store \"My Drive\"", c.cfg.DrivePath)
+	c.runner = runner.New(c.cfg, c.log)
+	c.runner.SetPrompt(c.readSecret)
+	return ExitOk
+}
+
+// readSecret asks for a secret, for example, a passphrase. The echo is turned off, if stdin is a terminal
+func (c *cli) readSecret(prompt string) ([]byte, error) {
+	fmt.Fprint(c.stderr, prompt)
+	if nil != c.terminal {
+		if err := c.stty("-echo"); nil == err {
+			defer func() {
+				c.stty("echo")
+				fmt.Fprintln(c.stderr)
+			}()
+		}
+	}
+	secret, err := c.stdin.ReadString('\n')
+	if nil != err && (io.EOF != err || "" == secret) {
+		return nil, errors.Wrap(err, "could not read secret")
+	}
+	return []byte(strings.TrimRight(secret, "\r\n")), nil
+}
+
+func (c *cli) stty(arg string) error {
+	cmd := exec.Command("stty", arg)
+	cmd.Stdin = c.terminal
+	return cmd.Run()
+}
+
+// fail logs the error and returns the exit code for it
+func (c *cli) fail(msg string, err error) int {
+	c.log.Error(msg, err)
+	if !c.verbose {
+		fmt.Fprintf(c.stderr, "%s: %v\n", msg, err)
+	}
+	if _, ok := errors.Cause(err).(runner.AuthError); ok {
+		return ExitAuth
+	}
+	return ExitError
+}
+
+func exitCodeOfParseErr(err error) int {
+	if flag.ErrHelp == err {
+		return ExitOk
+	}
+	return ExitUsage
+}
+
+func printUsage(w io.Writer, fs *flag.FlagSet) {
+	fmt.Fprintln(w, "Usage: gdriveapp [-profile name] [command] [flags] [arguments]")
+	fs.PrintDefaults()
+	fmt.Fprintln(w, "Commands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %s\n", strings.TrimSpace(cmd.name+" "+cmd.args))
+		fmt.Fprintf(w, "      %s\n", cmd.description)
+	}
+	fmt.Fprintln(w, "Run \"gdriveapp <command> -h\" for the flags of the command.")
+}
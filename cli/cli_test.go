@@ -0,0 +1,51 @@
+package cli
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "gdriveapp-cli-")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	defer os.Setenv("XDG_CONFIG_HOME", os.Getenv("XDG_CONFIG_HOME"))
+	os.Setenv("XDG_CONFIG_HOME", tmp)
+	drivePath := filepath.Join(tmp, "drive") + string(os.PathSeparator)
+
+	cases := []struct {
+		args     []string
+		code     int
+		inStdout string
+	}{
+		{[]string{"unknown"}, ExitUsage, ""},
+		{[]string{"sync", "-plan-format", "xml"}, ExitUsage, ""},
+		{[]string{"-profile", "../work", "status"}, ExitUsage, ""},
+		{[]string{"trash", "restore"}, ExitUsage, ""},
+		{[]string{"auth", "-manual", "-device"}, ExitUsage, ""},
+		{[]string{"version"}, ExitOk, "gdriveapp " + Version},
+		{[]string{"status"}, ExitConfig, ""},
+		{[]string{"sync", "-dry-run"}, ExitConfig, ""},
+		{[]string{"init", "-drive-path", "relative/"}, ExitConfig, ""},
+		{[]string{"init", "-drive-path", drivePath}, ExitOk, "initialized"},
+		{[]string{"init"}, ExitOk, "already initialized"},
+		{[]string{"status"}, ExitOk, drivePath},
+		{[]string{"reset-db", "-yes"}, ExitOk, ""},
+	}
+	for _, c := range cases {
+		var stdout, stderr bytes.Buffer
+		code := Run(c.args, strings.NewReader(""), &stdout, &stderr)
+		if c.code != code {
+			t.Errorf("%v: expected exit code %d, got %d: %s", c.args, c.code, code, stderr.String())
+		}
+		if !strings.Contains(stdout.String(), c.inStdout) {
+			t.Errorf("%v: expected %q in output, got %q", c.args, c.inStdout, stdout.String())
+		}
+	}
+}
@@ -0,0 +1,332 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"github.com/svetlyi/gdriveapp/config"
+	"github.com/svetlyi/gdriveapp/contracts"
+	"github.com/svetlyi/gdriveapp/rdrive/auth"
+	"github.com/svetlyi/gdriveapp/rdrive/db/migration"
+	"github.com/svetlyi/gdriveapp/runner"
+	"github.com/svetlyi/gdriveapp/synchronization"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+)
+
+const syncArgs = "[-dry-run] [-plan-format text|json]"
+
+// sides are the sides the files are changed on by the synchronization commands
+var sides = map[string]contracts.ActionSide{
+	"sync": "",
+	"pull": contracts.SIDE_LOCAL,
+	"push": contracts.SIDE_REMOTE,
+}
+
+func runInit(c *cli, name string, args []string) int {
+	fs := c.newFlagSet(name)
+	drivePath := fs.String("drive-path", "", "absolute path of the folder to store \"My Drive\" in, it is asked for if empty")
+	if err := fs.Parse(args); nil != err {
+		return exitCodeOfParseErr(err)
+	}
+	if 0 != fs.NArg() {
+		fs.Usage()
+		return ExitUsage
+	}
+	if initialized, err := config.IsInitialized(); nil != err {
+		fmt.Fprintln(c.stderr, "could not read config", err)
+		return ExitConfig
+	} else if initialized {
+		fmt.Fprintf(c.stdout, "profile %s is already initialized\n", config.GetProfile())
+		return ExitOk
+	}
+	if err := config.InitCfg(*drivePath); nil != err {
+		fmt.Fprintln(c.stderr, "could not initialize configuration", err)
+		return ExitConfig
+	}
+	dir, err := config.GetDir()
+	if nil != err {
+		fmt.Fprintln(c.stderr, "could not get config dir", err)
+		return ExitConfig
+	}
+	fmt.Fprintf(c.stdout, "profile %s is initialized in %s\n", config.GetProfile(), dir)
+	return ExitOk
+}
+
+func runAuth(c *cli, name string, args []string) int {
+	if len(args) > 0 && ("status" == args[0] || "logout" == args[0] || "rekey" == args[0]) {
+		return runAuthCommand(c, name, args)
+	}
+	fs := c.newFlagSet(name)
+	force := fs.Bool("force", false, "authorize again, even if there is a token already")
+	manual := fs.Bool("manual", false, "authorize in a browser on another device and paste the address it is redirected to")
+	device := fs.Bool("device", false, "authorize with a code entered on another device instead of a browser")
+	if err := fs.Parse(args); nil != err {
+		return exitCodeOfParseErr(err)
+	}
+	if 0 != fs.NArg() || (*manual && *device) {
+		fs.Usage()
+		return ExitUsage
+	}
+	if code := c.load(false); ExitOk != code {
+		return code
+	}
+	switch {
+	case *manual:
+		c.runner.SetAuthFlow(auth.FlowManual)
+	case *device:
+		c.runner.SetAuthFlow(auth.FlowDevice)
+	}
+	if err := c.runner.Authorize(*force); nil != err {
+		return c.fail("could not authorize", err)
+	}
+	if err := c.runner.PrintAccount(c.stdout); nil != err {
+		return c.fail("could not authorize", err)
+	}
+	return ExitOk
+}
+
+// runAuthCommand runs "auth status", "auth logout" and "auth rekey"
+func runAuthCommand(c *cli, name string, args []string) int {
+	if code := c.parseNoFlags(name, args, 1); ExitOk != code {
+		return code
+	}
+	if code := c.load(false); ExitOk != code {
+		return code
+	}
+	var err error
+	switch args[0] {
+	case "status":
+		err = c.runner.PrintAuthStatus(c.stdout)
+	case "logout":
+		err = c.runner.Logout(c.stdout)
+	default:
+		err = c.runner.Rekey()
+	}
+	if nil != err {
+		return c.fail("auth "+args[0]+" error", err)
+	}
+	return ExitOk
+}
+
+func runSync(c *cli, name string, args []string) int {
+	fs := c.newFlagSet(name)
+	dryRun := fs.Bool("dry-run", false, "print the actions of the synchronization without performing them")
+	planFormat := fs.String("plan-format", synchronization.PlanFormatText, "format of the dry-run plan: text or json")
+	if err := fs.Parse(args); nil != err {
+		return exitCodeOfParseErr(err)
+	}
+	isPlanFormatValid := synchronization.PlanFormatText == *planFormat || synchronization.PlanFormatJson == *planFormat
+	if 0 != fs.NArg() || !isPlanFormatValid {
+		fs.Usage()
+		return ExitUsage
+	}
+	// the plan goes to stdout, so the logs are just written to the log file
+	if code := c.load(!*dryRun); ExitOk != code {
+		return code
+	}
+	opts := runner.SyncOptions{Side: sides[name]}
+	if *dryRun {
+		opts.Plan = &synchronization.Plan{}
+	}
+	if err := c.runner.Sync(opts); nil != err {
+		return c.fail("synchronization error", err)
+	}
+	if *dryRun {
+		if err := opts.Plan.Print(c.stdout, *planFormat); nil != err {
+			return c.fail("could not print plan", err)
+		}
+	}
+	return ExitOk
+}
+
+func runDaemon(c *cli, name string, args []string) int {
+	if code := c.parseNoFlags(name, args, 0); ExitOk != code {
+		return code
+	}
+	if code := c.load(true); ExitOk != code {
+		return code
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-signals
+		cancel()
+	}()
+	if err := c.runner.Daemon(ctx); nil != err {
+		return c.fail("daemon error", err)
+	}
+	return ExitOk
+}
+
+func runStatus(c *cli, name string, args []string) int {
+	fs := c.newFlagSet(name)
+	fetch := fs.Bool("fetch", false, "get the remote changes first, without saving them")
+	if err := fs.Parse(args); nil != err {
+		return exitCodeOfParseErr(err)
+	}
+	if 0 != fs.NArg() {
+		fs.Usage()
+		return ExitUsage
+	}
+	if code := c.load(false); ExitOk != code {
+		return code
+	}
+	if err := c.runner.PrintStatus(c.stdout, *fetch); nil != err {
+		return c.fail("could not print status", err)
+	}
+	return ExitOk
+}
+
+func runResetDb(c *cli, name string, args []string) int {
+	fs := c.newFlagSet(name)
+	yes := fs.Bool("yes", false, "do not ask for confirmation")
+	if err := fs.Parse(args); nil != err {
+		return exitCodeOfParseErr(err)
+	}
+	if 0 != fs.NArg() {
+		fs.Usage()
+		return ExitUsage
+	}
+	if code := c.load(false); ExitOk != code {
+		return code
+	}
+	if !*yes {
+		fmt.Fprintf(c.stdout, "Remove database %s? The next synchronization compares all the files again [y/N]: ", c.cfg.DBPath)
+		answer, _ := c.stdin.ReadString('\n')
+		if "y" != strings.ToLower(strings.TrimSpace(answer)) {
+			fmt.Fprintln(c.stderr, "cancelled")
+			return ExitError
+		}
+	}
+	if err := c.runner.ResetDb(); nil != err {
+		return c.fail("could not reset database", err)
+	}
+	return ExitOk
+}
+
+func runDrives(c *cli, name string, args []string) int {
+	if code := c.parseNoFlags(name, args, 0); ExitOk != code {
+		return code
+	}
+	if code := c.load(false); ExitOk != code {
+		return code
+	}
+	if err := c.runner.PrintSharedDrives(c.stdout); nil != err {
+		return c.fail("could not print shared drives", err)
+	}
+	return ExitOk
+}
+
+func runTrash(c *cli, name string, args []string) int {
+	isArgsValid := (1 == len(args) && ("list" == args[0] || "empty" == args[0])) ||
+		(2 == len(args) && "restore" == args[0])
+	if code := c.parseNoFlags(name, args, len(args)); ExitOk != code {
+		return code
+	} else if !isArgsValid {
+		c.usage(name)
+		return ExitUsage
+	}
+	if code := c.load("list" != args[0]); ExitOk != code {
+		return code
+	}
+	var err error
+	switch args[0] {
+	case "list":
+		err = c.runner.PrintTrash(c.stdout)
+	case "restore":
+		err = c.runner.RestoreFromTrash(args[1])
+	default:
+		err = c.runner.EmptyTrash()
+	}
+	if nil != err {
+		return c.fail("trash error", err)
+	}
+	return ExitOk
+}
+
+func runRestore(c *cli, name string, args []string) int {
+	if code := c.parseNoFlags(name, args, 1); ExitOk != code {
+		return code
+	}
+	if code := c.load(true); ExitOk != code {
+		return code
+	}
+	if err := c.runner.RestoreFromRecycleBin(args[0]); nil != err {
+		return c.fail("could not restore from recycle bin", err)
+	}
+	return ExitOk
+}
+
+func runProfiles(c *cli, name string, args []string) int {
+	fs := c.newFlagSet(name)
+	drivePath := fs.String("drive-path", "", "absolute path of the folder to store \"My Drive\" of the new profile in, it is asked for if empty")
+	isArgsValid := (1 == len(args) && "list" == args[0]) ||
+		(len(args) >= 2 && "add" == args[0]) ||
+		(2 == len(args) && "remove" == args[0])
+	if isArgsValid && "add" == args[0] {
+		// the flags of "add" go after the name of the profile
+		if err := fs.Parse(args[2:]); nil != err {
+			return exitCodeOfParseErr(err)
+		}
+		isArgsValid = 0 == fs.NArg()
+	}
+	if !isArgsValid {
+		fs.Usage()
+		return ExitUsage
+	}
+	var err error
+	switch args[0] {
+	case "list":
+		var profiles []config.Profile
+		if profiles, err = config.ListProfiles(); nil != err {
+			break
+		}
+		tw := tabwriter.NewWriter(c.stdout, 0, 4, 2, ' ', 0)
+		for _, p := range profiles {
+			fmt.Fprintf(tw, "%s\t%s\n", p.Name, p.DrivePath)
+		}
+		err = tw.Flush()
+	case "add":
+		err = config.AddProfile(args[1], *drivePath)
+	default:
+		err = config.RemoveProfile(args[1])
+	}
+	if nil != err {
+		fmt.Fprintln(c.stderr, err)
+		return ExitConfig
+	}
+	return ExitOk
+}
+
+func runVersion(c *cli, name string, args []string) int {
+	if code := c.parseNoFlags(name, args, 0); ExitOk != code {
+		return code
+	}
+	fmt.Fprintf(c.stdout, "gdriveapp %s\n", Version)
+	fmt.Fprintf(c.stdout, "database schema version %d\n", migration.LatestVersion())
+	return ExitOk
+}
+
+// parseNoFlags parses the arguments of a command without flags, just to print
+// the usage for -h, and checks the number of the arguments
+func (c *cli) parseNoFlags(name string, args []string, nArgs int) int {
+	fs := c.newFlagSet(name)
+	if err := fs.Parse(args); nil != err {
+		return exitCodeOfParseErr(err)
+	}
+	if nArgs != fs.NArg() {
+		fs.Usage()
+		return ExitUsage
+	}
+	return ExitOk
+}
+
+// usage prints the usage of the command
+func (c *cli) usage(name string) {
+	c.newFlagSet(name).Usage()
+}
@@ -0,0 +1,97 @@
+package config
+
+import (
+	"github.com/pkg/errors"
+	"strings"
+	"time"
+)
+
+const timeOfDayFormat = "15:04"
+
+// BandwidthPeriod is a period of the day with its own upload and download limits
+type BandwidthPeriod struct {
+	// From and To are the local time of the day, for example, "09:00". The period
+	// may go over midnight: from "22:00" to "06:00"
+	From string `json:"from"`
+	To   string `json:"to"`
+	// Days are the days of the week the period is for: "mon", "tue" and so on. Empty means every day
+	Days          []string `json:"days"`
+	UploadLimit   int64    `json:"upload_limit"`
+	DownloadLimit int64    `json:"download_limit"`
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// GetBandwidthLimits returns the upload and the download limits for the time:
+// the limits of the first period of the schedule the time is in or the default ones
+func (cfg Cfg) GetBandwidthLimits(t time.Time) (upload int64, download int64) {
+	for _, period := range cfg.BandwidthSchedule {
+		if period.contains(t) {
+			return period.UploadLimit, period.DownloadLimit
+		}
+	}
+	return cfg.UploadLimit, cfg.DownloadLimit
+}
+
+// contains says if the time is in the period. The period must be valid
+func (p BandwidthPeriod) contains(t time.Time) bool {
+	from, _ := time.Parse(timeOfDayFormat, p.From)
+	to, _ := time.Parse(timeOfDayFormat, p.To)
+	minutes := t.Hour()*60 + t.Minute()
+	fromMinutes := from.Hour()*60 + from.Minute()
+	toMinutes := to.Hour()*60 + to.Minute()
+	day := t.Weekday()
+	if toMinutes <= fromMinutes && minutes < toMinutes {
+		// after midnight the period started the day before
+		day = (day + 6) % 7
+	}
+	if !p.isForDay(day) {
+		return false
+	}
+	if fromMinutes < toMinutes {
+		return minutes >= fromMinutes && minutes < toMinutes
+	}
+	return minutes >= fromMinutes || minutes < toMinutes
+}
+
+func (p BandwidthPeriod) isForDay(day time.Weekday) bool {
+	if 0 == len(p.Days) {
+		return true
+	}
+	for _, name := range p.Days {
+		if weekdays[strings.ToLower(name)] == day {
+			return true
+		}
+	}
+	return false
+}
+
+func validateBandwidth(cfg Cfg) error {
+	if cfg.UploadLimit < 0 || cfg.DownloadLimit < 0 {
+		return errors.New("upload and download limits must not be negative")
+	}
+	for _, period := range cfg.BandwidthSchedule {
+		for _, timeOfDay := range []string{period.From, period.To} {
+			if _, err := time.Parse(timeOfDayFormat, timeOfDay); err != nil {
+				return errors.Errorf("wrong time %q in bandwidth schedule, it must be like 09:00", timeOfDay)
+			}
+		}
+		for _, name := range period.Days {
+			if _, ok := weekdays[strings.ToLower(name)]; !ok {
+				return errors.Errorf("wrong day %q in bandwidth schedule, it must be like mon", name)
+			}
+		}
+		if period.UploadLimit < 0 || period.DownloadLimit < 0 {
+			return errors.New("upload and download limits must not be negative")
+		}
+	}
+	return nil
+}
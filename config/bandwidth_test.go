@@ -0,0 +1,46 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGetBandwidthLimits(t *testing.T) {
+	cfg := Cfg{
+		UploadLimit:   100,
+		DownloadLimit: 200,
+		BandwidthSchedule: []BandwidthPeriod{
+			{From: "09:00", To: "18:00", Days: []string{"mon", "tue", "wed", "thu", "fri"}, UploadLimit: 10, DownloadLimit: 20},
+			{From: "22:00", To: "06:00", Days: []string{"Fri"}},
+		},
+	}
+	if err := validateBandwidth(cfg); nil != err {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		time     string
+		upload   int64
+		download int64
+	}{
+		{"2020-06-15 09:00", 10, 20},   // monday business hours
+		{"2020-06-15 18:00", 100, 200}, // the end of the period is not in it
+		{"2020-06-14 12:00", 100, 200}, // sunday
+		{"2020-06-19 23:00", 0, 0},     // friday night
+		{"2020-06-20 05:59", 0, 0},     // the same night after midnight, on saturday
+		{"2020-06-19 05:00", 100, 200}, // the night from thursday
+	}
+	for _, c := range cases {
+		at, err := time.ParseInLocation("2006-01-02 15:04", c.time, time.Local)
+		if nil != err {
+			t.Fatal(err)
+		}
+		if upload, download := cfg.GetBandwidthLimits(at); c.upload != upload || c.download != download {
+			t.Errorf("%s: expected %d and %d, got %d and %d", c.time, c.upload, c.download, upload, download)
+		}
+	}
+
+	cfg.BandwidthSchedule[0].From = "9am"
+	if err := validateBandwidth(cfg); nil == err {
+		t.Error("wrong time must be rejected")
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"github.com/pkg/errors"
 	"github.com/svetlyi/gdriveapp/contracts"
+	"github.com/svetlyi/gdriveapp/rdrive/specification"
 	"io/ioutil"
 	"log"
 	"os"
@@ -17,24 +18,143 @@ type Cfg struct {
 	DrivePath       string `json:"drive_path"`
 	LogFileMaxSize  int64  `json:"log_file_max_size"`
 	LogVerbosity    int64  `json:"log_verbosity"`
+	// LogPath is the log file. Empty means the application name with the .log
+	// extension in the temporary dir of the OS
+	LogPath string `json:"log_path"`
+	// LogMaxFiles is how many compressed old log files are kept after the log file
+	// gets bigger than LogFileMaxSize
+	LogMaxFiles int64 `json:"log_max_files"`
+	// LogFormat is the format of the records: plain text or a JSON object per line
+	LogFormat string `json:"log_format"`
+	// LogOutput is where the records go: the log file, stderr or the systemd journal
+	LogOutput string `json:"log_output"`
+	// TokenEncryption says how token.json is encrypted: not at all, with a passphrase
+	// or with the content of TokenKeyFile
+	TokenEncryption string `json:"token_encryption"`
+	TokenKeyFile    string `json:"token_key_file"`
+	// ConflictPolicy says what to do with a file changed both locally
+	// and remotely since the last synchronization
+	ConflictPolicy string `json:"conflict_policy"`
+	// DaemonPollInterval is how often (in seconds) the daemon asks
+	// the remote drive for changes
+	DaemonPollInterval int64 `json:"daemon_poll_interval"`
+	// DaemonDebounce is how long (in milliseconds) the daemon waits for
+	// local changes to settle before synchronizing them
+	DaemonDebounce int64 `json:"daemon_debounce"`
+	// ExportFormats says which format (file extension) the native Google files
+	// are exported to. The keys are the kinds of the files: document, spreadsheet,
+	// presentation and drawing. The files of a kind with an empty format are skipped
+	ExportFormats map[string]string `json:"export_formats"`
+	// ExportReadOnly makes the exported files read-only, as the local changes
+	// of them cannot be uploaded back
+	ExportReadOnly bool `json:"export_read_only"`
+	// TransferWorkers is how many files are downloaded and uploaded concurrently
+	TransferWorkers int64 `json:"transfer_workers"`
+	// SharedDrives are the ids of the shared drives synchronized along with "My Drive".
+	// Each of them goes to a folder with the name of the drive in DrivePath
+	SharedDrives []string `json:"shared_drives"`
+	// SharedWithMe turns on the synchronization of the files shared with the user
+	// to the "Shared with me" folder in DrivePath
+	SharedWithMe bool `json:"shared_with_me"`
+	// Backend is what the files are synchronized with: Google Drive
+	// or a local directory, for example, a NAS mount
+	Backend string `json:"backend"`
+	// BackendDir is the directory the files are synchronized with, if the backend is a local directory
+	BackendDir string `json:"backend_dir"`
+	// PermanentDelete deletes the remote files removed locally for good
+	// instead of moving them to the trash
+	PermanentDelete bool `json:"permanent_delete"`
+	// RecycleBinMaxAge is how many days the recycle bin keeps the local files removed
+	// because of remote changes. RecycleBinMaxSize is the maximum size of the recycle bin
+	// in bytes: the oldest files are removed first. Zero means no limit
+	RecycleBinMaxAge  int64 `json:"recycle_bin_max_age"`
+	RecycleBinMaxSize int64 `json:"recycle_bin_max_size"`
+	// UploadLimit and DownloadLimit are the maximum rates in bytes per second of all
+	// the uploads and of all the downloads together. Zero means no limit
+	UploadLimit   int64 `json:"upload_limit"`
+	DownloadLimit int64 `json:"download_limit"`
+	// BandwidthSchedule sets other limits for periods of the day, for example, for business hours
+	BandwidthSchedule []BandwidthPeriod `json:"bandwidth_schedule"`
+	// RetryMaxAttempts is how many times a call to Google Drive is made at most, when it fails
+	// because of the rate limits or a temporary server or network error
+	RetryMaxAttempts int64 `json:"retry_max_attempts"`
+	// EncryptedFolders are the folders (relative to DrivePath, like "My Drive/HR"), which files are
+	// encrypted before they are uploaded, so that the remote drive cannot read them
+	EncryptedFolders []string `json:"encrypted_folders"`
+	// EncryptNames encrypts the names of the files and the folders inside EncryptedFolders as well
+	EncryptNames bool `json:"encrypt_names"`
+	// EncryptionKeyFile is the file with the key (at least 32 random bytes) the files are encrypted with
+	EncryptionKeyFile string `json:"encryption_key_file"`
+	// Symlinks says what to do with the local symbolic links: skip them, follow them to
+	// the files and the folders they point to or keep the links themselves remotely
+	Symlinks string `json:"symlinks"`
 }
 
+const (
+	// ConflictKeepBoth keeps the remote version under the original name and
+	// the local one as a conflicted copy next to it
+	ConflictKeepBoth = "keep-both"
+	// ConflictPreferLocal overwrites the remote version with the local one
+	ConflictPreferLocal = "prefer-local"
+	// ConflictPreferRemote overwrites the local version with the remote one
+	ConflictPreferRemote = "prefer-remote"
+	// ConflictNewestWins keeps the version that was modified last
+	ConflictNewestWins = "newest-wins"
+)
+
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+const (
+	LogOutputFile    = "file"
+	LogOutputStderr  = "stderr"
+	LogOutputJournal = "journal"
+)
+
+const (
+	TokenEncryptionNone       = "none"
+	TokenEncryptionPassphrase = "passphrase"
+	TokenEncryptionKeyFile    = "key-file"
+)
+
+const (
+	// BackendGoogle synchronizes the files with Google Drive
+	BackendGoogle = "google"
+	// BackendLocalDir synchronizes the files with a local directory
+	BackendLocalDir = "local-dir"
+)
+
+const (
+	// SymlinksSkip keeps the symbolic links out of synchronization
+	SymlinksSkip = "skip"
+	// SymlinksFollow synchronizes the files the links point to as if they were in place of the links.
+	// The folders are synchronized the same way, if they are inside DrivePath
+	SymlinksFollow = "follow"
+	// SymlinksFollowAll is SymlinksFollow with the folders outside DrivePath synchronized as well
+	SymlinksFollowAll = "follow-all"
+	// SymlinksStore keeps a link remotely as a small file with the target of the link,
+	// which is created as a link again when it is downloaded
+	SymlinksStore = "store"
+)
+
 var appName = "svetlyi_gdriveapp"
-var cfgPath string
 
-func init() {
-	if err := InitCfg(); err != nil {
-		log.Fatal("could not initialize config", err)
-	}
-	if path, err := GetDir(); nil == err {
-		cfgPath = filepath.Join(path, "config.json")
-	} else {
-		log.Fatal("could not get configuration dir", err)
-	}
-}
+const cfgFileName = "config.json"
+const credentialsFileName = "credentials.json"
 
+// Read reads the configuration file. The parameters missing in the file
+// (for example, added in a newer version) get their default values.
 func Read() (Cfg, error) {
-	var cfg Cfg
+	cfg, err := newDefault()
+	if nil != err {
+		return Cfg{}, errors.Wrap(err, "could not get default config")
+	}
+	cfgPath, err := getCfgPath()
+	if nil != err {
+		return Cfg{}, errors.Wrap(err, "could not get config path")
+	}
 	fBytes, err := ioutil.ReadFile(cfgPath)
 	if nil != err {
 		return Cfg{}, errors.Wrapf(err, "could not read config file %s", cfgPath)
@@ -43,15 +163,101 @@ func Read() (Cfg, error) {
 	if nil != err {
 		return Cfg{}, errors.Wrapf(err, "could not parse json in %s", cfgPath)
 	}
+	if err = validate(cfg); nil != err {
+		return Cfg{}, errors.Wrapf(err, "invalid config %s", cfgPath)
+	}
+	if err = checkOverlaps(cfg); nil != err {
+		return Cfg{}, errors.Wrapf(err, "invalid config %s", cfgPath)
+	}
 
 	return cfg, nil
 }
 
+func validate(cfg Cfg) error {
+	switch cfg.ConflictPolicy {
+	case ConflictKeepBoth, ConflictPreferLocal, ConflictPreferRemote, ConflictNewestWins:
+	default:
+		return errors.Errorf("unknown conflict policy %q", cfg.ConflictPolicy)
+	}
+	if cfg.DaemonPollInterval <= 0 || cfg.DaemonDebounce <= 0 {
+		return errors.New("daemon poll interval and debounce must be positive")
+	}
+	if cfg.TransferWorkers <= 0 {
+		return errors.New("transfer workers must be positive")
+	}
+	if cfg.RecycleBinMaxAge < 0 || cfg.RecycleBinMaxSize < 0 {
+		return errors.New("recycle bin max age and max size must not be negative")
+	}
+	if err := validateBandwidth(cfg); err != nil {
+		return err
+	}
+	if cfg.RetryMaxAttempts <= 0 {
+		return errors.New("retry max attempts must be positive")
+	}
+	if err := validateLog(cfg); err != nil {
+		return err
+	}
+	if err := validateEncryption(cfg); err != nil {
+		return err
+	}
+	switch cfg.Symlinks {
+	case SymlinksSkip, SymlinksFollow, SymlinksFollowAll, SymlinksStore:
+	default:
+		return errors.Errorf("unknown symlinks policy %q", cfg.Symlinks)
+	}
+	switch cfg.TokenEncryption {
+	case TokenEncryptionNone, TokenEncryptionPassphrase:
+	case TokenEncryptionKeyFile:
+		if !filepath.IsAbs(cfg.TokenKeyFile) {
+			return errors.Errorf("token key file %q must be an absolute path", cfg.TokenKeyFile)
+		}
+	default:
+		return errors.Errorf("unknown token encryption %q", cfg.TokenEncryption)
+	}
+	switch cfg.Backend {
+	case BackendGoogle:
+	case BackendLocalDir:
+		if !filepath.IsAbs(cfg.BackendDir) {
+			return errors.Errorf("backend dir %q must be an absolute path", cfg.BackendDir)
+		}
+		if len(cfg.SharedDrives) > 0 {
+			return errors.New("shared drives are synchronized just with Google Drive")
+		}
+	default:
+		return errors.Errorf("unknown backend %q", cfg.Backend)
+	}
+	for kind, format := range cfg.ExportFormats {
+		if !specification.IsExportFormatSupported(kind, format) {
+			return errors.Errorf("%s files cannot be exported to %q", kind, format)
+		}
+	}
+	return nil
+}
+
+func validateLog(cfg Cfg) error {
+	if cfg.LogFileMaxSize <= 0 || cfg.LogMaxFiles < 0 {
+		return errors.New("log file max size must be positive and log max files must not be negative")
+	}
+	if LogFormatText != cfg.LogFormat && LogFormatJSON != cfg.LogFormat {
+		return errors.Errorf("unknown log format %q", cfg.LogFormat)
+	}
+	switch cfg.LogOutput {
+	case LogOutputFile, LogOutputStderr, LogOutputJournal:
+	default:
+		return errors.Errorf("unknown log output %q", cfg.LogOutput)
+	}
+	return nil
+}
+
 func Save(cfg Cfg) error {
 	fBytes, err := json.MarshalIndent(cfg, "", "  ")
 	if nil != err {
 		return errors.Wrapf(err, "could not create json for %#v", cfg)
 	}
+	cfgPath, err := getCfgPath()
+	if nil != err {
+		return errors.Wrap(err, "could not get config path")
+	}
 	err = ioutil.WriteFile(cfgPath, fBytes, 0755)
 	if nil != err {
 		return errors.Wrapf(err, "could not write config to %s", cfgPath)
@@ -61,11 +267,32 @@ func Save(cfg Cfg) error {
 
 func newDefault() (Cfg, error) {
 	defaultCfg := Cfg{
-		DBPath:          "",
-		PageSizeToQuery: 300,
-		DrivePath:       "",
-		LogFileMaxSize:  1e7,
-		LogVerbosity:    int64(contracts.LogInfoLevel),
+		DBPath:             "",
+		PageSizeToQuery:    300,
+		DrivePath:          "",
+		LogFileMaxSize:     1e7,
+		LogVerbosity:       int64(contracts.LogInfoLevel),
+		LogMaxFiles:        3,
+		LogFormat:          LogFormatText,
+		LogOutput:          LogOutputFile,
+		TokenEncryption:    TokenEncryptionNone,
+		ConflictPolicy:     ConflictKeepBoth,
+		DaemonPollInterval: 60,
+		DaemonDebounce:     2000,
+		ExportFormats: map[string]string{
+			"document":     "docx",
+			"spreadsheet":  "xlsx",
+			"presentation": "pptx",
+			"drawing":      "pdf",
+		},
+		ExportReadOnly:  true,
+		TransferWorkers: 4,
+		Backend:         BackendGoogle,
+		// a month and a gigabyte
+		RecycleBinMaxAge:  30,
+		RecycleBinMaxSize: 1 << 30,
+		RetryMaxAttempts:  6,
+		Symlinks:          SymlinksFollow,
 	}
 	usr, err := user.Current()
 	if nil != err {
@@ -82,24 +309,24 @@ func newDefault() (Cfg, error) {
 	return defaultCfg, nil
 }
 
+// GetAppName returns the name of the application. For a profile other
+// than the default one the name of the profile is added
 func GetAppName() string {
-	return appName
+	if DefaultProfile == profile {
+		return appName
+	}
+	return appName + "_" + profile
 }
 
 func getCfgPath() (string, error) {
 	if path, err := GetDir(); nil == err {
-		return filepath.Join(path, "config.json"), nil
+		return filepath.Join(path, cfgFileName), nil
 	} else {
 		return "", err
 	}
 }
 
+// GetDir returns the dir with the configuration files of the current profile
 func GetDir() (string, error) {
-	usrConfDir, err := os.UserConfigDir()
-	if nil != err {
-		return "", errors.Wrap(err, "could not get current user's config dir")
-	}
-	dir := filepath.Join(usrConfDir, appName)
-
-	return dir, err
+	return getProfileDir(profile)
 }
@@ -0,0 +1,35 @@
+package config
+
+import (
+	"github.com/pkg/errors"
+	"path/filepath"
+	"strings"
+)
+
+// IsEncrypted says if the file or the folder with the path relative to the drive path
+// is inside one of the encrypted folders. The encrypted folders themselves are not
+func (cfg Cfg) IsEncrypted(relativePath string) bool {
+	relativePath = filepath.Clean(relativePath)
+	for _, folder := range cfg.EncryptedFolders {
+		if strings.HasPrefix(relativePath, filepath.Clean(folder)+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+func validateEncryption(cfg Cfg) error {
+	if 0 == len(cfg.EncryptedFolders) {
+		return nil
+	}
+	if !filepath.IsAbs(cfg.EncryptionKeyFile) {
+		return errors.Errorf("encryption key file %q must be an absolute path", cfg.EncryptionKeyFile)
+	}
+	for _, folder := range cfg.EncryptedFolders {
+		folder = filepath.Clean(folder)
+		if filepath.IsAbs(folder) || "." == folder || ".." == folder || strings.HasPrefix(folder, ".."+string(filepath.Separator)) {
+			return errors.Errorf("encrypted folder %q must be a path inside the drive path, like \"My Drive/HR\"", folder)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package config
+
+import "testing"
+
+func TestIsEncrypted(t *testing.T) {
+	cfg := Cfg{EncryptedFolders: []string{"My Drive/HR", "My Drive/contracts/"}, EncryptionKeyFile: "/etc/gdriveapp.key"}
+	if err := validateEncryption(cfg); nil != err {
+		t.Fatal(err)
+	}
+	cases := map[string]bool{
+		"My Drive/HR/salaries.xlsx":         true,
+		"My Drive/HR/2020/salaries.xlsx":    true,
+		"My Drive/contracts/acme.pdf":       true,
+		"My Drive/HR":                       false,
+		"My Drive/HRM/salaries.xlsx":        false,
+		"My Drive/report.txt":               false,
+		"Shared with me/HR/salaries.xlsx":   false,
+		"My Drive/HR/../report.txt":         false,
+		"My Drive/contracts/drafts/new.pdf": true,
+	}
+	for path, expected := range cases {
+		if encrypted := cfg.IsEncrypted(path); expected != encrypted {
+			t.Errorf("%s: expected %t, got %t", path, expected, encrypted)
+		}
+	}
+
+	for _, folder := range []string{"/home/user/HR", "..", "../HR", "."} {
+		cfg.EncryptedFolders = []string{folder}
+		if err := validateEncryption(cfg); nil == err {
+			t.Errorf("%s: the folder must be rejected", folder)
+		}
+	}
+	cfg.EncryptedFolders, cfg.EncryptionKeyFile = []string{"My Drive/HR"}, ""
+	if err := validateEncryption(cfg); nil == err {
+		t.Error("the key file must be required")
+	}
+}
@@ -11,11 +11,12 @@ import (
 type isParamValid func(param string) bool
 
 // InitCfg creates all the necessary folders and configuration files.
-func InitCfg() error {
+// If drivePath is empty, it is asked for
+func InitCfg(drivePath string) error {
 	if err := createDirIfNotExist(); err != nil {
 		return err
 	}
-	if err := createCfgIfNotExist(); err != nil {
+	if err := createCfgIfNotExist(drivePath); err != nil {
 		return err
 	}
 
@@ -24,22 +25,33 @@ func InitCfg() error {
 
 func createDirIfNotExist() error {
 	dir, err := GetDir()
-	if _, err := os.Stat(dir); os.IsNotExist(err) {
-		err = os.Mkdir(dir, 0700)
+	if nil != err {
+		return err
+	}
+	if _, err = os.Stat(dir); os.IsNotExist(err) {
+		err = os.MkdirAll(dir, 0700)
 	}
 
 	return err
 }
 
-func createCfgIfNotExist() error {
-	if cfgPath, err := getCfgPath(); err != nil {
+// IsInitialized says if the configuration file of the current profile exists
+func IsInitialized() (bool, error) {
+	cfgPath, err := getCfgPath()
+	if err != nil {
+		return false, err
+	}
+	if _, err = os.Stat(cfgPath); os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, errors.Wrapf(err, "could not get stat for %s", cfgPath)
+	}
+	return true, nil
+}
+
+func createCfgIfNotExist(drivePath string) error {
+	if initialized, err := IsInitialized(); err != nil || initialized {
 		return err
-	} else if _, err := os.Stat(cfgPath); err != nil {
-		if !os.IsNotExist(errors.Cause(err)) {
-			return err
-		}
-	} else {
-		return nil
 	}
 
 	cfg, err := newDefault()
@@ -47,13 +59,17 @@ func createCfgIfNotExist() error {
 		return errors.Wrap(err, "could not get default config")
 	}
 
-	drivePath, err := readParam(
-		"Store \"My Drive\" folder in absolute path: ",
-		cfg.DrivePath,
-		isDrivePathValid,
-	)
-	if nil != err {
-		return errors.Wrap(err, "could not read drive path param")
+	if "" == drivePath {
+		drivePath, err = readParam(
+			"Store \"My Drive\" folder in absolute path: ",
+			cfg.DrivePath,
+			isDrivePathValid,
+		)
+		if nil != err {
+			return errors.Wrap(err, "could not read drive path param")
+		}
+	} else if err = checkDrivePath(drivePath); nil != err {
+		return err
 	}
 	cfg.DrivePath = drivePath
 
@@ -64,8 +80,18 @@ func createCfgIfNotExist() error {
 }
 
 func isDrivePathValid(path string) bool {
-	return strings.HasSuffix(path, string(os.PathSeparator)) &&
-		strings.HasPrefix(path, string(os.PathSeparator))
+	if err := checkDrivePath(path); nil != err {
+		fmt.Println(err)
+		return false
+	}
+	return true
+}
+
+func checkDrivePath(path string) error {
+	if !strings.HasSuffix(path, string(os.PathSeparator)) || !strings.HasPrefix(path, string(os.PathSeparator)) {
+		return errors.Errorf("drive path %s must be an absolute path ending with %c", path, os.PathSeparator)
+	}
+	return checkOverlaps(Cfg{DrivePath: path})
 }
 
 // readParam reads a new parameter for configuration. It will ask again if the parameter is not valid.
@@ -81,8 +107,9 @@ func readParam(hint string, def string, valid isParamValid) (string, error) {
 			return "", errors.Wrap(err, "could not read param")
 		}
 		if "" == param {
-			return def, nil
-		} else if valid(param) {
+			param = def
+		}
+		if valid(param) {
 			return param, nil
 		}
 	}
@@ -0,0 +1,176 @@
+package config
+
+import (
+	"encoding/json"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// DefaultProfile is the profile used without --profile. Its files are kept in the config
+// dir itself, as they were before the profiles, the files of the rest are in "profiles/<name>"
+const DefaultProfile = "default"
+
+const profilesDirName = "profiles"
+
+var profileNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// profile is the profile the configuration files are read for
+var profile = DefaultProfile
+
+// Profile is a named configuration with its own config, token, database and drive path,
+// so that several accounts can be synchronized on the same computer
+type Profile struct {
+	Name      string
+	DrivePath string
+}
+
+// SetProfile makes the application work with the files of the profile
+func SetProfile(name string) error {
+	if !profileNameRegexp.MatchString(name) {
+		return errors.Errorf("wrong profile name %q: just letters, digits, \"-\" and \"_\" are allowed", name)
+	}
+	profile = name
+	return nil
+}
+
+// GetProfile returns the name of the current profile
+func GetProfile() string {
+	return profile
+}
+
+// ListProfiles returns the profiles with their drive paths. The profiles without
+// a config file yet (for example, the default one before the first launch) are skipped
+func ListProfiles() ([]Profile, error) {
+	var profiles []Profile
+	names := []string{DefaultProfile}
+	baseDir, err := getBaseDir()
+	if err != nil {
+		return nil, err
+	}
+	infos, err := ioutil.ReadDir(filepath.Join(baseDir, profilesDirName))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, errors.Wrap(err, "could not read profiles dir")
+	}
+	for _, info := range infos {
+		if info.IsDir() && DefaultProfile != info.Name() && profileNameRegexp.MatchString(info.Name()) {
+			names = append(names, info.Name())
+		}
+	}
+	for _, name := range names {
+		dir, err := getProfileDir(name)
+		if err != nil {
+			return nil, err
+		}
+		var profileCfg Cfg
+		fBytes, err := ioutil.ReadFile(filepath.Join(dir, cfgFileName))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, errors.Wrapf(err, "could not read config of profile %s", name)
+		}
+		if err = json.Unmarshal(fBytes, &profileCfg); err != nil {
+			return nil, errors.Wrapf(err, "could not parse config of profile %s", name)
+		}
+		profiles = append(profiles, Profile{Name: name, DrivePath: profileCfg.DrivePath})
+	}
+	return profiles, nil
+}
+
+// AddProfile creates the profile and its configuration. If drivePath is empty, it is asked for
+// the same way as on the first launch. The credentials of the default profile are used,
+// if the profile does not have its own credentials.json
+func AddProfile(name string, drivePath string) error {
+	if err := SetProfile(name); err != nil {
+		return err
+	}
+	cfgPath, err := getCfgPath()
+	if err != nil {
+		return err
+	}
+	if _, err = os.Stat(cfgPath); nil == err {
+		return errors.Errorf("profile %s already exists", name)
+	}
+	return InitCfg(drivePath)
+}
+
+// RemoveProfile removes the config, the token and the database of the profile.
+// The synchronized files in its drive path are kept
+func RemoveProfile(name string) error {
+	if DefaultProfile == name {
+		return errors.New("the default profile cannot be removed")
+	}
+	if !profileNameRegexp.MatchString(name) {
+		return errors.Errorf("wrong profile name %q", name)
+	}
+	dir, err := getProfileDir(name)
+	if err != nil {
+		return err
+	}
+	if _, err = os.Stat(dir); os.IsNotExist(err) {
+		return errors.Errorf("profile %s does not exist", name)
+	}
+	return errors.Wrapf(os.RemoveAll(dir), "could not remove profile %s", name)
+}
+
+// GetCredentialsPath returns the path of credentials.json of the profile
+// or, if the profile does not have it, of the default profile
+func GetCredentialsPath() (string, error) {
+	dir, err := GetDir()
+	if err != nil {
+		return "", err
+	}
+	credsPath := filepath.Join(dir, credentialsFileName)
+	if _, err = os.Stat(credsPath); os.IsNotExist(err) && DefaultProfile != profile {
+		baseDir, err := getBaseDir()
+		if err != nil {
+			return "", err
+		}
+		defaultCredsPath := filepath.Join(baseDir, credentialsFileName)
+		if _, err = os.Stat(defaultCredsPath); nil == err {
+			return defaultCredsPath, nil
+		}
+	}
+	return credsPath, nil
+}
+
+// checkOverlaps makes sure the drive path of the current profile
+// does not overlap the drive path of another profile
+func checkOverlaps(cfg Cfg) error {
+	profiles, err := ListProfiles()
+	if err != nil {
+		return err
+	}
+	for _, p := range profiles {
+		if p.Name != profile && isPathOverlapping(cfg.DrivePath, p.DrivePath) {
+			return errors.Errorf("drive path %s overlaps drive path %s of profile %s", cfg.DrivePath, p.DrivePath, p.Name)
+		}
+	}
+	return nil
+}
+
+// isPathOverlapping says if the paths are the same or one of them is inside the other
+func isPathOverlapping(a string, b string) bool {
+	a = strings.TrimSuffix(filepath.Clean(a), string(os.PathSeparator)) + string(os.PathSeparator)
+	b = strings.TrimSuffix(filepath.Clean(b), string(os.PathSeparator)) + string(os.PathSeparator)
+	return strings.HasPrefix(a, b) || strings.HasPrefix(b, a)
+}
+
+func getProfileDir(name string) (string, error) {
+	baseDir, err := getBaseDir()
+	if err != nil || DefaultProfile == name {
+		return baseDir, err
+	}
+	return filepath.Join(baseDir, profilesDirName, name), nil
+}
+
+func getBaseDir() (string, error) {
+	usrConfDir, err := os.UserConfigDir()
+	if nil != err {
+		return "", errors.Wrap(err, "could not get current user's config dir")
+	}
+	return filepath.Join(usrConfDir, appName), nil
+}
@@ -0,0 +1,92 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIsPathOverlapping(t *testing.T) {
+	cases := []struct {
+		a, b        string
+		overlapping bool
+	}{
+		{"/home/user/", "/home/user/", true},
+		{"/home/user/", "/home/user", true},
+		{"/home/user/", "/home/user/work/", true},
+		{"/home/user/work/", "/home/user/", true},
+		{"/home/user/", "/home/user2/", false},
+		{"/data/personal/", "/data/work/", false},
+		{"/", "/data/work/", true},
+	}
+	for _, c := range cases {
+		if c.overlapping != isPathOverlapping(c.a, c.b) {
+			t.Errorf("%s and %s: expected overlapping %t", c.a, c.b, c.overlapping)
+		}
+	}
+}
+
+func TestProfiles(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "gdriveapp-config-")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	defer os.Setenv("XDG_CONFIG_HOME", os.Getenv("XDG_CONFIG_HOME"))
+	os.Setenv("XDG_CONFIG_HOME", tmp)
+	defer SetProfile(DefaultProfile)
+
+	baseDir := filepath.Join(tmp, appName)
+	files := map[string]string{
+		filepath.Join(baseDir, cfgFileName):                            `{"drive_path": "/data/personal/"}`,
+		filepath.Join(baseDir, credentialsFileName):                    `{}`,
+		filepath.Join(baseDir, profilesDirName, "work", cfgFileName):   `{"drive_path": "/data/work/"}`,
+		filepath.Join(baseDir, profilesDirName, "other", "token.json"): `{}`,
+	}
+	for path, content := range files {
+		if err = os.MkdirAll(filepath.Dir(path), 0700); nil != err {
+			t.Fatal(err)
+		}
+		if err = ioutil.WriteFile(path, []byte(content), 0600); nil != err {
+			t.Fatal(err)
+		}
+	}
+
+	profiles, err := ListProfiles()
+	if nil != err {
+		t.Fatal(err)
+	}
+	if 2 != len(profiles) || DefaultProfile != profiles[0].Name || "work" != profiles[1].Name || "/data/work/" != profiles[1].DrivePath {
+		t.Errorf("expected the default and the work profiles, got %v", profiles)
+	}
+
+	if err = SetProfile("work"); nil != err {
+		t.Fatal(err)
+	}
+	if dir, _ := GetDir(); filepath.Join(baseDir, profilesDirName, "work") != dir {
+		t.Error("wrong dir of the profile", dir)
+	}
+	if credsPath, _ := GetCredentialsPath(); filepath.Join(baseDir, credentialsFileName) != credsPath {
+		t.Error("the credentials of the default profile must be used", credsPath)
+	}
+	if err = checkOverlaps(Cfg{DrivePath: "/data/personal/work/"}); nil == err {
+		t.Error("the drive path inside the drive path of the default profile must be rejected")
+	}
+	if err = checkOverlaps(Cfg{DrivePath: "/data/work/"}); nil != err {
+		t.Error("the drive path of the profile itself is not an overlap", err)
+	}
+
+	if err = SetProfile("../work"); nil == err {
+		t.Error("the profile name must not be a path")
+	}
+	if err = RemoveProfile(DefaultProfile); nil == err {
+		t.Error("the default profile must not be removed")
+	}
+	if err = RemoveProfile("work"); nil != err {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(filepath.Join(baseDir, profilesDirName, "work")); !os.IsNotExist(err) {
+		t.Error("the profile dir must be removed")
+	}
+}
@@ -0,0 +1,51 @@
+package contracts
+
+type ActionType string
+
+const (
+	ACTION_DOWNLOAD      ActionType = "download"
+	ACTION_UPLOAD        ActionType = "upload"
+	ACTION_MKDIR         ActionType = "mkdir"
+	ACTION_MOVE          ActionType = "move"
+	ACTION_DELETE_LOCAL  ActionType = "delete local"
+	ACTION_DELETE_REMOTE ActionType = "delete remote"
+	ACTION_CONFLICT      ActionType = "conflict"
+	// the actions below just update the metadata in the database
+	ACTION_MARK_SYNCED          ActionType = "mark synced"
+	ACTION_MARK_REMOVED_LOCALLY ActionType = "mark removed locally"
+)
+
+// ActionSide is the side (local or remote drive), where an action changes files
+type ActionSide string
+
+const (
+	SIDE_LOCAL  ActionSide = "local"
+	SIDE_REMOTE ActionSide = "remote"
+)
+
+// Action is a single step of synchronization. Actions are planned first
+// and then performed or, in the dry-run mode, just printed
+type Action struct {
+	Type ActionType `json:"type"`
+	Side ActionSide `json:"side"`
+	// Path is the path relative to the drive path
+	Path string `json:"path"`
+	// PrevPath is the path before a move
+	PrevPath string `json:"prev_path,omitempty"`
+	// FileId is the id of the remote file. It is empty for new local files
+	FileId string `json:"file_id,omitempty"`
+	// ParentId is the id of the remote folder to create or move the file in
+	ParentId     string         `json:"parent_id,omitempty"`
+	LocalChange  FileChangeType `json:"local_change,omitempty"`
+	RemoteChange FileChangeType `json:"remote_change,omitempty"`
+	// Resolution is the conflict policy for conflicts
+	Resolution string `json:"resolution,omitempty"`
+	Reason     string `json:"reason,omitempty"`
+	// File is the information about the file from the database
+	File File `json:"-"`
+}
+
+// IsBookkeeping says if the action just updates the metadata in the database
+func (a Action) IsBookkeeping() bool {
+	return ACTION_MARK_SYNCED == a.Type || ACTION_MARK_REMOVED_LOCALLY == a.Type
+}
@@ -33,6 +33,16 @@ type File struct {
 	RemovedLocally   uint8
 	// if it was placed to trash
 	Trashed uint8
+	// DriveId is the id of the shared drive the file belongs to. It is empty for "My Drive"
+	DriveId string
+	// ReadOnly files cannot be changed remotely, so the local changes of them are not uploaded
+	ReadOnly uint8
+	// Encrypted files have the encrypted content remotely. Their hash is the keyed
+	// hash of the plain content and their size is the size of the plain content
+	Encrypted uint8
+	// Symlink is the target of the local symbolic link the remote file keeps.
+	// It is empty for the rest of the files
+	Symlink string
 }
 
 type FilesChan chan File
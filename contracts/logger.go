@@ -5,8 +5,21 @@ type Logger interface {
 	Info(v ...interface{})
 	Warning(v ...interface{})
 	Error(v ...interface{})
+	// With returns the logger, that adds the fields to each record
+	With(fields Fields) Logger
 }
 
+// Fields are the named values of a log record, for example, the id and the path of a file
+type Fields map[string]interface{}
+
+// the names of the fields used across the application
+const (
+	FieldFileId = "file_id"
+	FieldPath   = "path"
+	FieldAction = "action"
+	FieldSide   = "side"
+)
+
 const (
 	LogErrorLevel uint8 = iota
 	LogInfoLevel
@@ -0,0 +1,253 @@
+// Package daemon keeps the local drive synchronized with the remote one
+// while the application is running. Local changes are noticed with inotify,
+// remote ones are polled periodically.
+package daemon
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"github.com/svetlyi/gdriveapp/config"
+	"github.com/svetlyi/gdriveapp/contracts"
+	"github.com/svetlyi/gdriveapp/ldrive/recycle"
+	"github.com/svetlyi/gdriveapp/ldrive/watcher"
+	"github.com/svetlyi/gdriveapp/rdrive"
+	"github.com/svetlyi/gdriveapp/rdrive/db/file"
+	"github.com/svetlyi/gdriveapp/synchronization"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// maxDebounceTimes limits how many debounce intervals local changes may be
+// postponed, so that a constant stream of events does not stop synchronization
+const maxDebounceTimes = 10
+
+// selfWriteWindow is how long after a synchronization the events about the files
+// it has changed locally are not taken for the user's changes
+const selfWriteWindow = 2 * time.Second
+
+type Daemon struct {
+	cfg          config.Cfg
+	log          contracts.Logger
+	rd           *rdrive.Drive
+	fr           file.Repository
+	synchronizer *synchronization.Synchronizer
+	// syncLocal synchronizes the local changes of the paths (everything, if there are
+	// no paths) and syncRemote the remote ones. The tests replace them
+	syncLocal  func(scope []string) error
+	syncRemote func() error
+	// selfWrites are the paths (relative to the drive path) the daemon has changed locally
+	// with the time the events about them are expected until. The time is zero, while
+	// the synchronization changing them is not over
+	selfWrites map[string]time.Time
+}
+
+// New creates a daemon. The database connection and the remote drive (with
+// its token source) are shared by all the synchronizations the daemon runs.
+func New(
+	cfg config.Cfg,
+	log contracts.Logger,
+	rd *rdrive.Drive,
+	fr file.Repository,
+	synchronizer *synchronization.Synchronizer,
+) *Daemon {
+	d := &Daemon{
+		cfg:          cfg,
+		log:          log,
+		rd:           rd,
+		fr:           fr,
+		synchronizer: synchronizer,
+		selfWrites:   make(map[string]time.Time),
+	}
+	d.syncLocal = d.syncLocalChanges
+	d.syncRemote = d.syncRemoteChanges
+	synchronizer.SetOnLocalWrite(d.recordSelfWrite)
+	return d
+}
+
+// Run watches for changes until the context is canceled. It expects the local drive
+// to be fully synchronized before the start.
+func (d *Daemon) Run(ctx context.Context) error {
+	w, err := watcher.New(d.cfg.DrivePath, d.cfg.Symlinks, d.log)
+	if err != nil {
+		return errors.Wrap(err, "could not watch the local drive")
+	}
+	defer w.Close()
+	return d.run(ctx, w)
+}
+
+func (d *Daemon) run(ctx context.Context, w *watcher.Watcher) error {
+	pollTicker := time.NewTicker(time.Duration(d.cfg.DaemonPollInterval) * time.Second)
+	defer pollTicker.Stop()
+	debounce := time.Duration(d.cfg.DaemonDebounce) * time.Millisecond
+
+	var debounceTimer <-chan time.Time
+	var pendingSince time.Time
+	// changed are the paths changed locally since the last synchronization. When some
+	// changes are unknown (for example, the events were lost), everything is synchronized
+	changed := make(map[string]bool)
+	unknownChanges := false
+
+	d.log.With(contracts.Fields{
+		contracts.FieldPath: d.cfg.DrivePath,
+		"poll_interval":     (time.Duration(d.cfg.DaemonPollInterval) * time.Second).String(),
+	}).Info("daemon started")
+
+	for {
+		select {
+		case event, ok := <-w.Events:
+			if !ok {
+				return errors.New("watcher stopped")
+			}
+			relativePath, err := filepath.Rel(d.cfg.DrivePath, event.Path)
+			if err != nil {
+				d.log.Warning("could not get the path of the changed file", err)
+				unknownChanges = true
+			} else if d.isIgnored(relativePath, event.IsDir) || d.isSelfWrite(relativePath) {
+				continue
+			} else {
+				changed[relativePath] = true
+			}
+			d.log.Debug("local change", event.Path)
+			if pendingSince.IsZero() {
+				pendingSince = time.Now()
+			}
+			// the synchronization is postponed until the changes settle down
+			if time.Since(pendingSince) < debounce*maxDebounceTimes {
+				debounceTimer = time.After(debounce)
+			}
+		case err, ok := <-w.Errors:
+			if !ok {
+				return errors.New("watcher stopped")
+			}
+			d.log.Warning("watcher error", err)
+			unknownChanges = true
+			if pendingSince.IsZero() {
+				pendingSince = time.Now()
+				debounceTimer = time.After(debounce)
+			}
+		case <-debounceTimer:
+			var scope []string
+			if !unknownChanges {
+				for path := range changed {
+					scope = append(scope, path)
+				}
+				sort.Strings(scope)
+			}
+			debounceTimer = nil
+			pendingSince = time.Time{}
+			changed = make(map[string]bool)
+			unknownChanges = false
+			if err := d.syncLocal(scope); err != nil {
+				d.log.Error("could not synchronize local changes", err)
+			}
+			d.settleSelfWrites()
+		case <-pollTicker.C:
+			if err := d.syncRemote(); err != nil {
+				d.log.Error("could not synchronize remote changes", err)
+			}
+			d.settleSelfWrites()
+		case <-ctx.Done():
+			d.log.Info("daemon stopped")
+			return nil
+		}
+	}
+}
+
+// isIgnored says if the changed file (with the path relative to the drive path)
+// is ignored, so it does not need synchronization
+func (d *Daemon) isIgnored(relativePath string, isDir bool) bool {
+	if strings.Split(relativePath, string(filepath.Separator))[0] == recycle.DirName {
+		return true // the recycle bin is not synchronized
+	}
+	ignored, err := d.synchronizer.IsIgnored(relativePath, isDir)
+	if err != nil {
+		d.log.Warning("could not check if the changed file is ignored", err)
+		return false
+	}
+	return ignored
+}
+
+// recordSelfWrite remembers the path the synchronization is about to change locally
+func (d *Daemon) recordSelfWrite(relativePath string) {
+	d.selfWrites[filepath.Clean(relativePath)] = time.Time{}
+}
+
+// settleSelfWrites starts waiting for the events about the paths the finished synchronization
+// has changed. The paths, which events are not expected anymore, are forgotten
+func (d *Daemon) settleSelfWrites() {
+	now := time.Now()
+	for path, until := range d.selfWrites {
+		if until.IsZero() {
+			d.selfWrites[path] = now.Add(selfWriteWindow)
+		} else if now.After(until) {
+			delete(d.selfWrites, path)
+		}
+	}
+}
+
+// isSelfWrite says if the changed file is the one the daemon has changed itself or is inside it
+func (d *Daemon) isSelfWrite(relativePath string) bool {
+	now := time.Now()
+	for path := relativePath; "." != path && string(filepath.Separator) != path; path = filepath.Dir(path) {
+		if until, ok := d.selfWrites[path]; ok && (until.IsZero() || now.Before(until)) {
+			d.log.Debug("skipping local change made by the daemon", relativePath)
+			return true
+		}
+	}
+	return false
+}
+
+// syncRemoteChanges gets the changes from the remote drive and applies them locally.
+// Just the remotely changed files are synchronized
+func (d *Daemon) syncRemoteChanges() error {
+	if err := d.rd.SyncMetadata(); err != nil {
+		return errors.Wrap(err, "saving changes to db error")
+	}
+	changedFiles, err := d.fr.GetRemotelyChangedFiles()
+	if err != nil {
+		return err
+	}
+	var scope []string
+	for _, f := range changedFiles {
+		scope = append(scope, d.rd.GetLocalPath(f), d.rd.GetPrevLocalPath(f))
+	}
+	if len(scope) > 0 {
+		d.synchronizer.SetScope(scope)
+		err = d.synchronizer.SyncRemoteWithLocal()
+		d.synchronizer.SetScope(nil)
+		if err != nil {
+			return errors.Wrap(err, "SyncRemoteWithLocal error")
+		}
+	}
+	if err := d.rd.CleanUpRecycleBin(); err != nil {
+		return err
+	}
+	if err := d.fr.CleanUpDatabase(); err != nil {
+		return err
+	}
+	return d.rd.SetLastSyncTime(time.Now())
+}
+
+// syncLocalChanges uploads the changed and the new local files and removes
+// remotely the files removed locally. Just the changed paths are synchronized,
+// if they are known
+func (d *Daemon) syncLocalChanges(scope []string) error {
+	d.synchronizer.SetScope(scope)
+	defer d.synchronizer.SetScope(nil)
+	// the changes of the already known files are detected here
+	if err := d.synchronizer.SyncRemoteWithLocal(); err != nil {
+		return errors.Wrap(err, "SyncRemoteWithLocal error")
+	}
+	if err := d.synchronizer.SyncLocalWithRemote(d.cfg.DrivePath); err != nil {
+		return errors.Wrap(err, "SyncLocalWithRemote error")
+	}
+	if err := d.synchronizer.RemoveLocallyRemoved(); err != nil {
+		return errors.Wrap(err, "RemoveLocallyRemoved error")
+	}
+	if err := d.fr.CleanUpDatabase(); err != nil {
+		return err
+	}
+	return d.rd.SetLastSyncTime(time.Now())
+}
@@ -0,0 +1,160 @@
+package daemon
+
+import (
+	"context"
+	"github.com/svetlyi/gdriveapp/config"
+	"github.com/svetlyi/gdriveapp/ldrive/watcher"
+	"github.com/svetlyi/gdriveapp/logger"
+	"github.com/svetlyi/gdriveapp/rdrive"
+	"github.com/svetlyi/gdriveapp/rdrive/db/file"
+	"github.com/svetlyi/gdriveapp/synchronization"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+const testDebounce = 100 * time.Millisecond
+
+// testDaemon runs the daemon with the synchronizations replaced: the local ones send
+// their scopes to syncs. It is stopped, when the test is over
+type testDaemon struct {
+	*Daemon
+	local string
+	syncs chan []string
+	stop  context.CancelFunc
+	done  chan error
+}
+
+func newTestDaemon(t *testing.T) *testDaemon {
+	drivePath, err := ioutil.TempDir("", "gdriveapp-daemon-")
+	if nil != err {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(drivePath) })
+	local := filepath.Join(drivePath, "My Drive")
+	if err = os.Mkdir(local, 0755); nil != err {
+		t.Fatal(err)
+	}
+	log, err := logger.New("svetlyi_gdriveapp_test", 10000, 0, false)
+	if nil != err {
+		t.Fatal(err)
+	}
+	cfg := config.Cfg{
+		DrivePath:          drivePath,
+		DaemonPollInterval: 3600,
+		DaemonDebounce:     int64(testDebounce / time.Millisecond),
+		Symlinks:           config.SymlinksSkip,
+	}
+	s := synchronization.New(file.Repository{}, log, nil, rdrive.Drive{}, nil, 1, cfg.Symlinks)
+	d := &testDaemon{
+		Daemon: New(cfg, log, nil, file.Repository{}, &s),
+		local:  local,
+		syncs:  make(chan []string, 10),
+		done:   make(chan error, 1),
+	}
+	d.syncLocal = func(scope []string) error {
+		d.syncs <- scope
+		return nil
+	}
+	d.syncRemote = func() error {
+		t.Error("the remote changes must not be polled")
+		return nil
+	}
+	w, err := watcher.New(drivePath, cfg.Symlinks, log)
+	if nil != err {
+		t.Fatal(err)
+	}
+	var ctx context.Context
+	ctx, d.stop = context.WithCancel(context.Background())
+	go func() {
+		d.done <- d.run(ctx, w)
+		w.Close()
+	}()
+	t.Cleanup(func() {
+		d.stop()
+		<-d.done
+	})
+	return d
+}
+
+func (d *testDaemon) write(t *testing.T, name string) {
+	if err := ioutil.WriteFile(filepath.Join(d.local, name), []byte(name), 0644); nil != err {
+		t.Fatal(err)
+	}
+}
+
+// expectSync waits for the synchronization of the local changes and checks its scope
+func (d *testDaemon) expectSync(t *testing.T, scope ...string) {
+	select {
+	case got := <-d.syncs:
+		if !reflect.DeepEqual(scope, got) {
+			t.Errorf("expected synchronization of %v, got %v", scope, got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no synchronization of %v", scope)
+	}
+}
+
+// expectNoSync checks, that the local changes are not synchronized for a while
+func (d *testDaemon) expectNoSync(t *testing.T) {
+	select {
+	case got := <-d.syncs:
+		t.Errorf("unexpected synchronization of %v", got)
+	case <-time.After(5 * testDebounce):
+	}
+}
+
+func TestDebounce(t *testing.T) {
+	d := newTestDaemon(t)
+	// the changes made in a row are synchronized together, just the changed files
+	for _, name := range []string{"c.txt", "a.txt", "b.txt"} {
+		d.write(t, name)
+	}
+	d.expectSync(t, "My Drive/a.txt", "My Drive/b.txt", "My Drive/c.txt")
+	d.expectNoSync(t)
+
+	d.write(t, "a.txt")
+	d.expectSync(t, "My Drive/a.txt")
+}
+
+func TestSelfWrites(t *testing.T) {
+	d := newTestDaemon(t)
+	// the synchronization downloads a file, as if it was changed remotely
+	d.syncLocal = func(scope []string) error {
+		d.recordSelfWrite(filepath.Join("My Drive", "downloaded"))
+		if err := os.MkdirAll(filepath.Join(d.local, "downloaded"), 0755); nil != err {
+			return err
+		}
+		d.write(t, filepath.Join("downloaded", "remote.txt"))
+		d.syncs <- scope
+		return nil
+	}
+	d.write(t, "local.txt")
+	d.expectSync(t, "My Drive/local.txt")
+	d.expectNoSync(t)
+
+	// the user's changes of the same files are synchronized later
+	time.Sleep(selfWriteWindow)
+	d.write(t, filepath.Join("downloaded", "remote.txt"))
+	d.expectSync(t, "My Drive/downloaded/remote.txt")
+}
+
+func TestShutdown(t *testing.T) {
+	d := newTestDaemon(t)
+	d.write(t, "a.txt")
+	d.stop()
+	select {
+	case err := <-d.done:
+		if nil != err {
+			t.Error(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the daemon is not stopped after the context is canceled")
+	}
+	// the changes pending at the moment are left for the next start
+	d.expectNoSync(t)
+	d.done <- nil
+}
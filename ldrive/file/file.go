@@ -1,15 +1,54 @@
 package file
 
 import (
+	"fmt"
 	"github.com/svetlyi/gdriveapp/config"
 	"github.com/svetlyi/gdriveapp/contracts"
+	"github.com/svetlyi/gdriveapp/rdrive/specification"
 	"path/filepath"
+	"strings"
+	"time"
 )
 
 func GetCurFullPath(cfg config.Cfg, file contracts.File) string {
-	return filepath.Join(cfg.DrivePath, file.CurPath)
+	return filepath.Join(cfg.DrivePath, GetLocalPath(cfg, file.CurPath, file))
 }
 
 func GetPrevFullPath(cfg config.Cfg, file contracts.File) string {
-	return filepath.Join(cfg.DrivePath, file.PrevPath)
+	return filepath.Join(cfg.DrivePath, GetLocalPath(cfg, file.PrevPath, file))
+}
+
+// GetLocalPath returns the path of the local copy of the file, where remotePath is
+// one of the file's remote paths. The exported native Google files get the extension
+// of the format they are exported to, for example, "My Drive/report.docx"
+func GetLocalPath(cfg config.Cfg, remotePath string, file contracts.File) string {
+	if ext, _, ok := specification.GetExportFormat(file, cfg.ExportFormats); ok {
+		return remotePath + "." + ext
+	}
+	return remotePath
+}
+
+// PartialSuffix is added to the name of a file while it is being downloaded
+const PartialSuffix = ".partial"
+
+// GetPartialPath returns the path the file is downloaded to before it is complete
+func GetPartialPath(fullPath string) string {
+	return fullPath + PartialSuffix
+}
+
+// GetConflictedCopyPath returns a path for the conflicted copy of the file
+// in the same folder, for example, "report (conflicted copy host 2020-06-14 153045).txt"
+func GetConflictedCopyPath(fullPath string, host string, t time.Time) string {
+	dir, name := filepath.Split(fullPath)
+	ext := filepath.Ext(name)
+	if ext == name { // a dot file such as .bashrc does not have an extension
+		ext = ""
+	}
+	return filepath.Join(dir, fmt.Sprintf(
+		"%s (conflicted copy %s %s)%s",
+		strings.TrimSuffix(name, ext),
+		host,
+		t.Format("2006-01-02 150405"),
+		ext,
+	))
 }
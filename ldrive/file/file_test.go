@@ -0,0 +1,42 @@
+package file
+
+import (
+	"github.com/svetlyi/gdriveapp/config"
+	"github.com/svetlyi/gdriveapp/contracts"
+	"testing"
+	"time"
+)
+
+func TestGetConflictedCopyPath(t *testing.T) {
+	tm := time.Date(2020, 6, 14, 15, 30, 45, 0, time.UTC)
+	cases := map[string]string{
+		"/drive/My Drive/report.txt":     "/drive/My Drive/report (conflicted copy host 2020-06-14 153045).txt",
+		"/drive/My Drive/archive.tar.gz": "/drive/My Drive/archive.tar (conflicted copy host 2020-06-14 153045).gz",
+		"/drive/My Drive/.bashrc":        "/drive/My Drive/.bashrc (conflicted copy host 2020-06-14 153045)",
+		"/drive/My Drive/README":         "/drive/My Drive/README (conflicted copy host 2020-06-14 153045)",
+	}
+	for path, expected := range cases {
+		if actual := GetConflictedCopyPath(path, "host", tm); actual != expected {
+			t.Errorf("expected %s, got %s", expected, actual)
+		}
+	}
+}
+
+func TestGetLocalPath(t *testing.T) {
+	cfg := config.Cfg{ExportFormats: map[string]string{"document": "odt", "spreadsheet": ""}}
+	cases := []struct {
+		mimeType string
+		expected string
+	}{
+		{"application/vnd.google-apps.document", "My Drive/report.odt"},
+		{"application/vnd.google-apps.spreadsheet", "My Drive/report"},
+		{"application/vnd.google-apps.folder", "My Drive/report"},
+		{"text/plain", "My Drive/report"},
+	}
+	for _, c := range cases {
+		file := contracts.File{MimeType: c.mimeType}
+		if actual := GetLocalPath(cfg, "My Drive/report", file); actual != c.expected {
+			t.Errorf("%s: expected %s, got %s", c.mimeType, c.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+// Package ignore decides which files are kept out of synchronization. The rules
+// are gitignore-style patterns from a global file and from .gdriveignore files
+// in the folders of the local drive.
+package ignore
+
+import (
+	"bufio"
+	"github.com/pkg/errors"
+	lfile "github.com/svetlyi/gdriveapp/ldrive/file"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// FileName is the name of the files with patterns inside the folders
+const FileName = ".gdriveignore"
+
+// builtInPatterns are ignored before the patterns from the files apply. The partially
+// downloaded files are not uploaded
+var builtInPatterns = "*" + lfile.PartialSuffix + "\n"
+
+type pattern struct {
+	// base is the folder (relative to the drive path) the pattern applies in.
+	// Global patterns have an empty base, they apply in every root folder
+	base string
+	// glob is the pattern split by "/"
+	glob    []string
+	negate  bool
+	dirOnly bool
+	// anchored patterns are matched against the path relative to the base,
+	// the rest are matched against the name at any depth
+	anchored bool
+}
+
+// Rules are the patterns from the global file and from the .gdriveignore files
+type Rules struct {
+	drivePath string
+	global    []pattern
+	// mu guards folders, that caches the patterns of the folders by their relative paths
+	mu      sync.Mutex
+	folders map[string][]pattern
+}
+
+// New reads the global patterns from globalFilePath (it may not exist).
+// The .gdriveignore files are looked for in drivePath.
+func New(drivePath string, globalFilePath string) (*Rules, error) {
+	global, err := parsePatterns(strings.NewReader(builtInPatterns), "")
+	if err != nil {
+		return nil, errors.Wrap(err, "could not parse built-in patterns")
+	}
+	globalFromFile, err := readPatterns(globalFilePath, "")
+	if err != nil {
+		return nil, err
+	}
+	global = append(global, globalFromFile...)
+	return &Rules{drivePath: drivePath, global: global, folders: make(map[string][]pattern)}, nil
+}
+
+// Reload forgets the patterns read from the .gdriveignore files, so that
+// they are read again. It is called before each synchronization
+func (r *Rules) Reload() {
+	r.mu.Lock()
+	r.folders = make(map[string][]pattern)
+	r.mu.Unlock()
+}
+
+// IsIgnored says if the file with the path relative to the drive path
+// (for example, "My Drive/project/node_modules") is ignored. A file is ignored
+// if any of its parent folders is ignored. The root folders are never ignored
+func (r *Rules) IsIgnored(relativePath string, isDir bool) (bool, error) {
+	segments := strings.Split(filepath.ToSlash(filepath.Clean(relativePath)), "/")
+	for i := 2; i < len(segments); i++ {
+		if ignored, err := r.matches(segments[:i], true); err != nil || ignored {
+			return ignored, err
+		}
+	}
+	if len(segments) < 2 {
+		return false, nil
+	}
+	return r.matches(segments, isDir)
+}
+
+// matches checks the path against all the patterns applying to it.
+// As in gitignore, the last matching pattern decides
+func (r *Rules) matches(segments []string, isDir bool) (bool, error) {
+	ignored := false
+	apply := func(patterns []pattern) {
+		for _, p := range patterns {
+			if p.match(segments, isDir) {
+				ignored = !p.negate
+			}
+		}
+	}
+	apply(r.global)
+	for i := 1; i < len(segments); i++ {
+		patterns, err := r.getFolderPatterns(strings.Join(segments[:i], "/"))
+		if err != nil {
+			return false, err
+		}
+		apply(patterns)
+	}
+	return ignored, nil
+}
+
+func (r *Rules) getFolderPatterns(folder string) ([]pattern, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if patterns, ok := r.folders[folder]; ok {
+		return patterns, nil
+	}
+	patterns, err := readPatterns(filepath.Join(r.drivePath, filepath.FromSlash(folder), FileName), folder)
+	if err != nil {
+		return nil, err
+	}
+	r.folders[folder] = patterns
+	return patterns, nil
+}
+
+func readPatterns(filePath string, base string) ([]pattern, error) {
+	f, err := os.Open(filePath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.Wrapf(err, "could not open ignore file %s", filePath)
+	}
+	defer f.Close()
+
+	patterns, err := parsePatterns(f, base)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not read ignore file %s", filePath)
+	}
+	return patterns, nil
+}
+
+func parsePatterns(r io.Reader, base string) ([]pattern, error) {
+	var patterns []pattern
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		if "" == line || strings.HasPrefix(line, "#") {
+			continue
+		}
+		p := pattern{base: base}
+		if strings.HasPrefix(line, "!") {
+			p.negate = true
+			line = line[1:]
+		} else if strings.HasPrefix(line, `\`) { // escaped "#" or "!"
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			p.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		if strings.Contains(line, "/") {
+			p.anchored = true
+			line = strings.TrimLeft(line, "/")
+		}
+		if "" == line {
+			continue
+		}
+		p.glob = strings.Split(line, "/")
+		patterns = append(patterns, p)
+	}
+	return patterns, scanner.Err()
+}
+
+// match says if the pattern matches the path, split by "/"
+func (p pattern) match(segments []string, isDir bool) bool {
+	if p.dirOnly && !isDir {
+		return false
+	}
+	baseLen := 1 // global patterns apply inside the root folder
+	if "" != p.base {
+		baseLen = len(strings.Split(p.base, "/"))
+		if len(segments) <= baseLen || strings.Join(segments[:baseLen], "/") != p.base {
+			return false
+		}
+	}
+	relative := segments[baseLen:]
+	if !p.anchored {
+		matched, _ := path.Match(p.glob[0], relative[len(relative)-1])
+		return matched
+	}
+	return matchSegments(p.glob, relative)
+}
+
+// matchSegments matches the path segments against the glob segments,
+// where "**" stands for any number of segments
+func matchSegments(glob []string, segments []string) bool {
+	if len(glob) == 0 {
+		return len(segments) == 0
+	}
+	if "**" == glob[0] {
+		for i := 0; i <= len(segments); i++ {
+			if matchSegments(glob[1:], segments[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(segments) == 0 {
+		return false
+	}
+	if matched, _ := path.Match(glob[0], segments[0]); !matched {
+		return false
+	}
+	return matchSegments(glob[1:], segments[1:])
+}
@@ -0,0 +1,64 @@
+package ignore
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIsIgnored(t *testing.T) {
+	drivePath, err := ioutil.TempDir("", "gdriveapp_ignore_test")
+	if err != nil {
+		t.Fatal("could not create temp dir", err)
+	}
+	defer os.RemoveAll(drivePath)
+
+	globalFile := filepath.Join(drivePath, "global")
+	writeFile(t, globalFile, "# editor files\n*.swp\nnode_modules/\n/build\n")
+	writeFile(t, filepath.Join(drivePath, "My Drive", "project", FileName), "*.iso\n!keep.iso\nout/**\n")
+
+	rules, err := New(drivePath, globalFile)
+	if err != nil {
+		t.Fatal("could not create rules", err)
+	}
+	cases := []struct {
+		path    string
+		isDir   bool
+		ignored bool
+	}{
+		{"My Drive", true, false},
+		{"My Drive/notes.txt", false, false},
+		{"My Drive/.notes.txt.swp", false, true},
+		{"My Drive/a/b/c.swp", false, true},
+		{"My Drive/a/node_modules", true, true},
+		{"My Drive/a/node_modules/lib/index.js", false, true},
+		{"My Drive/a/node_modules", false, false},
+		{"My Drive/build", true, true},
+		{"My Drive/a/build", true, false},
+		{"My Drive/project/vm.iso", false, true},
+		{"My Drive/project/keep.iso", false, false},
+		{"My Drive/vm.iso", false, false},
+		{"My Drive/project/out/a/b.txt", false, true},
+		{"My Drive/project/src/out/b.txt", false, false},
+		{"My Drive/movie.mkv.partial", false, true},
+	}
+	for _, c := range cases {
+		ignored, err := rules.IsIgnored(c.path, c.isDir)
+		if err != nil {
+			t.Error("could not check", c.path, err)
+		}
+		if ignored != c.ignored {
+			t.Errorf("%s: expected ignored to be %v", c.path, c.ignored)
+		}
+	}
+}
+
+func writeFile(t *testing.T, path string, content string) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal("could not create dir", err)
+	}
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal("could not write file", err)
+	}
+}
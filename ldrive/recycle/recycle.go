@@ -0,0 +1,204 @@
+// Package recycle keeps the local files removed because of remote changes, so that
+// a wrong deletion can be undone. The files are moved to DirName in the drive path,
+// to a folder with the date of the removal, under their original paths:
+//
+//	.gdriveapp-trash/2020-06-14/My Drive/docs/report.txt
+//
+// If the same path is removed twice on the same day, the second copy goes to
+// the "2020-06-14 (2)" folder and so on
+package recycle
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/svetlyi/gdriveapp/config"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DirName is the folder in the drive path the removed files are moved to
+const DirName = ".gdriveapp-trash"
+
+const dateFormat = "2006-01-02"
+
+// Bin is the recycle bin of the drive path
+type Bin struct {
+	drivePath string
+	// maxAge and maxSize limit how long and how much the bin keeps. Zero means no limit
+	maxAge  time.Duration
+	maxSize int64
+}
+
+// dateDir is a folder of the bin with the files removed on the same day
+type dateDir struct {
+	name string
+	date time.Time
+	// number is the number of the folder for the same date, starting with 1
+	number int
+}
+
+func New(cfg config.Cfg) Bin {
+	return Bin{
+		drivePath: cfg.DrivePath,
+		maxAge:    time.Duration(cfg.RecycleBinMaxAge) * 24 * time.Hour,
+		maxSize:   cfg.RecycleBinMaxSize,
+	}
+}
+
+// Move moves the file or the folder with the full path in the drive path to the bin
+func (b Bin) Move(fullPath string) error {
+	relativePath, err := filepath.Rel(b.drivePath, fullPath)
+	if err != nil || strings.HasPrefix(relativePath, "..") {
+		return errors.Errorf("%s is not in the drive path %s", fullPath, b.drivePath)
+	}
+	date := time.Now().Format(dateFormat)
+	var binPath string
+	for number := 1; ; number++ {
+		binPath = filepath.Join(b.dir(), formatDirName(date, number), relativePath)
+		if _, err = os.Lstat(binPath); os.IsNotExist(err) {
+			break
+		} else if err != nil {
+			return errors.Wrapf(err, "could not get stat for %s", binPath)
+		}
+	}
+	if err = os.MkdirAll(filepath.Dir(binPath), 0755); err != nil {
+		return errors.Wrapf(err, "could not create folder for %s in recycle bin", relativePath)
+	}
+	return errors.Wrapf(os.Rename(fullPath, binPath), "could not move %s to recycle bin", fullPath)
+}
+
+// Restore moves the file or the folder with the path relative to the drive path
+// back from the bin. If it was removed several times, the last copy is restored
+func (b Bin) Restore(relativePath string) error {
+	relativePath = filepath.Clean(relativePath)
+	if filepath.IsAbs(relativePath) || strings.HasPrefix(relativePath, "..") {
+		return errors.Errorf("%s is not a path in the drive path", relativePath)
+	}
+	fullPath := filepath.Join(b.drivePath, relativePath)
+	if _, err := os.Lstat(fullPath); nil == err {
+		return errors.Errorf("%s already exists", fullPath)
+	} else if !os.IsNotExist(err) {
+		return errors.Wrapf(err, "could not get stat for %s", fullPath)
+	}
+	dirs, err := b.readDirs()
+	if err != nil {
+		return err
+	}
+	for i := len(dirs) - 1; i >= 0; i-- {
+		binPath := filepath.Join(b.dir(), dirs[i].name, relativePath)
+		if _, err = os.Lstat(binPath); os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return errors.Wrapf(err, "could not get stat for %s", binPath)
+		}
+		if err = os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+			return errors.Wrapf(err, "could not create folder for %s", fullPath)
+		}
+		return errors.Wrapf(os.Rename(binPath, fullPath), "could not restore %s", fullPath)
+	}
+	return errors.Errorf("%s not found in recycle bin", relativePath)
+}
+
+// CleanUp removes the files older than the maximum age and, while the bin
+// is bigger than the maximum size, the oldest ones
+func (b Bin) CleanUp() error {
+	dirs, err := b.readDirs()
+	if err != nil {
+		return err
+	}
+	var sizes []int64
+	var totalSize int64
+	for _, dir := range dirs {
+		size, err := getSize(filepath.Join(b.dir(), dir.name))
+		if err != nil {
+			return err
+		}
+		sizes = append(sizes, size)
+		totalSize += size
+	}
+	for i, dir := range dirs {
+		isExpired := b.maxAge > 0 && time.Since(dir.date) > b.maxAge
+		isOverSize := b.maxSize > 0 && totalSize > b.maxSize
+		if !isExpired && !isOverSize {
+			break
+		}
+		if err = os.RemoveAll(filepath.Join(b.dir(), dir.name)); err != nil {
+			return errors.Wrapf(err, "could not remove %s from recycle bin", dir.name)
+		}
+		totalSize -= sizes[i]
+	}
+	return nil
+}
+
+func (b Bin) dir() string {
+	return filepath.Join(b.drivePath, DirName)
+}
+
+// readDirs returns the folders of the bin from the oldest to the newest
+func (b Bin) readDirs() ([]dateDir, error) {
+	infos, err := ioutil.ReadDir(b.dir())
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.Wrap(err, "could not read recycle bin")
+	}
+	var dirs []dateDir
+	for _, info := range infos {
+		if dir, ok := parseDirName(info.Name()); ok && info.IsDir() {
+			dirs = append(dirs, dir)
+		}
+	}
+	sort.Slice(dirs, func(i, j int) bool {
+		if dirs[i].date.Equal(dirs[j].date) {
+			return dirs[i].number < dirs[j].number
+		}
+		return dirs[i].date.Before(dirs[j].date)
+	})
+	return dirs, nil
+}
+
+func formatDirName(date string, number int) string {
+	if 1 == number {
+		return date
+	}
+	return fmt.Sprintf("%s (%d)", date, number)
+}
+
+func parseDirName(name string) (dateDir, bool) {
+	if len(name) < len(dateFormat) {
+		return dateDir{}, false
+	}
+	date, err := time.ParseInLocation(dateFormat, name[:len(dateFormat)], time.Local)
+	if err != nil {
+		return dateDir{}, false
+	}
+	dir := dateDir{name: name, date: date, number: 1}
+	if suffix := name[len(dateFormat):]; "" != suffix {
+		if !strings.HasPrefix(suffix, " (") || !strings.HasSuffix(suffix, ")") {
+			return dateDir{}, false
+		}
+		if dir.number, err = strconv.Atoi(suffix[2 : len(suffix)-1]); err != nil {
+			return dateDir{}, false
+		}
+	}
+	return dir, true
+}
+
+func getSize(path string) (int64, error) {
+	var size int64
+	err := filepath.Walk(path, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, errors.Wrapf(err, "could not get size of %s", path)
+}
@@ -0,0 +1,94 @@
+package recycle
+
+import (
+	"github.com/svetlyi/gdriveapp/config"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeFile(t *testing.T, path string, content string) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); nil != err {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte(content), 0644); nil != err {
+		t.Fatal(err)
+	}
+}
+
+func TestMoveAndRestore(t *testing.T) {
+	drivePath, err := ioutil.TempDir("", "gdriveapp-recycle-")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(drivePath)
+	bin := New(config.Cfg{DrivePath: drivePath})
+	report := filepath.Join(drivePath, "My Drive", "docs", "report.txt")
+	today := time.Now().Format(dateFormat)
+
+	writeFile(t, report, "first")
+	if err = bin.Move(report); nil != err {
+		t.Fatal(err)
+	}
+	writeFile(t, report, "second")
+	if err = bin.Move(report); nil != err {
+		t.Fatal(err)
+	}
+	for dirName, expected := range map[string]string{today: "first", today + " (2)": "second"} {
+		content, err := ioutil.ReadFile(filepath.Join(drivePath, DirName, dirName, "My Drive", "docs", "report.txt"))
+		if nil != err || expected != string(content) {
+			t.Errorf("%s: expected %q, got %q: %v", dirName, expected, content, err)
+		}
+	}
+
+	if err = bin.Restore(filepath.Join("My Drive", "docs", "report.txt")); nil != err {
+		t.Fatal(err)
+	}
+	if content, err := ioutil.ReadFile(report); nil != err || "second" != string(content) {
+		t.Errorf("the last copy must be restored, got %q: %v", content, err)
+	}
+	if err = bin.Restore(filepath.Join("My Drive", "docs", "report.txt")); nil == err {
+		t.Error("the existing file must not be overwritten")
+	}
+	if err = bin.Restore(filepath.Join("..", "report.txt")); nil == err {
+		t.Error("the file outside of the drive path must not be restored")
+	}
+}
+
+func TestCleanUp(t *testing.T) {
+	drivePath, err := ioutil.TempDir("", "gdriveapp-recycle-")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(drivePath)
+	bin := New(config.Cfg{DrivePath: drivePath, RecycleBinMaxAge: 30, RecycleBinMaxSize: 10})
+	binDir := filepath.Join(drivePath, DirName)
+	now := time.Now()
+	expired := now.AddDate(0, 0, -31).Format(dateFormat)
+	old := now.AddDate(0, 0, -2).Format(dateFormat)
+	today := now.Format(dateFormat)
+
+	writeFile(t, filepath.Join(binDir, expired, "a.txt"), "a")
+	writeFile(t, filepath.Join(binDir, old, "b.txt"), "bbbbbb")
+	writeFile(t, filepath.Join(binDir, old+" (2)", "c.txt"), "cccccc")
+	writeFile(t, filepath.Join(binDir, today, "d.txt"), "dddd")
+	writeFile(t, filepath.Join(binDir, "not a date", "e.txt"), "e")
+	if err = bin.CleanUp(); nil != err {
+		t.Fatal(err)
+	}
+
+	// the expired folder goes first, then the oldest ones until the bin fits the size
+	for dirName, isKept := range map[string]bool{
+		expired:      false,
+		old:          false,
+		old + " (2)": true,
+		today:        true,
+		"not a date": true,
+	} {
+		if _, err = os.Stat(filepath.Join(binDir, dirName)); isKept != (nil == err) {
+			t.Errorf("%s: expected to be kept %t, got %v", dirName, isKept, err)
+		}
+	}
+}
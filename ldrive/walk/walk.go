@@ -0,0 +1,137 @@
+// Package walk walks the local file tree with the symbolic links handled the way it is configured.
+package walk
+
+import (
+	"github.com/svetlyi/gdriveapp/config"
+	"github.com/svetlyi/gdriveapp/contracts"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+type walker struct {
+	// drivePath is the real path of the drive, the followed links to the folders must point inside it
+	drivePath string
+	symlinks  string
+	log       contracts.Logger
+	fn        filepath.WalkFunc
+}
+
+// Walk walks the file tree rooted at root the same way filepath.Walk does: fn is called for
+// each file and folder in lexical order and filepath.SkipDir skips a folder. The root itself
+// is always followed. The symbolic links inside it are handled according to symlinks:
+//   - config.SymlinksSkip: the links are not reported;
+//   - config.SymlinksFollow: the links are reported as the files and the folders they point to
+//     and the folders are walked into. The broken links and the links to the folders they are
+//     in (which would make a loop) are not reported. Neither are the links to the folders
+//     outside drivePath;
+//   - config.SymlinksFollowAll: the same as config.SymlinksFollow, but the links to the folders
+//     outside drivePath are followed as well;
+//   - config.SymlinksStore: the links are reported as they are, without walking into them.
+func Walk(root string, drivePath string, symlinks string, log contracts.Logger, fn filepath.WalkFunc) error {
+	info, err := os.Stat(root)
+	if nil != err {
+		err = fn(root, nil, err)
+	} else {
+		realDrivePath, evalErr := filepath.EvalSymlinks(drivePath)
+		if nil != evalErr {
+			realDrivePath = filepath.Clean(drivePath)
+		}
+		w := walker{drivePath: realDrivePath, symlinks: symlinks, log: log, fn: fn}
+		err = w.walk(root, info, nil)
+	}
+	if filepath.SkipDir == err {
+		return nil
+	}
+	return err
+}
+
+// walk reports the file or walks the folder. ancestors are the folders the path is in,
+// a followed link must not point to one of them
+func (w walker) walk(path string, info os.FileInfo, ancestors []os.FileInfo) error {
+	if !info.IsDir() {
+		return w.fn(path, info, nil)
+	}
+	names, err := readDirNames(path)
+	fnErr := w.fn(path, info, err)
+	// the folder is reported with the error, if it could not be read, and it is not walked into
+	if nil != err || nil != fnErr {
+		return fnErr
+	}
+	ancestors = append(ancestors, info)
+	for _, name := range names {
+		filename := filepath.Join(path, name)
+		fileInfo, err := os.Lstat(filename)
+		if nil != err {
+			if err = w.fn(filename, fileInfo, err); nil != err && filepath.SkipDir != err {
+				return err
+			}
+			continue
+		}
+		if 0 != fileInfo.Mode()&os.ModeSymlink {
+			if fileInfo = w.resolve(filename, fileInfo, ancestors); nil == fileInfo {
+				continue
+			}
+		}
+		if err = w.walk(filename, fileInfo, ancestors); nil != err {
+			if !fileInfo.IsDir() || filepath.SkipDir != err {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// resolve returns the info the symbolic link is reported with or nil, if it is not reported
+func (w walker) resolve(path string, info os.FileInfo, ancestors []os.FileInfo) os.FileInfo {
+	switch w.symlinks {
+	case config.SymlinksStore:
+		return info
+	case config.SymlinksFollow, config.SymlinksFollowAll:
+		target, err := os.Stat(path)
+		if nil != err {
+			w.log.Warning("skipping broken symbolic link", path, err)
+			return nil
+		}
+		if target.IsDir() {
+			for _, ancestor := range ancestors {
+				if os.SameFile(ancestor, target) {
+					w.log.Warning("skipping symbolic link to the folder it is in", path)
+					return nil
+				}
+			}
+			if config.SymlinksFollow == w.symlinks && !w.isInside(path) {
+				w.log.Warning("skipping symbolic link to a folder outside the drive path", path)
+				return nil
+			}
+		}
+		return target
+	}
+	w.log.Debug("skipping symbolic link", path)
+	return nil
+}
+
+// isInside says if the real path of the link is inside the drive path
+func (w walker) isInside(path string) bool {
+	realPath, err := filepath.EvalSymlinks(path)
+	if nil != err {
+		return false
+	}
+	return realPath == w.drivePath || strings.HasPrefix(realPath, w.drivePath+string(filepath.Separator))
+}
+
+// readDirNames returns the sorted names of the folder's entries
+func readDirNames(dirname string) ([]string, error) {
+	f, err := os.Open(dirname)
+	if nil != err {
+		return nil, err
+	}
+	names, err := f.Readdirnames(-1)
+	f.Close()
+	if nil != err {
+		return nil, err
+	}
+	sort.Strings(names)
+	return names, nil
+}
@@ -0,0 +1,90 @@
+package walk
+
+import (
+	"github.com/svetlyi/gdriveapp/config"
+	"github.com/svetlyi/gdriveapp/logger"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestWalk(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gdriveapp-walk-")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	l, err := logger.New("svetlyi_gdriveapp_test", 10000, 0, false)
+	if nil != err {
+		t.Fatal(err)
+	}
+	root := filepath.Join(dir, "My Drive")
+	if err = os.MkdirAll(filepath.Join(root, "docs"), 0755); nil != err {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(filepath.Join(root, "docs", "report.txt"), []byte("report"), 0644); nil != err {
+		t.Fatal(err)
+	}
+	if err = os.Mkdir(filepath.Join(dir, "outside"), 0755); nil != err {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(filepath.Join(dir, "outside", "notes.txt"), []byte("notes"), 0644); nil != err {
+		t.Fatal(err)
+	}
+	links := map[string]string{
+		"docs-link":         "docs",
+		"report-link.txt":   "docs/report.txt",
+		"broken-link":       "missing",
+		"docs/parent-link":  "..",
+		"docs/sibling-link": "../report-link.txt",
+		"outside-link":      "../outside",
+	}
+	for link, target := range links {
+		if err = os.Symlink(target, filepath.Join(root, link)); nil != err {
+			t.Fatal(err)
+		}
+	}
+
+	cases := map[string][]string{
+		config.SymlinksSkip: {"docs/", "docs/report.txt"},
+		config.SymlinksFollow: {
+			"docs/", "docs/report.txt", "docs/sibling-link",
+			"docs-link/", "docs-link/report.txt", "docs-link/sibling-link",
+			"report-link.txt",
+		},
+		config.SymlinksFollowAll: {
+			"docs/", "docs/report.txt", "docs/sibling-link",
+			"docs-link/", "docs-link/report.txt", "docs-link/sibling-link",
+			"outside-link/", "outside-link/notes.txt", "report-link.txt",
+		},
+		config.SymlinksStore: {
+			"broken-link", "docs/", "docs/parent-link", "docs/report.txt", "docs/sibling-link",
+			"docs-link", "outside-link", "report-link.txt",
+		},
+	}
+	for symlinks, expected := range cases {
+		var walked []string
+		err = Walk(root, root, symlinks, l, func(path string, info os.FileInfo, err error) error {
+			if nil != err {
+				return err
+			}
+			if path == root {
+				return nil
+			}
+			relativePath, _ := filepath.Rel(root, path)
+			if info.IsDir() {
+				relativePath += "/"
+			}
+			walked = append(walked, filepath.ToSlash(relativePath))
+			return nil
+		})
+		if nil != err {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(expected, walked) {
+			t.Errorf("%s: expected %v, got %v", symlinks, expected, walked)
+		}
+	}
+}
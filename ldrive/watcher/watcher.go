@@ -0,0 +1,14 @@
+// Package watcher reports changes in a local folder and all its subfolders.
+package watcher
+
+import "github.com/pkg/errors"
+
+// ErrOverflow means that some events were lost, so the whole folder
+// has to be checked for changes
+var ErrOverflow = errors.New("too many events, some of them were lost")
+
+// Event is a change of a file or a folder
+type Event struct {
+	Path  string
+	IsDir bool
+}
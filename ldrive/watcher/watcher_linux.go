@@ -0,0 +1,140 @@
+package watcher
+
+import (
+	"bytes"
+	"github.com/pkg/errors"
+	"github.com/svetlyi/gdriveapp/config"
+	"github.com/svetlyi/gdriveapp/contracts"
+	"github.com/svetlyi/gdriveapp/ldrive/walk"
+	"golang.org/x/sys/unix"
+	"os"
+	"path/filepath"
+	"sync"
+	"unsafe"
+)
+
+const watchMask = unix.IN_CREATE | unix.IN_MODIFY | unix.IN_CLOSE_WRITE | unix.IN_ATTRIB |
+	unix.IN_DELETE | unix.IN_MOVED_FROM | unix.IN_MOVED_TO | unix.IN_DELETE_SELF |
+	unix.IN_ONLYDIR | unix.IN_EXCL_UNLINK
+
+// Watcher watches a folder recursively with inotify. New subfolders
+// are watched as soon as they appear.
+type Watcher struct {
+	Events chan Event
+	Errors chan error
+
+	file *os.File
+	fd   int
+	log  contracts.Logger
+	// root is the watched folder
+	root string
+	// symlinks is the policy of the symbolic links: the folders the links point to
+	// are watched, if the links are followed
+	symlinks string
+	// mu guards watches, that maps watch descriptors to the watched folders
+	mu      sync.Mutex
+	watches map[int]string
+}
+
+func New(root string, symlinks string, log contracts.Logger) (*Watcher, error) {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not initialize inotify")
+	}
+	w := &Watcher{
+		Events:   make(chan Event, 1024),
+		Errors:   make(chan error, 1),
+		file:     os.NewFile(uintptr(fd), "inotify"),
+		fd:       fd,
+		log:      log,
+		root:     root,
+		symlinks: symlinks,
+		watches:  make(map[int]string),
+	}
+	if err = w.addRecursively(root); err != nil {
+		w.file.Close()
+		return nil, err
+	}
+	go w.readEvents()
+
+	return w, nil
+}
+
+// Close stops watching. Events and Errors channels are closed afterwards
+func (w *Watcher) Close() error {
+	return w.file.Close()
+}
+
+// addRecursively watches the folder and all its subfolders
+func (w *Watcher) addRecursively(root string) error {
+	return walk.Walk(root, w.root, w.symlinks, w.log, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) { // it has been removed in the meantime
+				return nil
+			}
+			return errors.Wrapf(err, "could not walk in path %s", path)
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		wd, err := unix.InotifyAddWatch(w.fd, path, watchMask)
+		if err != nil {
+			return errors.Wrapf(err, "could not watch %s", path)
+		}
+		w.mu.Lock()
+		w.watches[wd] = path
+		w.mu.Unlock()
+		return nil
+	})
+}
+
+func (w *Watcher) readEvents() {
+	defer close(w.Events)
+	defer close(w.Errors)
+
+	var buf [(unix.SizeofInotifyEvent + unix.NAME_MAX + 1) * 64]byte
+	for {
+		n, err := w.file.Read(buf[:])
+		if err != nil {
+			if !errors.Is(err, os.ErrClosed) {
+				w.Errors <- errors.Wrap(err, "could not read inotify events")
+			}
+			return
+		}
+		for offset := 0; offset+unix.SizeofInotifyEvent <= n; {
+			raw := (*unix.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameBytes := buf[offset+unix.SizeofInotifyEvent : offset+unix.SizeofInotifyEvent+int(raw.Len)]
+			offset += unix.SizeofInotifyEvent + int(raw.Len)
+			w.handleEvent(int(raw.Wd), raw.Mask, string(bytes.TrimRight(nameBytes, "\x00")))
+		}
+	}
+}
+
+func (w *Watcher) handleEvent(wd int, mask uint32, name string) {
+	if mask&unix.IN_Q_OVERFLOW != 0 {
+		select {
+		case w.Errors <- ErrOverflow:
+		default: // the overflow has already been reported
+		}
+		return
+	}
+	w.mu.Lock()
+	dir, ok := w.watches[wd]
+	if mask&unix.IN_IGNORED != 0 {
+		delete(w.watches, wd)
+	}
+	w.mu.Unlock()
+	if !ok || mask&unix.IN_IGNORED != 0 {
+		return
+	}
+
+	event := Event{Path: filepath.Join(dir, name), IsDir: mask&unix.IN_ISDIR != 0}
+	// a new link may point to a folder outside root, which is watched as well, if all the links are
+	// followed. With config.SymlinksFollow the links point to the folders inside root, that are watched already
+	if (event.IsDir || config.SymlinksFollowAll == w.symlinks) && mask&(unix.IN_CREATE|unix.IN_MOVED_TO) != 0 {
+		if err := w.addRecursively(event.Path); err != nil {
+			w.log.Error("could not watch a new folder", err)
+		}
+	}
+	w.Events <- event
+}
@@ -0,0 +1,79 @@
+package watcher
+
+import (
+	"github.com/svetlyi/gdriveapp/config"
+	"github.com/svetlyi/gdriveapp/logger"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// waitFor waits for the event about the path, skipping the rest
+func waitFor(t *testing.T, w *Watcher, path string, isDir bool) {
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case event, ok := <-w.Events:
+			if !ok {
+				t.Fatalf("the events are closed before %s", path)
+			}
+			if path == event.Path && isDir == event.IsDir {
+				return
+			}
+		case err := <-w.Errors:
+			t.Fatal(err)
+		case <-timeout:
+			t.Fatalf("no event about %s", path)
+		}
+	}
+}
+
+func TestWatcher(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gdriveapp-watcher-")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	l, err := logger.New("svetlyi_gdriveapp_test", 10000, 0, false)
+	if nil != err {
+		t.Fatal(err)
+	}
+	w, err := New(dir, config.SymlinksSkip, l)
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	// the new folders are watched as soon as they appear
+	folder := filepath.Join(dir, "docs")
+	if err = os.Mkdir(folder, 0755); nil != err {
+		t.Fatal(err)
+	}
+	waitFor(t, w, folder, true)
+	report := filepath.Join(folder, "report.txt")
+	if err = ioutil.WriteFile(report, []byte("report"), 0644); nil != err {
+		t.Fatal(err)
+	}
+	waitFor(t, w, report, false)
+	if err = os.Rename(report, filepath.Join(dir, "report.txt")); nil != err {
+		t.Fatal(err)
+	}
+	waitFor(t, w, report, false)
+	waitFor(t, w, filepath.Join(dir, "report.txt"), false)
+
+	if err = w.Close(); nil != err {
+		t.Fatal(err)
+	}
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case _, ok := <-w.Events:
+			if !ok {
+				return
+			}
+		case <-timeout:
+			t.Fatal("the events are not closed after the watcher is closed")
+		}
+	}
+}
@@ -0,0 +1,23 @@
+//go:build !linux
+// +build !linux
+
+package watcher
+
+import (
+	"github.com/pkg/errors"
+	"github.com/svetlyi/gdriveapp/contracts"
+)
+
+// Watcher is not implemented for the OS
+type Watcher struct {
+	Events chan Event
+	Errors chan error
+}
+
+func New(root string, symlinks string, log contracts.Logger) (*Watcher, error) {
+	return nil, errors.New("watching for local changes is supported only on Linux")
+}
+
+func (w *Watcher) Close() error {
+	return nil
+}
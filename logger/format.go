@@ -0,0 +1,71 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// formatText writes the record as a line like
+// "[2020-06-14T10:00:00+03:00] INFO download. new file, My Drive/a.txt file_id=1a2b"
+func formatText(w io.Writer, r record) error {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "[%s] %s %s", r.time.Format(time.RFC3339), strings.ToUpper(r.level), r.message)
+	if data, ok := r.fields["data"]; ok {
+		if "" != r.message {
+			buf.WriteString(", ")
+		}
+		fmt.Fprint(&buf, data)
+	}
+	for _, name := range sortedNames(r) {
+		if "data" != name {
+			fmt.Fprintf(&buf, " %s=%v", name, r.fields[name])
+		}
+	}
+	buf.WriteByte('\n')
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// formatJSON writes the record as a JSON object on one line: the time,
+// the level and the message go first, then the fields sorted by name
+func formatJSON(w io.Writer, r record) error {
+	var buf bytes.Buffer
+	buf.WriteString(`{"time":`)
+	writeJSONValue(&buf, r.time.Format(time.RFC3339Nano))
+	buf.WriteString(`,"level":`)
+	writeJSONValue(&buf, r.level)
+	buf.WriteString(`,"message":`)
+	writeJSONValue(&buf, r.message)
+	for _, name := range sortedNames(r) {
+		buf.WriteByte(',')
+		writeJSONValue(&buf, name)
+		buf.WriteByte(':')
+		writeJSONValue(&buf, r.fields[name])
+	}
+	buf.WriteString("}\n")
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// writeJSONValue writes the value as JSON or, if it cannot be encoded, as a string
+func writeJSONValue(buf *bytes.Buffer, value interface{}) {
+	encoded, err := json.Marshal(value)
+	if nil != err {
+		encoded, _ = json.Marshal(fmt.Sprintf("%+v", value))
+	}
+	buf.Write(encoded)
+}
+
+func sortedNames(r record) []string {
+	names := make([]string, 0, len(r.fields))
+	for name := range r.fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,80 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"github.com/pkg/errors"
+	"net"
+	"strings"
+)
+
+// journalSocket is where systemd-journald receives the records in its native protocol
+const journalSocket = "/run/systemd/journal/socket"
+
+// journal sends the records to the systemd journal. The fields become journal fields
+// in upper case, for example, FILE_ID, so that "journalctl FILE_ID=..." finds them
+type journal struct {
+	conn       *net.UnixConn
+	identifier string
+}
+
+func openJournal(identifier string) (*journal, error) {
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: journalSocket, Net: "unixgram"})
+	if nil != err {
+		return nil, errors.Wrap(err, "could not connect to systemd journal")
+	}
+	return &journal{conn: conn, identifier: identifier}, nil
+}
+
+func (j *journal) write(r record) error {
+	var buf bytes.Buffer
+	writeJournalField(&buf, "MESSAGE", r.message)
+	writeJournalField(&buf, "PRIORITY", journalPriority(r.level))
+	writeJournalField(&buf, "SYSLOG_IDENTIFIER", j.identifier)
+	for _, name := range sortedNames(r) {
+		writeJournalField(&buf, journalFieldName(name), fmt.Sprint(r.fields[name]))
+	}
+	_, err := j.conn.Write(buf.Bytes())
+	return err
+}
+
+// writeJournalField writes the field as "NAME=value" or, if the value has several
+// lines, as the name, the length of the value and the value
+func writeJournalField(buf *bytes.Buffer, name string, value string) {
+	buf.WriteString(name)
+	if strings.Contains(value, "\n") {
+		buf.WriteByte('\n')
+		binary.Write(buf, binary.LittleEndian, uint64(len(value)))
+	} else {
+		buf.WriteByte('=')
+	}
+	buf.WriteString(value)
+	buf.WriteByte('\n')
+}
+
+// journalFieldName makes the name of a journal field: just upper case letters, digits and underscores
+func journalFieldName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+// journalPriority returns the syslog priority of the level
+func journalPriority(level string) string {
+	switch level {
+	case "debug":
+		return "7"
+	case "info":
+		return "6"
+	case "warning":
+		return "4"
+	}
+	return "3"
+}
@@ -3,86 +3,198 @@ package logger
 import (
 	"fmt"
 	"github.com/pkg/errors"
+	"github.com/svetlyi/gdriveapp/config"
 	"github.com/svetlyi/gdriveapp/contracts"
-	"log"
+	"io"
 	"os"
 	"path/filepath"
+	"sync"
 	"time"
 )
 
-var logPath string
+// Options are the settings of the logger
+type Options struct {
+	// Path is the log file. Empty means appName.log in the temporary dir of the OS
+	Path string
+	// MaxSize is the size of the log file it is rotated after. MaxFiles is how many
+	// compressed old files are kept
+	MaxSize  int64
+	MaxFiles int
+	// Format is config.LogFormatText or config.LogFormatJSON
+	Format string
+	// Output is one of config.LogOutputFile, config.LogOutputStderr and config.LogOutputJournal
+	Output        string
+	Verbosity     uint8
+	AlsoUseStdout bool
+}
 
 type Logger struct {
-	appName        string
-	logFileMaxSize int64
-	verbosity      uint8
-	alsoUseStdout  bool
+	verbosity uint8
+	// out is shared by the loggers with different fields
+	out    *output
+	fields contracts.Fields
+}
+
+// output writes the records to the sink one by one
+type output struct {
+	mu            sync.Mutex
+	sink          sink
+	alsoUseStdout bool
+}
+
+// sink is where the records go
+type sink interface {
+	write(r record) error
+}
+
+type record struct {
+	time    time.Time
+	level   string
+	message string
+	fields  contracts.Fields
 }
 
+// New creates the logger writing text records to appName.log in the temporary dir
 func New(appName string, logFileMaxSize int64, verbosity uint8, alsoUseStdout bool) (Logger, error) {
-	l := Logger{appName, logFileMaxSize, verbosity, alsoUseStdout}
-	logPath = filepath.Join(os.TempDir(), appName+".log")
-	if stat, err := os.Stat(logPath); nil == err {
-		if stat.Size() > logFileMaxSize {
-			if err = os.Remove(logPath); nil != err {
-				return Logger{}, errors.Wrapf(err, "could not remove log file %s", logPath)
-			}
+	return Open(appName, Options{
+		MaxSize:       logFileMaxSize,
+		Format:        config.LogFormatText,
+		Output:        config.LogOutputFile,
+		Verbosity:     verbosity,
+		AlsoUseStdout: alsoUseStdout,
+	})
+}
+
+// NewFromConfig creates the logger with the log settings of the configuration
+func NewFromConfig(appName string, cfg config.Cfg, alsoUseStdout bool) (Logger, error) {
+	return Open(appName, Options{
+		Path:          cfg.LogPath,
+		MaxSize:       cfg.LogFileMaxSize,
+		MaxFiles:      int(cfg.LogMaxFiles),
+		Format:        cfg.LogFormat,
+		Output:        cfg.LogOutput,
+		Verbosity:     uint8(cfg.LogVerbosity),
+		AlsoUseStdout: alsoUseStdout,
+	})
+}
+
+// Open creates the logger writing the records to the log file, stderr or the systemd journal
+func Open(appName string, opts Options) (Logger, error) {
+	var s sink
+	var logPath string
+	switch opts.Output {
+	case config.LogOutputJournal:
+		j, err := openJournal(appName)
+		if nil != err {
+			return Logger{}, err
+		}
+		s = j
+	case config.LogOutputStderr:
+		s = newWriterSink(os.Stderr, opts.Format)
+	default:
+		logPath = opts.Path
+		if "" == logPath {
+			logPath = filepath.Join(os.TempDir(), appName+".log")
+		}
+		f, err := openRotatingFile(logPath, opts.MaxSize, opts.MaxFiles)
+		if nil != err {
+			return Logger{}, errors.Wrapf(err, "could not open log file %s", logPath)
 		}
-	} else if !os.IsNotExist(err) {
-		return Logger{}, errors.Wrapf(err, "could not open log file %s", logPath)
+		s = newWriterSink(f, opts.Format)
+	}
+	l := Logger{
+		verbosity: opts.Verbosity,
+		out:       &output{sink: s, alsoUseStdout: opts.AlsoUseStdout},
+	}
+	if "" != logPath {
+		l.Info("log file location", logPath)
 	}
-	l.Info("log file location", logPath)
 	return l, nil
 }
 
 func (l Logger) Debug(v ...interface{}) {
 	if l.verbosity >= contracts.LogDebugLevel {
-		l.log(v...)
+		l.log("debug", v...)
 	}
 }
 func (l Logger) Info(v ...interface{}) {
 	if l.verbosity >= contracts.LogInfoLevel {
-		l.log(v...)
+		l.log("info", v...)
 	}
 }
 func (l Logger) Warning(v ...interface{}) {
 	if l.verbosity >= contracts.LogWarningLevel {
-		l.log(v...)
+		l.log("warning", v...)
 	}
 }
 func (l Logger) Error(v ...interface{}) {
-	l.log(v...)
+	l.log("error", v...)
 }
 
-func (l Logger) log(v ...interface{}) {
-	var msg string
-	isFirstArgString := "string" == fmt.Sprintf("%T", v[0])
+func (l Logger) With(fields contracts.Fields) contracts.Logger {
+	merged := make(contracts.Fields, len(l.fields)+len(fields))
+	for name, value := range l.fields {
+		merged[name] = value
+	}
+	for name, value := range fields {
+		merged[name] = value
+	}
+	l.fields = merged
+	return l
+}
 
-	timeString := time.Now().Format(time.RFC3339)
-	switch {
-	case isFirstArgString && len(v[1:]) > 0:
-		msg = fmt.Sprintf("[%s] %s, %+v", timeString, v[0], v[1:])
-	case isFirstArgString:
-		msg = fmt.Sprintf("[%s] %s", timeString, v[0])
+// log makes a record of the arguments: the first one is the message, if it is a string,
+// an error goes to the error field and the rest goes to the data field as it is printed
+func (l Logger) log(level string, v ...interface{}) {
+	r := record{time: time.Now(), level: level, fields: make(contracts.Fields, len(l.fields)+2)}
+	for name, value := range l.fields {
+		r.fields[name] = value
+	}
+	if len(v) > 0 {
+		switch message := v[0].(type) {
+		case string:
+			r.message, v = message, v[1:]
+		}
+	}
+	var data []interface{}
+	for _, arg := range v {
+		if err, ok := arg.(error); ok {
+			r.fields["error"] = err.Error()
+		} else {
+			data = append(data, arg)
+		}
+	}
+	switch len(data) {
+	case 0:
+	case 1:
+		r.fields["data"] = fmt.Sprintf("%+v", data[0])
 	default:
-		msg = fmt.Sprintf("[%s] %+v", timeString, v)
+		r.fields["data"] = fmt.Sprintf("%+v", data)
 	}
 
-	if l.alsoUseStdout {
-		fmt.Println(msg)
+	l.out.mu.Lock()
+	defer l.out.mu.Unlock()
+	if l.out.alsoUseStdout {
+		formatText(os.Stdout, r)
 	}
-	f := l.getLogFile()
-	defer f.Close()
-	_, err := f.WriteString(msg + "\n")
-	if err != nil {
-		log.Fatal(err)
+	if err := l.out.sink.write(r); nil != err {
+		fmt.Fprintln(os.Stderr, "could not write log:", err)
 	}
 }
 
-func (l Logger) getLogFile() *os.File {
-	file, err := os.OpenFile(logPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if nil != err {
-		log.Fatal(err)
+// writerSink writes the formatted records to the writer
+type writerSink struct {
+	w      io.Writer
+	format func(w io.Writer, r record) error
+}
+
+func newWriterSink(w io.Writer, format string) writerSink {
+	if config.LogFormatJSON == format {
+		return writerSink{w, formatJSON}
 	}
-	return file
+	return writerSink{w, formatText}
+}
+
+func (s writerSink) write(r record) error {
+	return s.format(s.w, r)
 }
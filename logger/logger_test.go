@@ -0,0 +1,161 @@
+package logger
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"github.com/svetlyi/gdriveapp/config"
+	"github.com/svetlyi/gdriveapp/contracts"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestJSONFormatWithFields(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "gdriveapp-logger-")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	logPath := filepath.Join(tmp, "app.log")
+	l, err := Open("app", Options{
+		Path:      logPath,
+		MaxSize:   1e6,
+		Format:    config.LogFormatJSON,
+		Output:    config.LogOutputFile,
+		Verbosity: contracts.LogInfoLevel,
+	})
+	if nil != err {
+		t.Fatal(err)
+	}
+	l.With(contracts.Fields{contracts.FieldFileId: "1a2b"}).
+		With(contracts.Fields{contracts.FieldPath: "My Drive/a.txt"}).
+		Error("could not download", errors.New("not found"))
+	l.Debug("not logged with the info verbosity")
+
+	f, err := os.Open(logPath)
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var records []map[string]interface{}
+	for scanner := bufio.NewScanner(f); scanner.Scan(); {
+		var r map[string]interface{}
+		if err = json.Unmarshal(scanner.Bytes(), &r); nil != err {
+			t.Fatalf("%s is not JSON: %v", scanner.Text(), err)
+		}
+		records = append(records, r)
+	}
+	// the first record is the location of the log file
+	if 2 != len(records) {
+		t.Fatalf("expected 2 records, got %v", records)
+	}
+	expected := map[string]string{
+		"level":   "error",
+		"message": "could not download",
+		"error":   "not found",
+		"file_id": "1a2b",
+		"path":    "My Drive/a.txt",
+	}
+	for name, value := range expected {
+		if records[1][name] != value {
+			t.Errorf("expected %s to be %q, got %v", name, value, records[1][name])
+		}
+	}
+	if _, ok := records[1]["time"]; !ok {
+		t.Error("time is missing")
+	}
+}
+
+func TestRecordWithoutMessage(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "gdriveapp-logger-")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	logPath := filepath.Join(tmp, "app.log")
+	l, err := Open("app", Options{Path: logPath, MaxSize: 1e6, Format: config.LogFormatJSON, Output: config.LogOutputFile})
+	if nil != err {
+		t.Fatal(err)
+	}
+	l.Error()
+	l.Error(errors.New("not found"))
+	l.Error(42, "answer")
+
+	content, err := ioutil.ReadFile(logPath)
+	if nil != err {
+		t.Fatal(err)
+	}
+	var records []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(string(content)), "\n") {
+		var r map[string]interface{}
+		if err = json.Unmarshal([]byte(line), &r); nil != err {
+			t.Fatalf("%s is not JSON: %v", line, err)
+		}
+		records = append(records, r)
+	}
+	if 3 != len(records) {
+		t.Fatalf("expected 3 records, got %v", records)
+	}
+	if "not found" != records[1]["error"] {
+		t.Errorf("expected the error, got %v", records[1])
+	}
+	if "[42 answer]" != records[2]["data"] {
+		t.Errorf("expected the values in data, got %v", records[2])
+	}
+	for _, r := range records {
+		if message, ok := r["message"]; ok && "" != message {
+			t.Errorf("unexpected message in %v", r)
+		}
+	}
+}
+
+func TestRotation(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "gdriveapp-logger-")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	logPath := filepath.Join(tmp, "app.log")
+	f, err := openRotatingFile(logPath, 10, 2)
+	if nil != err {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		if _, err = f.Write([]byte(line)); nil != err {
+			t.Fatal(err)
+		}
+	}
+	assertFile(t, logPath, false, "fourth\n")
+	assertFile(t, logPath+".1.gz", true, "third\n")
+	assertFile(t, logPath+".2.gz", true, "second\n")
+	if _, err = os.Stat(logPath + ".3.gz"); !os.IsNotExist(err) {
+		t.Error("just 2 old files must be kept")
+	}
+}
+
+func assertFile(t *testing.T, path string, compressed bool, expected string) {
+	t.Helper()
+	f, err := os.Open(path)
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var r io.Reader = f
+	if compressed {
+		if r, err = gzip.NewReader(f); nil != err {
+			t.Fatal(err)
+		}
+	}
+	content, err := ioutil.ReadAll(r)
+	if nil != err {
+		t.Fatal(err)
+	}
+	if expected != string(content) {
+		t.Errorf("expected %q in %s, got %q", expected, path, content)
+	}
+}
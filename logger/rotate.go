@@ -0,0 +1,106 @@
+package logger
+
+import (
+	"compress/gzip"
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"os"
+)
+
+// rotatingFile is the log file, which is compressed and moved to <path>.1.gz, when it
+// gets bigger than maxSize. The older files are shifted to <path>.2.gz and so on,
+// just maxFiles of them are kept
+type rotatingFile struct {
+	path     string
+	maxSize  int64
+	maxFiles int
+	file     *os.File
+	size     int64
+}
+
+func openRotatingFile(path string, maxSize int64, maxFiles int) (*rotatingFile, error) {
+	f := &rotatingFile{path: path, maxSize: maxSize, maxFiles: maxFiles}
+	if err := f.open(); nil != err {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *rotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if nil != err {
+		return err
+	}
+	stat, err := file.Stat()
+	if nil != err {
+		file.Close()
+		return err
+	}
+	f.file, f.size = file, stat.Size()
+	return nil
+}
+
+// Write writes the record, which must be written at once, so that it is not
+// split between the files
+func (f *rotatingFile) Write(p []byte) (int, error) {
+	if f.maxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.maxSize {
+		if err := f.rotate(); nil != err {
+			return 0, errors.Wrap(err, "could not rotate log file")
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+func (f *rotatingFile) rotate() error {
+	if err := f.file.Close(); nil != err {
+		return err
+	}
+	if f.maxFiles > 0 {
+		if err := os.Remove(f.oldPath(f.maxFiles)); nil != err && !os.IsNotExist(err) {
+			return err
+		}
+		for i := f.maxFiles - 1; i > 0; i-- {
+			if err := os.Rename(f.oldPath(i), f.oldPath(i+1)); nil != err && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		if err := compress(f.path, f.oldPath(1)); nil != err {
+			return err
+		}
+	}
+	if err := os.Remove(f.path); nil != err && !os.IsNotExist(err) {
+		return err
+	}
+	return f.open()
+}
+
+func (f *rotatingFile) oldPath(i int) string {
+	return fmt.Sprintf("%s.%d.gz", f.path, i)
+}
+
+func compress(srcPath string, dstPath string) error {
+	src, err := os.Open(srcPath)
+	if nil != err {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.Create(dstPath)
+	if nil != err {
+		return err
+	}
+	zw := gzip.NewWriter(dst)
+	_, err = io.Copy(zw, src)
+	if closeErr := zw.Close(); nil == err {
+		err = closeErr
+	}
+	if closeErr := dst.Close(); nil == err {
+		err = closeErr
+	}
+	if nil != err {
+		os.Remove(dstPath)
+	}
+	return err
+}
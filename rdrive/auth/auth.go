@@ -2,44 +2,54 @@ package auth
 
 import (
 	"bufio"
-	"encoding/json"
 	"fmt"
 	"github.com/pkg/errors"
+	"github.com/svetlyi/gdriveapp/contracts"
 	"golang.org/x/net/context"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
 	"google.golang.org/api/drive/v3"
 	"io/ioutil"
 	"os"
-	"path/filepath"
 )
 
+const tokenFileName = "token.json"
+
 // GetTokenSource retrieves a token, saves the token, then returns the generated client.
-func GetTokenSource(configDirPath string) (oauth2.TokenSource, error) {
-	// The file token.json stores the user's access and refresh tokens, and is
-	// created automatically when the authorization flow completes for the first
+// The credentials of the OAuth client are read from credsFilePath. If there is no token
+// yet, the user is asked to authorize the application with the flow. The refreshed tokens,
+// that could not be saved, are logged
+func GetTokenSource(store *TokenStore, credsFilePath string, flow Flow, log contracts.Logger) (oauth2.TokenSource, error) {
+	// The store keeps the user's access and refresh tokens. The token is
+	// saved automatically when the authorization flow completes for the first
 	// time.
-	tokFile := filepath.Join(configDirPath, "token.json")
-	cfg, err := readCredsConfig(configDirPath)
+	cfg, err := readCredsConfig(credsFilePath)
 	if nil != err {
 		return nil, errors.Wrap(err, "could not read config with credentials")
 	}
-	tok, err := tokenFromFile(tokFile)
-	if err != nil { // if could not read token from file, create it
-		tok, err = getTokenFromWeb(cfg)
+	tok, err := store.Read()
+	if os.IsNotExist(err) { // if there is no token yet, create it
+		tok, err = newAuthorizer(cfg).getToken(context.Background(), flow)
 		if nil != err {
 			return nil, errors.Wrap(err, "could not get token from web")
 		}
-		if err = saveToken(tokFile, tok); nil != err {
+		fmt.Printf("Saving credential file to: %s\n", store.path)
+		if err = store.Save(tok); nil != err {
 			return nil, err
 		}
+	} else if nil != err {
+		return nil, err
 	}
 
-	return cfg.TokenSource(context.Background(), tok), nil
+	return &persistingTokenSource{
+		src:   cfg.TokenSource(context.Background(), tok),
+		store: store,
+		log:   log,
+		last:  *tok,
+	}, nil
 }
 
-func readCredsConfig(configDirPath string) (*oauth2.Config, error) {
-	credsFilePath := filepath.Join(configDirPath, "credentials.json")
+func readCredsConfig(credsFilePath string) (*oauth2.Config, error) {
 	var (
 		b   []byte
 		err error
@@ -58,44 +68,3 @@ func readCredsConfig(configDirPath string) (*oauth2.Config, error) {
 
 	return config, nil
 }
-
-// getTokenFromWeb requests a token from the web, then returns the retrieved token.
-func getTokenFromWeb(config *oauth2.Config) (*oauth2.Token, error) {
-	authURL := config.AuthCodeURL("state-token", oauth2.AccessTypeOffline)
-	fmt.Printf("Go to the following link in your browser then type the "+
-		"authorization code: \n%v\n", authURL)
-
-	var authCode string
-	if _, err := fmt.Scan(&authCode); err != nil {
-		return nil, errors.Wrap(err, "unable to read authorization code")
-	}
-
-	tok, err := config.Exchange(context.TODO(), authCode)
-	if err != nil {
-		return nil, errors.Wrap(err, "unable to retrieve token from web")
-	}
-	return tok, nil
-}
-
-// tokenFromFile retrieves a token from a local file.
-func tokenFromFile(file string) (*oauth2.Token, error) {
-	f, err := os.Open(file)
-	if err != nil {
-		return nil, err
-	}
-	defer f.Close()
-	tok := &oauth2.Token{}
-	err = json.NewDecoder(f).Decode(tok)
-	return tok, err
-}
-
-// saveToken saves a token to a file path.
-func saveToken(path string, token *oauth2.Token) error {
-	fmt.Printf("Saving credential file to: %s\n", path)
-	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
-	if err != nil {
-		return errors.Wrapf(err, "unable to save oauth token to %s", path)
-	}
-	defer f.Close()
-	return json.NewEncoder(f).Encode(token)
-}
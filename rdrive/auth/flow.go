@@ -0,0 +1,337 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+	"golang.org/x/oauth2"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// Flow is how the user authorizes the application
+type Flow string
+
+const (
+	// FlowAuto is the loopback flow, if a browser can be opened, and the device flow otherwise
+	FlowAuto Flow = ""
+	// FlowLoopback opens the page of Google in the browser, which redirects to a local server
+	// with the authorization code
+	FlowLoopback Flow = "loopback"
+	// FlowManual prints the link to open in a browser on any device. The address the browser is
+	// redirected to is pasted back, for servers without a browser (for example, over SSH)
+	FlowManual Flow = "manual"
+	// FlowDevice shows a code to enter on another device, for servers without a browser
+	FlowDevice Flow = "device"
+)
+
+// authTimeout is how long the user has to authorize the application
+const authTimeout = 5 * time.Minute
+
+const deviceCodeURL = "https://oauth2.googleapis.com/device/code"
+
+// defaultPollInterval is how often the token is asked for in the device flow, if Google does not say
+const defaultPollInterval = 5 * time.Second
+
+// authorizer gets a token from Google with the user's consent
+type authorizer struct {
+	cfg           *oauth2.Config
+	in            io.Reader
+	out           io.Writer
+	openBrowser   func(url string) error
+	deviceCodeURL string
+}
+
+func newAuthorizer(cfg *oauth2.Config) authorizer {
+	return authorizer{cfg: cfg, in: os.Stdin, out: os.Stdout, openBrowser: openBrowser, deviceCodeURL: deviceCodeURL}
+}
+
+func (a authorizer) getToken(ctx context.Context, flow Flow) (*oauth2.Token, error) {
+	if FlowDevice == flow || (FlowAuto == flow && !canOpenBrowser()) {
+		return a.device(ctx)
+	}
+	return a.loopback(ctx, FlowManual == flow)
+}
+
+// loopback starts a server on 127.0.0.1, which Google redirects the browser to with the
+// authorization code. The code is bound to the request with PKCE and a random state.
+// In the manual flow the browser is not opened and it may run on another device, where
+// the redirect fails, so the address it is redirected to is also read from the input
+func (a authorizer) loopback(ctx context.Context, manual bool) (*oauth2.Token, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if nil != err {
+		return nil, errors.Wrap(err, "could not start local server for authorization")
+	}
+	defer listener.Close()
+	cfg := *a.cfg
+	cfg.RedirectURL = "http://" + listener.Addr().String()
+
+	state, err := randomString(16)
+	if nil != err {
+		return nil, err
+	}
+	verifier, err := randomString(32)
+	if nil != err {
+		return nil, err
+	}
+	challenge := sha256.Sum256([]byte(verifier))
+	authURL := cfg.AuthCodeURL(
+		state,
+		oauth2.AccessTypeOffline,
+		oauth2.SetAuthURLParam("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:])),
+		oauth2.SetAuthURLParam("code_challenge_method", "S256"),
+	)
+
+	results := make(chan authResult, 1)
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if "/" != r.URL.Path {
+			http.NotFound(w, r)
+			return
+		}
+		res := parseRedirect(r.URL.Query(), state)
+		if nil != res.err {
+			http.Error(w, res.err.Error(), http.StatusBadRequest)
+		} else {
+			fmt.Fprintln(w, "The application is authorized, the page can be closed now.")
+		}
+		select {
+		case results <- res:
+		default:
+		}
+	})}
+	go server.Serve(listener)
+	defer server.Close()
+	// the reading of the pasted address stops, when the token is received another way
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	if manual {
+		fmt.Fprintf(
+			a.out,
+			"Go to the following link in a browser on any device:\n%v\n"+
+				"After the access is granted, the browser is redirected to %s, which may fail to load. "+
+				"Copy the whole address from the address bar and paste it here:\n",
+			authURL,
+			cfg.RedirectURL,
+		)
+		go a.readRedirect(ctx, cfg.RedirectURL, state, results)
+	} else {
+		fmt.Fprintf(a.out, "Go to the following link in your browser, if it has not opened by itself:\n%v\n", authURL)
+		a.openBrowser(authURL)
+	}
+
+	var res authResult
+	select {
+	case res = <-results:
+	case <-time.After(authTimeout):
+		return nil, errors.New("authorization timed out")
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if nil != res.err {
+		return nil, res.err
+	}
+	tok, err := cfg.Exchange(ctx, res.code, oauth2.SetAuthURLParam("code_verifier", verifier))
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to retrieve token from web")
+	}
+	return tok, nil
+}
+
+// authResult is the authorization code the browser is redirected with or the error
+type authResult struct {
+	code string
+	err  error
+}
+
+// parseRedirect gets the authorization code from the query of the address the browser is redirected to
+func parseRedirect(query url.Values, state string) authResult {
+	switch {
+	case state != query.Get("state"):
+		return authResult{err: errors.New("wrong state in authorization response")}
+	case "" != query.Get("error"):
+		return authResult{err: errors.Errorf("authorization failed: %s", query.Get("error"))}
+	}
+	return authResult{code: query.Get("code")}
+}
+
+// readRedirect reads the addresses the user pastes, until one of them is the address
+// the browser was redirected to or the context is done
+func (a authorizer) readRedirect(ctx context.Context, redirectURL string, state string, results chan<- authResult) {
+	lines := readLines(ctx, a.in)
+	for {
+		var line string
+		select {
+		case <-ctx.Done():
+			return
+		case l, ok := <-lines:
+			if !ok {
+				return
+			}
+			line = strings.TrimSpace(l)
+		}
+		if "" == line {
+			continue
+		}
+		u, err := url.Parse(line)
+		if nil != err || !strings.HasPrefix(line, redirectURL) || ("" == u.Query().Get("code") && "" == u.Query().Get("error")) {
+			fmt.Fprintf(a.out, "It is not the address starting with %s, try again:\n", redirectURL)
+			continue
+		}
+		select {
+		case results <- parseRedirect(u.Query(), state):
+		default:
+		}
+		return
+	}
+}
+
+// readLines sends the lines of the reader to the channel, which is closed at the end of the reader.
+// A read in progress cannot be interrupted, so the reading stops at the next line after the context is done
+func readLines(ctx context.Context, r io.Reader) <-chan string {
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			select {
+			case lines <- scanner.Text():
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return lines
+}
+
+// deviceCode is the answer of Google to the start of the device flow
+type deviceCode struct {
+	DeviceCode      string `json:"device_code"`
+	UserCode        string `json:"user_code"`
+	VerificationURL string `json:"verification_url"`
+	ExpiresIn       int64  `json:"expires_in"`
+	Interval        int64  `json:"interval"`
+}
+
+// deviceToken is the answer of Google to a poll in the device flow
+type deviceToken struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int64  `json:"expires_in"`
+	Error        string `json:"error"`
+}
+
+// device shows the code the user enters on another device and waits until the access is granted
+func (a authorizer) device(ctx context.Context) (*oauth2.Token, error) {
+	var code deviceCode
+	err := postForm(ctx, a.deviceCodeURL, url.Values{
+		"client_id": {a.cfg.ClientID},
+		"scope":     {strings.Join(a.cfg.Scopes, " ")},
+	}, &code)
+	if nil != err {
+		return nil, errors.Wrap(err, "could not start device authorization")
+	}
+	fmt.Fprintf(a.out, "Go to %s on any device and enter the code %s\n", code.VerificationURL, code.UserCode)
+
+	interval := defaultPollInterval
+	if code.Interval > 0 {
+		interval = time.Duration(code.Interval) * time.Second
+	}
+	deadline := time.Now().Add(time.Duration(code.ExpiresIn) * time.Second)
+	for time.Now().Before(deadline) {
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		var tok deviceToken
+		err = postForm(ctx, a.cfg.Endpoint.TokenURL, url.Values{
+			"client_id":     {a.cfg.ClientID},
+			"client_secret": {a.cfg.ClientSecret},
+			"device_code":   {code.DeviceCode},
+			"grant_type":    {"urn:ietf:params:oauth:grant-type:device_code"},
+		}, &tok)
+		switch {
+		case "authorization_pending" == tok.Error:
+		case "slow_down" == tok.Error:
+			interval += defaultPollInterval
+		case "" != tok.Error:
+			return nil, errors.Errorf("device authorization failed: %s", tok.Error)
+		case nil != err:
+			return nil, errors.Wrap(err, "could not get token")
+		default:
+			return &oauth2.Token{
+				AccessToken:  tok.AccessToken,
+				TokenType:    tok.TokenType,
+				RefreshToken: tok.RefreshToken,
+				Expiry:       time.Now().Add(time.Duration(tok.ExpiresIn) * time.Second),
+			}, nil
+		}
+	}
+	return nil, errors.New("device code expired")
+}
+
+// postForm posts the form and decodes the JSON answer to v. The answer is decoded
+// even when the status is an error, as the OAuth errors are described in it
+func postForm(ctx context.Context, endpoint string, form url.Values, v interface{}) error {
+	req, err := http.NewRequest(http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if nil != err {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if nil != err {
+		return err
+	}
+	defer resp.Body.Close()
+	if err = json.NewDecoder(resp.Body).Decode(v); nil != err {
+		return errors.Wrapf(err, "could not decode answer with status %s", resp.Status)
+	}
+	if resp.StatusCode >= 300 {
+		return errors.Errorf("got status %s", resp.Status)
+	}
+	return nil
+}
+
+func randomString(size int) (string, error) {
+	b := make([]byte, size)
+	if _, err := rand.Read(b); nil != err {
+		return "", errors.Wrap(err, "could not generate random string")
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// canOpenBrowser says if there is a graphical session a browser can be opened in
+func canOpenBrowser() bool {
+	switch runtime.GOOS {
+	case "darwin", "windows":
+		return true
+	}
+	return "" != os.Getenv("DISPLAY") || "" != os.Getenv("WAYLAND_DISPLAY")
+}
+
+func openBrowser(url string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", url)
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", url)
+	default:
+		cmd = exec.Command("xdg-open", url)
+	}
+	return cmd.Start()
+}
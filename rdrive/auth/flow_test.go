@@ -0,0 +1,200 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"golang.org/x/net/context"
+	"golang.org/x/oauth2"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTokenServer starts the token endpoint, that gives the token for "the-code" requested
+// with the verifier of the challenge
+func newTokenServer(challenge *string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		verifier := sha256.Sum256([]byte(r.Form.Get("code_verifier")))
+		if "the-code" != r.Form.Get("code") || *challenge != base64.RawURLEncoding.EncodeToString(verifier[:]) {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"access_token": "access", "refresh_token": "refresh", "expires_in": 3600})
+	}))
+}
+
+// writerFunc passes everything written to the function
+type writerFunc func(p []byte)
+
+func (f writerFunc) Write(p []byte) (int, error) {
+	f(p)
+	return len(p), nil
+}
+
+func TestLoopback(t *testing.T) {
+	var challenge string
+	tokenServer := newTokenServer(&challenge)
+	defer tokenServer.Close()
+
+	cases := []struct {
+		name       string
+		wrongState bool
+	}{
+		{"success", false},
+		{"wrong state", true},
+	}
+	for _, c := range cases {
+		a := authorizer{
+			cfg: &oauth2.Config{
+				ClientID: "client",
+				Endpoint: oauth2.Endpoint{AuthURL: "https://accounts.example.com/auth", TokenURL: tokenServer.URL},
+			},
+			out: &bytes.Buffer{},
+			// the browser is redirected back right away
+			openBrowser: func(authURL string) error {
+				u, err := url.Parse(authURL)
+				if nil != err {
+					return err
+				}
+				query := u.Query()
+				challenge = query.Get("code_challenge")
+				state := query.Get("state")
+				if c.wrongState {
+					state = "forged"
+				}
+				go http.Get(query.Get("redirect_uri") + "?" + url.Values{"code": {"the-code"}, "state": {state}}.Encode())
+				return nil
+			},
+		}
+		tok, err := a.loopback(context.Background(), false)
+		if c.wrongState {
+			if nil == err {
+				t.Errorf("%s: the code with a wrong state must be rejected", c.name)
+			}
+			continue
+		}
+		if nil != err {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if "refresh" != tok.RefreshToken {
+			t.Errorf("%s: unexpected token %+v", c.name, tok)
+		}
+	}
+}
+
+func TestManual(t *testing.T) {
+	var challenge string
+	tokenServer := newTokenServer(&challenge)
+	defer tokenServer.Close()
+	in, paste := io.Pipe()
+	defer paste.Close()
+
+	askedAgain := false
+	a := authorizer{
+		cfg: &oauth2.Config{
+			ClientID: "client",
+			Endpoint: oauth2.Endpoint{AuthURL: "https://accounts.example.com/auth", TokenURL: tokenServer.URL},
+		},
+		in: in,
+		// the user opens the link on another device and pastes something wrong first,
+		// then the address the browser is redirected to
+		out: writerFunc(func(p []byte) {
+			if bytes.Contains(p, []byte("try again")) {
+				askedAgain = true
+			}
+			for _, field := range strings.Fields(string(p)) {
+				if !strings.HasPrefix(field, "https://accounts.example.com/auth?") {
+					continue
+				}
+				u, err := url.Parse(field)
+				if nil != err {
+					t.Error(err)
+					return
+				}
+				query := u.Query()
+				challenge = query.Get("code_challenge")
+				redirect := query.Get("redirect_uri") + "/?" + url.Values{"code": {"the-code"}, "state": {query.Get("state")}}.Encode()
+				go fmt.Fprintf(paste, "the-code\n%s\n", redirect)
+			}
+		}),
+		openBrowser: func(string) error {
+			t.Error("the browser must not be opened in the manual flow")
+			return nil
+		},
+	}
+	tok, err := a.loopback(context.Background(), true)
+	if nil != err {
+		t.Fatal(err)
+	}
+	if "refresh" != tok.RefreshToken {
+		t.Errorf("unexpected token %+v", tok)
+	}
+	if !askedAgain {
+		t.Error("the wrong address must be asked again")
+	}
+}
+
+func TestReadRedirectStops(t *testing.T) {
+	in, paste := io.Pipe()
+	defer paste.Close()
+	a := authorizer{in: in, out: &bytes.Buffer{}}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		a.readRedirect(ctx, "http://127.0.0.1:1", "state", make(chan authResult, 1))
+		close(done)
+	}()
+	// the token is received by the server, nothing is pasted
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("the reading of the redirect must stop with the context")
+	}
+}
+
+func TestDevice(t *testing.T) {
+	polls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		w.Header().Set("Content-Type", "application/json")
+		if "/device/code" == r.URL.Path {
+			json.NewEncoder(w).Encode(deviceCode{DeviceCode: "device", UserCode: "ABCD-EFGH", VerificationURL: "https://example.com/device", ExpiresIn: 60, Interval: 1})
+			return
+		}
+		polls++
+		if 1 == polls {
+			w.WriteHeader(http.StatusPreconditionRequired)
+			json.NewEncoder(w).Encode(deviceToken{Error: "authorization_pending"})
+			return
+		}
+		json.NewEncoder(w).Encode(deviceToken{AccessToken: "access", RefreshToken: "refresh", ExpiresIn: 3600})
+	}))
+	defer server.Close()
+
+	out := &bytes.Buffer{}
+	a := authorizer{
+		cfg:           &oauth2.Config{ClientID: "client", Endpoint: oauth2.Endpoint{TokenURL: server.URL + "/token"}},
+		out:           out,
+		deviceCodeURL: server.URL + "/device/code",
+	}
+	tok, err := a.device(context.Background())
+	if nil != err {
+		t.Fatal(err)
+	}
+	if "refresh" != tok.RefreshToken || 2 != polls {
+		t.Errorf("unexpected token %+v after %d polls", tok, polls)
+	}
+	if !bytes.Contains(out.Bytes(), []byte("ABCD-EFGH")) {
+		t.Errorf("the user code is not shown: %s", out)
+	}
+}
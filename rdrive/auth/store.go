@@ -0,0 +1,233 @@
+package auth
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/oauth2"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const (
+	// passphraseIterations makes guessing the passphrase slow
+	passphraseIterations = 600000
+	// keyFileIterations is just one, as the content of a key file is random
+	keyFileIterations = 1
+	saltSize          = 16
+	keySize           = 32
+)
+
+// TokenStore keeps the token in token.json in the config dir. If the store has a secret
+// (a passphrase or the content of a key file), the token is encrypted with AES-256-GCM
+// with a key derived from the secret with PBKDF2-HMAC-SHA256
+type TokenStore struct {
+	path string
+	// getSecret returns the secret. Nil means the token is not encrypted
+	getSecret  func() ([]byte, error)
+	iterations int
+	// usesPassphrase says the secret is a passphrase, which can be changed
+	usesPassphrase bool
+	mu             sync.Mutex
+	// secret is got once, so that the passphrase is asked for just once
+	secret []byte
+}
+
+// envelope is the content of an encrypted token file
+type envelope struct {
+	Iterations int    `json:"iterations"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// NewTokenStore creates the store of the token, which is not encrypted
+func NewTokenStore(configDirPath string) *TokenStore {
+	return &TokenStore{path: filepath.Join(configDirPath, tokenFileName)}
+}
+
+// NewPassphraseTokenStore creates the store of the token encrypted with the passphrase
+func NewPassphraseTokenStore(configDirPath string, passphrase func() ([]byte, error)) *TokenStore {
+	s := NewTokenStore(configDirPath)
+	s.getSecret, s.iterations, s.usesPassphrase = passphrase, passphraseIterations, true
+	return s
+}
+
+// NewKeyFileTokenStore creates the store of the token encrypted with the content of the key file
+func NewKeyFileTokenStore(configDirPath string, keyFilePath string) *TokenStore {
+	s := NewTokenStore(configDirPath)
+	s.getSecret = func() ([]byte, error) {
+		key, err := ioutil.ReadFile(keyFilePath)
+		if nil != err {
+			return nil, errors.Wrapf(err, "could not read key file %s", keyFilePath)
+		}
+		if len(key) < keySize {
+			return nil, errors.Errorf("key file %s must have at least %d bytes", keyFilePath, keySize)
+		}
+		return key, nil
+	}
+	s.iterations = keyFileIterations
+	return s
+}
+
+// Read reads the token. The error satisfies os.IsNotExist, if there is no token. A token
+// saved before the encryption was turned on is encrypted right away
+func (s *TokenStore) Read() (*oauth2.Token, error) {
+	content, err := ioutil.ReadFile(s.path)
+	if nil != err {
+		return nil, err
+	}
+	var env envelope
+	if err = json.Unmarshal(content, &env); nil != err {
+		return nil, errors.Wrapf(err, "could not parse token %s", s.path)
+	}
+	encrypted := len(env.Ciphertext) > 0
+	if encrypted {
+		if nil == s.getSecret {
+			return nil, errors.Errorf("token %s is encrypted, set token_encryption in config", s.path)
+		}
+		if content, err = s.decrypt(env); nil != err {
+			return nil, err
+		}
+	}
+	tok := &oauth2.Token{}
+	if err = json.Unmarshal(content, tok); nil != err {
+		return nil, errors.Wrapf(err, "could not parse token %s", s.path)
+	}
+	if !encrypted && nil != s.getSecret {
+		if err = s.Save(tok); nil != err {
+			return nil, errors.Wrap(err, "could not encrypt token")
+		}
+	}
+	return tok, nil
+}
+
+// Save saves the token. The token is written to a temporary file first, which
+// then replaces the old one, so that an interrupted write does not lose the token
+func (s *TokenStore) Save(token *oauth2.Token) error {
+	content, err := json.Marshal(token)
+	if nil != err {
+		return errors.Wrap(err, "could not encode token")
+	}
+	if nil != s.getSecret {
+		if content, err = s.encrypt(content); nil != err {
+			return err
+		}
+	}
+	f, err := ioutil.TempFile(filepath.Dir(s.path), tokenFileName+".*.tmp")
+	if err != nil {
+		return errors.Wrapf(err, "unable to save oauth token to %s", s.path)
+	}
+	_, err = f.Write(content)
+	if nil == err {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); nil == err {
+		err = closeErr
+	}
+	if nil == err {
+		err = os.Rename(f.Name(), s.path)
+	}
+	if nil != err {
+		os.Remove(f.Name())
+		return errors.Wrapf(err, "unable to save oauth token to %s", s.path)
+	}
+	return nil
+}
+
+// Remove removes the saved token, so that the authorization is asked for again
+func (s *TokenStore) Remove() error {
+	if err := os.Remove(s.path); nil != err && !os.IsNotExist(err) {
+		return errors.Wrapf(err, "could not remove token %s", s.path)
+	}
+	return nil
+}
+
+// Rekey encrypts the token with the new passphrase
+func (s *TokenStore) Rekey(passphrase []byte) error {
+	if !s.usesPassphrase {
+		return errors.New("the token is not encrypted with a passphrase")
+	}
+	if 0 == len(strings.TrimSpace(string(passphrase))) {
+		return errors.New("empty passphrase")
+	}
+	tok, err := s.Read()
+	if nil != err {
+		return err
+	}
+	s.mu.Lock()
+	s.secret = passphrase
+	s.mu.Unlock()
+	return s.Save(tok)
+}
+
+func (s *TokenStore) getKey(salt []byte, iterations int) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if nil == s.secret {
+		secret, err := s.getSecret()
+		if nil != err {
+			return nil, err
+		}
+		if 0 == len(strings.TrimSpace(string(secret))) {
+			return nil, errors.New("empty passphrase")
+		}
+		s.secret = secret
+	}
+	return pbkdf2.Key(s.secret, salt, iterations, keySize, sha256.New), nil
+}
+
+func (s *TokenStore) encrypt(plaintext []byte) ([]byte, error) {
+	env := envelope{Iterations: s.iterations, Salt: make([]byte, saltSize)}
+	if _, err := rand.Read(env.Salt); nil != err {
+		return nil, errors.Wrap(err, "could not generate salt")
+	}
+	key, err := s.getKey(env.Salt, env.Iterations)
+	if nil != err {
+		return nil, err
+	}
+	aead, err := newAEAD(key)
+	if nil != err {
+		return nil, err
+	}
+	env.Nonce = make([]byte, aead.NonceSize())
+	if _, err = rand.Read(env.Nonce); nil != err {
+		return nil, errors.Wrap(err, "could not generate nonce")
+	}
+	env.Ciphertext = aead.Seal(nil, env.Nonce, plaintext, nil)
+	return json.Marshal(env)
+}
+
+func (s *TokenStore) decrypt(env envelope) ([]byte, error) {
+	key, err := s.getKey(env.Salt, env.Iterations)
+	if nil != err {
+		return nil, err
+	}
+	aead, err := newAEAD(key)
+	if nil != err {
+		return nil, err
+	}
+	if aead.NonceSize() != len(env.Nonce) {
+		return nil, errors.Errorf("wrong nonce in token %s", s.path)
+	}
+	plaintext, err := aead.Open(nil, env.Nonce, env.Ciphertext, nil)
+	if nil != err {
+		return nil, errors.Errorf("could not decrypt token %s, the passphrase or the key is wrong", s.path)
+	}
+	return plaintext, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if nil != err {
+		return nil, errors.Wrap(err, "could not create cipher")
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,102 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/oauth2"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPbkdf2(t *testing.T) {
+	// the keys of the encrypted tokens are derived with PBKDF2-HMAC-SHA256. These are the test vectors
+	// of RFC 7914 and the inputs of RFC 6070 (which are for SHA-1), including the keys, that are not
+	// a multiple of the hash size
+	cases := []struct {
+		secret     string
+		salt       string
+		iterations int
+		keyLen     int
+		expected   string
+	}{
+		{"passwd", "salt", 1, 64, "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783"},
+		{"Password", "NaCl", 80000, 64, "4ddcd8f60b98be21830cee5ef22701f9641a4418d04c0414aeff08876b34ab56a1d425a1225833549adb841b51c9b3176a272bdebba1d078478f62b397f33c8d"},
+		{"password", "salt", 1, 32, "120fb6cffcf8b32c43e7225256c4f837a86548c92ccc35480805987cb70be17b"},
+		{"password", "salt", 2, 32, "ae4d0c95af6b46d32d0adff928f06dd02a303f8ef3c251dfd6e2d85a95474c43"},
+		{"password", "salt", 4096, 32, "c5e478d59288c841aa530db6845c4c8d962893a001ce4e11a4963873aa98134a"},
+		{"passwordPASSWORDpassword", "saltSALTsaltSALTsaltSALTsaltSALTsalt", 4096, 40, "348c89dbcbd32b2f32d814b8116e84cf2b17347ebc1800181c4e2a1fb8dd53e1c635518c7dac47e9"},
+		{"pass\x00word", "sa\x00lt", 4096, 16, "89b69d0516f829893c696226650a8687"},
+	}
+	for _, c := range cases {
+		key := hex.EncodeToString(pbkdf2.Key([]byte(c.secret), []byte(c.salt), c.iterations, c.keyLen, sha256.New))
+		if c.expected != key {
+			t.Errorf("%q, %d iterations: expected %s, got %s", c.secret, c.iterations, c.expected, key)
+		}
+	}
+}
+
+func TestEncryptedTokenStore(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "gdriveapp-auth-")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	tok := &oauth2.Token{AccessToken: "access", RefreshToken: "refresh"}
+	if err = NewTokenStore(tmp).Save(tok); nil != err {
+		t.Fatal(err)
+	}
+	newStore := func(passphrase string) *TokenStore {
+		s := NewPassphraseTokenStore(tmp, func() ([]byte, error) { return []byte(passphrase), nil })
+		// the real number of iterations is too slow for the tests
+		s.iterations = 10
+		return s
+	}
+
+	// the plain token is encrypted, when it is read the first time
+	if _, err = newStore("secret").Read(); nil != err {
+		t.Fatal(err)
+	}
+	content, err := ioutil.ReadFile(filepath.Join(tmp, tokenFileName))
+	if nil != err {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(content), "refresh") {
+		t.Fatalf("token is not encrypted: %s", content)
+	}
+	if _, err = NewTokenStore(tmp).Read(); nil == err {
+		t.Error("encrypted token must not be read without passphrase")
+	}
+	if _, err = newStore("wrong").Read(); nil == err {
+		t.Error("encrypted token must not be read with wrong passphrase")
+	}
+
+	if err = newStore("secret").Rekey([]byte("new secret")); nil != err {
+		t.Fatal(err)
+	}
+	if _, err = newStore("secret").Read(); nil == err {
+		t.Error("old passphrase must not work after rekey")
+	}
+	read, err := newStore("new secret").Read()
+	if nil != err {
+		t.Fatal(err)
+	}
+	if tok.RefreshToken != read.RefreshToken {
+		t.Errorf("expected %+v, got %+v", tok, read)
+	}
+
+	keyFile := filepath.Join(tmp, "token.key")
+	if err = ioutil.WriteFile(keyFile, []byte(strings.Repeat("k", keySize)), 0600); nil != err {
+		t.Fatal(err)
+	}
+	keyStore := NewKeyFileTokenStore(tmp, keyFile)
+	if err = keyStore.Save(tok); nil != err {
+		t.Fatal(err)
+	}
+	if read, err = keyStore.Read(); nil != err || tok.AccessToken != read.AccessToken {
+		t.Errorf("could not read token encrypted with key file: %+v, %v", read, err)
+	}
+}
@@ -0,0 +1,87 @@
+package auth
+
+import (
+	"encoding/json"
+	"github.com/pkg/errors"
+	"github.com/svetlyi/gdriveapp/contracts"
+	"golang.org/x/oauth2"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+const (
+	tokenInfoURL = "https://oauth2.googleapis.com/tokeninfo"
+	revokeURL    = "https://oauth2.googleapis.com/revoke"
+)
+
+// persistingTokenSource saves the token each time it changes, as Google may
+// replace the refresh token, when the access token is refreshed
+type persistingTokenSource struct {
+	src   oauth2.TokenSource
+	store *TokenStore
+	log   contracts.Logger
+	mu    sync.Mutex
+	// last is the saved token
+	last oauth2.Token
+}
+
+func (s *persistingTokenSource) Token() (*oauth2.Token, error) {
+	tok, err := s.src.Token()
+	if nil != err {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if tok.AccessToken == s.last.AccessToken && tok.RefreshToken == s.last.RefreshToken {
+		return tok, nil
+	}
+	// the token is still valid, so the call goes on. It is saved again with the next call
+	if err = s.store.Save(tok); nil != err {
+		s.log.Error("could not save refreshed token", err)
+		return tok, nil
+	}
+	s.last = *tok
+	return tok, nil
+}
+
+// GetScopes returns the scopes the access token grants
+func GetScopes(tok *oauth2.Token) ([]string, error) {
+	resp, err := http.Get(tokenInfoURL + "?" + url.Values{"access_token": {tok.AccessToken}}.Encode())
+	if nil != err {
+		return nil, errors.Wrap(err, "could not get token info")
+	}
+	defer resp.Body.Close()
+	var info struct {
+		Scope            string `json:"scope"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&info); nil != err {
+		return nil, errors.Wrapf(err, "could not decode token info with status %s", resp.Status)
+	}
+	if http.StatusOK != resp.StatusCode {
+		return nil, errors.Errorf("could not get token info: %s %s", resp.Status, info.ErrorDescription)
+	}
+	return strings.Fields(info.Scope), nil
+}
+
+// Revoke revokes the token, so that it cannot be used anymore. Revoking
+// the refresh token revokes the access tokens it gave as well
+func Revoke(tok *oauth2.Token) error {
+	token := tok.RefreshToken
+	if "" == token {
+		token = tok.AccessToken
+	}
+	resp, err := http.PostForm(revokeURL, url.Values{"token": {token}})
+	if nil != err {
+		return errors.Wrap(err, "could not revoke token")
+	}
+	defer resp.Body.Close()
+	if http.StatusOK != resp.StatusCode {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return errors.Errorf("could not revoke token: %s %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package auth
+
+import (
+	"github.com/svetlyi/gdriveapp/logger"
+	"golang.org/x/oauth2"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// tokens returns the tokens one by one, the last one is returned again and again
+type tokens []*oauth2.Token
+
+func (ts *tokens) Token() (*oauth2.Token, error) {
+	tok := (*ts)[0]
+	if len(*ts) > 1 {
+		*ts = (*ts)[1:]
+	}
+	return tok, nil
+}
+
+func TestPersistingTokenSource(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "gdriveapp-auth-")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	store := NewTokenStore(tmp)
+	first := &oauth2.Token{AccessToken: "access1", RefreshToken: "refresh1"}
+	if err = store.Save(first); nil != err {
+		t.Fatal(err)
+	}
+	refreshed := &oauth2.Token{AccessToken: "access2", RefreshToken: "refresh1"}
+	rotated := &oauth2.Token{AccessToken: "access3", RefreshToken: "refresh2"}
+	log, err := logger.New("svetlyi_gdriveapp_test", 10000, 0, false)
+	if nil != err {
+		t.Fatal(err)
+	}
+	src := &persistingTokenSource{
+		src:   &tokens{first, refreshed, rotated},
+		store: store,
+		log:   log,
+		last:  *first,
+	}
+	for _, expected := range []*oauth2.Token{first, refreshed, rotated, rotated} {
+		if _, err = src.Token(); nil != err {
+			t.Fatal(err)
+		}
+		saved, err := store.Read()
+		if nil != err {
+			t.Fatal(err)
+		}
+		if expected.AccessToken != saved.AccessToken || expected.RefreshToken != saved.RefreshToken {
+			t.Errorf("expected %+v to be saved, got %+v", expected, saved)
+		}
+	}
+	files, err := ioutil.ReadDir(tmp)
+	if nil != err {
+		t.Fatal(err)
+	}
+	if 1 != len(files) {
+		t.Errorf("just the token must be in the dir, got %d files", len(files))
+	}
+}
+
+func TestPersistingTokenSourceSaveFailure(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "gdriveapp-auth-")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	log, err := logger.New("svetlyi_gdriveapp_test", 10000, 0, false)
+	if nil != err {
+		t.Fatal(err)
+	}
+	// the token cannot be saved to a folder, that does not exist
+	refreshed := &oauth2.Token{AccessToken: "access2", RefreshToken: "refresh1"}
+	src := &persistingTokenSource{
+		src:   &tokens{refreshed},
+		store: NewTokenStore(filepath.Join(tmp, "missing")),
+		log:   log,
+		last:  oauth2.Token{AccessToken: "access1", RefreshToken: "refresh1"},
+	}
+	tok, err := src.Token()
+	if nil != err {
+		t.Fatalf("the refreshed token must be used, even if it is not saved: %v", err)
+	}
+	if refreshed.AccessToken != tok.AccessToken {
+		t.Errorf("expected %+v, got %+v", refreshed, tok)
+	}
+}
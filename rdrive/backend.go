@@ -0,0 +1,54 @@
+package rdrive
+
+import (
+	"google.golang.org/api/drive/v3"
+	"io"
+)
+
+// RootFolderId is the alias of the root folder of "My Drive"
+const RootFolderId = "root"
+
+// RemoteBackend is the storage the local drive is synchronized with. Google Drive is one
+// of them. The remote files are described with the Google Drive types, as it is what the
+// database keeps. The drive id is empty for "My Drive" and is the id of a shared drive otherwise
+type RemoteBackend interface {
+	// List calls fn for every file of the drive
+	List(driveId string, fn func(*drive.File) error) error
+	// ListSharedWithMe calls fn for every file shared with the user
+	ListSharedWithMe(fn func(*drive.File) error) error
+	// ListChildren calls fn for every file in the folder
+	ListChildren(folderId string, fn func(*drive.File) error) error
+	// StartPageToken returns the token the future changes of the drive are listed from
+	StartPageToken(driveId string) (string, error)
+	// Changes calls fn for every change of the drive since pageToken
+	Changes(driveId string, pageToken string, fn func(*drive.Change) error) error
+	// Get gets the file by id. RootFolderId is the root folder of "My Drive"
+	Get(fileId string) (*drive.File, error)
+	// Download returns the content of the file starting with offset, if the backend
+	// can continue a download, or from the beginning otherwise. The returned offset
+	// is where the content actually starts
+	Download(fileId string, offset int64) (io.ReadCloser, int64, error)
+	// Export returns the content of a native Google file converted to mimeType
+	Export(fileId string, mimeType string) (io.ReadCloser, error)
+	// Upload uploads the local file to the existing file with id fileId or, if fileId
+	// is empty, to a new file with the name in the folder with id parentId. The properties,
+	// if any, are set to the app properties of the file
+	Upload(localPath string, fileId string, parentId string, name string, properties map[string]string) (*drive.File, error)
+	// Update renames the file and moves it from the folder with id removeParentId
+	// to the folder with id addParentId
+	Update(fileId string, name string, addParentId string, removeParentId string) (*drive.File, error)
+	// Copy copies the file to the folder with id parentId under the name
+	Copy(fileId string, name string, parentId string) (*drive.File, error)
+	// CreateFolder creates a folder with the name in the folder with id parentId
+	CreateFolder(name string, parentId string) (*drive.File, error)
+	// Delete deletes the file or the folder with all its content for good
+	Delete(fileId string) error
+	// Trash moves the file or the folder with all its content to the trash
+	Trash(fileId string) error
+	// ListTrashed calls fn for every file in the trash
+	ListTrashed(fn func(*drive.File) error) error
+	// Restore restores the file from the trash to the folder it was in
+	Restore(fileId string) (*drive.File, error)
+	// EmptyTrash deletes the files in the trash for good
+	EmptyTrash() error
+}
@@ -0,0 +1,163 @@
+package rdrive
+
+import (
+	"database/sql"
+	"github.com/pkg/errors"
+	"github.com/svetlyi/gdriveapp/config"
+	"github.com/svetlyi/gdriveapp/contracts"
+	lfile "github.com/svetlyi/gdriveapp/ldrive/file"
+	"os"
+	"time"
+)
+
+// resolveConflict resolves a file that has been changed both locally and remotely
+// since the last synchronization according to the configured conflict policy
+func (d *Drive) resolveConflict(
+	file contracts.File,
+	localChangeType contracts.FileChangeType,
+	remoteChangeType contracts.FileChangeType,
+) error {
+	d.log.With(contracts.Fields{
+		contracts.FieldFileId: file.Id,
+		contracts.FieldPath:   file.CurPath,
+		"policy":              d.getConflictPolicy(file),
+	}).Info("resolving conflict")
+
+	switch {
+	case contracts.FILE_DELETED == localChangeType: // the remote one was updated
+		// a deletion time is unknown, so with newest-wins the changes are kept
+		if config.ConflictPreferLocal == d.getConflictPolicy(file) {
+			return d.fileRepository.SetRemovedLocally(file.Id, true)
+		}
+		return d.download(file)
+	case contracts.FILE_DELETED == remoteChangeType: // the local one was updated
+		if config.ConflictPreferRemote == d.getConflictPolicy(file) {
+			return d.recycleBin.Move(lfile.GetCurFullPath(d.cfg, file))
+		}
+		return d.uploadAsNew(file)
+	case contracts.FILE_MOVED == remoteChangeType && !d.canUpload(file):
+		prevFullPath := lfile.GetPrevFullPath(d.cfg, file)
+		if err := os.Rename(prevFullPath, lfile.GetCurFullPath(d.cfg, file)); err != nil {
+			return errors.Wrapf(err, "could not move %s after it was moved remotely", prevFullPath)
+		}
+	case contracts.FILE_MOVED == remoteChangeType:
+		isContentChangedRemotely := !file.CurRemoteModTime.Equal(file.PrevRemoteModTime)
+		prevFullPath := lfile.GetPrevFullPath(d.cfg, file)
+		if err := os.Rename(prevFullPath, lfile.GetCurFullPath(d.cfg, file)); err != nil {
+			return errors.Wrapf(err, "could not move %s after it was moved remotely", prevFullPath)
+		}
+		if !isContentChangedRemotely {
+			// only the location has changed remotely, so the local changes can be uploaded
+			return d.updateRemote(file)
+		}
+	}
+
+	policy, err := d.getContentConflictPolicy(file)
+	if err != nil {
+		return err
+	}
+	switch policy {
+	case config.ConflictPreferLocal:
+		return d.updateRemote(file)
+	case config.ConflictPreferRemote:
+		return d.download(file)
+	default:
+		return d.keepBoth(file)
+	}
+}
+
+// getContentConflictPolicy returns the policy for a file which content was changed
+// on both sides. newest-wins turns into either prefer-local or prefer-remote
+func (d *Drive) getContentConflictPolicy(file contracts.File) (string, error) {
+	if config.ConflictNewestWins != d.getConflictPolicy(file) {
+		return d.getConflictPolicy(file), nil
+	}
+	curFullPath := lfile.GetCurFullPath(d.cfg, file)
+	stat, err := d.stat(curFullPath)
+	if err != nil {
+		return "", errors.Wrapf(err, "could not get the file's %s stats", curFullPath)
+	}
+	if stat.ModTime().After(file.CurRemoteModTime) {
+		return config.ConflictPreferLocal, nil
+	}
+	return config.ConflictPreferRemote, nil
+}
+
+// getConflictPolicy returns the configured conflict policy. The local changes of an exported
+// file cannot be uploaded back to the native Google file, the local changes of a read-only
+// file cannot be uploaded at all, so they are either kept as a conflicted copy or
+// overwritten, if the remote version is preferred
+func (d *Drive) getConflictPolicy(file contracts.File) string {
+	if !d.canUpload(file) && config.ConflictPreferRemote != d.cfg.ConflictPolicy {
+		return config.ConflictKeepBoth
+	}
+	return d.cfg.ConflictPolicy
+}
+
+// keepBoth renames the local file to a conflicted copy, downloads the remote
+// version to the original path and uploads the conflicted copy next to it.
+// The uploaded copy is saved in the database, so it is a regular file for the next run
+func (d *Drive) keepBoth(file contracts.File) error {
+	parentId, err := d.fileRepository.GetParentIdByChildId(file.Id)
+	if err != nil {
+		return err
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	curFullPath := lfile.GetCurFullPath(d.cfg, file)
+	copyFullPath := lfile.GetConflictedCopyPath(curFullPath, host, time.Now())
+	d.log.With(contracts.Fields{
+		contracts.FieldPath: curFullPath,
+		"conflict_path":     copyFullPath,
+	}).Info("keeping both versions")
+
+	if err = os.Rename(curFullPath, copyFullPath); err != nil {
+		return errors.Wrapf(err, "could not rename %s to %s", curFullPath, copyFullPath)
+	}
+	if err = d.download(file); err != nil {
+		return errors.Wrapf(err, "could not download file %s", file.Id)
+	}
+	if readOnly, err := d.IsFolderReadOnly(parentId); err != nil || readOnly {
+		d.log.Warning("the folder is read-only, the conflicted copy is kept just locally", copyFullPath)
+		return err
+	}
+	if err = d.Upload(copyFullPath, []string{parentId}); err != nil {
+		return errors.Wrapf(err, "could not upload conflicted copy %s", copyFullPath)
+	}
+	return nil
+}
+
+// canUpload says if the local changes of the file can be uploaded
+func (d *Drive) canUpload(file contracts.File) bool {
+	return !d.IsExported(file) && 0 == file.ReadOnly
+}
+
+// IsFolderReadOnly says if new files cannot be uploaded to the remote folder
+func (d *Drive) IsFolderReadOnly(folderId string) (bool, error) {
+	folder, err := d.fileRepository.GetFileById(folderId)
+	if sql.ErrNoRows == errors.Cause(err) { // the folder is planned to be created in the dry-run mode
+		return false, nil
+	} else if err != nil {
+		return false, errors.Wrapf(err, "could not get folder %s", folderId)
+	}
+	return 1 == folder.ReadOnly, nil
+}
+
+// uploadAsNew uploads a local file which remote version does not exist anymore
+// as a new file to the same folder
+func (d *Drive) uploadAsNew(file contracts.File) error {
+	parentId, err := d.fileRepository.GetParentIdByChildId(file.Id)
+	if err != nil {
+		return err
+	}
+	if err = d.fileRepository.Delete(file.Id); err != nil {
+		return err
+	}
+	if readOnly, err := d.IsFolderReadOnly(parentId); err != nil || readOnly {
+		d.log.Warning("the folder is read-only, the file is kept just locally", file.CurPath)
+		return err
+	}
+	return d.Upload(lfile.GetCurFullPath(d.cfg, file), []string{parentId})
+}
@@ -0,0 +1,280 @@
+// Package crypt encrypts the content and the names of the files before they are uploaded
+// and decrypts them after they are downloaded, so that the remote drive cannot read them.
+//
+// The content is split into chunks of 64 KB, each of them is encrypted with AES-256-GCM.
+// The key of a file is derived from the master key and a random salt, which starts the
+// encrypted content. The nonce of a chunk is its number and a flag of the last chunk,
+// so the chunks cannot be reordered, removed or cut off without notice.
+//
+// The names are encrypted deterministically (the nonce is derived from the name itself),
+// so the same name is always encrypted to the same string.
+package crypt
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"github.com/pkg/errors"
+	"google.golang.org/api/drive/v3"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// the app properties of an encrypted remote file
+const (
+	// PropertyScheme is the scheme the content is encrypted with. It is empty or
+	// missing, if the content is not encrypted
+	PropertyScheme = "gdriveapp_encryption"
+	// PropertyHash is the keyed hash of the plain content
+	PropertyHash = "gdriveapp_hash"
+	// PropertySize is the size of the plain content
+	PropertySize = "gdriveapp_size"
+)
+
+// Scheme is the scheme the content is encrypted with
+const Scheme = "aes-256-gcm-chunked-v1"
+
+// NamePrefix starts the encrypted names, so that they are told from the plain ones
+const NamePrefix = "gdae1."
+
+const (
+	// KeySize is the minimal size of the master key
+	KeySize   = 32
+	saltSize  = 32
+	chunkSize = 64 << 10
+	// overhead is the size of the authentication tag of a chunk
+	overhead = 16
+)
+
+// magic starts the encrypted content
+var magic = []byte("GDAPPEN1")
+
+var errDamaged = errors.New("the encrypted content is damaged or the key is wrong")
+
+// Cipher encrypts the files with the keys derived from the master key
+type Cipher struct {
+	contentKey []byte
+	nameKey    []byte
+	nameIvKey  []byte
+	hashKey    []byte
+}
+
+// New creates the cipher with the master key, which must be at least KeySize bytes
+func New(key []byte) (*Cipher, error) {
+	if len(key) < KeySize {
+		return nil, errors.Errorf("the key must be at least %d bytes", KeySize)
+	}
+	return &Cipher{
+		contentKey: deriveKey(key, "content"),
+		nameKey:    deriveKey(key, "name"),
+		nameIvKey:  deriveKey(key, "name iv"),
+		hashKey:    deriveKey(key, "hash"),
+	}, nil
+}
+
+// ReadKeyFile creates the cipher with the content of the key file as the master key
+func ReadKeyFile(path string) (*Cipher, error) {
+	key, err := ioutil.ReadFile(path)
+	if nil != err {
+		return nil, errors.Wrapf(err, "could not read encryption key file %s", path)
+	}
+	c, err := New(key)
+	return c, errors.Wrapf(err, "wrong encryption key file %s", path)
+}
+
+// Encrypt encrypts the content of src to dst
+func (c *Cipher) Encrypt(dst io.Writer, src io.Reader) error {
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); nil != err {
+		return errors.Wrap(err, "could not generate salt")
+	}
+	aead, err := newAEAD(deriveKey(c.contentKey, string(salt)))
+	if nil != err {
+		return err
+	}
+	if _, err = dst.Write(append(append([]byte(nil), magic...), salt...)); nil != err {
+		return err
+	}
+	r := bufio.NewReaderSize(src, chunkSize)
+	chunk := make([]byte, chunkSize)
+	var sealed []byte
+	for counter := uint64(0); ; counter++ {
+		n, last, err := readChunk(r, chunk)
+		if nil != err {
+			return err
+		}
+		sealed = aead.Seal(sealed[:0], chunkNonce(counter, last), chunk[:n], nil)
+		if _, err = dst.Write(sealed); nil != err {
+			return err
+		}
+		if last {
+			return nil
+		}
+	}
+}
+
+// Decrypt decrypts the content of src encrypted by Encrypt to dst. An error
+// is returned, if the content is changed, cut off or encrypted with another key
+func (c *Cipher) Decrypt(dst io.Writer, src io.Reader) error {
+	header := make([]byte, len(magic)+saltSize)
+	if _, err := io.ReadFull(src, header); nil != err {
+		if io.EOF == err || io.ErrUnexpectedEOF == err {
+			return errDamaged
+		}
+		return err
+	}
+	if !hmac.Equal(magic, header[:len(magic)]) {
+		return errors.New("the content is not encrypted")
+	}
+	aead, err := newAEAD(deriveKey(c.contentKey, string(header[len(magic):])))
+	if nil != err {
+		return err
+	}
+	r := bufio.NewReaderSize(src, chunkSize+overhead)
+	chunk := make([]byte, chunkSize+overhead)
+	var plain []byte
+	for counter := uint64(0); ; counter++ {
+		n, last, err := readChunk(r, chunk)
+		if nil != err {
+			return err
+		}
+		if plain, err = aead.Open(plain[:0], chunkNonce(counter, last), chunk[:n], nil); nil != err {
+			return errDamaged
+		}
+		if _, err = dst.Write(plain); nil != err {
+			return err
+		}
+		if last {
+			return nil
+		}
+	}
+}
+
+// EncryptedSize returns the size of the encrypted content of the given size
+func EncryptedSize(size int64) int64 {
+	chunks := (size + chunkSize - 1) / chunkSize
+	if 0 == chunks {
+		chunks = 1 // the empty content is an empty last chunk
+	}
+	return int64(len(magic)+saltSize) + size + chunks*overhead
+}
+
+// Hash returns the keyed hash of the plain content with the given md5 hash. The remote drive
+// gets it instead of the md5 hash, which would tell if the content is some known file
+func (c *Cipher) Hash(md5 string) string {
+	mac := hmac.New(sha256.New, c.hashKey)
+	mac.Write([]byte(md5))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// EncryptName encrypts the name of a file. The same name is always encrypted the same way
+func (c *Cipher) EncryptName(name string) string {
+	aead, err := newAEAD(c.nameKey)
+	if nil != err {
+		panic(err) // the key size is always right
+	}
+	mac := hmac.New(sha256.New, c.nameIvKey)
+	mac.Write([]byte(name))
+	nonce := mac.Sum(nil)[:aead.NonceSize()]
+	return NamePrefix + base64.RawURLEncoding.EncodeToString(aead.Seal(nonce, nonce, []byte(name), nil))
+}
+
+// DecryptName decrypts the name encrypted by EncryptName
+func (c *Cipher) DecryptName(encrypted string) (string, error) {
+	aead, err := newAEAD(c.nameKey)
+	if nil != err {
+		return "", err
+	}
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(encrypted, NamePrefix))
+	if nil != err || len(data) < aead.NonceSize() {
+		return "", errors.Errorf("wrong encrypted name %s", encrypted)
+	}
+	name, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], nil)
+	if nil != err {
+		return "", errors.Errorf("could not decrypt name %s: the key is wrong", encrypted)
+	}
+	return string(name), nil
+}
+
+// IsEncryptedName says if the name was encrypted by EncryptName
+func IsEncryptedName(name string) bool {
+	return strings.HasPrefix(name, NamePrefix)
+}
+
+// IsEncrypted says if the content of the remote file is encrypted
+func IsEncrypted(file *drive.File) bool {
+	return Scheme == file.AppProperties[PropertyScheme]
+}
+
+// Properties returns the app properties of a remote file with the encrypted content,
+// where hash is the keyed hash and size is the size of the plain content
+func Properties(hash string, size int64) map[string]string {
+	return map[string]string{
+		PropertyScheme: Scheme,
+		PropertyHash:   hash,
+		PropertySize:   strconv.FormatInt(size, 10),
+	}
+}
+
+// PlainProperties returns the app properties of a remote file, which content
+// is not encrypted anymore. The properties cannot be removed, just emptied
+func PlainProperties() map[string]string {
+	return map[string]string{PropertyScheme: "", PropertyHash: "", PropertySize: ""}
+}
+
+// GetPlainContent returns the keyed hash and the size of the plain content of the encrypted file
+func GetPlainContent(file *drive.File) (hash string, size int64, err error) {
+	size, err = strconv.ParseInt(file.AppProperties[PropertySize], 10, 64)
+	if nil != err {
+		return "", 0, errors.Wrapf(err, "wrong plain size of encrypted file %s", file.Id)
+	}
+	return file.AppProperties[PropertyHash], size, nil
+}
+
+// readChunk reads the chunk and says if it is the last one
+func readChunk(r *bufio.Reader, chunk []byte) (int, bool, error) {
+	n, err := io.ReadFull(r, chunk)
+	if io.EOF == err || io.ErrUnexpectedEOF == err {
+		return n, true, nil
+	} else if nil != err {
+		return 0, false, err
+	}
+	if _, err = r.Peek(1); io.EOF == err {
+		return n, true, nil
+	} else if nil != err {
+		return 0, false, err
+	}
+	return n, false, nil
+}
+
+func chunkNonce(counter uint64, last bool) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce[3:11], counter)
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+// deriveKey derives a key for the purpose from the key
+func deriveKey(key []byte, purpose string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if nil != err {
+		return nil, errors.Wrap(err, "could not create cipher")
+	}
+	return cipher.NewGCM(block)
+}
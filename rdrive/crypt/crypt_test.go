@@ -0,0 +1,79 @@
+package crypt
+
+import (
+	"bytes"
+	"testing"
+)
+
+func newTestCipher(t *testing.T, b byte) *Cipher {
+	c, err := New(bytes.Repeat([]byte{b}, KeySize))
+	if nil != err {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	c := newTestCipher(t, 1)
+	for _, size := range []int{0, 1, chunkSize - 1, chunkSize, chunkSize + 1, 3 * chunkSize} {
+		plain := bytes.Repeat([]byte("0123456789"), size/10+1)[:size]
+		var encrypted bytes.Buffer
+		if err := c.Encrypt(&encrypted, bytes.NewReader(plain)); nil != err {
+			t.Fatal(size, err)
+		}
+		if EncryptedSize(int64(size)) != int64(encrypted.Len()) {
+			t.Errorf("%d: expected encrypted size %d, got %d", size, EncryptedSize(int64(size)), encrypted.Len())
+		}
+		if size > 0 && bytes.Contains(encrypted.Bytes(), plain) {
+			t.Errorf("%d: the plain content is in the encrypted one", size)
+		}
+		var decrypted bytes.Buffer
+		if err := c.Decrypt(&decrypted, bytes.NewReader(encrypted.Bytes())); nil != err {
+			t.Fatal(size, err)
+		}
+		if !bytes.Equal(plain, decrypted.Bytes()) {
+			t.Errorf("%d: the decrypted content differs from the plain one", size)
+		}
+	}
+}
+
+func TestDecryptDetectsChanges(t *testing.T) {
+	c := newTestCipher(t, 1)
+	var encrypted bytes.Buffer
+	if err := c.Encrypt(&encrypted, bytes.NewReader(make([]byte, 2*chunkSize))); nil != err {
+		t.Fatal(err)
+	}
+	data := encrypted.Bytes()
+	changed := append([]byte(nil), data...)
+	changed[len(changed)-1] ^= 1
+	cases := map[string][]byte{
+		"changed":            changed,
+		"cut off by a chunk": data[:len(magic)+saltSize+chunkSize+overhead],
+		"header only":        data[:len(magic)+saltSize],
+	}
+	for name, content := range cases {
+		if err := c.Decrypt(&bytes.Buffer{}, bytes.NewReader(content)); nil == err {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+	if err := newTestCipher(t, 2).Decrypt(&bytes.Buffer{}, bytes.NewReader(data)); nil == err {
+		t.Error("the content must not be decrypted with another key")
+	}
+}
+
+func TestNames(t *testing.T) {
+	c := newTestCipher(t, 1)
+	encrypted := c.EncryptName("contract.pdf")
+	if !IsEncryptedName(encrypted) || IsEncryptedName("contract.pdf") {
+		t.Errorf("wrong encrypted name %s", encrypted)
+	}
+	if encrypted != c.EncryptName("contract.pdf") {
+		t.Error("the same name must be encrypted the same way")
+	}
+	if name, err := c.DecryptName(encrypted); nil != err || "contract.pdf" != name {
+		t.Errorf("expected contract.pdf, got %q, %v", name, err)
+	}
+	if _, err := newTestCipher(t, 2).DecryptName(encrypted); nil == err {
+		t.Error("the name must not be decrypted with another key")
+	}
+}
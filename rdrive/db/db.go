@@ -2,10 +2,15 @@ package db
 
 import (
 	"database/sql"
+	"fmt"
 	_ "github.com/mattn/go-sqlite3"
+	"github.com/pkg/errors"
 	"github.com/svetlyi/gdriveapp/contracts"
 	"github.com/svetlyi/gdriveapp/rdrive/db/migration"
+	"io"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 )
 
 var db *sql.DB = nil
@@ -14,10 +19,18 @@ func New(dbPath string, logger contracts.Logger) *sql.DB {
 	var err error
 
 	logger.Debug("opening database", dbPath)
-	db, err = sql.Open("sqlite3", dbPath)
+	stat, statErr := os.Stat(dbPath)
+	isNew := os.IsNotExist(statErr) || (nil == statErr && 0 == stat.Size())
+	// the transfer workers read the database while it is written, so they wait for
+	// the lock instead of failing, and the readers do not block the writer
+	db, err = sql.Open("sqlite3", dbPath+"?_busy_timeout=10000&_journal_mode=WAL")
 	if err != nil {
 		logger.Error("Could not open the database file", err)
 	}
+	if err = backupBeforeMigration(db, dbPath, isNew, logger); err != nil {
+		logger.Error("Could not back up the database before migration", err)
+		os.Exit(1)
+	}
 	if err = migration.RunMigrations(db, logger); err != nil {
 		logger.Error("Could not migrate", err)
 		os.Exit(1)
@@ -25,3 +38,87 @@ func New(dbPath string, logger contracts.Logger) *sql.DB {
 
 	return db
 }
+
+// backupBeforeMigration saves a copy of the database, if its schema is going to be upgraded
+func backupBeforeMigration(db *sql.DB, dbPath string, isNew bool, logger contracts.Logger) error {
+	version, err := migration.GetVersion(db)
+	if err != nil {
+		return err
+	}
+	if isNew || version >= migration.LatestVersion() {
+		return nil
+	}
+	backupPath := GetBackupPath(dbPath, version)
+	logger.Info("backing up the database before migration", backupPath)
+	if err = os.Remove(backupPath); err != nil && !os.IsNotExist(err) {
+		return errors.Wrapf(err, "could not remove old backup %s", backupPath)
+	}
+	// unlike copying the file, it takes the changes in the write-ahead log as well
+	if _, err = db.Exec(`VACUUM INTO ?`, backupPath); err != nil {
+		return errors.Wrapf(err, "could not back up database to %s", backupPath)
+	}
+	return nil
+}
+
+// GetBackupPath returns the path of the copy of the database made before
+// the upgrade from the schema version
+func GetBackupPath(dbPath string, version int) string {
+	return fmt.Sprintf("%s.v%d.bak", dbPath, version)
+}
+
+// Remove removes the database with its write-ahead log and backups
+func Remove(dbPath string) error {
+	paths, err := filepath.Glob(dbPath + ".v*.bak")
+	if err != nil {
+		return errors.Wrapf(err, "could not find backups of %s", dbPath)
+	}
+	paths = append(paths, dbPath, dbPath+"-wal", dbPath+"-shm")
+	for _, path := range paths {
+		if err = os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return errors.Wrapf(err, "could not remove %s", path)
+		}
+	}
+	return nil
+}
+
+// Copy copies the database to a temporary file and returns the path to the copy.
+// If the database does not exist yet, the copy is a new empty database
+func Copy(dbPath string) (string, error) {
+	dst, err := ioutil.TempFile("", "gdriveapp-*.db")
+	if err != nil {
+		return "", errors.Wrap(err, "could not create a temporary database")
+	}
+	defer dst.Close()
+
+	src, err := os.Open(dbPath)
+	if os.IsNotExist(err) {
+		return dst.Name(), nil
+	} else if err != nil {
+		return "", errors.Wrapf(err, "could not open database %s", dbPath)
+	}
+	defer src.Close()
+
+	if _, err = io.Copy(dst, src); err != nil {
+		return "", errors.Wrapf(err, "could not copy database %s", dbPath)
+	}
+	// the changes, that have not been moved to the database file yet, are in the write-ahead log
+	if err = copyFile(dbPath+"-wal", dst.Name()+"-wal"); err != nil && !os.IsNotExist(errors.Cause(err)) {
+		return "", errors.Wrapf(err, "could not copy write-ahead log of database %s", dbPath)
+	}
+	return dst.Name(), nil
+}
+
+func copyFile(srcPath string, dstPath string) error {
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.Create(dstPath)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+	_, err = io.Copy(dst, src)
+	return err
+}
@@ -0,0 +1,49 @@
+package db
+
+import (
+	"database/sql"
+	"github.com/svetlyi/gdriveapp/logger"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewBacksUpBeforeMigration(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gdriveapp-db-")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dbPath := filepath.Join(dir, "sync.db")
+	l, err := logger.New("svetlyi_gdriveapp_test", 10000, 0, false)
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	New(dbPath, l).Close()
+	if _, err = os.Stat(GetBackupPath(dbPath, 0)); !os.IsNotExist(err) {
+		t.Error("a new database must not be backed up")
+	}
+
+	// a database of an older version
+	old, err := sql.Open("sqlite3", dbPath)
+	if nil != err {
+		t.Fatal(err)
+	}
+	if _, err = old.Exec(`DELETE FROM schema_version WHERE version > 2`); nil != err {
+		t.Fatal(err)
+	}
+	old.Close()
+	New(dbPath, l).Close()
+	if _, err = os.Stat(GetBackupPath(dbPath, 2)); nil != err {
+		t.Error("the database must be backed up before migration", err)
+	}
+
+	if err = Remove(dbPath); nil != err {
+		t.Fatal(err)
+	}
+	if files, _ := filepath.Glob(dbPath + "*"); 0 != len(files) {
+		t.Error("the database files must be removed", files)
+	}
+}
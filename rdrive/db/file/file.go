@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"github.com/pkg/errors"
 	"github.com/svetlyi/gdriveapp/contracts"
+	"github.com/svetlyi/gdriveapp/rdrive/crypt"
+	"github.com/svetlyi/gdriveapp/rdrive/specification"
 	"google.golang.org/api/drive/v3"
 	"os"
 	"path/filepath"
@@ -33,7 +35,11 @@ var fileSelectFields = `
     files.size,
     files.trashed,
     files.removed_remotely,
-    files.removed_locally
+    files.removed_locally,
+    files.drive_id,
+    files.read_only,
+    files.encrypted,
+    files.symlink
 `
 
 func NewRepository(db *sql.DB, log contracts.Logger) Repository {
@@ -58,9 +64,13 @@ func (fr Repository) CreateFile(file *drive.File) error {
 		root_folder,
 		'size',
 		trashed,
-		removed_remotely
+		removed_remotely,
+		drive_id,
+		read_only,
+		encrypted,
+		symlink
 	)
-	VALUES (?,?,?,?,?,?,?,?,?,?,?,?)
+	VALUES (?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?)
 	`
 	_, err := fr.db.Exec(
 		query,
@@ -76,6 +86,10 @@ func (fr Repository) CreateFile(file *drive.File) error {
 		file.Size,
 		file.Trashed,
 		0,
+		file.DriveId,
+		IsReadOnly(file),
+		crypt.IsEncrypted(file),
+		specification.GetSymlinkTarget(file),
 	)
 	if nil == err {
 		err = fr.linkWithParents(file)
@@ -84,7 +98,8 @@ func (fr Repository) CreateFile(file *drive.File) error {
 	return err
 }
 
-func (fr Repository) SaveRootFolder(file *drive.File) error {
+// SaveRootFolder saves the root folder of "My Drive" (driveId is empty) or of a shared drive
+func (fr Repository) SaveRootFolder(file *drive.File, driveId string) error {
 	query := `
 	INSERT INTO 
 	files(
@@ -97,9 +112,11 @@ func (fr Repository) SaveRootFolder(file *drive.File) error {
 		root_folder,
 		'size',
 		trashed,
-		removed_remotely
+		removed_remotely,
+		drive_id,
+		read_only
 	)
-	VALUES (?,?,?,?,?,?,?,?,?,?)
+	VALUES (?,?,?,?,?,?,?,?,?,?,?,?)
 	`
 	_, err := fr.db.Exec(
 		query,
@@ -113,6 +130,8 @@ func (fr Repository) SaveRootFolder(file *drive.File) error {
 		file.Size,
 		0,
 		0,
+		driveId,
+		IsReadOnly(file),
 	)
 	if nil != err {
 		err = errors.Wrap(err, "error while inserting root folder")
@@ -120,6 +139,26 @@ func (fr Repository) SaveRootFolder(file *drive.File) error {
 	return err
 }
 
+// IsReadOnly says if the user cannot edit the remote file or, for a folder, cannot add
+// files to it. The files without the information about the capabilities are considered editable
+func IsReadOnly(file *drive.File) bool {
+	if nil == file.Capabilities {
+		return false
+	}
+	if specification.GetFolderMime() == file.MimeType {
+		return !file.Capabilities.CanAddChildren
+	}
+	return !file.Capabilities.CanEdit
+}
+
+// SetReadOnly updates read_only when the permissions of the file change
+func (fr *Repository) SetReadOnly(fileId string, readOnly bool) (err error) {
+	if _, err = fr.db.Exec(`UPDATE files SET 'read_only' = ? WHERE id = ?`, readOnly, fileId); err != nil {
+		err = errors.Wrapf(err, "could not set read_only for file id %s", fileId)
+	}
+	return
+}
+
 func (fr *Repository) linkWithParents(file *drive.File) error {
 	if len(file.Parents) > 1 {
 		return errors.New("there is no support for multiple parents yet")
@@ -135,15 +174,63 @@ func (fr *Repository) linkWithParents(file *drive.File) error {
 	return err
 }
 
-// getRootFolder gets the root folder. As in google drive as in Linux
-// everything is a file, we return a file.
-func (fr *Repository) GetRootFolder() (contracts.File, error) {
+// GetRootFolder gets the root folder of "My Drive" (driveId is empty) or of a shared drive.
+// As in google drive as in Linux everything is a file, we return a file.
+func (fr *Repository) GetRootFolder(driveId string) (contracts.File, error) {
 	row := fr.db.QueryRow(
-		fmt.Sprintf(`SELECT %s FROM files WHERE files.root_folder = 1 LIMIT 1`, fileSelectFields),
+		fmt.Sprintf(`SELECT %s FROM files WHERE files.root_folder = 1 AND files.drive_id = ? LIMIT 1`, fileSelectFields),
+		driveId,
 	)
 	return parseFileFromRow(row)
 }
 
+// GetRootFolders gets the root folders of all the synchronized drives, "My Drive" goes first
+func (fr *Repository) GetRootFolders() ([]contracts.File, error) {
+	rows, err := fr.db.Query(
+		fmt.Sprintf(`SELECT %s FROM files WHERE files.root_folder = 1 ORDER BY files.drive_id`, fileSelectFields),
+	)
+	if nil != err {
+		return nil, errors.Wrap(err, "could not get root folders")
+	}
+	defer rows.Close()
+	var rootFolders []contracts.File
+	for rows.Next() {
+		f, err := parseFileFromRow(rows)
+		if nil != err {
+			return nil, err
+		}
+		f.PrevPath = f.PrevRemoteName
+		f.CurPath = f.CurRemoteName
+		rootFolders = append(rootFolders, f)
+	}
+	return rootFolders, rows.Err()
+}
+
+// DeleteDrive deletes all the files of the shared drive (or of "Shared with me"), that is not
+// synchronized anymore. The files are found by their parents from the root folder of the drive,
+// as the files shared with the user keep the ids of their own drives
+func (fr *Repository) DeleteDrive(driveId string) error {
+	query := `
+		WITH RECURSIVE drive_files (id) AS (
+			SELECT id
+			FROM files
+			WHERE root_folder = 1 AND drive_id = ?
+			UNION
+			SELECT fp.file_id
+			FROM drive_files df
+					 JOIN files_parents fp ON fp.cur_parent_id = df.id OR fp.prev_parent_id = df.id
+		)
+		DELETE
+		FROM files
+		WHERE drive_id = ? OR id IN (SELECT id FROM drive_files)
+	`
+	if _, err := fr.db.Exec(query, driveId, driveId); nil != err {
+		return errors.Wrapf(err, "could not delete files of drive %s", driveId)
+	}
+	_, err := fr.db.Exec(`DELETE FROM files_parents WHERE file_id NOT IN (SELECT id FROM files)`)
+	return errors.Wrapf(err, "could not delete parents of files of drive %s", driveId)
+}
+
 // SetCurRemoteData updates cur_remote_modification_time and other data so that
 // after we could check if it was changed remotely. mtime is in RFC3339Nano format
 func (fr *Repository) SetCurRemoteData(fileId string, mtime string, name string, parents []string) error {
@@ -167,15 +254,37 @@ func (fr *Repository) SetCurRemoteData(fileId string, mtime string, name string,
 	return nil
 }
 
+// SetPrevRemoteDataToCur marks the current remote data (name, modification
+// time and parent) as synchronized with the local file
 func (fr *Repository) SetPrevRemoteDataToCur(fileId string) error {
 	var err error
 	err = fr.setPrevRemoteModTimeToCur(fileId)
-	if err != nil {
+	if err == nil {
 		err = fr.setPrevRemoteParentToCur(fileId)
 	}
 	return err
 }
 
+// SetCurRemoteContent updates the hash and the size of the file's content
+// on the remote drive, so that it could be compared with the local one,
+// if the content is encrypted and the target of the symbolic link the file keeps
+func (fr *Repository) SetCurRemoteContent(file *drive.File) (err error) {
+	query := `UPDATE files SET 'hash' = ?, 'size' = ?, 'encrypted' = ?, 'symlink' = ? WHERE id = ?`
+
+	_, err = fr.db.Exec(
+		query,
+		file.Md5Checksum,
+		file.Size,
+		crypt.IsEncrypted(file),
+		specification.GetSymlinkTarget(file),
+		file.Id,
+	)
+	if err != nil {
+		err = errors.Wrapf(err, "could not update file's %s content data", file.Id)
+	}
+	return
+}
+
 func (fr *Repository) setPrevRemoteParentToCur(fileId string) error {
 	query := `UPDATE files_parents SET prev_parent_id = cur_parent_id WHERE files_parents.file_id = ?`
 	_, err := fr.db.Exec(query, fileId)
@@ -295,10 +404,11 @@ func (fr *Repository) GetFileById(id string) (contracts.File, error) {
 	return parseFileFromRow(row)
 }
 
-// GetFileByHash gets a file by its hash.
+// GetFileByHash gets a file by its hash. The symbolic links are not looked for,
+// as their content is just the target
 func (fr *Repository) GetFileByHash(hash string) (contracts.File, error) {
 	row := fr.db.QueryRow(
-		fmt.Sprintf(`SELECT %s FROM files WHERE files.hash = ? LIMIT 1`, fileSelectFields),
+		fmt.Sprintf(`SELECT %s FROM files WHERE files.hash = ? AND files.symlink = '' LIMIT 1`, fileSelectFields),
 		hash,
 	)
 	return parseFileFromRow(row)
@@ -390,11 +500,7 @@ func (fr *Repository) GetLocallyRemovedFoldersIds() ([]string, error) {
 func (fr *Repository) GetCurFilesListByParent(parentId string) ([]contracts.File, error) {
 	var filesList []contracts.File
 
-	fr.log.Debug("getting files by parent", struct {
-		parentId string
-	}{
-		parentId: parentId,
-	})
+	fr.log.With(contracts.Fields{"parent_id": parentId}).Debug("getting files by parent")
 	rows, err := fr.db.Query(
 		fmt.Sprintf(`
 			SELECT %s
@@ -412,29 +518,57 @@ func (fr *Repository) GetCurFilesListByParent(parentId string) ([]contracts.File
 		return filesList, errors.Wrap(err, "Error querying files by parent id.")
 	}
 	defer rows.Close()
+	return fr.scanFilesWithPaths(rows)
+}
 
+// GetRemotelyChangedFiles gets the files, that are new, changed, moved or removed remotely
+// since they were synchronized last time
+func (fr *Repository) GetRemotelyChangedFiles() ([]contracts.File, error) {
+	rows, err := fr.db.Query(
+		fmt.Sprintf(`
+			SELECT %s
+			FROM files
+			JOIN files_parents fp ON files.id = fp.file_id
+			WHERE files.download_time IS NULL
+			   OR files.prev_remote_name != files.cur_remote_name
+			   OR fp.prev_parent_id != fp.cur_parent_id
+			   OR files.prev_remote_modification_time != files.cur_remote_modification_time
+			   OR files.removed_remotely = 1
+			   OR files.trashed = 1
+		`,
+			fileSelectFields,
+		),
+	)
+	if nil != err {
+		return nil, errors.Wrap(err, "could not query remotely changed files")
+	}
+	defer rows.Close()
+	return fr.scanFilesWithPaths(rows)
+}
+
+// scanFilesWithPaths scans the files and gets their current and previous paths. The files,
+// which parents are removed, are skipped
+func (fr *Repository) scanFilesWithPaths(rows *sql.Rows) ([]contracts.File, error) {
+	var filesList []contracts.File
 	for rows.Next() {
-		var f contracts.File
-
-		if f, err = parseFileFromRow(rows); err == nil {
-			f.CurPath, f.PrevPath, err = fr.GetFileParentFolderPath(f.Id)
-			if sql.ErrNoRows == err {
-				// seems like the parent or the file itself was removed
-				continue
-			}
-			if err != nil {
-				return filesList, errors.Wrapf(err, "Could not get full path for file %s", f.Id)
-			}
-			f.CurPath = filepath.Join(f.CurPath, f.CurRemoteName)
-			f.PrevPath = filepath.Join(f.PrevPath, f.PrevRemoteName)
-			filesList = append(filesList, f)
-		} else {
+		f, err := parseFileFromRow(rows)
+		if nil != err {
 			return filesList, errors.Wrap(err, "Error looping over files in getFilesList.")
 		}
+		f.CurPath, f.PrevPath, err = fr.GetFileParentFolderPath(f.Id)
+		if sql.ErrNoRows == err {
+			// seems like the parent or the file itself was removed
+			continue
+		}
+		if err != nil {
+			return filesList, errors.Wrapf(err, "Could not get full path for file %s", f.Id)
+		}
+		f.CurPath = filepath.Join(f.CurPath, f.CurRemoteName)
+		f.PrevPath = filepath.Join(f.PrevPath, f.PrevRemoteName)
+		filesList = append(filesList, f)
 	}
-
-	if err = rows.Err(); err != nil {
-		return filesList, errors.Wrap(err, "Error fetching files by parent id.")
+	if err := rows.Err(); err != nil {
+		return filesList, errors.Wrap(err, "Error fetching files.")
 	}
 	return filesList, nil
 }
@@ -575,6 +709,32 @@ func (fr *Repository) GetFileIdByPathSlice(lookForPath []string, lookInParentId
 	}
 }
 
+// GetFileIdByPrevPath gets file's id by the path it had before the last remote change
+// (for example, a move), which has not been synchronized locally yet
+func (fr *Repository) GetFileIdByPrevPath(fullPath string, startWithFolder contracts.File) (string, error) {
+	pathSlice := strings.Split(fullPath, string(os.PathSeparator))
+	if startWithFolder.PrevRemoteName != pathSlice[0] {
+		return "", sql.ErrNoRows
+	}
+	fileId := startWithFolder.Id
+	for _, name := range pathSlice[1:] {
+		err := fr.db.QueryRow(`
+			SELECT f.id
+			FROM files f
+			JOIN files_parents fp ON f.id = fp.file_id
+			WHERE
+			  f.prev_remote_name = ?
+			  AND fp.prev_parent_id = ?
+		`, name, fileId).Scan(&fileId)
+		if sql.ErrNoRows == err {
+			return "", errors.Wrapf(err, "could not find file id by previous path %s", fullPath)
+		} else if nil != err {
+			return "", errors.Wrap(err, "could not scan file id")
+		}
+	}
+	return fileId, nil
+}
+
 func parseFileFromRow(row contracts.RowScanner) (f contracts.File, err error) {
 	var DownloadTime interface{}
 	var PrevRemoteModificationTime interface{}
@@ -594,6 +754,10 @@ func parseFileFromRow(row contracts.RowScanner) (f contracts.File, err error) {
 		&f.Trashed,
 		&f.RemovedRemotely,
 		&f.RemovedLocally,
+		&f.DriveId,
+		&f.ReadOnly,
+		&f.Encrypted,
+		&f.Symlink,
 	)
 
 	if err == nil {
@@ -3,13 +3,22 @@ package migration
 import (
 	"database/sql"
 	"fmt"
+	"github.com/pkg/errors"
 	"github.com/svetlyi/gdriveapp/contracts"
 )
 
-var queries []string
+// migration upgrades the schema by one version
+type migration struct {
+	description string
+	up          func(tx *sql.Tx) error
+}
 
-func init() {
-	queries = append(queries, `
+// migrations are the steps of the schema upgrade. The schema version after a step is
+// its index plus one, so the steps are only appended. The databases created before the
+// versioning have version 0, that is why the first steps do not fail, if they have been
+// already done
+var migrations = []migration{
+	{"create files, files_parents and app_state tables", execQueries(`
 CREATE TABLE IF NOT EXISTS files (
 	id VARCHAR(255) PRIMARY KEY,
 	prev_remote_name VARCHAR(255),
@@ -25,31 +34,145 @@ CREATE TABLE IF NOT EXISTS files (
 	trashed SMALLINT DEFAULT 0,
 	removed_remotely SMALLINT DEFAULT 0,
 	removed_locally SMALLINT DEFAULT 0
-)
-`)
-	queries = append(queries, `
+)`, `
 CREATE TABLE IF NOT EXISTS files_parents (
 	file_id VARCHAR(255),
 	prev_parent_id VARCHAR(255),
 	cur_parent_id VARCHAR(255)
-)
-`)
-	queries = append(queries, `
+)`, `
 CREATE TABLE IF NOT EXISTS app_state (
 	setting VARCHAR(255) PRIMARY KEY,
 	value VARCHAR(255)
-)
-`)
+)`)},
+	{"create transfers table", execQueries(`
+CREATE TABLE IF NOT EXISTS transfers (
+	local_path TEXT PRIMARY KEY,
+	file_id VARCHAR(255),
+	parent_id VARCHAR(255),
+	session_uri TEXT,
+	size INTEGER,
+	modification_time VARCHAR(255),
+	created VARCHAR(255)
+)`)},
+	// the shared drive the file belongs to. It is empty for "My Drive"
+	{"add files.drive_id", addColumn("files", "drive_id", "VARCHAR(255) NOT NULL DEFAULT ''")},
+	// the file cannot be changed remotely by the user
+	{"add files.read_only", addColumn("files", "read_only", "SMALLINT NOT NULL DEFAULT 0")},
+	// when the setting was changed last time, for example, how old the change token is
+	{"add app_state.updated", addColumn("app_state", "updated", "DATETIME")},
+	// the content of the remote file is encrypted
+	{"add files.encrypted", addColumn("files", "encrypted", "SMALLINT NOT NULL DEFAULT 0")},
+	// the target of the symbolic link the remote file keeps
+	{"add files.symlink", addColumn("files", "symlink", "TEXT NOT NULL DEFAULT ''")},
+}
+
+// LatestVersion is the version of the schema the application works with
+func LatestVersion() int {
+	return len(migrations)
+}
+
+// GetVersion returns the version of the schema of the database
+func GetVersion(db *sql.DB) (int, error) {
+	if _, err := db.Exec(`
+CREATE TABLE IF NOT EXISTS schema_version (
+	version INTEGER PRIMARY KEY,
+	applied DATETIME DEFAULT CURRENT_TIMESTAMP
+)`); err != nil {
+		return 0, errors.Wrap(err, "could not create schema_version table")
+	}
+	var version int
+	if err := db.QueryRow(`SELECT IFNULL(MAX(version), 0) FROM schema_version`).Scan(&version); err != nil {
+		return 0, errors.Wrap(err, "could not get schema version")
+	}
+	return version, nil
 }
 
+// RunMigrations upgrades the schema to the latest version. Each step runs in a transaction,
+// so an interrupted upgrade continues from the failed step
 func RunMigrations(db *sql.DB, logger contracts.Logger) error {
-	for _, query := range queries {
-		if _, err := (*db).Exec(query); err != nil {
-			logger.Error(fmt.Sprintf("%q: %s\n", err, query))
-			return err
+	version, err := GetVersion(db)
+	if err != nil {
+		return err
+	}
+	if version > LatestVersion() {
+		return errors.Errorf(
+			"the database schema version %d is newer than the version %d the application supports. Please, update the application",
+			version,
+			LatestVersion(),
+		)
+	}
+	for ; version < LatestVersion(); version++ {
+		m := migrations[version]
+		logger.Info(fmt.Sprintf("migrating to version %d: %s", version+1, m.description))
+		if err = runMigration(db, version+1, m); err != nil {
+			logger.Error(fmt.Sprintf("could not migrate to version %d", version+1), err)
+			return errors.Wrapf(err, "could not migrate to version %d", version+1)
 		}
 	}
 	logger.Info("Migrated successfully")
 
 	return nil
 }
+
+func runMigration(db *sql.DB, version int, m migration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return errors.Wrap(err, "could not begin transaction")
+	}
+	if err = m.up(tx); err == nil {
+		_, err = tx.Exec(`INSERT INTO schema_version (version) VALUES (?)`, version)
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func execQueries(queries ...string) func(tx *sql.Tx) error {
+	return func(tx *sql.Tx) error {
+		for _, query := range queries {
+			if _, err := tx.Exec(query); err != nil {
+				return errors.Wrapf(err, "%q", query)
+			}
+		}
+		return nil
+	}
+}
+
+// addColumn adds the column to the table, if the table does not have it yet
+func addColumn(table string, name string, definition string) func(tx *sql.Tx) error {
+	return func(tx *sql.Tx) error {
+		exists, err := hasColumn(tx, table, name)
+		if err != nil || exists {
+			return err
+		}
+		_, err = tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, name, definition))
+		return err
+	}
+}
+
+func hasColumn(tx *sql.Tx, table string, name string) (bool, error) {
+	rows, err := tx.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			cid          int
+			columnName   string
+			columnType   string
+			notNull      int
+			defaultValue interface{}
+			primaryKey   int
+		)
+		if err = rows.Scan(&cid, &columnName, &columnType, &notNull, &defaultValue, &primaryKey); err != nil {
+			return false, err
+		}
+		if columnName == name {
+			return true, nil
+		}
+	}
+	return false, rows.Err()
+}
@@ -0,0 +1,78 @@
+package migration
+
+import (
+	"database/sql"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/svetlyi/gdriveapp/logger"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func openTestDb(t *testing.T) (*sql.DB, func()) {
+	dir, err := ioutil.TempDir("", "gdriveapp-migration-")
+	if nil != err {
+		t.Fatal(err)
+	}
+	db, err := sql.Open("sqlite3", filepath.Join(dir, "sync.db"))
+	if nil != err {
+		t.Fatal(err)
+	}
+	return db, func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestRunMigrationsUpgradesUnversionedDb(t *testing.T) {
+	db, cleanUp := openTestDb(t)
+	defer cleanUp()
+	l, err := logger.New("svetlyi_gdriveapp_test", 10000, 0, false)
+	if nil != err {
+		t.Fatal(err)
+	}
+	// the schema before the versioning
+	if _, err = db.Exec(`CREATE TABLE files (id VARCHAR(255) PRIMARY KEY, cur_remote_name VARCHAR(255))`); nil != err {
+		t.Fatal(err)
+	}
+	if _, err = db.Exec(`INSERT INTO files (id, cur_remote_name) VALUES ('id1', 'report.txt')`); nil != err {
+		t.Fatal(err)
+	}
+
+	if err = RunMigrations(db, l); nil != err {
+		t.Fatal(err)
+	}
+	if version, err := GetVersion(db); nil != err || LatestVersion() != version {
+		t.Errorf("expected version %d, got %d (%v)", LatestVersion(), version, err)
+	}
+	var name, driveId string
+	if err = db.QueryRow(`SELECT cur_remote_name, drive_id FROM files WHERE id = 'id1'`).Scan(&name, &driveId); nil != err {
+		t.Fatal(err)
+	}
+	if "report.txt" != name || "" != driveId {
+		t.Errorf("the file was not kept: %s, %q", name, driveId)
+	}
+	// nothing happens the second time
+	if err = RunMigrations(db, l); nil != err {
+		t.Error(err)
+	}
+}
+
+func TestRunMigrationsFailsOnNewerDb(t *testing.T) {
+	db, cleanUp := openTestDb(t)
+	defer cleanUp()
+	l, err := logger.New("svetlyi_gdriveapp_test", 10000, 0, false)
+	if nil != err {
+		t.Fatal(err)
+	}
+	if err = RunMigrations(db, l); nil != err {
+		t.Fatal(err)
+	}
+	if _, err = db.Exec(`INSERT INTO schema_version (version) VALUES (?)`, LatestVersion()+1); nil != err {
+		t.Fatal(err)
+	}
+	if err = RunMigrations(db, l); nil == err {
+		t.Error("a newer database must not be migrated")
+	}
+}
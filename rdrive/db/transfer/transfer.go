@@ -0,0 +1,122 @@
+// Package transfer stores the resumable upload sessions, so that an interrupted
+// upload continues from where it stopped on the next run.
+package transfer
+
+import (
+	"database/sql"
+	"github.com/pkg/errors"
+	"github.com/svetlyi/gdriveapp/contracts"
+	"sync"
+	"time"
+)
+
+// Session is a resumable upload session of a local file
+type Session struct {
+	LocalPath string
+	// FileId is the id of the remote file being updated. It is empty for a new file
+	FileId string
+	// ParentId is the folder a new file is uploaded to
+	ParentId   string
+	SessionUri string
+	// Size and ModTime describe the local file when the session started.
+	// If the file has changed since then, the session cannot be continued
+	Size    int64
+	ModTime time.Time
+	Created time.Time
+}
+
+type Repository struct {
+	db  *sql.DB
+	log contracts.Logger
+	// writer is shared by the copies of the repository, as the backend has its own copy
+	writer *writer
+}
+
+// writer hands the writes of the sessions over to the function set by SetWriter
+type writer struct {
+	mu    sync.Mutex
+	write func(write func() error) error
+}
+
+func NewRepository(db *sql.DB, log contracts.Logger) Repository {
+	return Repository{db: db, log: log, writer: &writer{}}
+}
+
+// SetWriter makes the repository hand the writes over to write, which performs them in the goroutine,
+// that writes to the database. The uploads are made by the transfer workers, but the database has
+// a single writer. nil makes the repository write itself
+func (tr Repository) SetWriter(write func(write func() error) error) {
+	if nil == tr.writer {
+		return
+	}
+	tr.writer.mu.Lock()
+	tr.writer.write = write
+	tr.writer.mu.Unlock()
+}
+
+// exec performs the write with the writer, if it is set
+func (tr Repository) exec(write func() error) error {
+	var w func(write func() error) error
+	if nil != tr.writer {
+		tr.writer.mu.Lock()
+		w = tr.writer.write
+		tr.writer.mu.Unlock()
+	}
+	if nil == w {
+		return write()
+	}
+	return w(write)
+}
+
+// Get gets the session of the local file
+func (tr Repository) Get(localPath string) (Session, error) {
+	row := tr.db.QueryRow(`
+		SELECT local_path, file_id, parent_id, session_uri, size, modification_time, created
+		FROM transfers WHERE local_path = ? LIMIT 1`,
+		localPath,
+	)
+	var s Session
+	var modTime, created string
+	if err := row.Scan(&s.LocalPath, &s.FileId, &s.ParentId, &s.SessionUri, &s.Size, &modTime, &created); err != nil {
+		return Session{}, errors.Wrapf(err, "could not scan upload session of %s", localPath)
+	}
+	var err error
+	if s.ModTime, err = time.Parse(time.RFC3339Nano, modTime); err != nil {
+		return Session{}, errors.Wrapf(err, "could not parse modification time of %s", localPath)
+	}
+	if s.Created, err = time.Parse(time.RFC3339Nano, created); err != nil {
+		return Session{}, errors.Wrapf(err, "could not parse creation time of session of %s", localPath)
+	}
+	return s, nil
+}
+
+// Save saves the session replacing the previous session of the same local file
+func (tr Repository) Save(s Session) error {
+	tr.log.With(contracts.Fields{
+		contracts.FieldFileId: s.FileId,
+		contracts.FieldPath:   s.LocalPath,
+	}).Debug("saving upload session")
+	return tr.exec(func() error {
+		_, err := tr.db.Exec(`
+			INSERT OR REPLACE INTO transfers
+				(local_path, file_id, parent_id, session_uri, size, modification_time, created)
+			VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			s.LocalPath,
+			s.FileId,
+			s.ParentId,
+			s.SessionUri,
+			s.Size,
+			s.ModTime.Format(time.RFC3339Nano),
+			s.Created.Format(time.RFC3339Nano),
+		)
+		return errors.Wrapf(err, "could not save upload session of %s", s.LocalPath)
+	})
+}
+
+// Delete deletes the session of the local file
+func (tr Repository) Delete(localPath string) error {
+	return tr.exec(func() error {
+		_, err := tr.db.Exec(`DELETE FROM transfers WHERE local_path = ?`, localPath)
+		return errors.Wrapf(err, "could not delete upload session of %s", localPath)
+	})
+}
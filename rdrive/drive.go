@@ -7,37 +7,54 @@ import (
 	"github.com/svetlyi/gdriveapp/app"
 	"github.com/svetlyi/gdriveapp/config"
 	"github.com/svetlyi/gdriveapp/contracts"
+	"github.com/svetlyi/gdriveapp/ldrive/recycle"
+	"github.com/svetlyi/gdriveapp/rdrive/crypt"
 	"github.com/svetlyi/gdriveapp/rdrive/db/file"
+	"github.com/svetlyi/gdriveapp/rdrive/throttle"
+	"golang.org/x/net/context"
 	"google.golang.org/api/drive/v3"
+	"io"
 	"math"
-	"os"
+	"text/tabwriter"
 	"time"
 )
 
 type Drive struct {
-	filesService   drive.FilesService
-	changesService drive.ChangesService
+	backend        RemoteBackend
 	fileRepository file.Repository
 	appState       app.Store
 	log            contracts.Logger
 	cfg            config.Cfg
+	recycleBin     recycle.Bin
+	// downloadLimiter is a pointer, as the copies of the drive share it
+	downloadLimiter *throttle.Limiter
+	// cipher encrypts the files in the encrypted folders. It is nil, if there are no such folders
+	cipher *crypt.Cipher
 }
 
 func New(
-	filesService drive.FilesService,
-	changesService drive.ChangesService,
+	backend RemoteBackend,
 	fileRepository file.Repository,
 	log contracts.Logger,
 	appState app.Store,
 	cfg config.Cfg,
+	cipher *crypt.Cipher,
 ) Drive {
+	if nil != backend {
+		backend = plainBackend{RemoteBackend: backend, cipher: cipher}
+	}
 	return Drive{
-		filesService:   filesService,
-		changesService: changesService,
+		backend:        backend,
 		fileRepository: fileRepository,
 		log:            log,
 		appState:       appState,
 		cfg:            cfg,
+		recycleBin:     recycle.New(cfg),
+		downloadLimiter: throttle.New(func(t time.Time) int64 {
+			_, download := cfg.GetBandwidthLimits(t)
+			return download
+		}),
+		cipher: cipher,
 	}
 }
 
@@ -46,119 +63,196 @@ func New(
 // saves us from querying the server many times. With that information we can
 // easily find a deleted, modified file not only by modification date,
 // but also by hash and full path
-func (d *Drive) FillDb() error {
-	var filesChan = make(chan *drive.File)
-
-	var rootFolder, err = d.getRootFolder()
+func (d *Drive) FillDb(driveId string) error {
+	// the changes made while filling are received the next time
+	startPageToken, err := d.backend.StartPageToken(driveId)
+	if err != nil {
+		return err
+	}
+	rootFolder, err := d.getRootFolder(driveId)
 	if err != nil {
 		return errors.Wrap(err, "could not get root folder while filling database")
 	}
 
-	if err = d.fileRepository.SaveRootFolder(rootFolder); err != nil {
+	if err = d.fileRepository.SaveRootFolder(rootFolder, driveId); err != nil {
 		return errors.Wrap(err, "error saving root folder")
 	}
 
-	go d.getFilesList(filesChan)
-	for gfile := range filesChan {
-		if _, err = d.fileRepository.GetFileById(gfile.Id); err == nil {
-			if err = d.fileRepository.SetCurRemoteData(gfile.Id, gfile.ModifiedTime, gfile.Name, gfile.Parents); err != nil {
-				return errors.Wrapf(err, "could not set current remote data for file id %s", gfile.Id)
-			}
-		} else if sql.ErrNoRows == errors.Cause(err) { // if gfile is a new file in the remote drive
-			d.log.Debug("creating file in db", struct {
-				id   string
-				name string
-			}{
-				id:   gfile.Id,
-				name: gfile.Name,
-			})
-			if err = d.fileRepository.CreateFile(gfile); err != nil {
-				return errors.Wrap(err, "error creating a new file in db in FillDb")
-			}
-		} else {
-			return errors.Wrap(err, "error getting file by id in FillDb")
-		}
+	if err = d.backend.List(driveId, d.saveRemoteFile); err != nil {
+		return errors.Wrap(err, "could not save files in FillDb")
 	}
-	return nil
+	return d.appState.Set(app.GetNextChangeTokenKey(driveId), startPageToken)
+}
+
+// saveRemoteFile saves the information about the remote file to the database:
+// creates the file, if it is new, or updates it otherwise
+func (d *Drive) saveRemoteFile(gfile *drive.File) error {
+	localFile := *gfile
+	localFile.Parents = d.getLocalParents(gfile)
+	_, err := d.fileRepository.GetFileById(gfile.Id)
+	if sql.ErrNoRows == errors.Cause(err) { // if gfile is a new file in the remote drive
+		d.log.With(contracts.Fields{
+			contracts.FieldFileId: gfile.Id,
+			"name":                gfile.Name,
+		}).Debug("creating file in db")
+		return errors.Wrap(d.fileRepository.CreateFile(&localFile), "error creating a new file in db")
+	} else if err != nil {
+		return errors.Wrap(err, "error getting file by id")
+	}
+
+	d.log.With(contracts.Fields{
+		contracts.FieldFileId: gfile.Id,
+		"name":                gfile.Name,
+	}).Debug("setting remote data")
+	if err = d.fileRepository.SetCurRemoteData(gfile.Id, gfile.ModifiedTime, gfile.Name, localFile.Parents); err != nil {
+		return errors.Wrapf(err, "could not set current remote data for file id %s", gfile.Id)
+	}
+	if err = d.fileRepository.SetCurRemoteContent(gfile); err != nil {
+		return errors.Wrapf(err, "could not set current remote content for file id %s", gfile.Id)
+	}
+	return d.fileRepository.SetReadOnly(gfile.Id, file.IsReadOnly(gfile))
 }
 
-// SaveChangesToDb gets changes since the last synchronization and
-// saves the changes to the database
-func (d *Drive) SaveChangesToDb() error {
-	var changesChan = make(chan *drive.Change)
-	var exitChan = make(contracts.ExitChan)
-
-	go d.getChangedFilesList(changesChan, exitChan)
-
-	var err error = nil
-	for {
-		select {
-		case change, ok := <-changesChan:
-			if !ok { //no more changes
-				d.log.Debug("changesChan closed")
-				return nil
+// SyncMetadata saves the information about the files of "My Drive" and of the configured
+// shared drives to the database: the drives synchronized for the first time are filled,
+// for the rest just the changes since the last synchronization are saved. The information
+// about the shared drives, that are not synchronized anymore, is deleted
+func (d *Drive) SyncMetadata() error {
+	rootFolders, err := d.fileRepository.GetRootFolders()
+	if err != nil {
+		return err
+	}
+	synchronizedDrives := make(map[string]bool)
+	for _, rootFolder := range rootFolders {
+		synchronizedDrives[rootFolder.DriveId] = true
+	}
+
+	configuredDrives := append([]string{""}, d.cfg.SharedDrives...)
+	for _, driveId := range configuredDrives {
+		if synchronizedDrives[driveId] {
+			if err = d.SaveChangesToDb(driveId); err != nil {
+				return errors.Wrapf(err, "could not save changes of drive %q", driveId)
 			}
-			// we do not have a trash been here, so we mark just as removed
-			if change.Removed || change.File.Trashed || change.File.ExplicitlyTrashed {
-				d.log.Debug("changes:removed", struct{ id string }{id: change.FileId})
-
-				if err = d.fileRepository.SetRemovedRemotely(change.FileId); err != nil {
-					err = errors.Wrap(err, "could not SetRemovedRemotely")
-					break
-				}
-			} else {
-				if _, err = d.fileRepository.GetFileById(change.FileId); nil == err {
-					d.log.Debug("changes:setting remote data", struct {
-						id   string
-						name string
-					}{
-						id:   change.FileId,
-						name: change.File.Name,
-					})
-					if err = d.fileRepository.SetCurRemoteData(change.FileId, change.File.ModifiedTime, change.File.Name, change.File.Parents); err != nil {
-						err = errors.Wrap(err, "could not SetCurRemoteData")
-						break
-					}
-				} else if sql.ErrNoRows == errors.Cause(err) { // if gfile is a new file in the remote drive
-					d.log.Debug("changes:creating a new file in db", struct {
-						id   string
-						name string
-					}{
-						id:   change.FileId,
-						name: change.File.Name,
-					})
-					if err = d.fileRepository.CreateFile(change.File); err != nil {
-						err = errors.Wrap(err, "could not CreateFile in db")
-						break
-					}
-				} else {
-					err = errors.Wrap(err, "could not GetFileById")
-					break
-				}
+			delete(synchronizedDrives, driveId)
+			continue
+		}
+		d.log.Info("filling database", struct{ driveId string }{driveId})
+		if err = d.FillDb(driveId); err != nil {
+			return errors.Wrapf(err, "could not fill database for drive %q", driveId)
+		}
+	}
+	if d.cfg.SharedWithMe {
+		// the changes of the shared files were saved with the changes of "My Drive"
+		if !synchronizedDrives[SharedWithMeRootId] {
+			d.log.Info("filling database with shared files")
+			if err = d.fillSharedWithMe(); err != nil {
+				return errors.Wrap(err, "could not fill database with shared files")
 			}
-		case <-exitChan:
-			err = errors.New("getting changed files list error")
-		case <-time.After(10 * time.Second):
-			err = errors.New("timeout error")
 		}
-
-		if nil != err {
+		delete(synchronizedDrives, SharedWithMeRootId)
+	}
+	for driveId := range synchronizedDrives {
+		d.log.Info("the drive is not synchronized anymore", driveId)
+		if err = d.fileRepository.DeleteDrive(driveId); err != nil {
+			return err
+		}
+		if err = d.appState.Delete(app.GetNextChangeTokenKey(driveId)); err != nil {
 			return err
 		}
 	}
+	return nil
 }
 
+// SaveChangesToDb gets changes of "My Drive" (driveId is empty) or of a shared
+// drive since the last synchronization and saves the changes to the database
+func (d *Drive) SaveChangesToDb(driveId string) error {
+	pageToken, err := d.appState.Get(app.GetNextChangeTokenKey(driveId))
+	if err != nil && sql.ErrNoRows != errors.Cause(err) {
+		return errors.Wrap(err, "error getting NextChangeToken from app state")
+	}
+	// the changes made while saving are received the next time
+	startPageToken, err := d.backend.StartPageToken(driveId)
+	if err != nil {
+		return err
+	}
+	if "" == pageToken {
+		pageToken = startPageToken
+	}
+	err = d.backend.Changes(driveId, pageToken, func(change *drive.Change) error {
+		// we do not have a trash been here, so we mark just as removed
+		if change.Removed || change.File.Trashed || change.File.ExplicitlyTrashed {
+			d.log.Debug("changes:removed", struct{ id string }{id: change.FileId})
+			return errors.Wrap(d.fileRepository.SetRemovedRemotely(change.FileId), "could not SetRemovedRemotely")
+		}
+		return errors.Wrap(d.saveRemoteFile(change.File), "could not save changed file")
+	})
+	if err != nil {
+		return errors.Wrap(err, "could not save changes")
+	}
+	return d.appState.Set(app.GetNextChangeTokenKey(driveId), startPageToken)
+}
+
+// PrintUsageStats logs the used and the available storage. The synchronization
+// does not need them, so an error is just logged
 func PrintUsageStats(aboutService *drive.AboutService, log contracts.Logger) {
 	aboutData, err := aboutService.Get().Fields("storageQuota").Do()
 	if err != nil {
 		log.Error("Unable to retrieve About data: %v", err)
-		os.Exit(1)
-	}
-	log.Info("Usage stats:", struct {
-		Used  string
-		Limit string
-	}{
-		fmt.Sprintf("%.3f GB", float64(aboutData.StorageQuota.Usage)/math.Pow(1024, 2)),
-		fmt.Sprintf("%.3f GB", float64(aboutData.StorageQuota.Limit)/math.Pow(1024, 2)),
+		return
+	}
+	log.With(contracts.Fields{
+		"used":  fmt.Sprintf("%.3f GB", float64(aboutData.StorageQuota.Usage)/math.Pow(1024, 2)),
+		"limit": fmt.Sprintf("%.3f GB", float64(aboutData.StorageQuota.Limit)/math.Pow(1024, 2)),
+	}).Info("Usage stats:")
+}
+
+// PrintSharedDrives prints the ids and the names of the shared drives the user has access to
+func PrintSharedDrives(drivesService *drive.DrivesService, w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	err := drivesService.List().PageSize(100).Pages(context.Background(), func(list *drive.DriveList) error {
+		for _, sharedDrive := range list.Drives {
+			if _, err := fmt.Fprintf(tw, "%s\t%s\n", sharedDrive.Id, sharedDrive.Name); err != nil {
+				return err
+			}
+		}
+		return nil
 	})
+	if err != nil {
+		return errors.Wrap(err, "could not list shared drives")
+	}
+	return tw.Flush()
+}
+
+// CleanUpRecycleBin removes the files kept in the recycle bin longer than
+// configured and the oldest ones, if the recycle bin is too big
+func (d *Drive) CleanUpRecycleBin() error {
+	return errors.Wrap(d.recycleBin.CleanUp(), "could not clean up recycle bin")
+}
+
+// SetLastSyncTime saves the time of the finished synchronization
+func (d *Drive) SetLastSyncTime(t time.Time) error {
+	return errors.Wrap(d.appState.Set(app.LastSyncTime, t.Format(time.RFC3339)), "could not save last sync time")
+}
+
+// GetLastSyncTime returns the time of the last finished synchronization.
+// It is zero, if the files have not been synchronized yet
+func (d *Drive) GetLastSyncTime() (time.Time, error) {
+	value, err := d.appState.Get(app.LastSyncTime)
+	if sql.ErrNoRows == errors.Cause(err) {
+		return time.Time{}, nil
+	} else if err != nil {
+		return time.Time{}, err
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	return t, errors.Wrapf(err, "could not parse last sync time %s", value)
+}
+
+// GetChangeTokenTime returns the time the change token of "My Drive" (driveId is empty)
+// or of a shared drive was saved, that is when the remote changes were received last time
+func (d *Drive) GetChangeTokenTime(driveId string) (time.Time, error) {
+	t, err := d.appState.GetUpdated(app.GetNextChangeTokenKey(driveId))
+	if sql.ErrNoRows == errors.Cause(err) {
+		return time.Time{}, nil
+	}
+	return t, err
 }
@@ -0,0 +1,306 @@
+package rdrive
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/svetlyi/gdriveapp/contracts"
+	lfile "github.com/svetlyi/gdriveapp/ldrive/file"
+	lfileHash "github.com/svetlyi/gdriveapp/ldrive/file/hash"
+	"github.com/svetlyi/gdriveapp/rdrive/crypt"
+	"github.com/svetlyi/gdriveapp/rdrive/specification"
+	"google.golang.org/api/drive/v3"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// encryptedDirName is the folder next to the database the files are encrypted to before
+// they are uploaded. An encrypted file is kept until its upload is finished, so that
+// an interrupted upload continues with the same encrypted content
+const encryptedDirName = "encrypted"
+
+// decryptedSuffix is added to the path of a downloaded encrypted file while it is decrypted
+const decryptedSuffix = ".decrypted"
+
+var errNoKey = errors.New("the file is encrypted, but there is no encryption key in the config")
+
+// plainBackend shows the encrypted remote files the way they are kept locally: with the plain
+// names and with the keyed hash and the size of the plain content. So the rest of the application
+// compares the remote files with the local ones the same way, whether they are encrypted or not
+type plainBackend struct {
+	RemoteBackend
+	// cipher decrypts the names. It is nil, if the encryption is not configured
+	cipher *crypt.Cipher
+}
+
+// plain returns the file as it is kept locally
+func (b plainBackend) plain(f *drive.File) (*drive.File, error) {
+	if nil == f {
+		return nil, nil
+	}
+	plain := *f
+	var err error
+	if crypt.IsEncrypted(f) {
+		if plain.Md5Checksum, plain.Size, err = crypt.GetPlainContent(f); nil != err {
+			return nil, err
+		}
+	}
+	if crypt.IsEncryptedName(f.Name) {
+		if nil == b.cipher {
+			return nil, errors.Errorf("the name of file %s is encrypted, but there is no encryption key in the config", f.Id)
+		}
+		if plain.Name, err = b.cipher.DecryptName(f.Name); nil != err {
+			return nil, errors.Wrapf(err, "could not decrypt the name of file %s", f.Id)
+		}
+	}
+	return &plain, nil
+}
+
+// plainFn calls fn with the plain files
+func (b plainBackend) plainFn(fn func(*drive.File) error) func(*drive.File) error {
+	return func(f *drive.File) error {
+		plain, err := b.plain(f)
+		if nil != err {
+			return err
+		}
+		return fn(plain)
+	}
+}
+
+// plainResult returns the plain file, if the call did not fail
+func (b plainBackend) plainResult(f *drive.File, err error) (*drive.File, error) {
+	if nil != err {
+		return nil, err
+	}
+	return b.plain(f)
+}
+
+func (b plainBackend) List(driveId string, fn func(*drive.File) error) error {
+	return b.RemoteBackend.List(driveId, b.plainFn(fn))
+}
+
+func (b plainBackend) ListSharedWithMe(fn func(*drive.File) error) error {
+	return b.RemoteBackend.ListSharedWithMe(b.plainFn(fn))
+}
+
+func (b plainBackend) ListChildren(folderId string, fn func(*drive.File) error) error {
+	return b.RemoteBackend.ListChildren(folderId, b.plainFn(fn))
+}
+
+func (b plainBackend) ListTrashed(fn func(*drive.File) error) error {
+	return b.RemoteBackend.ListTrashed(b.plainFn(fn))
+}
+
+func (b plainBackend) Changes(driveId string, pageToken string, fn func(*drive.Change) error) error {
+	return b.RemoteBackend.Changes(driveId, pageToken, func(change *drive.Change) error {
+		if nil != change.File {
+			plain, err := b.plain(change.File)
+			if nil != err {
+				return err
+			}
+			plainChange := *change
+			plainChange.File = plain
+			change = &plainChange
+		}
+		return fn(change)
+	})
+}
+
+func (b plainBackend) Get(fileId string) (*drive.File, error) {
+	return b.plainResult(b.RemoteBackend.Get(fileId))
+}
+
+func (b plainBackend) Upload(localPath string, fileId string, parentId string, name string, properties map[string]string) (*drive.File, error) {
+	return b.plainResult(b.RemoteBackend.Upload(localPath, fileId, parentId, name, properties))
+}
+
+func (b plainBackend) Update(fileId string, name string, addParentId string, removeParentId string) (*drive.File, error) {
+	return b.plainResult(b.RemoteBackend.Update(fileId, name, addParentId, removeParentId))
+}
+
+func (b plainBackend) Copy(fileId string, name string, parentId string) (*drive.File, error) {
+	return b.plainResult(b.RemoteBackend.Copy(fileId, name, parentId))
+}
+
+func (b plainBackend) CreateFolder(name string, parentId string) (*drive.File, error) {
+	return b.plainResult(b.RemoteBackend.CreateFolder(name, parentId))
+}
+
+func (b plainBackend) Restore(fileId string) (*drive.File, error) {
+	return b.plainResult(b.RemoteBackend.Restore(fileId))
+}
+
+// CalcHash calculates the hash of the local file the way the hash of the remote one
+// is kept in the database: the hash of an encrypted file is keyed. A stored symbolic
+// link is never encrypted, its hash is the hash of the target
+func (d *Drive) CalcHash(fullPath string, encrypted bool) (string, error) {
+	if target, isLink, err := d.readSymlink(fullPath); nil != err {
+		return "", err
+	} else if isLink {
+		return symlinkHash(target), nil
+	}
+	hash, err := lfileHash.CalcCachedHash(fullPath)
+	if nil != err || !encrypted {
+		return hash, err
+	}
+	if nil == d.cipher {
+		return "", errNoKey
+	}
+	return d.cipher.Hash(hash), nil
+}
+
+// isEncrypted says if the local file is in one of the encrypted folders
+func (d *Drive) isEncrypted(fullPath string) bool {
+	relativePath, err := filepath.Rel(d.cfg.DrivePath, fullPath)
+	return nil == err && d.cfg.IsEncrypted(relativePath)
+}
+
+// getRemoteName returns the name the local file has remotely
+func (d *Drive) getRemoteName(fullPath string) string {
+	name := filepath.Base(fullPath)
+	if d.cfg.EncryptNames && nil != d.cipher && d.isEncrypted(fullPath) {
+		return d.cipher.EncryptName(name)
+	}
+	return name
+}
+
+// upload uploads the local file to the remote file prev or, if it is empty, to a new file
+// in the folder with id parentId. The files in the encrypted folders are encrypted first,
+// a stored symbolic link is uploaded as its target. The app properties of prev, that do
+// not describe the new content anymore, are cleared
+func (d *Drive) upload(fullPath string, parentId string, prev contracts.File) (*drive.File, error) {
+	properties := make(map[string]string)
+	if 1 == prev.Encrypted {
+		for name, value := range crypt.PlainProperties() {
+			properties[name] = value
+		}
+	}
+	if specification.IsSymlink(prev) {
+		properties[specification.PropertySymlink] = ""
+	}
+	target, isLink, err := d.readSymlink(fullPath)
+	if nil != err {
+		return nil, err
+	}
+	localPath := fullPath
+	encrypted := !isLink && d.isEncrypted(fullPath)
+	if isLink {
+		if localPath, err = writeSymlinkContent(target); nil != err {
+			return nil, err
+		}
+		defer os.Remove(localPath)
+		properties[specification.PropertySymlink] = target
+	} else if encrypted {
+		var encryptedProperties map[string]string
+		if localPath, encryptedProperties, err = d.encrypt(fullPath); nil != err {
+			return nil, err
+		}
+		for name, value := range encryptedProperties {
+			properties[name] = value
+		}
+	}
+	rf, err := d.backend.Upload(localPath, prev.Id, parentId, d.getRemoteName(fullPath), properties)
+	if nil == err && encrypted {
+		err = os.Remove(localPath)
+	}
+	return rf, err
+}
+
+// encrypt encrypts the local file to the folder with the encrypted files and returns the path
+// of the encrypted file and the app properties of the remote one. The same file with the same
+// content is encrypted just once, so that an interrupted upload of it continues
+func (d *Drive) encrypt(fullPath string) (string, map[string]string, error) {
+	if nil == d.cipher {
+		return "", nil, errNoKey
+	}
+	stat, err := os.Stat(fullPath)
+	if nil != err {
+		return "", nil, errors.Wrapf(err, "could not get stat for file %s", fullPath)
+	}
+	hash, err := d.CalcHash(fullPath, true)
+	if nil != err {
+		return "", nil, err
+	}
+	properties := crypt.Properties(hash, stat.Size())
+	dir := filepath.Join(filepath.Dir(d.cfg.DBPath), encryptedDirName)
+	id := sha256.Sum256([]byte(fmt.Sprintf("%s\x00%s\x00%d", fullPath, hash, stat.Size())))
+	encryptedPath := filepath.Join(dir, hex.EncodeToString(id[:]))
+	if _, err = os.Stat(encryptedPath); nil == err {
+		return encryptedPath, properties, nil
+	}
+	if err = os.MkdirAll(dir, 0700); nil != err {
+		return "", nil, errors.Wrapf(err, "could not create folder %s", dir)
+	}
+	d.removeStaleEncrypted(dir)
+
+	src, err := os.Open(fullPath)
+	if nil != err {
+		return "", nil, errors.Wrapf(err, "could not open %s", fullPath)
+	}
+	defer src.Close()
+	tmp, err := ioutil.TempFile(dir, "encrypting-*")
+	if nil != err {
+		return "", nil, errors.Wrap(err, "could not create temporary file")
+	}
+	err = d.cipher.Encrypt(tmp, src)
+	if closeErr := tmp.Close(); nil == err {
+		err = closeErr
+	}
+	if nil == err {
+		err = os.Rename(tmp.Name(), encryptedPath)
+	}
+	if nil != err {
+		os.Remove(tmp.Name())
+		return "", nil, errors.Wrapf(err, "could not encrypt %s", fullPath)
+	}
+	return encryptedPath, properties, nil
+}
+
+// removeStaleEncrypted removes the encrypted files, which uploads were interrupted
+// too long ago to be continued, for example, because the local files changed since then
+func (d *Drive) removeStaleEncrypted(dir string) {
+	infos, err := ioutil.ReadDir(dir)
+	if nil != err {
+		d.log.Warning("could not list encrypted files", err)
+		return
+	}
+	for _, info := range infos {
+		if time.Since(info.ModTime()) > sessionLifetime {
+			if err = os.Remove(filepath.Join(dir, info.Name())); nil != err {
+				d.log.Warning("could not remove stale encrypted file", err)
+			}
+		}
+	}
+}
+
+// decrypt decrypts the downloaded encrypted file of the local file with the path and returns
+// the path of the decrypted one. The encrypted file is removed, as it is either decrypted
+// or damaged, most probably because the remote file changed while it was downloaded partially
+func (d *Drive) decrypt(encryptedPath string, fileFullPath string) (string, error) {
+	decryptedPath := lfile.GetPartialPath(fileFullPath + decryptedSuffix)
+	src, err := os.Open(encryptedPath)
+	if nil != err {
+		return "", errors.Wrapf(err, "could not open %s", encryptedPath)
+	}
+	dst, err := os.Create(decryptedPath)
+	if nil != err {
+		src.Close()
+		return "", errors.Wrapf(err, "could not create %s", decryptedPath)
+	}
+	err = d.cipher.Decrypt(dst, src)
+	src.Close()
+	if closeErr := dst.Close(); nil == err {
+		err = closeErr
+	}
+	if removeErr := os.Remove(encryptedPath); nil == err {
+		err = removeErr
+	}
+	if nil != err {
+		os.Remove(decryptedPath)
+		return "", errors.Wrapf(err, "could not decrypt %s", encryptedPath)
+	}
+	return decryptedPath, nil
+}
@@ -0,0 +1,139 @@
+package rdrive
+
+import (
+	"github.com/pkg/errors"
+	"github.com/svetlyi/gdriveapp/contracts"
+	lfile "github.com/svetlyi/gdriveapp/ldrive/file"
+	"os"
+	"path/filepath"
+)
+
+// Execute performs an action planned by PlanSyncRemoteWithLocal or by the synchronizer.
+// It returns the id of the remote folder the action created or moved
+func (d *Drive) Execute(action contracts.Action) (string, error) {
+	if IsTransfer(action) {
+		commit, err := d.Transfer(action)
+		if nil != err {
+			return "", err
+		}
+		return "", commit()
+	}
+
+	d.logAction(action)
+	file := action.File
+	var err error
+	switch action.Type {
+	case contracts.ACTION_MKDIR:
+		if contracts.SIDE_REMOTE == action.Side {
+			return d.CreateFolder(d.getFullPath(action.Path), []string{action.ParentId})
+		}
+		err = d.createLocalFolder(file)
+	case contracts.ACTION_MOVE:
+		if contracts.SIDE_REMOTE == action.Side {
+			return d.moveRemotely(action.FileId, d.getRemoteName(d.getFullPath(action.Path)), action.ParentId)
+		}
+		err = d.handleMovedRemotely(file)
+	case contracts.ACTION_DELETE_LOCAL:
+		err = d.handleRemovedRemotely(file)
+	case contracts.ACTION_DELETE_REMOTE:
+		err = d.Delete(file)
+	case contracts.ACTION_CONFLICT:
+		err = d.resolveConflict(file, action.LocalChange, action.RemoteChange)
+	case contracts.ACTION_MARK_SYNCED:
+		err = d.markSynced(file)
+	case contracts.ACTION_MARK_REMOVED_LOCALLY:
+		if err = d.fileRepository.SetRemovedLocally(file.Id, true); err != nil {
+			err = errors.Wrapf(err, "could not set removed locally for file %s", file.Id)
+		}
+	default:
+		err = errors.Errorf("unknown action %s", action.Type)
+	}
+
+	return "", err
+}
+
+// Transfer performs the network part of a download or an upload action: the content
+// of the file is transferred, but nothing is saved to the database. It is safe to run
+// transfers concurrently. The returned function saves the result to the database, it must
+// be called by the only goroutine, that writes to the database
+func (d *Drive) Transfer(action contracts.Action) (func() error, error) {
+	d.logAction(action)
+	file := action.File
+	switch action.Type {
+	case contracts.ACTION_DOWNLOAD:
+		if err := d.downloadContent(file); err != nil {
+			return nil, errors.Wrapf(err, "could not download file %s", file.Id)
+		}
+		return func() error { return d.markSynced(file) }, nil
+	case contracts.ACTION_UPLOAD:
+		if "" == action.FileId { // a new local file
+			return d.uploadNew(d.getFullPath(action.Path), []string{action.ParentId})
+		}
+		commit, err := d.uploadContent(file)
+		if err != nil {
+			return nil, errors.Wrapf(err, "could not updateRemote file %s", file.Id)
+		}
+		return commit, nil
+	}
+	return nil, errors.Errorf("%s is not a transfer", action.Type)
+}
+
+// IsTransfer says if the action transfers the content of a file and can be performed by Transfer
+func IsTransfer(action contracts.Action) bool {
+	return contracts.ACTION_DOWNLOAD == action.Type || contracts.ACTION_UPLOAD == action.Type
+}
+
+func (d *Drive) logAction(action contracts.Action) {
+	if action.IsBookkeeping() {
+		return
+	}
+	log := d.log.With(contracts.Fields{
+		contracts.FieldAction: action.Type,
+		contracts.FieldPath:   action.Path,
+		contracts.FieldFileId: action.FileId,
+		contracts.FieldSide:   action.Side,
+	})
+	if contracts.ACTION_CONFLICT == action.Type {
+		log.Warning("CONFLICT. "+action.Reason, action.File)
+	} else {
+		log.Info(string(action.Type) + ". " + action.Reason)
+	}
+}
+
+func (d *Drive) getFullPath(relativePath string) string {
+	return filepath.Join(d.cfg.DrivePath, relativePath)
+}
+
+func (d *Drive) createLocalFolder(file contracts.File) error {
+	curFullFilePath := lfile.GetCurFullPath(d.cfg, file)
+	if err := os.Mkdir(curFullFilePath, 0744); err != nil && !os.IsExist(err) {
+		return errors.Wrap(err, "could not create dir")
+	}
+	return d.markSynced(file)
+}
+
+// moveRemotely moves a folder, that was moved locally, to the folder with id parentId
+// and renames it. Having been found, the folder is not considered as removed locally anymore
+func (d *Drive) moveRemotely(fileId string, name string, parentId string) (string, error) {
+	oldParentId, err := d.fileRepository.GetParentIdByChildId(fileId)
+	if nil != err {
+		return "", errors.Wrapf(err, "could not GetParentIdByChildId for file id %s", fileId)
+	}
+	// at this point it is known, that the folder with id fileId was moved from a folder with
+	// id oldParentId to a folder with id parentId and now the moved folder has the name.
+	// This is the information, that goes to the database
+	f, err := d.Update(fileId, name, []string{parentId}, []string{oldParentId})
+	if nil != err {
+		return "", err
+	}
+	if err = d.fileRepository.SetRemovedLocally(fileId, false); nil != err {
+		return "", err
+	}
+	if err = d.fileRepository.SetCurRemoteData(fileId, f.ModifiedTime, f.Name, d.getLocalParents(f)); nil != err {
+		return "", err
+	}
+	if err = d.fileRepository.SetPrevRemoteDataToCur(fileId); nil != err {
+		return "", err
+	}
+	return f.Id, nil
+}
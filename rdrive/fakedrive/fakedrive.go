@@ -0,0 +1,750 @@
+// Package fakedrive is an in-memory stand-in for the Google Drive v3 REST API. It serves
+// the endpoints the application uses, so the synchronization can be tested offline:
+//
+//	server := fakedrive.New()
+//	defer server.Close()
+//	srv, err := server.Service(context.Background())
+package fakedrive
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/option"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RootFolderId is the id of the root folder of "My Drive"
+const RootFolderId = "fake-root"
+
+// RootFolderName is the name of the root folder of "My Drive"
+const RootFolderName = "My Drive"
+
+const folderMimeType = "application/vnd.google-apps.folder"
+
+// storageLimit is the storage quota reported by the about endpoint
+const storageLimit = 15 << 30
+
+// Server is a fake Google Drive with an in-memory file tree
+type Server struct {
+	*httptest.Server
+
+	mu     sync.Mutex
+	files  map[string]*file
+	drives []*drive.Drive
+	// changes are the ids of the changed files. The page token is the index of a change plus one
+	changes  []change
+	sessions map[string]*session
+	lastId   int
+	lastTime time.Time
+	// failures is how many of the next requests fail with failureCode and failureReason
+	failures      int
+	failureCode   int
+	failureReason string
+}
+
+type file struct {
+	meta    drive.File
+	content []byte
+}
+
+type change struct {
+	fileId  string
+	driveId string
+}
+
+// session is a resumable upload session
+type session struct {
+	fileId  string
+	meta    drive.File
+	content []byte
+}
+
+// New starts the server with an empty "My Drive"
+func New() *Server {
+	s := &Server{files: make(map[string]*file), sessions: make(map[string]*session)}
+	s.files[RootFolderId] = &file{meta: drive.File{
+		Id:           RootFolderId,
+		Name:         RootFolderName,
+		MimeType:     folderMimeType,
+		ModifiedTime: s.tick(),
+		OwnedByMe:    true,
+		Capabilities: &drive.FileCapabilities{CanEdit: true, CanAddChildren: true},
+	}}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+// Service returns a drive service, that sends the requests to the server
+func (s *Server) Service(ctx context.Context) (*drive.Service, error) {
+	return drive.NewService(ctx, option.WithEndpoint(s.URL+"/drive/v3/"), option.WithHTTPClient(s.Client()))
+}
+
+// CreateFile creates a file with the content in the folder
+func (s *Server) CreateFile(name string, parentId string, content []byte) *drive.File {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f := s.create(drive.File{Name: name, Parents: []string{parentId}}, content)
+	return s.copyMeta(f)
+}
+
+// CreateFolder creates a folder in the folder
+func (s *Server) CreateFolder(name string, parentId string) *drive.File {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f := s.create(drive.File{Name: name, Parents: []string{parentId}, MimeType: folderMimeType}, nil)
+	return s.copyMeta(f)
+}
+
+// AddSharedDrive creates a shared drive. Its root folder has the same id and name
+func (s *Server) AddSharedDrive(name string) *drive.Drive {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sharedDrive := &drive.Drive{Id: s.newId(), Name: name}
+	s.drives = append(s.drives, sharedDrive)
+	s.files[sharedDrive.Id] = &file{meta: drive.File{
+		Id:           sharedDrive.Id,
+		Name:         name,
+		MimeType:     folderMimeType,
+		DriveId:      sharedDrive.Id,
+		ModifiedTime: s.tick(),
+		Capabilities: &drive.FileCapabilities{CanEdit: true, CanAddChildren: true},
+	}}
+	return sharedDrive
+}
+
+// SetContent replaces the content of the file
+func (s *Server) SetContent(fileId string, content []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, ok := s.files[fileId]
+	if !ok {
+		panic("fakedrive: no file " + fileId)
+	}
+	s.setContent(f, content)
+}
+
+// Modify changes the metadata of the file, for example, trashes it or shares it
+// with the user. The change is listed by the changes endpoint
+func (s *Server) Modify(fileId string, fn func(*drive.File)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, ok := s.files[fileId]
+	if !ok {
+		panic("fakedrive: no file " + fileId)
+	}
+	fn(&f.meta)
+	s.touch(f)
+}
+
+// Remove removes the file and its content for good
+func (s *Server) Remove(fileId string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.remove(fileId)
+}
+
+// Get returns the file by id
+func (s *Server) Get(fileId string) (*drive.File, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, ok := s.files[fileId]
+	if !ok {
+		return nil, false
+	}
+	return s.copyMeta(f), true
+}
+
+// Content returns the content of the file
+func (s *Server) Content(fileId string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, ok := s.files[fileId]
+	if !ok {
+		return nil, false
+	}
+	return append([]byte(nil), f.content...), true
+}
+
+// Find finds a not trashed file by the path relative to the root folder, like "docs/report.txt"
+func (s *Server) Find(path string) (*drive.File, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cur := s.files[RootFolderId]
+	for _, name := range strings.Split(path, "/") {
+		var next *file
+		for _, f := range s.files {
+			if !f.meta.Trashed && name == f.meta.Name && 1 == len(f.meta.Parents) && cur.meta.Id == f.meta.Parents[0] {
+				next = f
+				break
+			}
+		}
+		if nil == next {
+			return nil, false
+		}
+		cur = next
+	}
+	return s.copyMeta(cur), true
+}
+
+// FailNext makes the next count requests fail with the code and the reason, for example,
+// 403 and "userRateLimitExceeded"
+func (s *Server) FailNext(count int, code int, reason string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures, s.failureCode, s.failureReason = count, code, reason
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failures > 0 {
+		s.failures--
+		writeError(w, s.failureCode, s.failureReason, "failure made by FailNext")
+		return
+	}
+	path := r.URL.Path
+	switch {
+	case strings.HasPrefix(path, "/upload/drive/v3/files"):
+		s.serveUpload(w, r, strings.Trim(strings.TrimPrefix(path, "/upload/drive/v3/files"), "/"))
+	case "/drive/v3/about" == path:
+		s.serveAbout(w)
+	case "/drive/v3/changes/startPageToken" == path:
+		writeJSON(w, &drive.StartPageToken{StartPageToken: strconv.Itoa(len(s.changes) + 1)})
+	case "/drive/v3/changes" == path:
+		s.serveChanges(w, r)
+	case "/drive/v3/drives" == path:
+		writeJSON(w, &drive.DriveList{Drives: s.drives})
+	case "/drive/v3/files" == path && http.MethodGet == r.Method:
+		s.serveList(w, r)
+	case "/drive/v3/files" == path && http.MethodPost == r.Method:
+		var meta drive.File
+		if !readJSON(w, r.Body, &meta) {
+			return
+		}
+		s.writeFile(w, s.create(meta, nil))
+	case "/drive/v3/files/trash" == path && http.MethodDelete == r.Method:
+		for _, f := range s.sortedFiles() {
+			if f.meta.ExplicitlyTrashed {
+				s.remove(f.meta.Id)
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case strings.HasPrefix(path, "/drive/v3/files/"):
+		s.serveFile(w, r, strings.Split(strings.TrimPrefix(path, "/drive/v3/files/"), "/"))
+	default:
+		writeError(w, http.StatusNotFound, "notFound", "unknown endpoint "+path)
+	}
+}
+
+func (s *Server) serveAbout(w http.ResponseWriter) {
+	var usage int64
+	for _, f := range s.files {
+		usage += int64(len(f.content))
+	}
+	writeJSON(w, &drive.About{StorageQuota: &drive.AboutStorageQuota{Usage: usage, Limit: storageLimit}})
+}
+
+func (s *Server) serveFile(w http.ResponseWriter, r *http.Request, parts []string) {
+	fileId := parts[0]
+	if "root" == fileId {
+		fileId = RootFolderId
+	}
+	f, ok := s.files[fileId]
+	if !ok {
+		writeError(w, http.StatusNotFound, "notFound", "File not found: "+fileId)
+		return
+	}
+	action := ""
+	if len(parts) > 1 {
+		action = parts[1]
+	}
+	switch {
+	case "" == action && http.MethodGet == r.Method && "media" == r.URL.Query().Get("alt"):
+		s.serveContent(w, r, f)
+	case "" == action && http.MethodGet == r.Method:
+		s.writeFile(w, f)
+	case "" == action && http.MethodPatch == r.Method:
+		if s.update(w, r, f) {
+			s.writeFile(w, f)
+		}
+	case "" == action && http.MethodDelete == r.Method:
+		s.remove(fileId)
+		w.WriteHeader(http.StatusNoContent)
+	case "copy" == action && http.MethodPost == r.Method:
+		meta := *s.copyMeta(f)
+		var requested drive.File
+		if !readJSON(w, r.Body, &requested) {
+			return
+		}
+		if "" != requested.Name {
+			meta.Name = requested.Name
+		}
+		if 0 != len(requested.Parents) {
+			meta.Parents = requested.Parents
+		}
+		s.writeFile(w, s.create(meta, f.content))
+	case "export" == action && http.MethodGet == r.Method:
+		// there is no conversion, the content is kept in the exported format
+		w.Header().Set("Content-Type", r.URL.Query().Get("mimeType"))
+		w.Write(f.content)
+	default:
+		writeError(w, http.StatusNotFound, "notFound", "unknown endpoint "+r.URL.Path)
+	}
+}
+
+// serveContent downloads the file. "Range: bytes=N-" is supported to continue a download
+func (s *Server) serveContent(w http.ResponseWriter, r *http.Request, f *file) {
+	if folderMimeType == f.meta.MimeType {
+		writeError(w, http.StatusForbidden, "fileNotDownloadable", "folders cannot be downloaded")
+		return
+	}
+	w.Header().Set("Content-Type", f.meta.MimeType)
+	rangeHeader := r.Header.Get("Range")
+	if !strings.HasPrefix(rangeHeader, "bytes=") || !strings.HasSuffix(rangeHeader, "-") {
+		w.Write(f.content)
+		return
+	}
+	offset, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(rangeHeader, "bytes="), "-"))
+	if nil != err || offset >= len(f.content) {
+		writeError(w, http.StatusRequestedRangeNotSatisfiable, "requestedRangeNotSatisfiable", "wrong range "+rangeHeader)
+		return
+	}
+	w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, len(f.content)-1, len(f.content)))
+	w.WriteHeader(http.StatusPartialContent)
+	w.Write(f.content[offset:])
+}
+
+// serveList lists the files. Just the queries the application makes are supported:
+// the conditions on "trashed", "sharedWithMe" and "in parents" joined with "and"
+func (s *Server) serveList(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filters, err := parseQuery(query.Get("q"))
+	if nil != err {
+		writeError(w, http.StatusBadRequest, "invalidQuery", err.Error())
+		return
+	}
+	driveId := ""
+	if "drive" == query.Get("corpora") {
+		driveId = query.Get("driveId")
+	}
+	includeAllDrives := "" != query.Get("q") && "true" == query.Get("includeItemsFromAllDrives")
+	var files []*drive.File
+	for _, f := range s.sortedFiles() {
+		if f.meta.Id == driveId || (!includeAllDrives && driveId != f.meta.DriveId) || RootFolderId == f.meta.Id {
+			continue
+		}
+		matches := true
+		for _, filter := range filters {
+			matches = matches && filter(f.meta)
+		}
+		if matches {
+			files = append(files, s.copyMeta(f))
+		}
+	}
+	from, to, next := page(query, len(files))
+	writeJSON(w, &drive.FileList{Files: files[from:to], NextPageToken: next})
+}
+
+// serveChanges lists the changes. Unlike the files, the page token of the changes is the
+// number of the first change on the page, so that it stays valid while the files change
+func (s *Server) serveChanges(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	pageToken, err := strconv.Atoi(query.Get("pageToken"))
+	if nil != err || pageToken < 1 || pageToken > len(s.changes)+1 {
+		writeError(w, http.StatusBadRequest, "invalid", "wrong page token "+query.Get("pageToken"))
+		return
+	}
+	pageSize, err := strconv.Atoi(query.Get("pageSize"))
+	if nil != err || pageSize <= 0 {
+		pageSize = 100
+	}
+	list := &drive.ChangeList{}
+	i := pageToken - 1
+	for ; i < len(s.changes) && len(list.Changes) < pageSize; i++ {
+		c := s.changes[i]
+		if query.Get("driveId") != c.driveId {
+			continue
+		}
+		change := &drive.Change{ChangeType: "file", FileId: c.fileId}
+		if f, ok := s.files[c.fileId]; ok {
+			change.File = s.copyMeta(f)
+		} else {
+			change.Removed = true
+		}
+		list.Changes = append(list.Changes, change)
+	}
+	if i < len(s.changes) {
+		list.NextPageToken = strconv.Itoa(i + 1)
+	} else {
+		list.NewStartPageToken = strconv.Itoa(len(s.changes) + 1)
+	}
+	writeJSON(w, list)
+}
+
+// serveUpload serves the multipart and the resumable uploads of new files (fileId is empty)
+// and of the content of the existing ones
+func (s *Server) serveUpload(w http.ResponseWriter, r *http.Request, fileId string) {
+	query := r.URL.Query()
+	if uploadId := query.Get("upload_id"); "" != uploadId {
+		s.serveUploadChunk(w, r, uploadId)
+		return
+	}
+	var f *file
+	if "" != fileId {
+		var ok bool
+		if f, ok = s.files[fileId]; !ok {
+			writeError(w, http.StatusNotFound, "notFound", "File not found: "+fileId)
+			return
+		}
+	}
+	switch query.Get("uploadType") {
+	case "multipart":
+		meta, content, err := readMultipart(r)
+		if nil != err {
+			writeError(w, http.StatusBadRequest, "badContent", err.Error())
+			return
+		}
+		if nil == f {
+			f = s.create(meta, content)
+		} else {
+			if "" != meta.Name {
+				f.meta.Name = meta.Name
+			}
+			setProperties(f, meta.AppProperties)
+			s.setContent(f, content)
+		}
+		s.writeFile(w, f)
+	case "resumable":
+		var meta drive.File
+		if !readJSON(w, r.Body, &meta) {
+			return
+		}
+		uploadId := s.newId()
+		s.sessions[uploadId] = &session{fileId: fileId, meta: meta}
+		w.Header().Set("Location", s.URL+"/upload/drive/v3/files?uploadType=resumable&upload_id="+uploadId)
+		w.WriteHeader(http.StatusOK)
+	default:
+		writeError(w, http.StatusBadRequest, "badRequest", "unsupported upload type "+query.Get("uploadType"))
+	}
+}
+
+// serveUploadChunk receives a part of a resumable upload ("Content-Range: bytes 0-99/1000")
+// or tells how much has been received ("Content-Range: bytes */1000")
+func (s *Server) serveUploadChunk(w http.ResponseWriter, r *http.Request, uploadId string) {
+	sess, ok := s.sessions[uploadId]
+	if !ok {
+		writeError(w, http.StatusNotFound, "notFound", "upload session not found")
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if nil != err {
+		writeError(w, http.StatusBadRequest, "badContent", err.Error())
+		return
+	}
+	var first, last, total int64
+	contentRange := r.Header.Get("Content-Range")
+	if _, err = fmt.Sscanf(contentRange, "bytes %d-%d/%d", &first, &last, &total); nil == err {
+		if first != int64(len(sess.content)) || last-first+1 != int64(len(body)) {
+			writeError(w, http.StatusBadRequest, "badContent", "unexpected range "+contentRange)
+			return
+		}
+		sess.content = append(sess.content, body...)
+	} else if _, err = fmt.Sscanf(contentRange, "bytes */%d", &total); nil != err {
+		writeError(w, http.StatusBadRequest, "badContent", "wrong range "+contentRange)
+		return
+	}
+	if total != int64(len(sess.content)) {
+		if len(sess.content) > 0 {
+			w.Header().Set("Range", fmt.Sprintf("bytes=0-%d", len(sess.content)-1))
+		}
+		w.WriteHeader(308)
+		return
+	}
+	delete(s.sessions, uploadId)
+	if "" == sess.fileId {
+		s.writeFile(w, s.create(sess.meta, sess.content))
+		return
+	}
+	f, ok := s.files[sess.fileId]
+	if !ok {
+		writeError(w, http.StatusNotFound, "notFound", "File not found: "+sess.fileId)
+		return
+	}
+	if "" != sess.meta.Name {
+		f.meta.Name = sess.meta.Name
+	}
+	setProperties(f, sess.meta.AppProperties)
+	s.setContent(f, sess.content)
+	s.writeFile(w, f)
+}
+
+// create creates a file with the metadata
+func (s *Server) create(meta drive.File, content []byte) *file {
+	meta.Id = s.newId()
+	if "" == meta.MimeType {
+		meta.MimeType = mime.TypeByExtension(path.Ext(meta.Name))
+		if "" == meta.MimeType {
+			meta.MimeType = "application/octet-stream"
+		}
+	}
+	if 0 == len(meta.Parents) {
+		meta.Parents = []string{RootFolderId}
+	}
+	if parent, ok := s.files[meta.Parents[0]]; ok {
+		meta.DriveId = parent.meta.DriveId
+	}
+	meta.OwnedByMe = "" == meta.DriveId
+	meta.Capabilities = &drive.FileCapabilities{CanEdit: true, CanAddChildren: folderMimeType == meta.MimeType}
+	f := &file{meta: meta}
+	s.files[meta.Id] = f
+	if folderMimeType == meta.MimeType {
+		s.touch(f)
+	} else {
+		s.setContent(f, content)
+	}
+	return f
+}
+
+// update changes the name, the parents and the trashed state of the file
+func (s *Server) update(w http.ResponseWriter, r *http.Request, f *file) bool {
+	var fields map[string]json.RawMessage
+	data, err := ioutil.ReadAll(r.Body)
+	if nil == err && len(data) > 0 {
+		err = json.Unmarshal(data, &fields)
+	}
+	if nil != err {
+		writeError(w, http.StatusBadRequest, "parseError", err.Error())
+		return false
+	}
+	for name, value := range fields {
+		switch name {
+		case "name":
+			err = json.Unmarshal(value, &f.meta.Name)
+		case "trashed":
+			err = json.Unmarshal(value, &f.meta.Trashed)
+			f.meta.ExplicitlyTrashed = f.meta.Trashed
+			f.meta.TrashedTime = ""
+			if f.meta.Trashed {
+				f.meta.TrashedTime = s.tick()
+			}
+		}
+		if nil != err {
+			writeError(w, http.StatusBadRequest, "parseError", err.Error())
+			return false
+		}
+	}
+	query := r.URL.Query()
+	if removeParents := query.Get("removeParents"); "" != removeParents {
+		var parents []string
+		for _, parent := range f.meta.Parents {
+			if !strings.Contains(","+removeParents+",", ","+parent+",") {
+				parents = append(parents, parent)
+			}
+		}
+		f.meta.Parents = parents
+	}
+	if addParents := query.Get("addParents"); "" != addParents {
+		f.meta.Parents = append(f.meta.Parents, strings.Split(addParents, ",")...)
+	}
+	s.touch(f)
+	return true
+}
+
+// setProperties sets the app properties of the file. The other ones are kept
+func setProperties(f *file, properties map[string]string) {
+	if 0 == len(properties) {
+		return
+	}
+	merged := make(map[string]string, len(f.meta.AppProperties)+len(properties))
+	for name, value := range f.meta.AppProperties {
+		merged[name] = value
+	}
+	for name, value := range properties {
+		merged[name] = value
+	}
+	f.meta.AppProperties = merged
+}
+
+func (s *Server) setContent(f *file, content []byte) {
+	sum := md5.Sum(content)
+	f.content = append([]byte(nil), content...)
+	f.meta.Md5Checksum = hex.EncodeToString(sum[:])
+	f.meta.Size = int64(len(content))
+	s.touch(f)
+}
+
+// touch updates the modification time and records the change
+func (s *Server) touch(f *file) {
+	f.meta.ModifiedTime = s.tick()
+	s.changes = append(s.changes, change{fileId: f.meta.Id, driveId: f.meta.DriveId})
+}
+
+// remove removes the file with everything inside it
+func (s *Server) remove(fileId string) {
+	f, ok := s.files[fileId]
+	if !ok {
+		return
+	}
+	for _, child := range s.sortedFiles() {
+		if 1 == len(child.meta.Parents) && fileId == child.meta.Parents[0] {
+			s.remove(child.meta.Id)
+		}
+	}
+	delete(s.files, fileId)
+	s.changes = append(s.changes, change{fileId: fileId, driveId: f.meta.DriveId})
+}
+
+func (s *Server) sortedFiles() []*file {
+	files := make([]*file, 0, len(s.files))
+	for _, f := range s.files {
+		files = append(files, f)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].meta.Id < files[j].meta.Id })
+	return files
+}
+
+func (s *Server) newId() string {
+	s.lastId++
+	return fmt.Sprintf("fake-%06d", s.lastId)
+}
+
+// tick returns the current time, which is always later than the previous one,
+// so that every change has a new modification time
+func (s *Server) tick() string {
+	now := time.Now().UTC()
+	if !now.After(s.lastTime) {
+		now = s.lastTime.Add(time.Millisecond)
+	}
+	s.lastTime = now
+	return now.Format(time.RFC3339Nano)
+}
+
+func (s *Server) copyMeta(f *file) *drive.File {
+	meta := f.meta
+	meta.Parents = append([]string(nil), f.meta.Parents...)
+	if nil != f.meta.Capabilities {
+		capabilities := *f.meta.Capabilities
+		meta.Capabilities = &capabilities
+	}
+	if RootFolderId == meta.Id || meta.Id == meta.DriveId {
+		meta.Parents = nil
+	}
+	return &meta
+}
+
+func (s *Server) writeFile(w http.ResponseWriter, f *file) {
+	writeJSON(w, s.copyMeta(f))
+}
+
+// parseQuery parses the search query into a list of filters
+func parseQuery(q string) ([]func(drive.File) bool, error) {
+	var filters []func(drive.File) bool
+	if "" == q {
+		return filters, nil
+	}
+	for _, condition := range strings.Split(q, " and ") {
+		condition = strings.TrimSpace(condition)
+		switch {
+		case "trashed = false" == condition:
+			filters = append(filters, func(f drive.File) bool { return !f.Trashed })
+		case "trashed = true" == condition:
+			filters = append(filters, func(f drive.File) bool { return f.Trashed })
+		case "sharedWithMe = true" == condition:
+			filters = append(filters, func(f drive.File) bool { return "" != f.SharedWithMeTime })
+		case strings.HasPrefix(condition, "'") && strings.HasSuffix(condition, "' in parents"):
+			parentId := strings.TrimSuffix(strings.TrimPrefix(condition, "'"), "' in parents")
+			filters = append(filters, func(f drive.File) bool {
+				return 1 == len(f.Parents) && parentId == f.Parents[0]
+			})
+		default:
+			return nil, fmt.Errorf("unsupported query condition %q", condition)
+		}
+	}
+	return filters, nil
+}
+
+// page returns the range of the listed files on the requested page and the token
+// of the next page. The token is the index of the first file on the page
+func page(query url.Values, count int) (int, int, string) {
+	pageSize, err := strconv.Atoi(query.Get("pageSize"))
+	if nil != err || pageSize <= 0 {
+		pageSize = 100
+	}
+	from, _ := strconv.Atoi(query.Get("pageToken"))
+	if from > count {
+		from = count
+	}
+	to := from + pageSize
+	if to >= count {
+		return from, count, ""
+	}
+	return from, to, strconv.Itoa(to)
+}
+
+func readMultipart(r *http.Request) (drive.File, []byte, error) {
+	var meta drive.File
+	_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if nil != err {
+		return meta, nil, err
+	}
+	reader := multipart.NewReader(r.Body, params["boundary"])
+	metaPart, err := reader.NextPart()
+	if nil != err {
+		return meta, nil, err
+	}
+	if err = json.NewDecoder(metaPart).Decode(&meta); nil != err {
+		return meta, nil, err
+	}
+	contentPart, err := reader.NextPart()
+	if nil != err {
+		return meta, nil, err
+	}
+	content, err := ioutil.ReadAll(contentPart)
+	return meta, content, err
+}
+
+func readJSON(w http.ResponseWriter, body io.Reader, v interface{}) bool {
+	data, err := ioutil.ReadAll(body)
+	if nil == err && len(data) > 0 {
+		err = json.Unmarshal(data, v)
+	}
+	if nil != err {
+		writeError(w, http.StatusBadRequest, "parseError", err.Error())
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError writes the error the way Google APIs do, so that googleapi.CheckResponse parses it
+func writeError(w http.ResponseWriter, code int, reason string, message string) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]interface{}{
+			"code":    code,
+			"message": message,
+			"errors":  []map[string]string{{"reason": reason, "message": message}},
+		},
+	})
+}
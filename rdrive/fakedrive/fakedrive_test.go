@@ -0,0 +1,81 @@
+package fakedrive
+
+import (
+	"bytes"
+	"context"
+	"google.golang.org/api/drive/v3"
+	"io/ioutil"
+	"testing"
+)
+
+func TestServer(t *testing.T) {
+	server := New()
+	defer server.Close()
+	srv, err := server.Service(context.Background())
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	startPageToken, err := srv.Changes.GetStartPageToken().Do()
+	if nil != err {
+		t.Fatal(err)
+	}
+	folder, err := srv.Files.Create(&drive.File{Name: "docs", MimeType: folderMimeType}).Do()
+	if nil != err {
+		t.Fatal(err)
+	}
+	var uploaded []*drive.File
+	for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		f, err := srv.Files.Create(&drive.File{Name: name, Parents: []string{folder.Id}}).
+			Media(bytes.NewReader([]byte("content of " + name))).
+			Do()
+		if nil != err {
+			t.Fatal(err)
+		}
+		uploaded = append(uploaded, f)
+	}
+	if f, ok := server.Find("docs/b.txt"); !ok || uploaded[1].Id != f.Id {
+		t.Error("docs/b.txt not found")
+	}
+
+	var listed []*drive.File
+	err = srv.Files.List().Q("'"+folder.Id+"' in parents and trashed = false").PageSize(2).
+		Pages(context.Background(), func(list *drive.FileList) error {
+			listed = append(listed, list.Files...)
+			return nil
+		})
+	if nil != err {
+		t.Fatal(err)
+	}
+	if 3 != len(listed) {
+		t.Errorf("expected 3 files, got %d", len(listed))
+	}
+
+	resp, err := srv.Files.Get(uploaded[0].Id).Download()
+	if nil != err {
+		t.Fatal(err)
+	}
+	content, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if nil != err || "content of a.txt" != string(content) {
+		t.Errorf("wrong content %q: %v", content, err)
+	}
+
+	if err = srv.Files.Delete(folder.Id).Do(); nil != err {
+		t.Fatal(err)
+	}
+	removed := make(map[string]bool)
+	for pageToken := startPageToken.StartPageToken; "" != pageToken; {
+		list, err := srv.Changes.List(pageToken).PageSize(2).Do()
+		if nil != err {
+			t.Fatal(err)
+		}
+		for _, change := range list.Changes {
+			removed[change.FileId] = change.Removed
+		}
+		pageToken = list.NextPageToken
+	}
+	if 4 != len(removed) || !removed[folder.Id] || !removed[uploaded[2].Id] {
+		t.Error("the folder and the files must be listed as removed", removed)
+	}
+}
@@ -4,256 +4,44 @@ import (
 	"database/sql"
 	"fmt"
 	"github.com/pkg/errors"
-	"github.com/svetlyi/gdriveapp/app"
 	"github.com/svetlyi/gdriveapp/contracts"
 	lfile "github.com/svetlyi/gdriveapp/ldrive/file"
-	lfileHash "github.com/svetlyi/gdriveapp/ldrive/file/hash"
+	"github.com/svetlyi/gdriveapp/rdrive/crypt"
 	"github.com/svetlyi/gdriveapp/rdrive/specification"
+	"github.com/svetlyi/gdriveapp/rdrive/throttle"
 	"google.golang.org/api/drive/v3"
-	"google.golang.org/api/googleapi"
 	"io"
 	"os"
-	"time"
 )
 
-var fileFieldsSet = "id, name, mimeType, parents, shared, md5Checksum, size, modifiedTime, trashed, explicitlyTrashed"
-
-// getFilesList puts files from the remote drive into filesChan channel
-// one by one
-func (d *Drive) getFilesList(filesChan chan *drive.File) {
-	var nextPageToken = ""
-	var filesListCall *drive.FilesListCall
-
-	for {
-		filesListCall = d.filesService.List()
-		if "" != nextPageToken {
-			filesListCall.PageToken(nextPageToken)
-		}
-		fileList, err := filesListCall.PageSize(d.cfg.PageSizeToQuery).Fields(
-			googleapi.Field(fmt.Sprintf("nextPageToken, files(%s)", fileFieldsSet)),
-		).Do()
-
-		if err != nil {
-			d.log.Error("Unable to retrieve files: %v", err)
-			close(filesChan)
-		}
-		nextPageToken = fileList.NextPageToken
-
-		d.log.Info("Getting files list...")
-
-		for _, rfile := range fileList.Files {
-			d.log.Debug("Found file", rfile)
-			filesChan <- rfile
-		}
-		if "" == nextPageToken {
-			break
-		}
-	}
-	close(filesChan)
-}
-
-// getChangedFilesList gets the changed files from the remote drive into filesChan channel
-// one by one
-func (d *Drive) getChangedFilesList(filesChan chan *drive.Change, exitChan contracts.ExitChan) {
-	nextPageToken, err := d.appState.Get(app.NextChangeToken)
-	if err != nil && errors.Cause(err) != sql.ErrNoRows {
-		d.log.Error("error getting NextChangeToken from app state", err)
-		close(exitChan)
-	}
-
-	var changesListCall *drive.ChangesListCall
-
-	startPageToken, err := d.changesService.GetStartPageToken().Do()
-	if err != nil {
-		d.log.Error("error getting start page token in changed files list", err)
-		close(exitChan)
-	}
-
-	for {
-		d.log.Debug("change token", struct {
-			nextPageToken  string
-			startPageToken string
-		}{nextPageToken, startPageToken.StartPageToken})
-
-		if nextPageToken == "" {
-			nextPageToken = startPageToken.StartPageToken
-			d.log.Info("next page token", nextPageToken)
-		}
-		changesListCall = d.changesService.List(nextPageToken)
-		changeList, err := changesListCall.PageSize(d.cfg.PageSizeToQuery).Fields(
-			googleapi.Field(fmt.Sprintf("nextPageToken, changes(removed, fileId, file(%s))", fileFieldsSet)),
-		).Do()
-
-		if err != nil {
-			d.log.Error("Unable to retrieve changed files: %v", err)
-			close(exitChan)
-			break
-		}
-		nextPageToken = changeList.NextPageToken
-
-		d.log.Info("getting changed files list. amount of changes: ", len(changeList.Changes))
-
-		for _, change := range changeList.Changes {
-			// if the file was removed, there is no any information except its identifier
-			if change.Removed {
-				d.log.Debug(fmt.Sprintf("changeList:file %s was removed", change.FileId))
-			} else if change.File.Trashed {
-				d.log.Debug(fmt.Sprintf("changeList:file %s was trashed", change.FileId))
-			} else if change.File.ExplicitlyTrashed {
-				d.log.Debug(fmt.Sprintf("changeList:file %s was explicitly trashed", change.FileId))
-			} else {
-				d.log.Debug("changeList:found change", struct {
-					Id           string
-					Name         string
-					ModifiedTime string
-				}{
-					Id:           change.FileId,
-					Name:         change.File.Name,
-					ModifiedTime: change.File.ModifiedTime,
-				})
-			}
-			filesChan <- change
-		}
-
-		if "" == nextPageToken {
-			d.log.Debug("no more changes")
-			break
-		}
-	}
-	if err := d.appState.Set(app.NextChangeToken, startPageToken.StartPageToken); err != nil {
-		d.log.Error("error saving NextChangeToken to app state", err)
-		close(exitChan)
+// getRootFolder gets the root folder of "My Drive" (driveId is empty) or of a shared drive.
+// The root folder of a shared drive has the same id and name as the drive
+func (d *Drive) getRootFolder(driveId string) (*drive.File, error) {
+	rootFolderId := RootFolderId
+	if "" != driveId {
+		rootFolderId = driveId
 	}
-	d.log.Debug("changes:closing files channel")
-
-	close(filesChan)
-}
-
-func (d *Drive) getRootFolder() (*drive.File, error) {
-	rootFolder, err := d.filesService.Get("root").Fields(googleapi.Field(fileFieldsSet)).Do()
+	rootFolder, err := d.backend.Get(rootFolderId)
 	if err != nil {
 		return &drive.File{}, errors.Wrap(err, "Could not fetch root folder info")
 	}
-	d.log.Debug("Found root folder", struct {
-		Id   string
-		Name string
-	}{
-		rootFolder.Id,
-		rootFolder.Name,
-	})
+	d.log.With(contracts.Fields{
+		contracts.FieldFileId: rootFolder.Id,
+		"name":                rootFolder.Name,
+	}).Debug("Found root folder")
 
 	return rootFolder, nil
 }
 
-// SyncRemoteWithLocal synchronizes the local file system with remote one
-// file - file information from remote
-func (d *Drive) SyncRemoteWithLocal(file contracts.File) error {
-	localChangeType, err := d.isChangedLocally(file)
-	if err != nil {
-		return errors.Wrap(err, "could not determine if it was locally changed")
-	}
-	remoteChangeType, err := d.isChangedRemotely(file)
-	if err != nil {
-		return errors.Wrap(err, "could not determine if it was remotely changed")
-	}
-
-	if (localChangeType != contracts.FILE_NOT_CHANGED || remoteChangeType != contracts.FILE_NOT_CHANGED) &&
-		(specification.CanDownloadFile(file) || specification.IsFolder(file)) {
-		d.log.Debug("SyncRemoteWithLocal. change types", struct {
-			file             contracts.File
-			localChangeType  contracts.FileChangeType
-			remoteChangeType contracts.FileChangeType
-		}{file, localChangeType, remoteChangeType})
-	}
-
-	curFullFilePath := lfile.GetCurFullPath(d.cfg, file)
-	if specification.IsFolder(file) {
-		switch { // the only the things that can happen to a folder are: move, Delete
-		case contracts.FILE_MOVED == remoteChangeType:
-			return d.handleMovedRemotely(file)
-		case contracts.FILE_DELETED == remoteChangeType:
-			return d.handleRemovedRemotely(file)
-		case contracts.FILE_UPDATED == remoteChangeType ||
-			(contracts.FILE_NOT_CHANGED == remoteChangeType && contracts.FILE_NOT_EXIST == localChangeType):
-			d.log.Info("creating folder", struct {
-				name string
-			}{name: file.CurRemoteName})
-			if err := os.Mkdir(curFullFilePath, 0744); !os.IsExist(err) {
-				return errors.Wrap(err, "could not create dir")
-			}
-			if err := d.fileRepository.SetPrevRemoteDataToCur(file.Id); nil != err {
-				return errors.Wrap(err, "could not set previous remote data to current")
-			}
-		}
-	}
-	if !specification.CanDownloadFile(file) {
-		return nil
-	}
-
-	switch {
-	case contracts.FILE_NOT_CHANGED == remoteChangeType && contracts.FILE_NOT_EXIST == localChangeType:
-		d.log.Info("downloading file. remote file has not changed. local one does not exist", file)
-		if err = d.download(file); err != nil {
-			err = errors.Wrapf(err, "could not download file %s", file.Id)
-		}
-	case contracts.FILE_NOT_CHANGED == remoteChangeType && contracts.FILE_NOT_CHANGED == localChangeType:
-		if file.DownloadTime.IsZero() {
-			err = d.setDownloadTimeByStatsForFile(file)
-		}
-		break // do nothing
-	case contracts.FILE_NOT_CHANGED == remoteChangeType && contracts.FILE_UPDATED == localChangeType:
-		d.log.Info("uploading file. remote file has not changed. local one updated", file)
-		if err = d.updateRemote(file); err != nil {
-			err = errors.Wrapf(err, "could not updateRemote file %s", file.Id)
-		}
-	case contracts.FILE_NOT_CHANGED == remoteChangeType && contracts.FILE_DELETED == localChangeType:
-		d.log.Info("setting file to be deleted remotely. remote file has not changed. local one deleted", file)
-		if err = d.fileRepository.SetRemovedLocally(file.Id, true); err != nil {
-			err = errors.Wrapf(err, "could not set removed locally for file %s", file.Id)
-		}
-	case contracts.FILE_UPDATED == remoteChangeType && contracts.FILE_NOT_CHANGED == localChangeType:
-		d.log.Info("downloading file. remote file changed", file)
-		if err = d.download(file); err != nil {
-			err = errors.Wrapf(err, "could not download file %s", file.Id)
-		}
-	case contracts.FILE_UPDATED == remoteChangeType && contracts.FILE_UPDATED == localChangeType:
-		d.log.Warning("CONFLICT. remote and local files were changed", file)
-		break //TODO: conflict
-	case contracts.FILE_UPDATED == remoteChangeType && contracts.FILE_DELETED == localChangeType:
-		d.log.Warning("CONFLICT. remote file was changed, but local one was deleted", file)
-		break //TODO: conflict
-	case contracts.FILE_DELETED == remoteChangeType && contracts.FILE_NOT_CHANGED == localChangeType:
-		d.log.Info("deleting file locally", file)
-		err = os.Remove(curFullFilePath)
-	case contracts.FILE_DELETED == remoteChangeType && contracts.FILE_UPDATED == localChangeType:
-		d.log.Warning("CONFLICT. remote file was deleted, but local one was updated", file)
-		break //TODO: conflict
-	case contracts.FILE_DELETED == remoteChangeType && contracts.FILE_DELETED == localChangeType:
-		err = d.fileRepository.SetRemovedLocally(file.Id, true)
-	case contracts.FILE_MOVED == remoteChangeType && contracts.FILE_NOT_CHANGED == localChangeType:
-		err = d.handleMovedRemotely(file)
-	case contracts.FILE_MOVED == remoteChangeType && contracts.FILE_UPDATED == localChangeType:
-		d.log.Warning("CONFLICT. remote file was moved, but local one was updated", file)
-		break //TODO: conflict
-	case contracts.FILE_MOVED == remoteChangeType && contracts.FILE_DELETED == localChangeType:
-		d.log.Info("downloading file. remote file was moved, local one deleted", file)
-		if err = d.download(file); err != nil {
-			err = errors.Wrapf(err, "could not download file %s", file.Id)
-		}
-	}
-
-	return err
-}
-
-// handleRemovedRemotely removes a file locally because it was remoted remotely
+// handleRemovedRemotely moves a file to the recycle bin because it was removed remotely
 func (d *Drive) handleRemovedRemotely(file contracts.File) (err error) {
 	d.log.Debug("removing file", file)
 	curFullFilePath := lfile.GetCurFullPath(d.cfg, file)
-	if _, err = os.Stat(curFullFilePath); os.IsNotExist(err) {
+	if _, err = d.stat(curFullFilePath); os.IsNotExist(err) {
 		return nil // we are going to remove a file, but it does not exist. just do nothing in this case
 	}
 
-	err = os.RemoveAll(curFullFilePath)
+	err = d.recycleBin.Move(curFullFilePath)
 	if nil == err {
 		err = d.fileRepository.Delete(file.Id)
 	}
@@ -266,29 +54,25 @@ func (d *Drive) handleMovedRemotely(file contracts.File) (err error) {
 	d.log.Debug("moving file", file)
 	curFullFilePath := lfile.GetCurFullPath(d.cfg, file)
 	getPrevFullPath := lfile.GetPrevFullPath(d.cfg, file)
-	if _, err = os.Stat(getPrevFullPath); os.IsNotExist(err) {
+	if _, err = d.stat(getPrevFullPath); os.IsNotExist(err) {
 		return nil // we are going to move a file, but it does not exist. just do nothing in this case
 	}
 
 	// if the file does not exist at the destination (current path)
-	if _, err = os.Stat(curFullFilePath); os.IsNotExist(err) {
+	if _, err = d.stat(curFullFilePath); os.IsNotExist(err) {
 		err = os.Rename(getPrevFullPath, curFullFilePath)
 	}
-	if err != nil {
-		err = d.fileRepository.SetPrevRemoteDataToCur(file.Id)
-	}
-	if err != nil {
-		err = d.setDownloadTimeByStatsForFile(file)
+	if err == nil {
+		err = d.markSynced(file)
 	}
 
 	return err
 }
 
-// isChangedLocally determines if the file was changed locally (updated or deleted)
-func (d *Drive) isChangedLocally(file contracts.File) (contracts.FileChangeType, error) {
-	curFullPath := lfile.GetCurFullPath(d.cfg, file)
-
-	if stats, err := os.Stat(curFullPath); os.IsNotExist(err) {
+// isChangedLocally determines if the file located at localFullPath was changed
+// locally (updated or deleted)
+func (d *Drive) isChangedLocally(file contracts.File, localFullPath string) (contracts.FileChangeType, error) {
+	if stats, err := d.stat(localFullPath); os.IsNotExist(err) {
 		if file.DownloadTime.IsZero() {
 			return contracts.FILE_NOT_EXIST, nil
 		} else {
@@ -312,7 +96,7 @@ func (d *Drive) isChangedLocally(file contracts.File) (contracts.FileChangeType,
 			return contracts.FILE_UPDATED, nil
 		}
 	} else {
-		return contracts.FILE_ERROR, errors.Wrapf(err, "could not get file '%s' stats", curFullPath)
+		return contracts.FILE_ERROR, errors.Wrapf(err, "could not get file '%s' stats", localFullPath)
 	}
 }
 
@@ -339,98 +123,96 @@ func (d *Drive) isChangedRemotely(file contracts.File) (contracts.FileChangeType
 }
 
 func (d *Drive) updateRemote(file contracts.File) error {
+	commit, err := d.uploadContent(file)
+	if err != nil {
+		return err
+	}
+	return commit()
+}
+
+// uploadContent uploads the content of the local file to the remote one. The returned
+// function saves the result to the database
+func (d *Drive) uploadContent(file contracts.File) (func() error, error) {
+	markSynced := func() error { return d.markSynced(file) }
 	if sameFileExists, err := d.isLocalSameAsRemote(file); err == nil && sameFileExists {
 		d.log.Debug(fmt.Sprintf("skipping file %s: already exists", file.Id))
-		return d.setDownloadTimeByStatsForFile(file) // most probably it was not downloaded previously
+		return markSynced, nil // most probably it was not downloaded previously
 	} else if err != nil {
-		return err
+		return nil, err
 	}
 
-	curFullPath := lfile.GetCurFullPath(d.cfg, file)
-	if lf, err := os.Open(curFullPath); err == nil {
-		rf, err := d.filesService.Update(file.Id, &drive.File{}).Media(lf).Do()
-		if err != nil {
-			return errors.Wrap(err, "could not update file remotely")
+	rf, err := d.upload(lfile.GetCurFullPath(d.cfg, file), "", file)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not update file remotely")
+	}
+	return func() error {
+		if err := d.fileRepository.SetCurRemoteData(rf.Id, rf.ModifiedTime, rf.Name, d.getLocalParents(rf)); err != nil {
+			return errors.Wrapf(err, "could not set current remote data for file id %s", rf.Id)
 		}
-		if t, err := time.Parse(time.RFC3339Nano, rf.ModifiedTime); err != nil {
-			return d.fileRepository.SetPrevRemoteModificationDate(file.Id, t)
-		} else {
-			return errors.Wrapf(err, "could not parse modified time %s", rf.ModifiedTime)
+		if err := d.fileRepository.SetCurRemoteContent(rf); err != nil {
+			return errors.Wrapf(err, "could not set current remote content for file id %s", rf.Id)
 		}
-	} else {
-		return errors.Wrapf(err, "open file %s error", curFullPath)
-	}
+		return markSynced()
+	}, nil
 }
 
 func (d *Drive) Upload(curFullPath string, parentIds []string) error {
-	fileHash, err := lfileHash.CalcCachedHash(curFullPath)
+	commit, err := d.uploadNew(curFullPath, parentIds)
 	if nil != err {
-		return errors.Wrapf(err, "could not calculate hash for %s", curFullPath)
+		return err
 	}
-	sameFile, err := d.fileRepository.GetFileByHash(fileHash)
-	if nil != err && sql.ErrNoRows != errors.Cause(err) {
-		return errors.Wrapf(err, "error finding a file %s by hash %s", curFullPath, fileHash)
+	return commit()
+}
+
+// uploadNew uploads a new local file (or copies the same file remotely). The returned
+// function saves the uploaded file to the database. The hash of a file in an encrypted
+// folder is keyed, so just the same encrypted file is copied. A stored symbolic link
+// is always uploaded, as its content is just the target
+func (d *Drive) uploadNew(curFullPath string, parentIds []string) (func() error, error) {
+	fileHash, err := d.CalcHash(curFullPath, d.isEncrypted(curFullPath))
+	if nil != err {
+		return nil, errors.Wrapf(err, "could not calculate hash for %s", curFullPath)
 	}
-	stat, err := os.Stat(curFullPath)
+	sameFile, sameFileErr := d.fileRepository.GetFileByHash(fileHash)
+	if nil != sameFileErr && sql.ErrNoRows != errors.Cause(sameFileErr) {
+		return nil, errors.Wrapf(sameFileErr, "error finding a file %s by hash %s", curFullPath, fileHash)
+	}
+	stat, err := d.stat(curFullPath)
 	if nil != err {
-		return errors.Wrapf(err, "could not get stat for file %s", curFullPath)
+		return nil, errors.Wrapf(err, "could not get stat for file %s", curFullPath)
 	}
 	var rf *drive.File
-	if sql.ErrNoRows == errors.Cause(err) || sameFile.SizeBytes != uint64(stat.Size()) {
+	if sql.ErrNoRows == errors.Cause(sameFileErr) || sameFile.SizeBytes != uint64(stat.Size()) ||
+		0 != stat.Mode()&os.ModeSymlink {
 		// if there is no such a file, then just upload
-		lf, err := os.Open(curFullPath)
+		rf, err = d.upload(curFullPath, parentIds[0], contracts.File{})
 		if nil != err {
-			return errors.Wrapf(err, "error opening file %s", curFullPath)
-		}
-		defer lf.Close()
-
-		rf, err = d.filesService.
-			Create(&drive.File{Name: stat.Name(), Parents: parentIds}).
-			Fields(googleapi.Field(fileFieldsSet)).
-			Media(lf).Do()
-		if nil != err {
-			return errors.Wrapf(err, "could not upload file %s", curFullPath)
+			return nil, errors.Wrapf(err, "could not upload file %s", curFullPath)
 		}
 	} else {
-		d.log.Debug("uploading: found the same file. copying", struct {
-			sameFileName     string
-			sameFileId       string
-			fileNameToUpload string
-		}{
-			sameFile.CurRemoteName,
-			sameFile.Id,
-			stat.Name(),
-		})
-		rf, err = d.filesService.
-			Copy(sameFile.Id, &drive.File{Name: stat.Name(), Parents: parentIds}).
-			Fields(googleapi.Field(fileFieldsSet)).
-			Do()
+		d.log.With(contracts.Fields{
+			contracts.FieldFileId: sameFile.Id,
+			contracts.FieldPath:   curFullPath,
+			"same_file_name":      sameFile.CurRemoteName,
+		}).Debug("uploading: found the same file. copying")
+		rf, err = d.backend.Copy(sameFile.Id, d.getRemoteName(curFullPath), parentIds[0])
 		if nil != err {
-			return errors.Wrapf(err, "could not copy file %s remotely", curFullPath)
+			return nil, errors.Wrapf(err, "could not copy file %s remotely", curFullPath)
 		}
 	}
-	err = d.fileRepository.CreateFile(rf)
-	if nil != err {
-		return errors.Wrapf(err, "could not create file %s in db", curFullPath)
-	}
-
-	return d.fileRepository.SetDownloadTime(rf.Id, stat.ModTime())
+	return func() error {
+		if err := d.fileRepository.CreateFile(rf); nil != err {
+			return errors.Wrapf(err, "could not create file %s in db", curFullPath)
+		}
+		return d.fileRepository.SetDownloadTime(rf.Id, stat.ModTime())
+	}, nil
 }
 
 func (d *Drive) CreateFolder(curFullPath string, parentIds []string) (string, error) {
-	stat, err := os.Stat(curFullPath)
-	if nil != err {
+	if _, err := os.Stat(curFullPath); nil != err {
 		return "", errors.Wrapf(err, "could not get stat for folder %s", curFullPath)
 	}
-	var rf *drive.File
-	rf, err = d.filesService.
-		Create(&drive.File{
-			Name:     stat.Name(),
-			Parents:  parentIds,
-			MimeType: specification.GetFolderMime(),
-		}).
-		Fields(googleapi.Field(fileFieldsSet)).
-		Do()
+	rf, err := d.backend.CreateFolder(d.getRemoteName(curFullPath), parentIds[0])
 	if nil != err {
 		return "", errors.Wrapf(err, "could not upload file %s", curFullPath)
 	}
@@ -442,18 +224,18 @@ func (d *Drive) CreateFolder(curFullPath string, parentIds []string) (string, er
 }
 
 func (d *Drive) Update(fileId string, name string, parentIds []string, oldParentIds []string) (*drive.File, error) {
-	f, err := d.filesService.Update(fileId, &drive.File{
-		Name: name,
-	}).Fields(googleapi.Field(fileFieldsSet)).AddParents(parentIds[0]).RemoveParents(oldParentIds[0]).Do()
-	if nil != err {
-		err = errors.Wrapf(err, "could not update file with id", fileId)
-	}
-	return f, err
+	return d.backend.Update(fileId, name, parentIds[0], oldParentIds[0])
 }
 
+// Delete moves the remote file to the trash or, if it is configured, deletes it for good
 func (d *Drive) Delete(file contracts.File) error {
 	var err error
-	if err = d.filesService.Delete(file.Id).Do(); err == nil {
+	if d.cfg.PermanentDelete {
+		err = d.backend.Delete(file.Id)
+	} else {
+		err = d.backend.Trash(file.Id)
+	}
+	if err == nil {
 		err = d.fileRepository.Delete(file.Id)
 	}
 	if err != nil {
@@ -463,56 +245,159 @@ func (d *Drive) Delete(file contracts.File) error {
 }
 
 func (d *Drive) download(file contracts.File) error {
+	if err := d.downloadContent(file); err != nil {
+		return err
+	}
+	return d.markSynced(file)
+}
+
+// downloadContent downloads (or exports) the remote file to the local drive
+// without saving anything to the database
+func (d *Drive) downloadContent(file contracts.File) error {
 	fileFullPath := lfile.GetCurFullPath(d.cfg, file)
 
 	if sameFileExists, err := d.isLocalSameAsRemote(file); err == nil && sameFileExists {
 		d.log.Debug(fmt.Sprintf("skipping file %s: already exists", file.Id))
-		if err = d.setDownloadTimeByStatsForFile(file); err != nil {
-			return err
-		}
-		if err = d.fileRepository.SetPrevRemoteModificationDate(file.Id, file.CurRemoteModTime); err != nil {
-			return err
-		}
+		return nil
 	} else if err != nil {
 		return err
 	}
+	if specification.IsSymlink(file) {
+		return d.createSymlink(file, fileFullPath)
+	}
+	if 1 == file.Encrypted && nil == d.cipher {
+		return errors.Wrapf(errNoKey, "could not download file %s", file.Id)
+	}
 
-	gfileReader, err := d.filesService.Get(file.Id).Download()
+	partialPath := lfile.GetPartialPath(fileFullPath)
+	lf, content, err := d.openDownload(file, partialPath)
 	if err != nil {
 		d.log.Error("Unable to retrieve file: %v", err)
 		return err
 	}
-	lf, err := os.Create(fileFullPath)
+	defer content.Close()
+	_, err = io.Copy(lf, throttle.NewReader(content, d.downloadLimiter))
+	if closeErr := lf.Close(); nil == err {
+		err = closeErr
+	}
+	if err != nil {
+		// the downloaded part is kept, so the next time the download continues
+		return errors.Wrapf(err, "could not download file %s to %s", file.Id, partialPath)
+	}
 
-	if nil == err {
-		defer lf.Close()
-		buf := make([]byte, 1024)
-		for {
-			// read a chunk
-			n, err := gfileReader.Body.Read(buf)
-			if err != nil && err != io.EOF {
-				d.log.Error("Unable to download file: %v", err)
-				if remErr := os.Remove(fileFullPath); remErr != nil {
-					return remErr
-				}
-				return err
-			}
-			if n == 0 {
-				break
+	if 1 == file.Encrypted {
+		if partialPath, err = d.decrypt(partialPath, fileFullPath); err != nil {
+			return err
+		}
+	}
+	if !d.IsExported(file) && "" != file.Hash {
+		hash, err := d.CalcHash(partialPath, 1 == file.Encrypted)
+		if err != nil {
+			return err
+		}
+		if hash != file.Hash { // most probably the remote file changed while it was downloaded partially
+			if err = os.Remove(partialPath); err != nil {
+				return errors.Wrapf(err, "could not remove %s", partialPath)
 			}
+			return errors.Errorf("downloaded file %s does not match the remote one", partialPath)
+		}
+	}
+	if err = d.makeReadOnly(file, partialPath); err != nil {
+		return err
+	}
+	if err = os.Rename(partialPath, fileFullPath); err != nil {
+		return errors.Wrapf(err, "could not rename %s to %s", partialPath, fileFullPath)
+	}
+	return nil
+}
 
-			// write a chunk
-			if _, err := lf.Write(buf[:n]); err != nil {
-				if remErr := os.Remove(fileFullPath); remErr != nil {
-					return errors.Wrap(remErr, "Unable to remove file")
-				}
-				return errors.Wrap(err, "could not write a chunk")
-			}
+// openDownload starts downloading the file to partialPath. If a part of the file
+// has already been downloaded, only the rest is requested. The exported files are
+// generated on each request, so their downloads always start over
+func (d *Drive) openDownload(file contracts.File, partialPath string) (*os.File, io.ReadCloser, error) {
+	if _, exportMimeType, exported := specification.GetExportFormat(file, d.cfg.ExportFormats); exported {
+		content, err := d.backend.Export(file.Id, exportMimeType)
+		if err != nil {
+			return nil, nil, err
 		}
-		return d.setDownloadTimeByStatsForFile(file)
+		lf, err := os.Create(partialPath)
+		if err != nil {
+			content.Close()
+			return nil, nil, errors.Wrapf(err, "could not create %s", partialPath)
+		}
+		return lf, content, nil
 	}
 
-	return err
+	size := int64(file.SizeBytes)
+	if 1 == file.Encrypted {
+		size = crypt.EncryptedSize(size)
+	}
+	var offset int64
+	if stat, err := os.Stat(partialPath); nil == err && stat.Size() < size {
+		offset = stat.Size()
+	}
+	if offset > 0 {
+		d.log.With(contracts.Fields{
+			contracts.FieldFileId: file.Id,
+			contracts.FieldPath:   partialPath,
+			"offset":              offset,
+		}).Info("continuing download")
+	}
+	content, offset, err := d.backend.Download(file.Id, offset)
+	if err != nil {
+		return nil, nil, err
+	}
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if offset > 0 {
+		flags = os.O_WRONLY | os.O_APPEND
+	}
+	lf, err := os.OpenFile(partialPath, flags, 0644)
+	if err != nil {
+		content.Close()
+		return nil, nil, errors.Wrapf(err, "could not open %s", partialPath)
+	}
+	return lf, content, nil
+}
+
+// makeReadOnly makes a just downloaded file read-only, if its changes
+// cannot be uploaded, so that they are not made by accident
+func (d *Drive) makeReadOnly(file contracts.File, fullPath string) error {
+	if !d.isKeptReadOnly(file) {
+		return nil
+	}
+	if err := os.Chmod(fullPath, 0444); err != nil {
+		return errors.Wrapf(err, "could not make %s read-only", fullPath)
+	}
+	return nil
+}
+
+// isKeptReadOnly says if the local copy of the file is made read-only: the file is
+// read-only remotely or it is an exported file
+func (d *Drive) isKeptReadOnly(file contracts.File) bool {
+	_, _, exported := specification.GetExportFormat(file, d.cfg.ExportFormats)
+	return 1 == file.ReadOnly || (exported && d.cfg.ExportReadOnly)
+}
+
+// IsExported says if the file is a native Google file exported to a local format
+func (d *Drive) IsExported(file contracts.File) bool {
+	_, _, exported := specification.GetExportFormat(file, d.cfg.ExportFormats)
+	return exported
+}
+
+// GetLocalPath returns the path of the local copy of the file relative to the drive path
+func (d *Drive) GetLocalPath(file contracts.File) string {
+	return lfile.GetLocalPath(d.cfg, file.CurPath, file)
+}
+
+// GetPrevLocalPath returns the path relative to the drive path, the local copy of the file had
+// before the file was changed remotely
+func (d *Drive) GetPrevLocalPath(file contracts.File) string {
+	return lfile.GetLocalPath(d.cfg, file.PrevPath, file)
+}
+
+// GetLocalName returns the name of the local copy of the file
+func (d *Drive) GetLocalName(file contracts.File) string {
+	return lfile.GetLocalPath(d.cfg, file.CurRemoteName, file)
 }
 
 // isLocalSameAsRemote checks that a file with the same path, name and hash exists
@@ -520,7 +405,7 @@ func (d *Drive) download(file contracts.File) error {
 // or the downloaded time in the database is null
 func (d *Drive) isLocalSameAsRemote(file contracts.File) (bool, error) {
 	fileFullPath := lfile.GetCurFullPath(d.cfg, file)
-	stat, err := os.Stat(fileFullPath)
+	stat, err := d.stat(fileFullPath)
 
 	if err != nil {
 		if os.IsNotExist(err) {
@@ -533,8 +418,17 @@ func (d *Drive) isLocalSameAsRemote(file contracts.File) (bool, error) {
 	if stat.IsDir() {
 		return true, nil
 	}
+	if specification.IsSymlink(file) != (0 != stat.Mode()&os.ModeSymlink) {
+		// a stored link and a file with its target as the content have the same hash
+		return false, nil
+	}
+	if d.IsExported(file) {
+		// there is no hash of the native Google files to compare with. A never synchronized
+		// copy made after the last remote change is considered a previous export
+		return file.DownloadTime.IsZero() && !stat.ModTime().Before(file.CurRemoteModTime), nil
+	}
 
-	if hash, err := lfileHash.CalcCachedHash(fileFullPath); nil != err {
+	if hash, err := d.CalcHash(fileFullPath, 1 == file.Encrypted); nil != err {
 		return false, err
 	} else {
 		d.log.Debug(fmt.Sprintf("calculated hash for %s: %s. File id: %s", fileFullPath, hash, file.Id))
@@ -542,9 +436,19 @@ func (d *Drive) isLocalSameAsRemote(file contracts.File) (bool, error) {
 	}
 }
 
+// markSynced records that the local file is the same as the remote one: the current
+// remote data becomes the previous one and the local modification time becomes
+// the download time
+func (d *Drive) markSynced(file contracts.File) error {
+	if err := d.fileRepository.SetPrevRemoteDataToCur(file.Id); err != nil {
+		return err
+	}
+	return d.setDownloadTimeByStatsForFile(file)
+}
+
 func (d *Drive) setDownloadTimeByStatsForFile(file contracts.File) error {
 	fileFullPath := lfile.GetCurFullPath(d.cfg, file)
-	if stat, err := os.Stat(fileFullPath); nil == err {
+	if stat, err := d.stat(fileFullPath); nil == err {
 		return d.fileRepository.SetDownloadTime(file.Id, stat.ModTime())
 	} else {
 		return errors.Wrapf(err, "could not get the file's %s stats", fileFullPath)
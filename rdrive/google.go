@@ -0,0 +1,331 @@
+package rdrive
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/svetlyi/gdriveapp/contracts"
+	"github.com/svetlyi/gdriveapp/rdrive/db/transfer"
+	"github.com/svetlyi/gdriveapp/rdrive/retry"
+	"github.com/svetlyi/gdriveapp/rdrive/specification"
+	"github.com/svetlyi/gdriveapp/rdrive/throttle"
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
+	"io"
+	"net/http"
+)
+
+var fileFieldsSet = "id, name, mimeType, parents, shared, md5Checksum, size, modifiedTime, trashed, explicitlyTrashed, driveId, " +
+	"sharedWithMeTime, ownedByMe, capabilities/canEdit, capabilities/canAddChildren, trashedTime, appProperties"
+
+// GoogleBackend is the Google Drive backend
+type GoogleBackend struct {
+	filesService   drive.FilesService
+	changesService drive.ChangesService
+	log            contracts.Logger
+	pageSize       int64
+	// httpClient and basePath are used for the resumable uploads, as the drive
+	// services do not allow to continue an interrupted upload
+	httpClient    *http.Client
+	basePath      string
+	transfers     transfer.Repository
+	uploadLimiter *throttle.Limiter
+	// retrier repeats the calls failed because of the rate limits or temporary errors
+	retrier retry.Retrier
+}
+
+func NewGoogleBackend(
+	filesService drive.FilesService,
+	changesService drive.ChangesService,
+	log contracts.Logger,
+	pageSize int64,
+	httpClient *http.Client,
+	basePath string,
+	transfers transfer.Repository,
+	retrier retry.Retrier,
+	uploadLimiter *throttle.Limiter,
+) *GoogleBackend {
+	return &GoogleBackend{
+		filesService:   filesService,
+		changesService: changesService,
+		log:            log,
+		pageSize:       pageSize,
+		httpClient:     httpClient,
+		basePath:       basePath,
+		transfers:      transfers,
+		retrier:        retrier,
+		uploadLimiter:  uploadLimiter,
+	}
+}
+
+func (b *GoogleBackend) List(driveId string, fn func(*drive.File) error) error {
+	return b.listFiles(driveId, "", fn)
+}
+
+func (b *GoogleBackend) ListSharedWithMe(fn func(*drive.File) error) error {
+	return b.listFiles("", "sharedWithMe = true and trashed = false", fn)
+}
+
+func (b *GoogleBackend) ListChildren(folderId string, fn func(*drive.File) error) error {
+	return b.listFiles("", fmt.Sprintf("'%s' in parents and trashed = false", folderId), fn)
+}
+
+// listFiles lists the files from "My Drive" (driveId is empty) or from a shared drive.
+// If query is not empty, just the matching files are listed
+func (b *GoogleBackend) listFiles(driveId string, query string, fn func(*drive.File) error) error {
+	var nextPageToken = ""
+	var filesListCall *drive.FilesListCall
+
+	for {
+		filesListCall = b.filesService.List()
+		if "" != driveId {
+			filesListCall.Corpora("drive").DriveId(driveId).IncludeItemsFromAllDrives(true).SupportsAllDrives(true)
+		}
+		if "" != query {
+			// the files shared with the user may be in the shared drives
+			filesListCall.Q(query).IncludeItemsFromAllDrives(true).SupportsAllDrives(true)
+		}
+		if "" != nextPageToken {
+			filesListCall.PageToken(nextPageToken)
+		}
+		filesListCall.PageSize(b.pageSize).Fields(
+			googleapi.Field(fmt.Sprintf("nextPageToken, files(%s)", fileFieldsSet)),
+		)
+		var fileList *drive.FileList
+		err := b.retrier.Do("files.list", func() (err error) {
+			fileList, err = filesListCall.Do()
+			return err
+		})
+		if err != nil {
+			return errors.Wrap(err, "unable to retrieve files")
+		}
+		nextPageToken = fileList.NextPageToken
+
+		b.log.Info("Getting files list...")
+
+		for _, rfile := range fileList.Files {
+			b.log.Debug("Found file", rfile)
+			if err = fn(rfile); err != nil {
+				return err
+			}
+		}
+		if "" == nextPageToken {
+			return nil
+		}
+	}
+}
+
+func (b *GoogleBackend) StartPageToken(driveId string) (string, error) {
+	startPageTokenCall := b.changesService.GetStartPageToken()
+	if "" != driveId {
+		startPageTokenCall.DriveId(driveId).SupportsAllDrives(true)
+	}
+	var startPageToken *drive.StartPageToken
+	err := b.retrier.Do("changes.getStartPageToken", func() (err error) {
+		startPageToken, err = startPageTokenCall.Do()
+		return err
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "could not get start page token")
+	}
+	return startPageToken.StartPageToken, nil
+}
+
+func (b *GoogleBackend) Changes(driveId string, pageToken string, fn func(*drive.Change) error) error {
+	var changesListCall *drive.ChangesListCall
+
+	for {
+		b.log.Debug("change token", pageToken)
+		changesListCall = b.changesService.List(pageToken)
+		if "" != driveId {
+			changesListCall.DriveId(driveId).IncludeItemsFromAllDrives(true).SupportsAllDrives(true)
+		}
+		changesListCall.PageSize(b.pageSize).Fields(
+			googleapi.Field(fmt.Sprintf("nextPageToken, changes(changeType, removed, fileId, file(%s))", fileFieldsSet)),
+		)
+		var changeList *drive.ChangeList
+		err := b.retrier.Do("changes.list", func() (err error) {
+			changeList, err = changesListCall.Do()
+			return err
+		})
+		if err != nil {
+			return errors.Wrap(err, "unable to retrieve changed files")
+		}
+		pageToken = changeList.NextPageToken
+
+		b.log.Info("getting changed files list. amount of changes: ", len(changeList.Changes))
+
+		for _, change := range changeList.Changes {
+			if "drive" == change.ChangeType { // the shared drive itself has changed, not a file
+				continue
+			}
+			// if the file was removed, there is no any information except its identifier
+			if change.Removed {
+				b.log.Debug(fmt.Sprintf("changeList:file %s was removed", change.FileId))
+			} else if change.File.Trashed {
+				b.log.Debug(fmt.Sprintf("changeList:file %s was trashed", change.FileId))
+			} else if change.File.ExplicitlyTrashed {
+				b.log.Debug(fmt.Sprintf("changeList:file %s was explicitly trashed", change.FileId))
+			} else {
+				b.log.With(contracts.Fields{
+					contracts.FieldFileId: change.FileId,
+					"name":                change.File.Name,
+					"modified_time":       change.File.ModifiedTime,
+				}).Debug("changeList:found change")
+			}
+			if err = fn(change); err != nil {
+				return err
+			}
+		}
+
+		if "" == pageToken {
+			b.log.Debug("no more changes")
+			return nil
+		}
+	}
+}
+
+func (b *GoogleBackend) Get(fileId string) (*drive.File, error) {
+	call := b.filesService.Get(fileId).SupportsAllDrives(true).Fields(googleapi.Field(fileFieldsSet))
+	f, err := b.doFileCall("files.get "+fileId, call.Do)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not get file %s", fileId)
+	}
+	return f, nil
+}
+
+func (b *GoogleBackend) Download(fileId string, offset int64) (io.ReadCloser, int64, error) {
+	call := b.filesService.Get(fileId).SupportsAllDrives(true)
+	if offset > 0 {
+		call.Header().Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	resp, err := b.download("files.get "+fileId, call.Download)
+	if err != nil {
+		return nil, 0, errors.Wrapf(err, "could not download file %s", fileId)
+	}
+	if http.StatusPartialContent != resp.StatusCode {
+		offset = 0
+	}
+	return resp.Body, offset, nil
+}
+
+func (b *GoogleBackend) Export(fileId string, mimeType string) (io.ReadCloser, error) {
+	resp, err := b.download("files.export "+fileId, b.filesService.Export(fileId, mimeType).Download)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not export file %s", fileId)
+	}
+	return resp.Body, nil
+}
+
+func (b *GoogleBackend) Upload(localPath string, fileId string, parentId string, name string, properties map[string]string) (*drive.File, error) {
+	metadata := &drive.File{AppProperties: properties}
+	if "" == fileId {
+		metadata.Name, metadata.Parents = name, []string{parentId}
+	}
+	return b.uploadResumable(localPath, fileId, parentId, metadata)
+}
+
+func (b *GoogleBackend) Update(fileId string, name string, addParentId string, removeParentId string) (*drive.File, error) {
+	call := b.filesService.Update(fileId, &drive.File{
+		Name: name,
+	}).SupportsAllDrives(true).Fields(googleapi.Field(fileFieldsSet)).AddParents(addParentId).RemoveParents(removeParentId)
+	f, err := b.doFileCall("files.update "+fileId, call.Do)
+	if nil != err {
+		err = errors.Wrapf(err, "could not update file with id %s", fileId)
+	}
+	return f, err
+}
+
+func (b *GoogleBackend) Copy(fileId string, name string, parentId string) (*drive.File, error) {
+	call := b.filesService.
+		Copy(fileId, &drive.File{Name: name, Parents: []string{parentId}}).
+		SupportsAllDrives(true).
+		Fields(googleapi.Field(fileFieldsSet))
+	f, err := b.doFileCall("files.copy "+fileId, call.Do)
+	if nil != err {
+		err = errors.Wrapf(err, "could not copy file with id %s", fileId)
+	}
+	return f, err
+}
+
+func (b *GoogleBackend) CreateFolder(name string, parentId string) (*drive.File, error) {
+	call := b.filesService.
+		Create(&drive.File{
+			Name:     name,
+			Parents:  []string{parentId},
+			MimeType: specification.GetFolderMime(),
+		}).
+		SupportsAllDrives(true).
+		Fields(googleapi.Field(fileFieldsSet))
+	f, err := b.doFileCall("files.create "+name, call.Do)
+	if nil != err {
+		err = errors.Wrapf(err, "could not create folder %s", name)
+	}
+	return f, err
+}
+
+func (b *GoogleBackend) Delete(fileId string) error {
+	call := b.filesService.Delete(fileId).SupportsAllDrives(true)
+	if err := b.retrier.Do("files.delete "+fileId, func() error {
+		return call.Do()
+	}); err != nil {
+		return errors.Wrapf(err, "could not delete file with id %s", fileId)
+	}
+	return nil
+}
+
+func (b *GoogleBackend) Trash(fileId string) error {
+	_, err := b.setTrashed(fileId, true)
+	return err
+}
+
+func (b *GoogleBackend) ListTrashed(fn func(*drive.File) error) error {
+	return b.listFiles("", "trashed = true", fn)
+}
+
+func (b *GoogleBackend) Restore(fileId string) (*drive.File, error) {
+	return b.setTrashed(fileId, false)
+}
+
+func (b *GoogleBackend) setTrashed(fileId string, trashed bool) (*drive.File, error) {
+	call := b.filesService.Update(fileId, &drive.File{
+		Trashed: trashed,
+		// false is omitted from the request otherwise
+		ForceSendFields: []string{"Trashed"},
+	}).SupportsAllDrives(true).Fields(googleapi.Field(fileFieldsSet))
+	f, err := b.doFileCall("files.update "+fileId, call.Do)
+	if nil != err {
+		err = errors.Wrapf(err, "could not set trashed to %t for file with id %s", trashed, fileId)
+	}
+	return f, err
+}
+
+func (b *GoogleBackend) EmptyTrash() error {
+	call := b.filesService.EmptyTrash()
+	if err := b.retrier.Do("files.emptyTrash", func() error {
+		return call.Do()
+	}); err != nil {
+		return errors.Wrap(err, "could not empty trash")
+	}
+	return nil
+}
+
+// doFileCall makes the call returning a file with retries
+func (b *GoogleBackend) doFileCall(call string, do func(...googleapi.CallOption) (*drive.File, error)) (*drive.File, error) {
+	var f *drive.File
+	err := b.retrier.Do(call, func() (err error) {
+		f, err = do()
+		return err
+	})
+	return f, err
+}
+
+// download starts the download with retries. Just getting the response is repeated,
+// an interrupted download continues the next time
+func (b *GoogleBackend) download(call string, do func(...googleapi.CallOption) (*http.Response, error)) (*http.Response, error) {
+	var resp *http.Response
+	err := b.retrier.Do(call, func() (err error) {
+		resp, err = do()
+		return err
+	})
+	return resp, err
+}
@@ -0,0 +1,820 @@
+package localdir
+
+import (
+	"bufio"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/svetlyi/gdriveapp/rdrive"
+	"github.com/svetlyi/gdriveapp/rdrive/specification"
+	"google.golang.org/api/drive/v3"
+	"io"
+	"io/ioutil"
+	"mime"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MetadataDirName is the folder in the directory with the index and the journal of the files
+const MetadataDirName = ".gdriveapp"
+
+// RootFolderName is the name of the root folder, the same as in Google Drive
+const RootFolderName = "My Drive"
+
+const indexFileName = "index.json"
+const journalFileName = "journal"
+
+// trashDirName is the folder in the metadata folder the trashed files are moved to
+const trashDirName = "trash"
+
+var errSharedDrives = errors.New("shared drives are not supported by a local directory")
+
+// Backend keeps the remote files in a plain local directory (for example, a NAS mount).
+// The ids of the files are kept in an index and every change is written to a journal,
+// so the changes are listed the same way as the changes of Google Drive. The changes made
+// directly in the directory are found when it is rescanned, that is when a synchronization
+// asks for the start page token
+type Backend struct {
+	dir string
+	// mu guards the index and the journal, as the transfer workers upload concurrently
+	mu    sync.Mutex
+	files map[string]*entry
+	// lastSeq is the sequence number of the last change in the journal
+	lastSeq int64
+}
+
+// entry is a file in the index
+type entry struct {
+	Id       string    `json:"id"`
+	Name     string    `json:"name"`
+	ParentId string    `json:"parent_id"`
+	Folder   bool      `json:"folder"`
+	Size     int64     `json:"size"`
+	ModTime  time.Time `json:"modification_time"`
+	Md5      string    `json:"md5"`
+	// Trashed says the file was moved to the trash. The files inside
+	// a trashed folder are in the trash too, but are not marked
+	Trashed     bool      `json:"trashed,omitempty"`
+	TrashedTime time.Time `json:"trashed_time"`
+	// Properties are the app properties of the file
+	Properties map[string]string `json:"properties,omitempty"`
+}
+
+// index is what is saved to the index file
+type index struct {
+	LastSeq int64    `json:"last_seq"`
+	Files   []*entry `json:"files"`
+}
+
+// journalRecord is a line of the journal: the file with the id was changed or removed
+type journalRecord struct {
+	Seq     int64  `json:"seq"`
+	FileId  string `json:"file_id"`
+	Removed bool   `json:"removed"`
+}
+
+// New opens the directory as a backend. The index is created on the first use
+func New(dir string) (*Backend, error) {
+	b := &Backend{dir: dir, files: make(map[string]*entry)}
+	if err := os.MkdirAll(b.metadataPath(""), 0755); err != nil {
+		return nil, errors.Wrapf(err, "could not create metadata folder in %s", dir)
+	}
+	data, err := ioutil.ReadFile(b.metadataPath(indexFileName))
+	if os.IsNotExist(err) {
+		b.files[rdrive.RootFolderId] = &entry{Id: rdrive.RootFolderId, Name: RootFolderName, Folder: true, ModTime: time.Now()}
+		return b, b.saveIndex()
+	} else if err != nil {
+		return nil, errors.Wrapf(err, "could not read index of %s", dir)
+	}
+	var idx index
+	if err = json.Unmarshal(data, &idx); err != nil {
+		return nil, errors.Wrapf(err, "could not parse index of %s", dir)
+	}
+	b.lastSeq = idx.LastSeq
+	for _, e := range idx.Files {
+		b.files[e.Id] = e
+	}
+	if _, ok := b.files[rdrive.RootFolderId]; !ok {
+		return nil, errors.Errorf("index of %s has no root folder", dir)
+	}
+	return b, nil
+}
+
+var _ rdrive.RemoteBackend = (*Backend)(nil)
+
+func (b *Backend) List(driveId string, fn func(*drive.File) error) error {
+	if "" != driveId {
+		return errSharedDrives
+	}
+	b.mu.Lock()
+	var files []*drive.File
+	for _, e := range b.files {
+		if rdrive.RootFolderId != e.Id && !b.isTrashed(e) {
+			files = append(files, b.toFile(e))
+		}
+	}
+	b.mu.Unlock()
+	for _, f := range files {
+		if err := fn(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListSharedWithMe lists nothing, as nobody shares files in a local directory
+func (b *Backend) ListSharedWithMe(fn func(*drive.File) error) error {
+	return nil
+}
+
+func (b *Backend) ListChildren(folderId string, fn func(*drive.File) error) error {
+	b.mu.Lock()
+	var files []*drive.File
+	for _, e := range b.files {
+		if folderId == e.ParentId && !e.Trashed {
+			files = append(files, b.toFile(e))
+		}
+	}
+	b.mu.Unlock()
+	for _, f := range files {
+		if err := fn(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// StartPageToken rescans the directory and returns the sequence number of the next change
+// with the size of the journal, so that the changes are read from where it ends now
+func (b *Backend) StartPageToken(driveId string) (string, error) {
+	if "" != driveId {
+		return "", errSharedDrives
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.scan(); err != nil {
+		return "", errors.Wrapf(err, "could not scan %s", b.dir)
+	}
+	var offset int64
+	if stat, err := os.Stat(b.metadataPath(journalFileName)); nil == err {
+		offset = stat.Size()
+	} else if !os.IsNotExist(err) {
+		return "", errors.Wrap(err, "could not get size of journal")
+	}
+	return fmt.Sprintf("%d:%d", b.lastSeq+1, offset), nil
+}
+
+// Changes lists the files changed since the change with the sequence number in pageToken.
+// A file changed several times is listed once with its current state
+func (b *Backend) Changes(driveId string, pageToken string, fn func(*drive.Change) error) error {
+	if "" != driveId {
+		return errSharedDrives
+	}
+	fromSeq, offset, err := parsePageToken(pageToken)
+	if err != nil {
+		return err
+	}
+	b.mu.Lock()
+	changes, err := b.readChanges(fromSeq, offset)
+	b.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	for _, change := range changes {
+		if err = fn(change); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parsePageToken returns the sequence number and the offset in the journal the changes are read from.
+// The tokens of the earlier versions have just the sequence number, the journal is read from the start then
+func parsePageToken(pageToken string) (int64, int64, error) {
+	parts := strings.SplitN(pageToken, ":", 2)
+	fromSeq, err := strconv.ParseInt(parts[0], 10, 64)
+	var offset int64
+	if nil == err && 2 == len(parts) {
+		offset, err = strconv.ParseInt(parts[1], 10, 64)
+	}
+	if nil != err || offset < 0 {
+		return 0, 0, errors.Errorf("wrong page token %q", pageToken)
+	}
+	return fromSeq, offset, nil
+}
+
+// readChanges reads the journal from the offset. The records before it were written before the
+// page token, so they are not read again. If the journal was replaced and there is no record
+// at the offset, it is read from the start
+func (b *Backend) readChanges(fromSeq int64, offset int64) ([]*drive.Change, error) {
+	journal, err := os.Open(b.metadataPath(journalFileName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.Wrap(err, "could not open journal")
+	}
+	defer journal.Close()
+	stat, err := journal.Stat()
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get size of journal")
+	}
+	if offset > stat.Size() || !b.isLineStart(journal, offset) {
+		offset = 0
+	}
+	if _, err = journal.Seek(offset, io.SeekStart); err != nil {
+		return nil, errors.Wrap(err, "could not seek in journal")
+	}
+
+	var changes []*drive.Change
+	listed := make(map[string]bool)
+	scanner := bufio.NewScanner(journal)
+	for scanner.Scan() {
+		var record journalRecord
+		if err = json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, errors.Wrap(err, "could not parse journal")
+		}
+		if record.Seq < fromSeq || listed[record.FileId] {
+			continue
+		}
+		listed[record.FileId] = true
+		if e, ok := b.files[record.FileId]; ok {
+			changes = append(changes, &drive.Change{FileId: e.Id, File: b.toFile(e)})
+		} else {
+			changes = append(changes, &drive.Change{FileId: record.FileId, Removed: true})
+		}
+	}
+	return changes, errors.Wrap(scanner.Err(), "could not read journal")
+}
+
+// isLineStart says if a record of the journal starts at the offset
+func (b *Backend) isLineStart(journal *os.File, offset int64) bool {
+	if 0 == offset {
+		return true
+	}
+	prev := make([]byte, 1)
+	_, err := journal.ReadAt(prev, offset-1)
+	return nil == err && '\n' == prev[0]
+}
+
+func (b *Backend) Get(fileId string) (*drive.File, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	e, err := b.get(fileId)
+	if err != nil {
+		return nil, err
+	}
+	return b.toFile(e), nil
+}
+
+// Download opens the file. The content starts with offset, as a local file can be read from any place
+func (b *Backend) Download(fileId string, offset int64) (io.ReadCloser, int64, error) {
+	b.mu.Lock()
+	e, err := b.get(fileId)
+	var path string
+	if nil == err {
+		path = b.path(e)
+	}
+	b.mu.Unlock()
+	if err != nil {
+		return nil, 0, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, errors.Wrapf(err, "could not open %s", path)
+	}
+	if _, err = f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, 0, errors.Wrapf(err, "could not seek %s", path)
+	}
+	return f, offset, nil
+}
+
+// Export fails, as there are no native Google files in a local directory
+func (b *Backend) Export(fileId string, mimeType string) (io.ReadCloser, error) {
+	return nil, errors.Errorf("file %s cannot be exported from a local directory", fileId)
+}
+
+// Upload copies the local file to a temporary file, which replaces the target one
+// when the copy is complete, so that an interrupted upload does not leave a broken file
+func (b *Backend) Upload(localPath string, fileId string, parentId string, name string, properties map[string]string) (*drive.File, error) {
+	tmpPath, size, hash, err := b.copyToTemp(localPath)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmpPath)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var e *entry
+	if "" == fileId {
+		if e, err = b.newEntry(name, parentId, false); err != nil {
+			return nil, err
+		}
+	} else if e, err = b.get(fileId); err != nil {
+		return nil, err
+	}
+	path := b.path(e)
+	if err = os.Rename(tmpPath, path); err != nil {
+		return nil, errors.Wrapf(err, "could not move uploaded file to %s", path)
+	}
+	if err = b.setContent(e, path, size, hash); err != nil {
+		return nil, err
+	}
+	e.setProperties(properties)
+	return b.save(e)
+}
+
+func (b *Backend) Update(fileId string, name string, addParentId string, removeParentId string) (*drive.File, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	e, err := b.get(fileId)
+	if err != nil {
+		return nil, err
+	}
+	if e.ParentId != removeParentId {
+		return nil, errors.Errorf("file %s is not in folder %s", fileId, removeParentId)
+	}
+	if _, err = b.getFolder(addParentId); err != nil {
+		return nil, err
+	}
+	oldPath := b.path(e)
+	moved := *e
+	moved.Name, moved.ParentId = name, addParentId
+	newPath := b.path(&moved)
+	if err = b.checkNotExists(newPath); err != nil {
+		return nil, err
+	}
+	if err = os.Rename(oldPath, newPath); err != nil {
+		return nil, errors.Wrapf(err, "could not move %s to %s", oldPath, newPath)
+	}
+	e.Name, e.ParentId = name, addParentId
+	return b.save(e)
+}
+
+func (b *Backend) Copy(fileId string, name string, parentId string) (*drive.File, error) {
+	b.mu.Lock()
+	e, err := b.get(fileId)
+	var srcPath string
+	if nil == err {
+		srcPath = b.path(e)
+	}
+	b.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	if e.Folder {
+		return nil, errors.Errorf("folder %s cannot be copied", fileId)
+	}
+	// the file is copied inside the directory, so it is not limited as an upload
+	tmpPath, size, hash, err := b.copyToTemp(srcPath)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmpPath)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	copied, err := b.newEntry(name, parentId, false)
+	if err != nil {
+		return nil, err
+	}
+	path := b.path(copied)
+	if err = os.Rename(tmpPath, path); err != nil {
+		return nil, errors.Wrapf(err, "could not move copied file to %s", path)
+	}
+	if err = b.setContent(copied, path, size, hash); err != nil {
+		return nil, err
+	}
+	copied.setProperties(e.Properties)
+	return b.save(copied)
+}
+
+func (b *Backend) CreateFolder(name string, parentId string) (*drive.File, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	e, err := b.newEntry(name, parentId, true)
+	if err != nil {
+		return nil, err
+	}
+	path := b.path(e)
+	if err = os.Mkdir(path, 0755); err != nil {
+		return nil, errors.Wrapf(err, "could not create folder %s", path)
+	}
+	e.ModTime = time.Now()
+	return b.save(e)
+}
+
+func (b *Backend) Delete(fileId string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	e, err := b.get(fileId)
+	if err != nil {
+		return err
+	}
+	if rdrive.RootFolderId == e.Id {
+		return errors.New("root folder cannot be deleted")
+	}
+	path := b.path(e)
+	if err = os.RemoveAll(path); err != nil {
+		return errors.Wrapf(err, "could not delete %s", path)
+	}
+	var records []journalRecord
+	for _, id := range b.withDescendants(e.Id) {
+		delete(b.files, id)
+		records = append(records, b.nextRecord(id, true))
+	}
+	return b.commit(records)
+}
+
+// Trash moves the file to the trash folder in the metadata folder
+func (b *Backend) Trash(fileId string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	e, err := b.get(fileId)
+	if err != nil {
+		return err
+	}
+	if rdrive.RootFolderId == e.Id {
+		return errors.New("root folder cannot be trashed")
+	}
+	if b.isTrashed(e) {
+		return errors.Errorf("file %s is already in the trash", fileId)
+	}
+	if err = os.MkdirAll(b.metadataPath(trashDirName), 0755); err != nil {
+		return errors.Wrap(err, "could not create trash folder")
+	}
+	trashed := *e
+	trashed.Trashed, trashed.TrashedTime = true, time.Now()
+	if err = os.Rename(b.path(e), b.path(&trashed)); err != nil {
+		return errors.Wrapf(err, "could not move %s to the trash", b.path(e))
+	}
+	*e = trashed
+	_, err = b.save(e)
+	return err
+}
+
+func (b *Backend) ListTrashed(fn func(*drive.File) error) error {
+	b.mu.Lock()
+	var files []*drive.File
+	for _, e := range b.files {
+		if e.Trashed {
+			files = append(files, b.toFile(e))
+		}
+	}
+	b.mu.Unlock()
+	for _, f := range files {
+		if err := fn(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Restore moves the file from the trash back to its folder. If the folder
+// does not exist anymore, the file goes to the root folder as in Google Drive
+func (b *Backend) Restore(fileId string) (*drive.File, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	e, err := b.get(fileId)
+	if err != nil {
+		return nil, err
+	}
+	if !e.Trashed {
+		return nil, errors.Errorf("file %s is not in the trash", fileId)
+	}
+	restored := *e
+	restored.Trashed, restored.TrashedTime = false, time.Time{}
+	if parent, ok := b.files[e.ParentId]; !ok {
+		restored.ParentId = rdrive.RootFolderId
+	} else if b.isTrashed(parent) {
+		return nil, errors.Errorf("folder %s of file %s is in the trash", parent.Id, fileId)
+	}
+	newPath := b.path(&restored)
+	if err = b.checkNotExists(newPath); err != nil {
+		return nil, err
+	}
+	if err = os.Rename(b.path(e), newPath); err != nil {
+		return nil, errors.Wrapf(err, "could not restore %s", newPath)
+	}
+	*e = restored
+	return b.save(e)
+}
+
+func (b *Backend) EmptyTrash() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var trashedIds []string
+	for _, e := range b.files {
+		if e.Trashed {
+			trashedIds = append(trashedIds, e.Id)
+		}
+	}
+	var records []journalRecord
+	for _, trashedId := range trashedIds {
+		for _, id := range b.withDescendants(trashedId) {
+			delete(b.files, id)
+			records = append(records, b.nextRecord(id, true))
+		}
+	}
+	if err := os.RemoveAll(b.metadataPath(trashDirName)); err != nil {
+		return errors.Wrap(err, "could not empty trash")
+	}
+	if 0 == len(records) {
+		return nil
+	}
+	return b.commit(records)
+}
+
+// scan finds the changes made directly in the directory: new, changed and removed files.
+// A file moved outside of the application becomes a new one, as there is nothing to
+// tell it is the same file
+func (b *Backend) scan() error {
+	byPath := make(map[string]*entry, len(b.files))
+	for _, e := range b.files {
+		byPath[b.path(e)] = e
+	}
+	seen := make(map[string]bool, len(b.files))
+	var records []journalRecord
+	err := filepath.Walk(b.dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == b.metadataPath("") {
+			return filepath.SkipDir
+		}
+		e, known := byPath[path]
+		if known && e.Folder != info.IsDir() { // a file was replaced with a folder or vice versa
+			known = false
+		}
+		if !known {
+			parent, ok := byPath[filepath.Dir(path)]
+			if !ok {
+				return errors.Errorf("no parent folder for %s", path)
+			}
+			e = &entry{Id: newId(), Name: info.Name(), ParentId: parent.Id, Folder: info.IsDir(), ModTime: info.ModTime()}
+			b.files[e.Id] = e
+			byPath[path] = e
+		}
+		seen[e.Id] = true
+		if !e.Folder && (!known || e.Size != info.Size() || !e.ModTime.Equal(info.ModTime())) {
+			hash, err := calcHash(path)
+			if err != nil {
+				return err
+			}
+			e.Size, e.ModTime, e.Md5 = info.Size(), info.ModTime(), hash
+			// the properties describe the content written by the application
+			e.Properties = nil
+			known = false
+		}
+		if !known {
+			records = append(records, b.nextRecord(e.Id, false))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for id, e := range b.files {
+		if !seen[id] && !b.isTrashed(e) {
+			delete(b.files, id)
+			records = append(records, b.nextRecord(id, true))
+		}
+	}
+	if 0 == len(records) {
+		return nil
+	}
+	return b.commit(records)
+}
+
+func (b *Backend) get(fileId string) (*entry, error) {
+	e, ok := b.files[fileId]
+	if !ok {
+		return nil, errors.Errorf("file %s not found", fileId)
+	}
+	return e, nil
+}
+
+func (b *Backend) getFolder(folderId string) (*entry, error) {
+	e, err := b.get(folderId)
+	if nil == err && !e.Folder {
+		err = errors.Errorf("file %s is not a folder", folderId)
+	}
+	return e, err
+}
+
+// newEntry creates a new file in the folder, but does not add it to the index yet
+func (b *Backend) newEntry(name string, parentId string, folder bool) (*entry, error) {
+	if _, err := b.getFolder(parentId); err != nil {
+		return nil, err
+	}
+	e := &entry{Id: newId(), Name: name, ParentId: parentId, Folder: folder}
+	return e, b.checkNotExists(b.path(e))
+}
+
+// checkNotExists makes sure nothing is overwritten, as unlike Google Drive a local
+// directory cannot have several files with the same name
+func (b *Backend) checkNotExists(path string) error {
+	if _, err := os.Lstat(path); nil == err {
+		return errors.Errorf("%s already exists", path)
+	} else if !os.IsNotExist(err) {
+		return errors.Wrapf(err, "could not get stat for %s", path)
+	}
+	return nil
+}
+
+func (b *Backend) setContent(e *entry, path string, size int64, hash string) error {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return errors.Wrapf(err, "could not get stat for %s", path)
+	}
+	e.Size, e.ModTime, e.Md5 = size, stat.ModTime(), hash
+	return nil
+}
+
+// save adds the file to the index and writes the change to the journal
+func (b *Backend) save(e *entry) (*drive.File, error) {
+	b.files[e.Id] = e
+	if err := b.commit([]journalRecord{b.nextRecord(e.Id, false)}); err != nil {
+		return nil, err
+	}
+	return b.toFile(e), nil
+}
+
+func (b *Backend) nextRecord(fileId string, removed bool) journalRecord {
+	b.lastSeq++
+	return journalRecord{Seq: b.lastSeq, FileId: fileId, Removed: removed}
+}
+
+// commit appends the records to the journal and saves the index. The journal goes
+// first: if the index is not saved, the records just point to the current state of the files
+func (b *Backend) commit(records []journalRecord) error {
+	journal, err := os.OpenFile(b.metadataPath(journalFileName), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return errors.Wrap(err, "could not open journal")
+	}
+	w := bufio.NewWriter(journal)
+	enc := json.NewEncoder(w)
+	for _, record := range records {
+		if err = enc.Encode(record); err != nil {
+			break
+		}
+	}
+	if nil == err {
+		err = w.Flush()
+	}
+	if closeErr := journal.Close(); nil == err {
+		err = closeErr
+	}
+	if err != nil {
+		return errors.Wrap(err, "could not write journal")
+	}
+	return b.saveIndex()
+}
+
+func (b *Backend) saveIndex() error {
+	idx := index{LastSeq: b.lastSeq}
+	for _, e := range b.files {
+		idx.Files = append(idx.Files, e)
+	}
+	data, err := json.Marshal(idx)
+	if err != nil {
+		return errors.Wrap(err, "could not encode index")
+	}
+	tmpPath := b.metadataPath(indexFileName + ".tmp")
+	if err = ioutil.WriteFile(tmpPath, data, 0644); err != nil {
+		return errors.Wrap(err, "could not write index")
+	}
+	return errors.Wrap(os.Rename(tmpPath, b.metadataPath(indexFileName)), "could not replace index")
+}
+
+// copyToTemp copies the file to a temporary file in the metadata folder
+// and returns its path, size and md5 hash
+func (b *Backend) copyToTemp(srcPath string) (string, int64, string, error) {
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return "", 0, "", errors.Wrapf(err, "could not open %s", srcPath)
+	}
+	defer src.Close()
+	tmp, err := ioutil.TempFile(b.metadataPath(""), "upload-*")
+	if err != nil {
+		return "", 0, "", errors.Wrap(err, "could not create temporary file")
+	}
+	h := md5.New()
+	size, err := io.Copy(io.MultiWriter(tmp, h), src)
+	if closeErr := tmp.Close(); nil == err {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", 0, "", errors.Wrapf(err, "could not copy %s", srcPath)
+	}
+	return tmp.Name(), size, hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// withDescendants returns the id of the folder and the ids of everything inside it
+func (b *Backend) withDescendants(folderId string) []string {
+	ids := []string{folderId}
+	for i := 0; i < len(ids); i++ {
+		for _, e := range b.files {
+			if ids[i] == e.ParentId {
+				ids = append(ids, e.Id)
+			}
+		}
+	}
+	return ids
+}
+
+// isTrashed says if the file or any of its folders is in the trash
+func (b *Backend) isTrashed(e *entry) bool {
+	for ; nil != e; e = b.files[e.ParentId] {
+		if e.Trashed {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *Backend) path(e *entry) string {
+	if rdrive.RootFolderId == e.Id {
+		return b.dir
+	}
+	if e.Trashed {
+		return filepath.Join(b.metadataPath(trashDirName), e.Id)
+	}
+	return filepath.Join(b.path(b.files[e.ParentId]), e.Name)
+}
+
+func (b *Backend) metadataPath(name string) string {
+	return filepath.Join(b.dir, MetadataDirName, name)
+}
+
+func (b *Backend) toFile(e *entry) *drive.File {
+	f := &drive.File{
+		Id:           e.Id,
+		Name:         e.Name,
+		ModifiedTime: e.ModTime.UTC().Format(time.RFC3339Nano),
+		OwnedByMe:    true,
+		Trashed:      b.isTrashed(e),
+	}
+	if e.Trashed {
+		f.ExplicitlyTrashed = true
+		f.TrashedTime = e.TrashedTime.UTC().Format(time.RFC3339Nano)
+	}
+	if "" != e.ParentId {
+		f.Parents = []string{e.ParentId}
+	}
+	if e.Folder {
+		f.MimeType = specification.GetFolderMime()
+		return f
+	}
+	f.MimeType = mime.TypeByExtension(filepath.Ext(e.Name))
+	if "" == f.MimeType {
+		f.MimeType = "application/octet-stream"
+	}
+	f.Md5Checksum, f.Size = e.Md5, e.Size
+	f.AppProperties = e.Properties
+	return f
+}
+
+// setProperties sets the properties the same way Google Drive does: the other ones are kept
+func (e *entry) setProperties(properties map[string]string) {
+	if 0 == len(properties) {
+		return
+	}
+	merged := make(map[string]string, len(e.Properties)+len(properties))
+	for name, value := range e.Properties {
+		merged[name] = value
+	}
+	for name, value := range properties {
+		merged[name] = value
+	}
+	e.Properties = merged
+}
+
+func calcHash(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", errors.Wrapf(err, "could not open %s", path)
+	}
+	defer f.Close()
+	h := md5.New()
+	if _, err = io.Copy(h, f); err != nil {
+		return "", errors.Wrapf(err, "could not calculate hash for %s", path)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func newId() string {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		panic(fmt.Sprintf("could not generate file id: %v", err))
+	}
+	return hex.EncodeToString(id)
+}
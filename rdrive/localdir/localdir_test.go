@@ -0,0 +1,221 @@
+package localdir
+
+import (
+	"crypto/md5"
+	"fmt"
+	"github.com/svetlyi/gdriveapp/rdrive"
+	"google.golang.org/api/drive/v3"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func getChanges(t *testing.T, b *Backend, pageToken string) map[string]*drive.Change {
+	changes := make(map[string]*drive.Change)
+	err := b.Changes("", pageToken, func(change *drive.Change) error {
+		changes[change.FileId] = change
+		return nil
+	})
+	if nil != err {
+		t.Fatal(err)
+	}
+	return changes
+}
+
+func TestJournal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gdriveapp-localdir-")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	b, err := New(dir)
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	token, err := b.StartPageToken("")
+	if nil != err {
+		t.Fatal(err)
+	}
+	folder, err := b.CreateFolder("docs", rdrive.RootFolderId)
+	if nil != err {
+		t.Fatal(err)
+	}
+	localPath := filepath.Join(dir, MetadataDirName, "report.txt")
+	if err = ioutil.WriteFile(localPath, []byte("report"), 0644); nil != err {
+		t.Fatal(err)
+	}
+	uploaded, err := b.Upload(localPath, "", folder.Id, "report.txt", nil)
+	if nil != err {
+		t.Fatal(err)
+	}
+	if fmt.Sprintf("%x", md5.Sum([]byte("report"))) != uploaded.Md5Checksum {
+		t.Error("wrong md5 checksum", uploaded.Md5Checksum)
+	}
+	changes := getChanges(t, b, token)
+	if 2 != len(changes) || nil == changes[folder.Id] || nil == changes[uploaded.Id] {
+		t.Fatal("expected changes of the folder and the file, got", changes)
+	}
+
+	// the changes made directly in the directory are found on the next synchronization
+	if err = ioutil.WriteFile(filepath.Join(dir, "docs", "external.txt"), []byte("external"), 0644); nil != err {
+		t.Fatal(err)
+	}
+	if err = os.Remove(filepath.Join(dir, "docs", "report.txt")); nil != err {
+		t.Fatal(err)
+	}
+	b, err = New(dir) // the ids survive a restart
+	if nil != err {
+		t.Fatal(err)
+	}
+	if token, err = b.StartPageToken(""); nil != err {
+		t.Fatal(err)
+	}
+	changes = getChanges(t, b, "1")
+	if !changes[uploaded.Id].Removed {
+		t.Error("the removed file must be listed as removed")
+	}
+	if _, ok := changes[folder.Id]; !ok {
+		t.Error("the folder must keep its id")
+	}
+	var external *drive.File
+	for _, change := range changes {
+		if nil != change.File && "external.txt" == change.File.Name {
+			external = change.File
+		}
+	}
+	if nil == external || folder.Id != external.Parents[0] {
+		t.Fatal("the external file must be found in the folder", external)
+	}
+
+	if err = b.Delete(folder.Id); nil != err {
+		t.Fatal(err)
+	}
+	changes = getChanges(t, b, token)
+	if 2 != len(changes) || !changes[folder.Id].Removed || !changes[external.Id].Removed {
+		t.Error("the folder and its content must be removed", changes)
+	}
+}
+
+func TestPageTokenOffset(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gdriveapp-localdir-")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	b, err := New(dir)
+	if nil != err {
+		t.Fatal(err)
+	}
+	if _, err = b.CreateFolder("old", rdrive.RootFolderId); nil != err {
+		t.Fatal(err)
+	}
+	token, err := b.StartPageToken("")
+	if nil != err {
+		t.Fatal(err)
+	}
+	folder, err := b.CreateFolder("new", rdrive.RootFolderId)
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	// the records written before the token are not read again
+	journalPath := filepath.Join(dir, MetadataDirName, journalFileName)
+	_, offset, err := parsePageToken(token)
+	if nil != err {
+		t.Fatal(err)
+	}
+	journal, err := os.OpenFile(journalPath, os.O_WRONLY, 0644)
+	if nil != err {
+		t.Fatal(err)
+	}
+	_, err = journal.Write([]byte(strings.Repeat(" ", int(offset)-1)))
+	if closeErr := journal.Close(); nil == err {
+		err = closeErr
+	}
+	if nil != err {
+		t.Fatal(err)
+	}
+	changes := getChanges(t, b, token)
+	if 1 != len(changes) || nil == changes[folder.Id] {
+		t.Fatal("expected the change of the new folder, got", changes)
+	}
+}
+
+func TestTrash(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gdriveapp-localdir-")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err = os.MkdirAll(filepath.Join(dir, "docs", "old"), 0755); nil != err {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(filepath.Join(dir, "docs", "old", "report.txt"), []byte("report"), 0644); nil != err {
+		t.Fatal(err)
+	}
+	b, err := New(dir)
+	if nil != err {
+		t.Fatal(err)
+	}
+	token, err := b.StartPageToken("")
+	if nil != err {
+		t.Fatal(err)
+	}
+	files := make(map[string]*drive.File)
+	if err = b.List("", func(f *drive.File) error { files[f.Name] = f; return nil }); nil != err {
+		t.Fatal(err)
+	}
+	docs, old, report := files["docs"], files["old"], files["report.txt"]
+
+	if err = b.Trash(old.Id); nil != err {
+		t.Fatal(err)
+	}
+	if err = b.Trash(docs.Id); nil != err {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(filepath.Join(dir, "docs")); !os.IsNotExist(err) {
+		t.Error("the trashed folder must be moved from the directory")
+	}
+	// the trashed files are not found as removed, when the directory is rescanned
+	if _, err = b.StartPageToken(""); nil != err {
+		t.Fatal(err)
+	}
+	changes := getChanges(t, b, token)
+	if 2 != len(changes) || !changes[docs.Id].File.Trashed || !changes[old.Id].File.ExplicitlyTrashed {
+		t.Fatal("expected the folders to be trashed, got", changes)
+	}
+	if f, err := b.Get(report.Id); nil != err || !f.Trashed || f.ExplicitlyTrashed {
+		t.Error("the file in a trashed folder must be in the trash", f, err)
+	}
+
+	if _, err = b.Restore(old.Id); nil == err {
+		t.Error("the folder cannot be restored to a trashed folder")
+	}
+	if _, err = b.Restore(docs.Id); nil != err {
+		t.Fatal(err)
+	}
+	if _, err = b.Restore(old.Id); nil != err {
+		t.Fatal(err)
+	}
+	content, err := ioutil.ReadFile(filepath.Join(dir, "docs", "old", "report.txt"))
+	if nil != err || "report" != string(content) {
+		t.Errorf("the file must be restored, got %q: %v", content, err)
+	}
+
+	if err = b.Trash(docs.Id); nil != err {
+		t.Fatal(err)
+	}
+	if err = b.EmptyTrash(); nil != err {
+		t.Fatal(err)
+	}
+	if _, err = b.Get(report.Id); nil == err {
+		t.Error("the file in the trash must be deleted")
+	}
+	var trashed []*drive.File
+	if err = b.ListTrashed(func(f *drive.File) error { trashed = append(trashed, f); return nil }); nil != err || 0 != len(trashed) {
+		t.Error("the trash must be empty", trashed, err)
+	}
+}
@@ -0,0 +1,127 @@
+package rdrive
+
+import (
+	"github.com/pkg/errors"
+	"github.com/svetlyi/gdriveapp/contracts"
+	lfile "github.com/svetlyi/gdriveapp/ldrive/file"
+	"github.com/svetlyi/gdriveapp/rdrive/specification"
+)
+
+// PlanSyncRemoteWithLocal decides what has to be done to synchronize the local file
+// system with the remote one. It does not change anything, the actions are
+// performed by Execute.
+// file - file information from remote
+func (d *Drive) PlanSyncRemoteWithLocal(file contracts.File) ([]contracts.Action, error) {
+	remoteChangeType, err := d.isChangedRemotely(file)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not determine if it was remotely changed")
+	}
+	// the local copy of a remotely moved file is still in the previous location
+	localFullFilePath := lfile.GetCurFullPath(d.cfg, file)
+	if contracts.FILE_MOVED == remoteChangeType {
+		localFullFilePath = lfile.GetPrevFullPath(d.cfg, file)
+	}
+	localChangeType, err := d.isChangedLocally(file, localFullFilePath)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not determine if it was locally changed")
+	}
+
+	if (localChangeType != contracts.FILE_NOT_CHANGED || remoteChangeType != contracts.FILE_NOT_CHANGED) &&
+		(specification.CanDownloadFile(file, d.cfg.ExportFormats) || specification.IsFolder(file)) {
+		d.log.With(contracts.Fields{
+			contracts.FieldFileId: file.Id,
+			contracts.FieldPath:   file.CurPath,
+			"local_change_type":   localChangeType,
+			"remote_change_type":  remoteChangeType,
+		}).Debug("SyncRemoteWithLocal. change types")
+	}
+
+	plan := func(actionType contracts.ActionType, side contracts.ActionSide, reason string) []contracts.Action {
+		return []contracts.Action{{
+			Type:         actionType,
+			Side:         side,
+			Path:         lfile.GetLocalPath(d.cfg, file.CurPath, file),
+			PrevPath:     lfile.GetLocalPath(d.cfg, file.PrevPath, file),
+			FileId:       file.Id,
+			LocalChange:  localChangeType,
+			RemoteChange: remoteChangeType,
+			Reason:       reason,
+			File:         file,
+		}}
+	}
+	conflict := func(reason string) []contracts.Action {
+		actions := plan(contracts.ACTION_CONFLICT, contracts.SIDE_LOCAL, reason)
+		actions[0].Resolution = d.getConflictPolicy(file)
+		return actions
+	}
+
+	if specification.IsFolder(file) {
+		switch { // the only the things that can happen to a folder are: move, Delete
+		case contracts.FILE_MOVED == remoteChangeType && contracts.FILE_NOT_EXIST == localChangeType:
+			return plan(contracts.ACTION_MKDIR, contracts.SIDE_LOCAL, "remote folder was moved, local one does not exist"), nil
+		case contracts.FILE_MOVED == remoteChangeType:
+			return plan(contracts.ACTION_MOVE, contracts.SIDE_LOCAL, "remote folder was moved"), nil
+		case contracts.FILE_DELETED == remoteChangeType:
+			return plan(contracts.ACTION_DELETE_LOCAL, contracts.SIDE_LOCAL, "remote folder was deleted"), nil
+		case contracts.FILE_NOT_CHANGED == remoteChangeType && contracts.FILE_DELETED == localChangeType &&
+			file.RootFolder == 0 && file.ReadOnly == 0:
+			return plan(contracts.ACTION_MARK_REMOVED_LOCALLY, contracts.SIDE_REMOTE, "local folder was deleted"), nil
+		case contracts.FILE_UPDATED == remoteChangeType ||
+			(contracts.FILE_NOT_CHANGED == remoteChangeType && contracts.FILE_NOT_EXIST == localChangeType):
+			if contracts.FILE_NOT_EXIST == localChangeType || contracts.FILE_DELETED == localChangeType {
+				return plan(contracts.ACTION_MKDIR, contracts.SIDE_LOCAL, "local folder does not exist"), nil
+			}
+			return plan(contracts.ACTION_MARK_SYNCED, contracts.SIDE_LOCAL, "remote folder was updated"), nil
+		}
+		return nil, nil
+	}
+	if !specification.CanDownloadFile(file, d.cfg.ExportFormats) {
+		return nil, nil
+	}
+	if !d.canUpload(file) && contracts.FILE_NOT_CHANGED == remoteChangeType {
+		// the exported copies and the read-only files cannot be uploaded back or be a reason
+		// to delete the remote files
+		switch localChangeType {
+		case contracts.FILE_UPDATED:
+			return conflict("read-only or exported file was changed locally"), nil
+		case contracts.FILE_DELETED:
+			return plan(contracts.ACTION_DOWNLOAD, contracts.SIDE_LOCAL, "read-only or exported file was deleted locally"), nil
+		}
+	}
+
+	switch {
+	case contracts.FILE_NOT_CHANGED == remoteChangeType && contracts.FILE_NOT_EXIST == localChangeType:
+		return plan(contracts.ACTION_DOWNLOAD, contracts.SIDE_LOCAL, "remote file has not changed. local one does not exist"), nil
+	case contracts.FILE_NOT_CHANGED == remoteChangeType && contracts.FILE_NOT_CHANGED == localChangeType:
+		if file.DownloadTime.IsZero() {
+			return plan(contracts.ACTION_MARK_SYNCED, contracts.SIDE_LOCAL, "local file is the same as remote"), nil
+		}
+	case contracts.FILE_NOT_CHANGED == remoteChangeType && contracts.FILE_UPDATED == localChangeType:
+		return plan(contracts.ACTION_UPLOAD, contracts.SIDE_REMOTE, "remote file has not changed. local one updated"), nil
+	case contracts.FILE_NOT_CHANGED == remoteChangeType && contracts.FILE_DELETED == localChangeType:
+		// the file is removed remotely later, after looking for moved files
+		return plan(contracts.ACTION_MARK_REMOVED_LOCALLY, contracts.SIDE_REMOTE, "remote file has not changed. local one deleted"), nil
+	case contracts.FILE_UPDATED == remoteChangeType && contracts.FILE_NOT_CHANGED == localChangeType:
+		return plan(contracts.ACTION_DOWNLOAD, contracts.SIDE_LOCAL, "remote file changed"), nil
+	case contracts.FILE_UPDATED == remoteChangeType && contracts.FILE_UPDATED == localChangeType:
+		return conflict("remote and local files were changed"), nil
+	case contracts.FILE_UPDATED == remoteChangeType && contracts.FILE_DELETED == localChangeType:
+		return conflict("remote file was changed, but local one was deleted"), nil
+	case contracts.FILE_DELETED == remoteChangeType && contracts.FILE_NOT_CHANGED == localChangeType:
+		return plan(contracts.ACTION_DELETE_LOCAL, contracts.SIDE_LOCAL, "remote file was deleted"), nil
+	case contracts.FILE_DELETED == remoteChangeType && contracts.FILE_UPDATED == localChangeType:
+		return conflict("remote file was deleted, but local one was updated"), nil
+	case contracts.FILE_DELETED == remoteChangeType && contracts.FILE_DELETED == localChangeType:
+		return plan(contracts.ACTION_MARK_REMOVED_LOCALLY, contracts.SIDE_REMOTE, "remote and local files were deleted"), nil
+	case contracts.FILE_MOVED == remoteChangeType && contracts.FILE_NOT_CHANGED == localChangeType:
+		return plan(contracts.ACTION_MOVE, contracts.SIDE_LOCAL, "remote file was moved"), nil
+	case contracts.FILE_MOVED == remoteChangeType && contracts.FILE_UPDATED == localChangeType:
+		return conflict("remote file was moved, but local one was updated"), nil
+	case contracts.FILE_MOVED == remoteChangeType && contracts.FILE_DELETED == localChangeType:
+		return plan(contracts.ACTION_DOWNLOAD, contracts.SIDE_LOCAL, "remote file was moved, local one deleted"), nil
+	case contracts.FILE_MOVED == remoteChangeType && contracts.FILE_NOT_EXIST == localChangeType:
+		return plan(contracts.ACTION_DOWNLOAD, contracts.SIDE_LOCAL, "remote file was moved, local one does not exist"), nil
+	}
+
+	return nil, nil
+}
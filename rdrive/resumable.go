@@ -0,0 +1,223 @@
+package rdrive
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/svetlyi/gdriveapp/rdrive/db/transfer"
+	"github.com/svetlyi/gdriveapp/rdrive/throttle"
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// uploadChunkSize is the size of the parts the files are uploaded by. It must be a multiple of 256 KB
+const uploadChunkSize = 8 << 20
+
+// sessionLifetime is how long Google Drive keeps a resumable upload session
+const sessionLifetime = 7 * 24 * time.Hour
+
+// statusResumeIncomplete is returned while the upload session is not finished
+const statusResumeIncomplete = 308
+
+// errSessionExpired means the upload session cannot be continued and the upload starts over
+var errSessionExpired = errors.New("upload session expired")
+
+// uploadResumable uploads the content of the local file to a new remote file in the folder
+// with id parentId (if fileId is empty) or to the existing file with id fileId. The session
+// is saved, so that the next run continues an interrupted upload
+func (b *GoogleBackend) uploadResumable(localPath string, fileId string, parentId string, metadata *drive.File) (*drive.File, error) {
+	lf, err := os.Open(localPath)
+	if nil != err {
+		return nil, errors.Wrapf(err, "error opening file %s", localPath)
+	}
+	defer lf.Close()
+	stat, err := lf.Stat()
+	if nil != err {
+		return nil, errors.Wrapf(err, "could not get stat for file %s", localPath)
+	}
+
+	session, err := b.transfers.Get(localPath)
+	if nil != err && sql.ErrNoRows != errors.Cause(err) {
+		return nil, err
+	}
+	if nil == err && session.FileId == fileId && session.ParentId == parentId && session.Size == stat.Size() &&
+		session.ModTime.Equal(stat.ModTime()) && time.Since(session.Created) < sessionLifetime {
+		b.log.Info("continuing upload", localPath)
+		rf, err := b.upload(session.SessionUri, lf, stat.Size(), true)
+		if errSessionExpired != errors.Cause(err) {
+			if nil == err {
+				err = b.transfers.Delete(localPath)
+			}
+			return rf, err
+		}
+		b.log.Info("upload session expired. starting over", localPath)
+	}
+
+	session = transfer.Session{
+		LocalPath: localPath,
+		FileId:    fileId,
+		ParentId:  parentId,
+		Size:      stat.Size(),
+		ModTime:   stat.ModTime(),
+		Created:   time.Now(),
+	}
+	err = b.retrier.Do("upload session of "+localPath, func() (err error) {
+		session.SessionUri, err = b.startSession(fileId, metadata, stat.Size())
+		return err
+	})
+	if nil != err {
+		return nil, err
+	}
+	// unlike the rest of the metadata, the session is saved before the upload, otherwise it would
+	// be lost on interruption. The transfer workers hand the write over to the transfer pool
+	if err = b.transfers.Save(session); nil != err {
+		return nil, err
+	}
+	rf, err := b.upload(session.SessionUri, lf, stat.Size(), false)
+	if nil == err {
+		err = b.transfers.Delete(localPath)
+	}
+	return rf, err
+}
+
+// startSession starts a resumable upload session and returns its uri
+func (b *GoogleBackend) startSession(fileId string, metadata *drive.File, size int64) (string, error) {
+	body, err := json.Marshal(metadata)
+	if nil != err {
+		return "", errors.Wrap(err, "could not encode file metadata")
+	}
+	method, path := http.MethodPost, "/upload/drive/v3/files"
+	if "" != fileId {
+		method, path = http.MethodPatch, "/upload/drive/v3/files/"+url.PathEscape(fileId)
+	}
+	params := url.Values{}
+	params.Set("uploadType", "resumable")
+	params.Set("fields", fileFieldsSet)
+	params.Set("supportsAllDrives", "true")
+	req, err := http.NewRequest(method, googleapi.ResolveRelative(b.basePath, path)+"?"+params.Encode(), bytes.NewReader(body))
+	if nil != err {
+		return "", errors.Wrap(err, "could not create upload session request")
+	}
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	req.Header.Set("X-Upload-Content-Length", strconv.FormatInt(size, 10))
+
+	resp, err := b.httpClient.Do(req)
+	if nil != err {
+		return "", errors.Wrap(err, "could not start upload session")
+	}
+	defer resp.Body.Close()
+	if err = googleapi.CheckResponse(resp); nil != err {
+		return "", errors.Wrap(err, "could not start upload session")
+	}
+	sessionUri := resp.Header.Get("Location")
+	if "" == sessionUri {
+		return "", errors.New("upload session uri is missing")
+	}
+	return sessionUri, nil
+}
+
+// upload uploads the file in the session with retries. After a failure the upload
+// continues from where the server says it stopped
+func (b *GoogleBackend) upload(sessionUri string, lf *os.File, size int64, continued bool) (*drive.File, error) {
+	var rf *drive.File
+	err := b.retrier.Do("upload of "+lf.Name(), func() (err error) {
+		if continued {
+			rf, err = b.continueSession(sessionUri, lf, size)
+		} else {
+			rf, err = b.uploadFrom(sessionUri, lf, 0, size)
+		}
+		continued = true
+		return err
+	})
+	return rf, err
+}
+
+// continueSession asks how much of the file the server has got and uploads the rest
+func (b *GoogleBackend) continueSession(sessionUri string, lf *os.File, size int64) (*drive.File, error) {
+	req, err := http.NewRequest(http.MethodPut, sessionUri, nil)
+	if nil != err {
+		return nil, errors.Wrap(err, "could not create upload status request")
+	}
+	req.Header.Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+	resp, err := b.httpClient.Do(req)
+	if nil != err {
+		return nil, errors.Wrap(err, "could not get upload status")
+	}
+	rf, offset, err := b.parseUploadResponse(resp)
+	if nil != err || nil != rf {
+		return rf, err
+	}
+	return b.uploadFrom(sessionUri, lf, offset, size)
+}
+
+// uploadFrom uploads the file by chunks starting with offset
+func (b *GoogleBackend) uploadFrom(sessionUri string, lf *os.File, offset int64, size int64) (*drive.File, error) {
+	for {
+		chunkSize := size - offset
+		if chunkSize > uploadChunkSize {
+			chunkSize = uploadChunkSize
+		}
+		req, err := http.NewRequest(http.MethodPut, sessionUri, throttle.NewReader(io.NewSectionReader(lf, offset, chunkSize), b.uploadLimiter))
+		if nil != err {
+			return nil, errors.Wrap(err, "could not create upload request")
+		}
+		req.ContentLength = chunkSize
+		if 0 == size {
+			req.Header.Set("Content-Range", "bytes */0")
+		} else {
+			req.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, offset+chunkSize-1, size))
+		}
+		resp, err := b.httpClient.Do(req)
+		if nil != err {
+			return nil, errors.Wrapf(err, "could not upload %s", lf.Name())
+		}
+		rf, nextOffset, err := b.parseUploadResponse(resp)
+		if nil != err || nil != rf {
+			return rf, err
+		}
+		if nextOffset <= offset {
+			return nil, errors.Errorf("upload of %s does not progress", lf.Name())
+		}
+		offset = nextOffset
+	}
+}
+
+// parseUploadResponse returns either the uploaded file or, if the upload
+// is not finished, the offset the upload continues from
+func (b *GoogleBackend) parseUploadResponse(resp *http.Response) (*drive.File, int64, error) {
+	defer resp.Body.Close()
+	switch {
+	case statusResumeIncomplete == resp.StatusCode:
+		io.Copy(ioutil.Discard, resp.Body)
+		// the range is like "bytes=0-524287". No range means nothing has been received
+		received := resp.Header.Get("Range")
+		if "" == received {
+			return nil, 0, nil
+		}
+		last, err := strconv.ParseInt(received[strings.LastIndex(received, "-")+1:], 10, 64)
+		if nil != err {
+			return nil, 0, errors.Wrapf(err, "could not parse uploaded range %s", received)
+		}
+		return nil, last + 1, nil
+	case http.StatusNotFound == resp.StatusCode || http.StatusGone == resp.StatusCode:
+		return nil, 0, errSessionExpired
+	}
+	if err := googleapi.CheckResponse(resp); nil != err {
+		return nil, 0, errors.Wrap(err, "upload error")
+	}
+	var rf drive.File
+	if err := json.NewDecoder(resp.Body).Decode(&rf); nil != err {
+		return nil, 0, errors.Wrap(err, "could not decode uploaded file")
+	}
+	return &rf, 0, nil
+}
@@ -0,0 +1,125 @@
+// Package retry repeats the calls to Google Drive, which failed because of the rate
+// limits or a temporary server or network problem, with exponential backoff
+package retry
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"github.com/svetlyi/gdriveapp/contracts"
+	"google.golang.org/api/googleapi"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+const (
+	// firstDelay is the delay before the first retry. Each next one is twice as long
+	firstDelay = time.Second
+	// maxDelay is the longest delay between the attempts
+	maxDelay = 64 * time.Second
+)
+
+// Retrier calls a function until it succeeds, fails with an error that
+// cannot go away by itself or the attempts are over
+type Retrier struct {
+	maxAttempts int
+	log         contracts.Logger
+	// sleep is replaced in the tests
+	sleep func(d time.Duration)
+}
+
+func New(maxAttempts int, log contracts.Logger) Retrier {
+	return Retrier{maxAttempts: maxAttempts, log: log, sleep: time.Sleep}
+}
+
+// Do calls fn up to the max attempts times while it returns a temporary error.
+// The last error is returned. call describes the call for the log
+func (r Retrier) Do(call string, fn func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if nil == err || attempt >= r.maxAttempts {
+			return err
+		}
+		reason, retryAfter, temporary := classify(err)
+		if !temporary {
+			return err
+		}
+		delay := backoff(attempt)
+		if retryAfter > delay {
+			delay = retryAfter
+		}
+		r.log.With(contracts.Fields{
+			"call":    call,
+			"reason":  reason,
+			"attempt": attempt + 1,
+			"delay":   delay.String(),
+		}).Warning("retrying call", err)
+		r.sleep(delay)
+	}
+}
+
+// backoff returns the delay after the attempt: it doubles with each attempt and
+// is random from half of it to the whole of it, so that the concurrent transfers
+// hitting the rate limit together do not come back all at once
+func backoff(attempt int) time.Duration {
+	delay := maxDelay
+	if attempt <= 6 {
+		delay = firstDelay << uint(attempt-1)
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// classify says if the error is temporary and why, and how long the server asked to wait.
+// The canceled calls are never repeated
+func classify(err error) (reason string, retryAfter time.Duration, temporary bool) {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return "", 0, false
+	}
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		retryAfter = parseRetryAfter(apiErr.Header.Get("Retry-After"))
+		switch apiErr.Code {
+		case http.StatusTooManyRequests:
+			return "too many requests", retryAfter, true
+		case http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return http.StatusText(apiErr.Code), retryAfter, true
+		case http.StatusForbidden:
+			for _, item := range apiErr.Errors {
+				switch item.Reason {
+				case "userRateLimitExceeded", "rateLimitExceeded":
+					return item.Reason, retryAfter, true
+				}
+			}
+		}
+		return "", 0, false
+	}
+	switch {
+	case errors.Is(err, syscall.ECONNRESET):
+		return "connection reset", 0, true
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return "unexpected end of response", 0, true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return "network timeout", 0, true
+	}
+	return "", 0, false
+}
+
+// parseRetryAfter parses the value of the Retry-After header: either seconds or a date
+func parseRetryAfter(value string) time.Duration {
+	if "" == value {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); nil == err {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); nil == err {
+		return time.Until(at)
+	}
+	return 0
+}
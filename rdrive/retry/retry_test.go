@@ -0,0 +1,113 @@
+package retry
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"github.com/svetlyi/gdriveapp/logger"
+	"google.golang.org/api/googleapi"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestDo(t *testing.T) {
+	rateLimited := &googleapi.Error{
+		Code:   http.StatusForbidden,
+		Errors: []googleapi.ErrorItem{{Reason: "userRateLimitExceeded"}},
+		Header: http.Header{"Retry-After": []string{"120"}},
+	}
+	notFound := &googleapi.Error{Code: http.StatusNotFound}
+	cases := []struct {
+		name     string
+		errs     []error
+		attempts int
+		delays   []time.Duration
+	}{
+		{"success", []error{nil}, 1, nil},
+		{"rate limit then success", []error{errors.Wrap(rateLimited, "could not list"), nil}, 2, []time.Duration{120 * time.Second}},
+		{"not found is not retried", []error{notFound}, 1, nil},
+		{"attempts are over", []error{&googleapi.Error{Code: 503}, &googleapi.Error{Code: 500}, &googleapi.Error{Code: 429}}, 3, nil},
+	}
+	log, err := logger.New("svetlyi_gdriveapp_test", 10000, 0, false)
+	if nil != err {
+		t.Fatal(err)
+	}
+	for _, c := range cases {
+		var delays []time.Duration
+		r := New(3, log)
+		r.sleep = func(d time.Duration) { delays = append(delays, d) }
+		attempts := 0
+		err := r.Do("test call", func() error {
+			err := c.errs[attempts]
+			attempts++
+			return err
+		})
+		if attempts != c.attempts {
+			t.Errorf("%s: expected %d attempts, got %d", c.name, c.attempts, attempts)
+		}
+		if err != c.errs[len(c.errs)-1] {
+			t.Errorf("%s: expected the last error, got %v", c.name, err)
+		}
+		for i, delay := range c.delays {
+			if delays[i] != delay {
+				t.Errorf("%s: expected delay %s, got %s", c.name, delay, delays[i])
+			}
+		}
+	}
+}
+
+// timeoutError is a network error, that times out
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestClassify(t *testing.T) {
+	urlError := func(err error) error {
+		return &url.Error{Op: "Get", URL: "https://www.googleapis.com/drive/v3/files", Err: err}
+	}
+	reset := &net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", syscall.ECONNRESET)}
+	refused := &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}
+	cases := []struct {
+		name      string
+		err       error
+		temporary bool
+	}{
+		{"internal server error", &googleapi.Error{Code: http.StatusInternalServerError}, true},
+		{"bad gateway", &googleapi.Error{Code: http.StatusBadGateway}, true},
+		{"service unavailable", errors.Wrap(&googleapi.Error{Code: http.StatusServiceUnavailable}, "could not list"), true},
+		{"gateway timeout", &googleapi.Error{Code: http.StatusGatewayTimeout}, true},
+		{"not implemented", &googleapi.Error{Code: http.StatusNotImplemented}, false},
+		{"http version not supported", &googleapi.Error{Code: http.StatusHTTPVersionNotSupported}, false},
+		{"network timeout", urlError(timeoutError{}), true},
+		{"connection reset", errors.Wrap(urlError(reset), "could not upload"), true},
+		{"unexpected eof", urlError(io.ErrUnexpectedEOF), true},
+		{"connection refused", urlError(refused), false},
+		{"canceled", urlError(context.Canceled), false},
+		{"deadline exceeded", errors.Wrap(urlError(context.DeadlineExceeded), "could not list"), false},
+	}
+	for _, c := range cases {
+		if _, _, temporary := classify(c.err); c.temporary != temporary {
+			t.Errorf("%s: expected temporary %v, got %v", c.name, c.temporary, temporary)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	for attempt := 1; attempt <= 10; attempt++ {
+		delay := backoff(attempt)
+		expected := maxDelay
+		if attempt <= 6 {
+			expected = firstDelay << uint(attempt-1)
+		}
+		if delay < expected/2 || delay > expected {
+			t.Errorf("attempt %d: delay %s is out of %s", attempt, delay, expected)
+		}
+	}
+}
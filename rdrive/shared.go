@@ -0,0 +1,68 @@
+package rdrive
+
+import (
+	"github.com/pkg/errors"
+	"github.com/svetlyi/gdriveapp/rdrive/specification"
+	"google.golang.org/api/drive/v3"
+)
+
+// SharedWithMeRootId is the id of the virtual root folder with the files shared with
+// the user. It is also the drive id of the folder, as it is a separate root
+const SharedWithMeRootId = "shared-with-me"
+
+// SharedWithMeFolderName is the name of the local folder with the files shared with the user
+const SharedWithMeFolderName = "Shared with me"
+
+// isSharedWithMe says if the file is one of the files (or folders) shared with the user,
+// which are placed to the "Shared with me" folder. The files inside shared folders
+// keep their parents
+func (d *Drive) isSharedWithMe(gfile *drive.File) bool {
+	return d.cfg.SharedWithMe && "" != gfile.SharedWithMeTime && !gfile.OwnedByMe
+}
+
+// getLocalParents returns the parents of the remote file in the local tree. The files
+// shared with the user are moved to the "Shared with me" folder, as their parents
+// are usually not available for the user
+func (d *Drive) getLocalParents(gfile *drive.File) []string {
+	if d.isSharedWithMe(gfile) {
+		return []string{SharedWithMeRootId}
+	}
+	return gfile.Parents
+}
+
+// fillSharedWithMe saves the virtual "Shared with me" folder and all the files shared
+// with the user to the database. The changes of the files come with the changes of "My Drive"
+func (d *Drive) fillSharedWithMe() error {
+	rootFolder := &drive.File{
+		Id:           SharedWithMeRootId,
+		Name:         SharedWithMeFolderName,
+		MimeType:     specification.GetFolderMime(),
+		Capabilities: &drive.FileCapabilities{CanEdit: false},
+	}
+	if err := d.fileRepository.SaveRootFolder(rootFolder, SharedWithMeRootId); err != nil {
+		return errors.Wrap(err, "error saving shared with me folder")
+	}
+
+	// the shared folders are gone through level by level
+	var folderIds []string
+	saveSharedFile := func(gfile *drive.File) error {
+		if err := d.saveRemoteFile(gfile); err != nil {
+			return errors.Wrapf(err, "could not save shared file %s", gfile.Id)
+		}
+		if specification.GetFolderMime() == gfile.MimeType {
+			folderIds = append(folderIds, gfile.Id)
+		}
+		return nil
+	}
+	if err := d.backend.ListSharedWithMe(saveSharedFile); err != nil {
+		return err
+	}
+	for len(folderIds) > 0 {
+		folderId := folderIds[0]
+		folderIds = folderIds[1:]
+		if err := d.backend.ListChildren(folderId, saveSharedFile); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -7,6 +7,38 @@ import (
 	"strings"
 )
 
+const googleAppsMimePrefix = "application/vnd.google-apps."
+
+// exportMimeTypes maps the kinds of native Google files to the formats (file extensions)
+// they can be exported to and the mime types of the formats
+var exportMimeTypes = map[string]map[string]string{
+	"document": {
+		"docx": "application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+		"odt":  "application/vnd.oasis.opendocument.text",
+		"rtf":  "application/rtf",
+		"pdf":  "application/pdf",
+		"txt":  "text/plain",
+		"epub": "application/epub+zip",
+	},
+	"spreadsheet": {
+		"xlsx": "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+		"ods":  "application/x-vnd.oasis.opendocument.spreadsheet",
+		"csv":  "text/csv",
+		"pdf":  "application/pdf",
+	},
+	"presentation": {
+		"pptx": "application/vnd.openxmlformats-officedocument.presentationml.presentation",
+		"odp":  "application/vnd.oasis.opendocument.presentation",
+		"pdf":  "application/pdf",
+	},
+	"drawing": {
+		"pdf": "application/pdf",
+		"png": "image/png",
+		"jpg": "image/jpeg",
+		"svg": "image/svg+xml",
+	},
+}
+
 func GetFolderMime() string {
 	return "application/vnd.google-apps.folder"
 }
@@ -15,6 +47,34 @@ func IsFolder(file contracts.File) bool {
 	return file.MimeType == GetFolderMime()
 }
 
-func CanDownloadFile(file contracts.File) bool {
-	return !strings.Contains(file.MimeType, "application/vnd.google-apps")
+// CanDownloadFile says if the file can be downloaded: either it is a regular
+// file or a native Google file exported to one of exportFormats
+func CanDownloadFile(file contracts.File, exportFormats map[string]string) bool {
+	_, _, exported := GetExportFormat(file, exportFormats)
+	return exported || !strings.Contains(file.MimeType, "application/vnd.google-apps")
+}
+
+// GetExportFormat returns the file extension and the mime type a native Google file is
+// exported to. exportFormats maps the kinds of the files (document, spreadsheet and so on)
+// to the extensions. ok is false if the file is not exported
+func GetExportFormat(file contracts.File, exportFormats map[string]string) (ext string, mimeType string, ok bool) {
+	if !strings.HasPrefix(file.MimeType, googleAppsMimePrefix) {
+		return "", "", false
+	}
+	kind := strings.TrimPrefix(file.MimeType, googleAppsMimePrefix)
+	ext = exportFormats[kind]
+	mimeType, ok = exportMimeTypes[kind][ext]
+	return ext, mimeType, ok
+}
+
+// IsExportFormatSupported says if a native Google file of the kind (document,
+// spreadsheet and so on) can be exported to the format. An empty format means
+// the files of the kind are not exported
+func IsExportFormatSupported(kind string, ext string) bool {
+	formats, ok := exportMimeTypes[kind]
+	if !ok {
+		return false
+	}
+	_, ok = formats[ext]
+	return ok || "" == ext
 }
@@ -0,0 +1,21 @@
+package specification
+
+import (
+	"github.com/svetlyi/gdriveapp/contracts"
+	"google.golang.org/api/drive/v3"
+)
+
+// PropertySymlink is the app property of the remote file, that keeps a local symbolic link.
+// The value is the target of the link, the content of the file is the target as well
+const PropertySymlink = "gdriveapp_symlink"
+
+// GetSymlinkTarget returns the target of the symbolic link the remote file keeps.
+// It is empty, if the file is not a symbolic link
+func GetSymlinkTarget(file *drive.File) string {
+	return file.AppProperties[PropertySymlink]
+}
+
+// IsSymlink says if the remote file keeps a symbolic link
+func IsSymlink(file contracts.File) bool {
+	return "" != file.Symlink
+}
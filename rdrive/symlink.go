@@ -0,0 +1,120 @@
+package rdrive
+
+import (
+	"crypto/md5"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/svetlyi/gdriveapp/config"
+	"github.com/svetlyi/gdriveapp/contracts"
+	lfile "github.com/svetlyi/gdriveapp/ldrive/file"
+	"github.com/svetlyi/gdriveapp/rdrive/specification"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// stat returns the info of the local file. When the symbolic links are stored,
+// a link is synchronized itself, so the info is about the link, not about its target
+func (d *Drive) stat(fullPath string) (os.FileInfo, error) {
+	if config.SymlinksStore == d.cfg.Symlinks {
+		return os.Lstat(fullPath)
+	}
+	return os.Stat(fullPath)
+}
+
+// readSymlink returns the target of the local file, if it is a symbolic link, that is stored
+// remotely. isLink is false for the rest of the files and for all of them with the other policies
+func (d *Drive) readSymlink(fullPath string) (target string, isLink bool, err error) {
+	if config.SymlinksStore != d.cfg.Symlinks {
+		return "", false, nil
+	}
+	info, err := os.Lstat(fullPath)
+	if nil != err {
+		return "", false, errors.Wrapf(err, "could not get stat for file %s", fullPath)
+	}
+	if 0 == info.Mode()&os.ModeSymlink {
+		return "", false, nil
+	}
+	if target, err = os.Readlink(fullPath); nil != err {
+		return "", false, errors.Wrapf(err, "could not read symbolic link %s", fullPath)
+	}
+	return target, true, nil
+}
+
+// symlinkHash returns the hash of the remote file, that keeps the symbolic link with the target
+func symlinkHash(target string) string {
+	return fmt.Sprintf("%x", md5.Sum([]byte(target)))
+}
+
+// writeSymlinkContent writes the target of the symbolic link to a temporary file, which is
+// uploaded as the content of the remote file. The caller removes the file
+func writeSymlinkContent(target string) (string, error) {
+	f, err := ioutil.TempFile("", "gdriveapp-symlink-")
+	if nil != err {
+		return "", errors.Wrap(err, "could not create temporary file")
+	}
+	_, err = f.WriteString(target)
+	if closeErr := f.Close(); nil == err {
+		err = closeErr
+	}
+	if nil != err {
+		os.Remove(f.Name())
+		return "", errors.Wrapf(err, "could not write %s", f.Name())
+	}
+	return f.Name(), nil
+}
+
+// createSymlink creates the symbolic link the remote file keeps in place of the local file.
+// Like a downloaded file, the link is created next to it first
+func (d *Drive) createSymlink(file contracts.File, fullPath string) error {
+	if !d.isSymlinkInside(file.Symlink, fullPath) {
+		return errors.Errorf("symbolic link %s points outside the drive path: %s", fullPath, file.Symlink)
+	}
+	partialPath := lfile.GetPartialPath(fullPath)
+	if err := os.Remove(partialPath); nil != err && !os.IsNotExist(err) {
+		return errors.Wrapf(err, "could not remove %s", partialPath)
+	}
+	if err := os.Symlink(file.Symlink, partialPath); nil != err {
+		return errors.Wrapf(err, "could not create symbolic link %s", partialPath)
+	}
+	if err := os.Rename(partialPath, fullPath); nil != err {
+		os.Remove(partialPath)
+		return errors.Wrapf(err, "could not rename %s to %s", partialPath, fullPath)
+	}
+	return nil
+}
+
+// isSymlinkInside says if the link with the target at the full path points inside the drive path.
+// The absolute targets are not allowed, as the other computers have other drive paths
+func (d *Drive) isSymlinkInside(target string, fullPath string) bool {
+	if filepath.IsAbs(target) {
+		return false
+	}
+	drivePath := filepath.Clean(d.cfg.DrivePath)
+	resolved := filepath.Join(filepath.Dir(fullPath), target)
+	return resolved == drivePath || strings.HasPrefix(resolved, drivePath+string(filepath.Separator))
+}
+
+// IsSymlinkSkipped says if the remote file is not synchronized, because it keeps a symbolic
+// link, but the links are not stored. With the other policies the local links are not
+// the same as the remote ones, so the links are left to the computers, that store them.
+// The links pointing outside the drive path are skipped as well, otherwise the files
+// downloaded into a link to a folder would be written outside it
+func (d *Drive) IsSymlinkSkipped(file contracts.File) bool {
+	if !specification.IsSymlink(file) {
+		return false
+	}
+	if config.SymlinksStore != d.cfg.Symlinks {
+		return true
+	}
+	if !d.isSymlinkInside(file.Symlink, lfile.GetCurFullPath(d.cfg, file)) {
+		d.log.With(contracts.Fields{
+			contracts.FieldFileId: file.Id,
+			contracts.FieldPath:   file.CurPath,
+			"target":              file.Symlink,
+		}).Warning("skipping symbolic link pointing outside the drive path")
+		return true
+	}
+	return false
+}
@@ -0,0 +1,89 @@
+// Package throttle limits the rate of the transfers with a token bucket. A limiter
+// is shared by all the concurrent transfers in one direction, so the limit is
+// for all of them together, not for each one.
+package throttle
+
+import (
+	"io"
+	"math"
+	"sync"
+	"time"
+)
+
+// chunkSize is the most a read takes at once, so that the waits are short and smooth
+const chunkSize = 32 * 1024
+
+// Limiter is a token bucket with the rate in bytes per second. The bucket holds
+// one second worth of bytes, so an idle transfer cannot burst more than that
+type Limiter struct {
+	mu sync.Mutex
+	// rate returns the rate for the time, as it may change during the day. Zero means no limit
+	rate   func(t time.Time) int64
+	tokens float64
+	last   time.Time
+}
+
+func New(rate func(t time.Time) int64) *Limiter {
+	return &Limiter{rate: rate}
+}
+
+// Wait blocks until n bytes can be transferred. The bytes are taken from the bucket
+// right away, even if it goes below zero, so the transfers waiting at the same time
+// take turns instead of getting the same tokens
+func (l *Limiter) Wait(n int) {
+	l.mu.Lock()
+	now := time.Now()
+	rate := float64(l.rate(now))
+	if rate <= 0 {
+		l.tokens, l.last = 0, now
+		l.mu.Unlock()
+		return
+	}
+	l.tokens = math.Min(rate, l.tokens+now.Sub(l.last).Seconds()*rate)
+	l.last = now
+	l.tokens -= float64(n)
+	var wait time.Duration
+	if l.tokens < 0 {
+		wait = time.Duration(-l.tokens / rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+	time.Sleep(wait)
+}
+
+type reader struct {
+	r io.Reader
+	l *Limiter
+}
+
+func (r reader) Read(p []byte) (int, error) {
+	if len(p) > chunkSize {
+		p = p[:chunkSize]
+	}
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.l.Wait(n)
+	}
+	return n, err
+}
+
+type readCloser struct {
+	reader
+	io.Closer
+}
+
+// NewReader returns the reader, which reads r not faster than the limiter allows.
+// Without a limiter r itself is returned
+func NewReader(r io.Reader, l *Limiter) io.Reader {
+	if nil == l {
+		return r
+	}
+	return reader{r, l}
+}
+
+// NewReadCloser is NewReader for io.ReadCloser
+func NewReadCloser(rc io.ReadCloser, l *Limiter) io.ReadCloser {
+	if nil == l {
+		return rc
+	}
+	return readCloser{reader{rc, l}, rc}
+}
@@ -0,0 +1,40 @@
+package throttle
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLimiterIsShared(t *testing.T) {
+	const rate = 200 * 1024
+	l := New(func(time.Time) int64 { return rate })
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			io.Copy(ioutil.Discard, NewReader(bytes.NewReader(make([]byte, rate)), l))
+		}()
+	}
+	wg.Wait()
+	// a second worth of bytes is in the bucket at the start, the second one has to wait
+	if elapsed := time.Since(start); elapsed < 900*time.Millisecond || elapsed > 3*time.Second {
+		t.Errorf("two seconds worth of bytes took %s", elapsed)
+	}
+}
+
+func TestNoLimit(t *testing.T) {
+	l := New(func(time.Time) int64 { return 0 })
+	start := time.Now()
+	if n, err := io.Copy(ioutil.Discard, NewReader(bytes.NewReader(make([]byte, 10<<20)), l)); nil != err || 10<<20 != n {
+		t.Fatal(n, err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("the transfer without a limit took %s", elapsed)
+	}
+}
@@ -0,0 +1,154 @@
+package rdrive
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/svetlyi/gdriveapp/rdrive/specification"
+	"google.golang.org/api/drive/v3"
+	"io"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+)
+
+// trashedFile is a file moved to the trash with its path before that
+type trashedFile struct {
+	file *drive.File
+	// path is the path relative to the drive path. It is just the name of the
+	// file, if its folder is not synchronized
+	path string
+}
+
+// PrintTrash prints the ids, the trashing times and the paths of the files in the trash.
+// The files inside a trashed folder are not printed, as they are restored with the folder
+func (d *Drive) PrintTrash(w io.Writer) error {
+	trashed, err := d.listTrashed()
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, t := range trashed {
+		if _, err = fmt.Fprintf(tw, "%s\t%s\t%s\n", t.file.Id, t.file.TrashedTime, t.path); err != nil {
+			return err
+		}
+	}
+	return tw.Flush()
+}
+
+// RestoreFromTrash restores the file with the id or the path, as PrintTrash prints it,
+// and saves it to the database, so it is downloaded on the next synchronization. The trashed
+// folders the file is in are restored too
+func (d *Drive) RestoreFromTrash(pathOrId string) error {
+	trashed, err := d.listTrashed()
+	if err != nil {
+		return err
+	}
+	var found []*drive.File
+	for _, t := range trashed {
+		if pathOrId == t.file.Id || filepath.Clean(pathOrId) == t.path {
+			found = append(found, t.file)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return errors.Errorf("%s not found in the trash", pathOrId)
+	case 1:
+		return d.restore(found[0])
+	default:
+		var ids []string
+		for _, f := range found {
+			ids = append(ids, f.Id)
+		}
+		return errors.Errorf("there are several files %s in the trash, restore one of them by id: %s", pathOrId, strings.Join(ids, ", "))
+	}
+}
+
+// EmptyTrash deletes the files in the trash for good
+func (d *Drive) EmptyTrash() error {
+	return d.backend.EmptyTrash()
+}
+
+func (d *Drive) listTrashed() ([]trashedFile, error) {
+	var trashed []trashedFile
+	err := d.backend.ListTrashed(func(gfile *drive.File) error {
+		if !gfile.ExplicitlyTrashed {
+			return nil
+		}
+		t := trashedFile{file: gfile, path: gfile.Name}
+		for _, parentId := range d.getLocalParents(gfile) {
+			parentPath, err := d.getFolderPath(parentId)
+			if nil == err {
+				t.path = filepath.Join(parentPath, gfile.Name)
+				break
+			} else if sql.ErrNoRows != errors.Cause(err) {
+				return err
+			}
+		}
+		trashed = append(trashed, t)
+		return nil
+	})
+	return trashed, errors.Wrap(err, "could not list trash")
+}
+
+// getFolderPath returns the path of the synchronized folder relative to the drive path
+func (d *Drive) getFolderPath(folderId string) (string, error) {
+	folder, err := d.fileRepository.GetFileById(folderId)
+	if err != nil {
+		return "", err
+	}
+	if 1 == folder.RootFolder {
+		return folder.CurRemoteName, nil
+	}
+	parentPath, _, err := d.fileRepository.GetFileParentFolderPath(folderId)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(parentPath, folder.CurRemoteName), nil
+}
+
+// restore restores the file and the trashed folders it is in
+func (d *Drive) restore(gfile *drive.File) error {
+	for _, parentId := range gfile.Parents {
+		_, err := d.fileRepository.GetFileById(parentId)
+		if sql.ErrNoRows != errors.Cause(err) {
+			if err != nil {
+				return errors.Wrapf(err, "could not get folder %s", parentId)
+			}
+			continue
+		}
+		parent, err := d.backend.Get(parentId)
+		if err != nil {
+			return err
+		}
+		if parent.Trashed {
+			if err = d.restore(parent); err != nil {
+				return err
+			}
+		}
+	}
+	if !gfile.ExplicitlyTrashed { // it was in the trash with its folder, that has been restored
+		return nil
+	}
+	d.log.Info("restoring from trash", struct{ id, name string }{gfile.Id, gfile.Name})
+	restored, err := d.backend.Restore(gfile.Id)
+	if err != nil {
+		return err
+	}
+	return d.saveRestored(restored)
+}
+
+// saveRestored saves the restored file and everything inside it to the database
+// as new files, so they are downloaded on the next synchronization
+func (d *Drive) saveRestored(gfile *drive.File) error {
+	if err := d.fileRepository.Delete(gfile.Id); err != nil {
+		return err
+	}
+	if err := d.saveRemoteFile(gfile); err != nil {
+		return errors.Wrapf(err, "could not save restored file %s", gfile.Id)
+	}
+	if specification.GetFolderMime() != gfile.MimeType {
+		return nil
+	}
+	return d.backend.ListChildren(gfile.Id, d.saveRestored)
+}
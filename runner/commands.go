@@ -0,0 +1,180 @@
+package runner
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/svetlyi/gdriveapp/config"
+	"github.com/svetlyi/gdriveapp/ldrive/recycle"
+	"github.com/svetlyi/gdriveapp/rdrive"
+	"github.com/svetlyi/gdriveapp/rdrive/auth"
+	"github.com/svetlyi/gdriveapp/rdrive/db"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// PrintAccount prints the Google account the application has access to
+func (r *Runner) PrintAccount(w io.Writer) error {
+	if err := r.Authorize(false); nil != err {
+		return err
+	}
+	if nil == r.srv {
+		_, err := fmt.Fprintf(w, "no authorization is needed for the %s backend\n", r.cfg.Backend)
+		return err
+	}
+	about, err := r.srv.About.Get().Fields("user").Do()
+	if nil != err {
+		return AuthError{errors.Wrap(err, "could not get account info")}
+	}
+	_, err = fmt.Fprintf(w, "authorized as %s <%s>\n", about.User.DisplayName, about.User.EmailAddress)
+	return err
+}
+
+// PrintAuthStatus prints the account, the scopes of the token and when the access token expires
+func (r *Runner) PrintAuthStatus(w io.Writer) error {
+	if config.BackendGoogle != r.cfg.Backend {
+		_, err := fmt.Fprintf(w, "no authorization is needed for the %s backend\n", r.cfg.Backend)
+		return err
+	}
+	store, err := r.getTokenStore()
+	if nil != err {
+		return err
+	}
+	if _, err = store.Read(); os.IsNotExist(err) {
+		_, err = fmt.Fprintln(w, "not authorized, run auth")
+		return err
+	} else if nil != err {
+		return AuthError{errors.Wrap(err, "could not read token")}
+	}
+	if err = r.PrintAccount(w); nil != err {
+		return err
+	}
+	tok, err := r.tokenSource.Token()
+	if nil != err {
+		return AuthError{errors.Wrap(err, "could not get token")}
+	}
+	scopes, err := auth.GetScopes(tok)
+	if nil != err {
+		return err
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "scopes:\t%s\n", strings.Join(scopes, " "))
+	expiry := "never"
+	if !tok.Expiry.IsZero() {
+		expiry = fmt.Sprintf("%s (refreshed automatically)", tok.Expiry.Local().Format(time.RFC3339))
+	}
+	fmt.Fprintf(tw, "access token expires:\t%s\n", expiry)
+	fmt.Fprintf(tw, "refresh token:\t%t\n", "" != tok.RefreshToken)
+	return tw.Flush()
+}
+
+// Logout revokes the token and removes it. The token is removed, even if it could not
+// be read or revoked, for example, because the passphrase is forgotten or the token
+// has been revoked in the account settings already
+func (r *Runner) Logout(w io.Writer) error {
+	store, err := r.getTokenStore()
+	if nil != err {
+		return err
+	}
+	tok, err := store.Read()
+	switch {
+	case os.IsNotExist(err):
+		_, err = fmt.Fprintln(w, "not authorized")
+		return err
+	case nil != err:
+		fmt.Fprintf(w, "could not read token: %v, it is just removed\n", err)
+	default:
+		if err = auth.Revoke(tok); nil != err {
+			fmt.Fprintf(w, "%v, the token is just removed\n", err)
+		} else {
+			fmt.Fprintln(w, "token revoked")
+		}
+	}
+	if err = store.Remove(); nil != err {
+		return err
+	}
+	_, err = fmt.Fprintln(w, "token removed")
+	return err
+}
+
+// Rekey encrypts the token with a new passphrase
+func (r *Runner) Rekey() error {
+	if config.TokenEncryptionPassphrase != r.cfg.TokenEncryption {
+		return errors.Errorf("token_encryption in config must be %q to change the passphrase", config.TokenEncryptionPassphrase)
+	}
+	store, err := r.getTokenStore()
+	if nil != err {
+		return err
+	}
+	// the current passphrase is asked for first
+	if _, err = store.Read(); nil != err {
+		return AuthError{errors.Wrap(err, "could not read token")}
+	}
+	if nil == r.prompt {
+		return errors.New("the new passphrase cannot be asked for")
+	}
+	passphrase, err := r.prompt("new token passphrase: ")
+	if nil != err {
+		return err
+	}
+	repeated, err := r.prompt("repeat new token passphrase: ")
+	if nil != err {
+		return err
+	}
+	if string(passphrase) != string(repeated) {
+		return errors.New("the passphrases do not match")
+	}
+	return store.Rekey(passphrase)
+}
+
+// PrintSharedDrives prints the ids and the names of the shared drives the user has access to
+func (r *Runner) PrintSharedDrives(w io.Writer) error {
+	if err := r.Authorize(false); nil != err {
+		return err
+	}
+	if nil == r.srv {
+		return errors.New("shared drives are listed just for Google Drive")
+	}
+	return rdrive.PrintSharedDrives(r.srv.Drives, w)
+}
+
+// PrintTrash prints the files in the remote trash
+func (r *Runner) PrintTrash(w io.Writer) error {
+	if err := r.open(false); nil != err {
+		return err
+	}
+	return r.rd.PrintTrash(w)
+}
+
+// RestoreFromTrash restores the file with the path or the id from the remote trash
+func (r *Runner) RestoreFromTrash(pathOrId string) error {
+	if err := r.open(false); nil != err {
+		return err
+	}
+	return r.rd.RestoreFromTrash(pathOrId)
+}
+
+// EmptyTrash deletes the files in the remote trash for good
+func (r *Runner) EmptyTrash() error {
+	if err := r.open(false); nil != err {
+		return err
+	}
+	return r.rd.EmptyTrash()
+}
+
+// RestoreFromRecycleBin puts the file with the path relative to the drive path
+// back from the local recycle bin
+func (r *Runner) RestoreFromRecycleBin(relativePath string) error {
+	return recycle.New(r.cfg).Restore(relativePath)
+}
+
+// ResetDb removes the database, so that the next synchronization fills it from scratch.
+// The local and the remote files are not changed
+func (r *Runner) ResetDb() error {
+	if err := r.Close(); nil != err {
+		return err
+	}
+	return db.Remove(r.cfg.DBPath)
+}
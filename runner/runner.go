@@ -0,0 +1,221 @@
+// Package runner performs the commands of the application: it connects to the remote
+// drive, opens the database and synchronizes the files. The command line just parses
+// the arguments and calls the runner.
+package runner
+
+import (
+	"database/sql"
+	"github.com/pkg/errors"
+	"github.com/svetlyi/gdriveapp/app"
+	"github.com/svetlyi/gdriveapp/config"
+	"github.com/svetlyi/gdriveapp/contracts"
+	"github.com/svetlyi/gdriveapp/rdrive"
+	"github.com/svetlyi/gdriveapp/rdrive/auth"
+	"github.com/svetlyi/gdriveapp/rdrive/crypt"
+	"github.com/svetlyi/gdriveapp/rdrive/db"
+	"github.com/svetlyi/gdriveapp/rdrive/db/file"
+	"github.com/svetlyi/gdriveapp/rdrive/db/transfer"
+	"github.com/svetlyi/gdriveapp/rdrive/localdir"
+	"github.com/svetlyi/gdriveapp/rdrive/retry"
+	"github.com/svetlyi/gdriveapp/rdrive/throttle"
+	"golang.org/x/net/context"
+	"golang.org/x/oauth2"
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/option"
+	"net/http"
+	"os"
+	"time"
+)
+
+// AuthError is returned, when the application could not get access to Google Drive
+type AuthError struct {
+	err error
+}
+
+func (e AuthError) Error() string {
+	return e.err.Error()
+}
+
+type Runner struct {
+	cfg         config.Cfg
+	log         contracts.Logger
+	srv         *drive.Service
+	httpClient  *http.Client
+	tokenSource oauth2.TokenSource
+	// dbPath is the path of the opened database. In the dry-run mode it is a temporary copy
+	dbPath     string
+	dbInstance *sql.DB
+	repository file.Repository
+	// transfers are the resumable upload sessions
+	transfers transfer.Repository
+	rd        rdrive.Drive
+	// authFlow is how the user authorizes the application, if there is no token
+	authFlow   auth.Flow
+	tokenStore *auth.TokenStore
+	// prompt asks the user for a secret, for example, the passphrase of the token
+	prompt func(prompt string) ([]byte, error)
+}
+
+// PassphraseEnv is the environment variable with the passphrase of the token
+const PassphraseEnv = "GDRIVEAPP_TOKEN_PASSPHRASE"
+
+func New(cfg config.Cfg, log contracts.Logger) *Runner {
+	return &Runner{cfg: cfg, log: log}
+}
+
+// Close closes the database. The copy of the database made in the dry-run mode is removed
+func (r *Runner) Close() error {
+	if nil == r.dbInstance {
+		return nil
+	}
+	err := errors.Wrap(r.dbInstance.Close(), "could not close database")
+	if r.dbPath != r.cfg.DBPath {
+		if removeErr := db.Remove(r.dbPath); nil == err {
+			err = removeErr
+		}
+	}
+	r.dbInstance = nil
+	return err
+}
+
+// SetAuthFlow sets how the user authorizes the application
+func (r *Runner) SetAuthFlow(flow auth.Flow) {
+	r.authFlow = flow
+}
+
+// SetService sets the Google Drive service and its HTTP client, so that the runner uses them
+// instead of authorizing, for example, the service of a fake drive
+func (r *Runner) SetService(srv *drive.Service, httpClient *http.Client) {
+	r.srv = srv
+	r.httpClient = httpClient
+}
+
+// SetPrompt sets how the user is asked for a secret, for example, the passphrase of the token
+func (r *Runner) SetPrompt(prompt func(prompt string) ([]byte, error)) {
+	r.prompt = prompt
+}
+
+// getTokenStore returns the store of the token, which encrypts it, if the config says so
+func (r *Runner) getTokenStore() (*auth.TokenStore, error) {
+	if nil != r.tokenStore {
+		return r.tokenStore, nil
+	}
+	cfgDir, err := config.GetDir()
+	if nil != err {
+		return nil, errors.Wrap(err, "could not get config dir")
+	}
+	switch r.cfg.TokenEncryption {
+	case config.TokenEncryptionPassphrase:
+		r.tokenStore = auth.NewPassphraseTokenStore(cfgDir, r.passphrase)
+	case config.TokenEncryptionKeyFile:
+		r.tokenStore = auth.NewKeyFileTokenStore(cfgDir, r.cfg.TokenKeyFile)
+	default:
+		r.tokenStore = auth.NewTokenStore(cfgDir)
+	}
+	return r.tokenStore, nil
+}
+
+// passphrase returns the passphrase of the token from the environment or asks the user for it
+func (r *Runner) passphrase() ([]byte, error) {
+	if passphrase := os.Getenv(PassphraseEnv); "" != passphrase {
+		return []byte(passphrase), nil
+	}
+	if nil == r.prompt {
+		return nil, errors.Errorf("the token is encrypted, set %s", PassphraseEnv)
+	}
+	return r.prompt("token passphrase: ")
+}
+
+// Authorize gets access to Google Drive. The authorization in the browser is asked for,
+// if there is no token yet or if force is set. Nothing is needed for the local directory backend
+func (r *Runner) Authorize(force bool) error {
+	if config.BackendGoogle != r.cfg.Backend || (nil != r.srv && !force) {
+		return nil
+	}
+	store, err := r.getTokenStore()
+	if nil != err {
+		return err
+	}
+	if force {
+		if err = store.Remove(); nil != err {
+			return err
+		}
+	}
+	credsPath, err := config.GetCredentialsPath()
+	if nil != err {
+		return errors.Wrap(err, "could not get credentials path")
+	}
+	r.tokenSource, err = auth.GetTokenSource(store, credsPath, r.authFlow, r.log)
+	if nil != err {
+		return AuthError{errors.Wrap(err, "could not get token source")}
+	}
+	r.httpClient = oauth2.NewClient(context.Background(), r.tokenSource)
+	r.srv, err = drive.NewService(context.Background(), option.WithHTTPClient(r.httpClient))
+	return errors.Wrap(err, "unable to retrieve Drive client")
+}
+
+// openDb opens the database or, in the dry-run mode, a copy of it, as the metadata
+// is changed even when nothing else is
+func (r *Runner) openDb(dryRun bool) error {
+	if nil != r.dbInstance {
+		return nil
+	}
+	r.dbPath = r.cfg.DBPath
+	if dryRun {
+		var err error
+		if r.dbPath, err = db.Copy(r.cfg.DBPath); nil != err {
+			return errors.Wrap(err, "could not copy database")
+		}
+	}
+	r.dbInstance = db.New(r.dbPath, r.log)
+	r.repository = file.NewRepository(r.dbInstance, r.log)
+	r.transfers = transfer.NewRepository(r.dbInstance, r.log)
+	return nil
+}
+
+// open gets everything needed to work with the remote drive
+func (r *Runner) open(dryRun bool) error {
+	if err := r.Authorize(false); nil != err {
+		return err
+	}
+	if err := r.openDb(dryRun); nil != err {
+		return err
+	}
+	var backend rdrive.RemoteBackend
+	if nil != r.srv {
+		backend = rdrive.NewGoogleBackend(
+			*r.srv.Files,
+			*r.srv.Changes,
+			r.log,
+			r.cfg.PageSizeToQuery,
+			r.httpClient,
+			r.srv.BasePath,
+			r.transfers,
+			retry.New(int(r.cfg.RetryMaxAttempts), r.log),
+			throttle.New(func(t time.Time) int64 {
+				upload, _ := r.cfg.GetBandwidthLimits(t)
+				return upload
+			}),
+		)
+	} else {
+		var err error
+		if backend, err = localdir.New(r.cfg.BackendDir); nil != err {
+			return errors.Wrap(err, "could not open backend dir")
+		}
+	}
+	return r.newDrive(backend)
+}
+
+// newDrive creates the drive with the backend and, if there are encrypted folders,
+// with the key from the encryption key file
+func (r *Runner) newDrive(backend rdrive.RemoteBackend) error {
+	var cipher *crypt.Cipher
+	if len(r.cfg.EncryptedFolders) > 0 {
+		var err error
+		if cipher, err = crypt.ReadKeyFile(r.cfg.EncryptionKeyFile); nil != err {
+			return err
+		}
+	}
+	r.rd = rdrive.New(backend, r.repository, r.log, app.New(r.dbInstance, r.log), r.cfg, cipher)
+	return nil
+}
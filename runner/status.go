@@ -0,0 +1,90 @@
+package runner
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/svetlyi/gdriveapp/config"
+	"github.com/svetlyi/gdriveapp/rdrive"
+	"github.com/svetlyi/gdriveapp/synchronization"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+// PrintStatus prints the profile, where the files are synchronized, when they were synchronized
+// last time and the changes the next synchronization would apply. The changes are found in a copy
+// of the database the same way the synchronization finds them. Without fetch the remote drive is
+// not asked for anything, so just the remote changes received before are shown (for example,
+// the ones left by pull or push)
+func (r *Runner) PrintStatus(w io.Writer, fetch bool) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "profile:\t%s\n", config.GetProfile())
+	fmt.Fprintf(tw, "drive path:\t%s\n", r.cfg.DrivePath)
+	if config.BackendLocalDir == r.cfg.Backend {
+		fmt.Fprintf(tw, "backend:\t%s %s\n", r.cfg.Backend, r.cfg.BackendDir)
+	} else {
+		fmt.Fprintf(tw, "backend:\t%s\n", r.cfg.Backend)
+	}
+	fmt.Fprintf(tw, "database:\t%s\n", r.cfg.DBPath)
+	if _, err := os.Stat(r.cfg.DBPath); os.IsNotExist(err) {
+		fmt.Fprintln(tw, "last sync:\tnever")
+		return tw.Flush()
+	}
+
+	if fetch {
+		if err := r.open(true); nil != err {
+			return err
+		}
+	} else {
+		if err := r.openDb(true); nil != err {
+			return err
+		}
+		// the changes are just planned, so the backend is never called
+		if err := r.newDrive(nil); nil != err {
+			return err
+		}
+	}
+	lastSyncTime, err := r.rd.GetLastSyncTime()
+	if nil != err {
+		return err
+	}
+	fmt.Fprintf(tw, "last sync:\t%s\n", formatTime(lastSyncTime, "never"))
+	rootFolders, err := r.repository.GetRootFolders()
+	if nil != err {
+		return err
+	}
+	for _, rootFolder := range rootFolders {
+		if rdrive.SharedWithMeRootId == rootFolder.DriveId {
+			continue // its changes are received with the changes of "My Drive"
+		}
+		tokenTime, err := r.rd.GetChangeTokenTime(rootFolder.DriveId)
+		if nil != err {
+			return err
+		}
+		fmt.Fprintf(tw, "changes of %s:\treceived %s\n", rootFolder.CurRemoteName, formatTime(tokenTime, "at unknown time"))
+	}
+	if err = tw.Flush(); nil != err {
+		return err
+	}
+
+	if fetch {
+		if err = r.rd.SyncMetadata(); nil != err {
+			return errors.Wrap(err, "could not get remote changes")
+		}
+	}
+	var plan synchronization.Plan
+	if _, err = r.applyChanges(SyncOptions{Plan: &plan}); nil != err {
+		return err
+	}
+	fmt.Fprintln(w)
+	return synchronization.NewStatus(plan).Print(w)
+}
+
+// formatTime formats the time along with how long ago it was
+func formatTime(t time.Time, zeroText string) string {
+	if t.IsZero() {
+		return zeroText
+	}
+	return fmt.Sprintf("%s (%s ago)", t.Local().Format(time.RFC3339), time.Since(t).Round(time.Second))
+}
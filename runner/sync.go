@@ -0,0 +1,104 @@
+package runner
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"github.com/svetlyi/gdriveapp/config"
+	"github.com/svetlyi/gdriveapp/contracts"
+	"github.com/svetlyi/gdriveapp/daemon"
+	"github.com/svetlyi/gdriveapp/ldrive/ignore"
+	"github.com/svetlyi/gdriveapp/rdrive"
+	"github.com/svetlyi/gdriveapp/synchronization"
+	"path/filepath"
+	"time"
+)
+
+// SyncOptions are the options of a synchronization
+type SyncOptions struct {
+	// Side is the side the files are changed on: the local one to pull the remote changes,
+	// the remote one to push the local changes. Empty means both sides
+	Side contracts.ActionSide
+	// Plan is not nil in the dry-run mode. The actions are added to it instead of being performed
+	Plan *synchronization.Plan
+}
+
+// Sync synchronizes the local drive with the remote one
+func (r *Runner) Sync(opts SyncOptions) error {
+	_, err := r.sync(opts)
+	return err
+}
+
+// Daemon synchronizes the drives in both directions and then keeps them
+// synchronized until the context is canceled
+func (r *Runner) Daemon(ctx context.Context) error {
+	synchronizer, err := r.sync(SyncOptions{})
+	if nil != err {
+		return err
+	}
+	d := daemon.New(r.cfg, r.log, &r.rd, r.repository, &synchronizer)
+	return errors.Wrap(d.Run(ctx), "daemon error")
+}
+
+func (r *Runner) sync(opts SyncOptions) (synchronization.Synchronizer, error) {
+	var synchronizer synchronization.Synchronizer
+	if err := r.open(nil != opts.Plan); nil != err {
+		return synchronizer, err
+	}
+	if nil != r.srv {
+		rdrive.PrintUsageStats(r.srv.About, r.log)
+	}
+	// first sync changes in the remote drive
+	if err := r.rd.SyncMetadata(); nil != err {
+		return synchronizer, errors.Wrap(err, "synchronization error")
+	}
+	r.log.Info("metadata syncing has finished")
+
+	synchronizer, err := r.applyChanges(opts)
+	if nil != err || nil != opts.Plan {
+		return synchronizer, err
+	}
+	r.log.Info("successfully synchronized")
+
+	// the files skipped by the synchronization to one side are still needed
+	// to synchronize them later in both directions
+	if "" == opts.Side {
+		if err = r.repository.CleanUpDatabase(); nil != err {
+			return synchronizer, errors.Wrap(err, "error cleaning up database")
+		}
+		r.log.Debug("cleaned database from old files")
+	}
+	if err = r.rd.SetLastSyncTime(time.Now()); nil != err {
+		return synchronizer, err
+	}
+	return synchronizer, r.rd.CleanUpRecycleBin()
+}
+
+// applyChanges synchronizes the local files with the remote changes saved in the database
+// and then the remote files with the local changes
+func (r *Runner) applyChanges(opts SyncOptions) (synchronization.Synchronizer, error) {
+	var synchronizer synchronization.Synchronizer
+	cfgDir, err := config.GetDir()
+	if nil != err {
+		return synchronizer, errors.Wrap(err, "could not get config dir")
+	}
+	ignoreRules, err := ignore.New(r.cfg.DrivePath, filepath.Join(cfgDir, ignore.FileName))
+	if nil != err {
+		return synchronizer, errors.Wrap(err, "could not read ignore rules")
+	}
+	synchronizer = synchronization.New(r.repository, r.log, r.dbInstance, r.rd, ignoreRules, int(r.cfg.TransferWorkers), r.cfg.Symlinks)
+	synchronizer.SetUploadSessions(r.transfers)
+	synchronizer.SetPlan(opts.Plan)
+	synchronizer.SetSide(opts.Side)
+	if err = synchronizer.SyncRemoteWithLocal(); nil != err {
+		return synchronizer, errors.Wrap(err, "SyncRemoteWithLocal error")
+	}
+	if contracts.SIDE_LOCAL != opts.Side {
+		if err = synchronizer.SyncLocalWithRemote(r.cfg.DrivePath); nil != err {
+			return synchronizer, errors.Wrap(err, "SyncLocalWithRemote error")
+		}
+		if err = synchronizer.RemoveLocallyRemoved(); nil != err {
+			return synchronizer, errors.Wrap(err, "RemoveLocallyRemoved error")
+		}
+	}
+	return synchronizer, nil
+}
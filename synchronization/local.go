@@ -4,6 +4,7 @@ import (
 	"database/sql"
 	"github.com/pkg/errors"
 	"github.com/svetlyi/gdriveapp/contracts"
+	"github.com/svetlyi/gdriveapp/ldrive/walk"
 	"github.com/svetlyi/gdriveapp/structures"
 	"os"
 	"path/filepath"
@@ -12,67 +13,137 @@ import (
 
 // SyncLocalWithRemote synchronize local files and their changes
 // with remote version. It uploads new files, creates new folders remotely
-func (s *Synchronizer) SyncLocalWithRemote(drivePath string, rootFolder contracts.File) error {
+// in "My Drive" and in the shared drives
+func (s *Synchronizer) SyncLocalWithRemote(drivePath string) error {
+	if nil != s.ignoreRules {
+		s.ignoreRules.Reload()
+	}
+	rootFolders, err := s.fr.GetRootFolders()
+	if nil != err {
+		return errors.Wrap(err, "could not get root folders")
+	}
+	return s.withTransfers(func() error {
+		for _, rootFolder := range rootFolders {
+			if err := s.uploadLocalChanges(drivePath, rootFolder); nil != err {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// relativePath returns the path relative to the drive path (like "My Drive/docs"), whether
+// the drive path ends with the separator or not
+func relativePath(drivePath string, path string) (string, error) {
+	relative, err := filepath.Rel(drivePath, path)
+	if nil != err {
+		return "", errors.Wrapf(err, "could not get path of %s relative to %s", path, drivePath)
+	}
+	return relative, nil
+}
+
+// uploadLocalChanges walks through the local files and uploads the new ones
+func (s *Synchronizer) uploadLocalChanges(drivePath string, rootFolder contracts.File) error {
+	rootFolderPath := filepath.Join(drivePath, rootFolder.CurRemoteName)
+	if _, err := os.Stat(rootFolderPath); os.IsNotExist(err) {
+		return nil // it is created when the remote files are synchronized, in the dry-run mode it may not exist
+	}
 	locallyRemovedFoldersIds, err := s.fr.GetLocallyRemovedFoldersIds()
 	if nil != err {
-		s.log.Error(err)
-		os.Exit(1)
+		return errors.Wrap(err, "could not get locally removed folders")
 	}
 	var parentsStack structures.StringStack
 	parentsStack.Push(rootFolder.Id)
-	var curDepth int
 	var parentId string
 
-	return filepath.Walk(
-		filepath.Join(drivePath, rootFolder.CurRemoteName),
+	return walk.Walk(
+		rootFolderPath,
+		drivePath,
+		s.symlinks,
+		s.log,
 		func(path string, info os.FileInfo, err error) error {
 			if nil != err {
 				return errors.Wrapf(err, "cold not walk in path %s", path)
 			}
 			s.log.Debug("next local path", path)
-			curRelativeFilePath := path[len(drivePath):]
-			fileId, fileIdErr := s.fr.GetFileIdByCurPath(curRelativeFilePath, rootFolder)
-
-			curDepth = len(strings.Split(curRelativeFilePath, string(os.PathSeparator)))
-
-			if !info.IsDir() {
-				curDepth-- // minus file itself if the current element is a file
-				if parentsStack.Len() > curDepth {
-					if err := parentsStack.PopTimes(parentsStack.Len() - curDepth); nil != err {
-						return err
-					}
+			curRelativeFilePath, err := relativePath(drivePath, path)
+			if nil != err {
+				return err
+			}
+			// the stack keeps the ids of the folders from the root to the parent of the current element
+			ancestorsCount := len(strings.Split(curRelativeFilePath, string(os.PathSeparator))) - 1
+			if 0 == ancestorsCount { // the root folder itself
+				return nil
+			}
+			if !s.inScope(curRelativeFilePath, info.IsDir()) {
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if ignored, err := s.IsIgnored(curRelativeFilePath, info.IsDir()); nil != err {
+				return err
+			} else if ignored {
+				s.log.Debug("ignoring local file", curRelativeFilePath)
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if parentsStack.Len() > ancestorsCount {
+				if err := parentsStack.PopTimes(parentsStack.Len() - ancestorsCount); nil != err {
+					return err
 				}
 			}
 			if parentId, err = parentsStack.Front(); nil != err {
 				return err
 			}
-			s.log.Debug("depth info", struct {
-				currentDepth        int
-				currentRelativePath string
-				parentsStackLength  int
-				parentId            string
-				path                string
-			}{
-				curDepth,
-				curRelativeFilePath,
-				parentsStack.Len(),
-				parentId,
-				path,
-			})
+			s.log.With(contracts.Fields{
+				contracts.FieldPath: curRelativeFilePath,
+				"ancestors_count":   ancestorsCount,
+				"parents_stack_len": parentsStack.Len(),
+				"parent_id":         parentId,
+			}).Debug("depth info")
+			fileId, fileIdErr := s.fr.GetFileIdByCurPath(curRelativeFilePath, rootFolder)
+			if sql.ErrNoRows == errors.Cause(fileIdErr) && !info.IsDir() {
+				fileId, fileIdErr = s.getExportedFileId(curRelativeFilePath, rootFolder)
+			}
+			if sql.ErrNoRows == errors.Cause(fileIdErr) && contracts.SIDE_REMOTE == s.side {
+				// the file moved remotely stays in the old place, while the local files are not changed
+				if _, err := s.fr.GetFileIdByPrevPath(curRelativeFilePath, rootFolder); nil == err {
+					s.log.Debug("skipping local file moved remotely", curRelativeFilePath)
+					if info.IsDir() {
+						return filepath.SkipDir
+					}
+					return nil
+				} else if sql.ErrNoRows != errors.Cause(err) {
+					return errors.Wrapf(err, "could not GetFileIdByPrevPath for %s", curRelativeFilePath)
+				}
+			}
 			// it means the file or directory is new (created, moved or copied)
 			// here just new files are being synchronized. The rest have have already been synchronized previously
 			if sql.ErrNoRows == errors.Cause(fileIdErr) {
-				if info.IsDir() {
-					currentParentId, err := s.fr.GetFileParentIdByCurPath(curRelativeFilePath, rootFolder)
-					if nil != err {
-						return errors.Wrapf(err, "could not GetFileParentIdByCurPath for %s", curRelativeFilePath)
+				if readOnly, err := s.rd.IsFolderReadOnly(parentId); nil != err {
+					return err
+				} else if readOnly {
+					s.log.Warning("the folder is read-only, the new local file is not uploaded", curRelativeFilePath)
+					if info.IsDir() {
+						return filepath.SkipDir
 					}
+					return nil
+				}
+				action := contracts.Action{
+					Side:     contracts.SIDE_REMOTE,
+					Path:     curRelativeFilePath,
+					ParentId: parentId,
+				}
+				if info.IsDir() {
 					// if it is a dir, first guess, it was moved from somewhere else
 					// so, we are looking for the moved dir among the locally removed
 					var hasSameRemFolder = false
 					var locallyRemovedFolderId string
 					for _, locallyRemovedFolderId = range locallyRemovedFoldersIds {
-						if hasSameRemFolder, err = s.AreFoldersTheSame(path, locallyRemovedFolderId); nil != err {
+						if hasSameRemFolder, err = s.AreFoldersTheSame(drivePath, path, locallyRemovedFolderId); nil != err {
 							return errors.Wrapf(
 								err,
 								"error while checking if the folders %s(path) and %s(id) are the same",
@@ -80,49 +151,34 @@ func (s *Synchronizer) SyncLocalWithRemote(drivePath string, rootFolder contract
 								locallyRemovedFolderId,
 							)
 						}
+						if hasSameRemFolder {
+							break
+						}
 					}
 					if hasSameRemFolder {
-						oldParentId, err := s.fr.GetParentIdByChildId(locallyRemovedFolderId)
-						if nil != err {
-							return errors.Wrapf(err, "could not GetParentIdByChildId for file id %s", locallyRemovedFolderId)
-						}
-						s.log.Debug("local move detected", struct {
-							movedFolderId   string
-							currentParentId string
-							currentName     string
-						}{locallyRemovedFolderId, currentParentId, info.Name()})
-						// at this point it is known, that the folder with id locallyRemovedFolderId was
-						// moved from a folder with id oldParentId to a folder with id currentParentId and now
-						// the moved folder has name info.Name(). This is the information, that goes to the database
-						f, err := s.rd.Update(locallyRemovedFolderId, info.Name(), []string{currentParentId}, []string{oldParentId})
-						if nil != err {
-							return err
-						}
-						fileId = f.Id
-						err = s.fr.SetRemovedLocally(locallyRemovedFolderId, false)
-						if nil != err {
-							return err
-						}
-						err = s.fr.SetCurRemoteData(locallyRemovedFolderId, f.ModifiedTime, f.Name, f.Parents)
-						if nil != err {
-							return err
-						}
-						err = s.fr.SetPrevRemoteDataToCur(locallyRemovedFolderId)
-						if nil != err {
-							return err
-						}
-					} else {
-						s.log.Info("creating folder", struct {
-							path     string
-							parentId string
-						}{path, parentId})
-						if fileId, err = s.rd.CreateFolder(path, []string{parentId}); nil != err {
-							return errors.Wrapf(err, "could not create folder %s", path)
+						s.log.With(contracts.Fields{
+							contracts.FieldFileId: locallyRemovedFolderId,
+							contracts.FieldPath:   curRelativeFilePath,
+							"parent_id":           parentId,
+						}).Debug("local move detected")
+						action.Type = contracts.ACTION_MOVE
+						action.FileId = locallyRemovedFolderId
+						action.Reason = "local folder was moved"
+						if _, err = s.apply(action); nil != err {
+							return errors.Wrapf(err, "could not move folder %s", path)
 						}
+						// the content of the moved folder is the same, so there is nothing new inside
+						return filepath.SkipDir
+					}
+					action.Type = contracts.ACTION_MKDIR
+					action.Reason = "local folder was created"
+					if fileId, err = s.apply(action); nil != err {
+						return errors.Wrapf(err, "could not create folder %s", path)
 					}
 				} else {
-					s.log.Info("creating file", path, "in", parentId)
-					if err = s.rd.Upload(path, []string{parentId}); nil != err {
+					action.Type = contracts.ACTION_UPLOAD
+					action.Reason = "local file was created"
+					if _, err = s.apply(action); nil != err {
 						return errors.Wrapf(err, "could not upload file %s", path)
 					}
 				}
@@ -131,21 +187,31 @@ func (s *Synchronizer) SyncLocalWithRemote(drivePath string, rootFolder contract
 			}
 
 			if info.IsDir() {
-				if parentsStack.Len() < curDepth {
-					parentsStack.Push(fileId)
-				} else if parentsStack.Len() > curDepth {
-					if err := parentsStack.PopTimes(parentsStack.Len() - curDepth); nil != err {
-						return err
-					}
-				} else if parentId != fileId {
-					if err := parentsStack.Pop(); nil != err {
-						return err
-					}
-					parentsStack.Push(fileId)
-				}
+				parentsStack.Push(fileId)
 			}
 
 			return nil
 		},
 	)
 }
+
+// getExportedFileId gets the id of the native Google file, which exported copy has the path.
+// The exported copies have an extension (for example, "report.docx"), which the remote files do not have
+func (s *Synchronizer) getExportedFileId(relativePath string, rootFolder contracts.File) (string, error) {
+	ext := filepath.Ext(relativePath)
+	if "" == ext {
+		return "", sql.ErrNoRows
+	}
+	fileId, err := s.fr.GetFileIdByCurPath(strings.TrimSuffix(relativePath, ext), rootFolder)
+	if nil != err {
+		return "", err
+	}
+	f, err := s.fr.GetFileById(fileId)
+	if nil != err {
+		return "", errors.Wrapf(err, "could not get file by id %s", fileId)
+	}
+	if !s.rd.IsExported(f) || s.rd.GetLocalName(f) != filepath.Base(relativePath) {
+		return "", sql.ErrNoRows
+	}
+	return fileId, nil
+}
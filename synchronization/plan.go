@@ -0,0 +1,76 @@
+package synchronization
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/svetlyi/gdriveapp/contracts"
+	"io"
+	"text/tabwriter"
+)
+
+const (
+	PlanFormatText = "text"
+	PlanFormatJson = "json"
+)
+
+// Plan is an ordered list of actions a synchronization would perform
+type Plan struct {
+	Actions []contracts.Action
+}
+
+// Add adds an action to the plan. The actions that just update
+// the metadata are not a part of the plan
+func (p *Plan) Add(action contracts.Action) {
+	if !action.IsBookkeeping() {
+		p.Actions = append(p.Actions, action)
+	}
+}
+
+// Print prints the plan in the format (text or json)
+func (p *Plan) Print(w io.Writer, format string) error {
+	switch format {
+	case PlanFormatText:
+		return p.printText(w)
+	case PlanFormatJson:
+		return p.printJson(w)
+	default:
+		return errors.Errorf("unknown plan format %s", format)
+	}
+}
+
+func (p *Plan) printText(w io.Writer) error {
+	if len(p.Actions) == 0 {
+		_, err := fmt.Fprintln(w, "nothing to do")
+		return err
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for i, action := range p.Actions {
+		name := string(action.Type)
+		if contracts.ACTION_MKDIR == action.Type || contracts.ACTION_MOVE == action.Type {
+			name += " " + string(action.Side)
+		}
+		path := action.Path
+		if contracts.ACTION_MOVE == action.Type && action.PrevPath != "" && action.PrevPath != action.Path {
+			path = fmt.Sprintf("%s -> %s", action.PrevPath, action.Path)
+		}
+		details := action.Reason
+		if contracts.ACTION_CONFLICT == action.Type {
+			details = fmt.Sprintf("%s (%s)", action.Reason, action.Resolution)
+		}
+		if _, err := fmt.Fprintf(tw, "%d.\t%s\t%s\t%s\n", i+1, name, path, details); err != nil {
+			return err
+		}
+	}
+	return tw.Flush()
+}
+
+func (p *Plan) printJson(w io.Writer) error {
+	actions := p.Actions
+	if nil == actions {
+		actions = []contracts.Action{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(actions)
+}
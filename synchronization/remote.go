@@ -4,9 +4,11 @@ import (
 	"database/sql"
 	"github.com/pkg/errors"
 	"github.com/svetlyi/gdriveapp/contracts"
-	lfileHash "github.com/svetlyi/gdriveapp/ldrive/file/hash"
+	"github.com/svetlyi/gdriveapp/ldrive/ignore"
+	"github.com/svetlyi/gdriveapp/ldrive/walk"
 	"github.com/svetlyi/gdriveapp/rdrive"
 	"github.com/svetlyi/gdriveapp/rdrive/db/file"
+	"github.com/svetlyi/gdriveapp/rdrive/db/transfer"
 	"github.com/svetlyi/gdriveapp/rdrive/specification"
 	"os"
 	"path/filepath"
@@ -17,35 +19,168 @@ type Synchronizer struct {
 	log contracts.Logger
 	db  *sql.DB
 	rd  rdrive.Drive
+	// ignoreRules keep the ignored files out of synchronization in both directions
+	ignoreRules *ignore.Rules
+	// plan is not nil in the planning (dry-run) mode
+	plan *Plan
+	// transferWorkers is the amount of the downloads and uploads performed concurrently
+	transferWorkers int
+	// transfers is not nil while the transfers are performed by the workers
+	transfers *transferPool
+	// sessions are the resumable upload sessions the workers save
+	sessions transfer.Repository
+	// side is not empty, if just the files on that side are changed
+	side contracts.ActionSide
+	// symlinks is the policy of the local symbolic links
+	symlinks string
+	// scope are the paths the synchronization is limited to, it is empty for the whole drive
+	scope []string
+	// onLocalWrite is called with the paths of the local files before they are changed
+	onLocalWrite func(relativePath string)
 }
 
-func New(fr file.Repository, log contracts.Logger, db *sql.DB, rd rdrive.Drive) Synchronizer {
-	return Synchronizer{fr, log, db, rd}
+func New(
+	fr file.Repository,
+	log contracts.Logger,
+	db *sql.DB,
+	rd rdrive.Drive,
+	ignoreRules *ignore.Rules,
+	transferWorkers int,
+	symlinks string,
+) Synchronizer {
+	return Synchronizer{
+		fr:              fr,
+		log:             log,
+		db:              db,
+		rd:              rd,
+		ignoreRules:     ignoreRules,
+		transferWorkers: transferWorkers,
+		symlinks:        symlinks,
+	}
+}
+
+// SetUploadSessions sets the repository of the resumable upload sessions. While the transfers
+// are performed by the workers, the sessions are written by the goroutine submitting the transfers
+func (s *Synchronizer) SetUploadSessions(sessions transfer.Repository) {
+	s.sessions = sessions
+}
+
+// IsIgnored says if the file with the path relative to the drive path
+// is kept out of synchronization
+func (s *Synchronizer) IsIgnored(relativePath string, isDir bool) (bool, error) {
+	if nil == s.ignoreRules {
+		return false, nil
+	}
+	ignored, err := s.ignoreRules.IsIgnored(relativePath, isDir)
+	if nil != err {
+		return false, errors.Wrapf(err, "could not check if %s is ignored", relativePath)
+	}
+	return ignored, nil
+}
+
+// SetPlan switches the synchronizer to the planning mode: the actions are added
+// to the plan instead of being performed. The metadata is still updated to plan
+// the next steps, so in this mode the synchronizer must work with a copy of the database
+func (s *Synchronizer) SetPlan(plan *Plan) {
+	s.plan = plan
+}
+
+// SetSide makes the synchronizer change just the files on the side: the local ones
+// to pull the remote changes or the remote ones to push the local changes.
+// The actions on the other side and the conflicts are left for the synchronization
+// in both directions, so the database must not be cleaned up after such a synchronization
+func (s *Synchronizer) SetSide(side contracts.ActionSide) {
+	s.side = side
+}
+
+// apply performs the action or adds it to the plan in the planning mode.
+// It returns the id of the remote folder the action created or moved
+func (s *Synchronizer) apply(action contracts.Action) (string, error) {
+	if "" != s.side && (s.side != action.Side || contracts.ACTION_CONFLICT == action.Type) {
+		s.log.Debug("skipping action on the other side", action)
+		return "", nil
+	}
+	s.notifyLocalWrite(action)
+	if nil == s.plan {
+		if nil != s.transfers && rdrive.IsTransfer(action) {
+			return "", s.transfers.submit(action)
+		}
+		return s.rd.Execute(action)
+	}
+	s.plan.Add(action)
+	switch {
+	case action.IsBookkeeping():
+		return s.rd.Execute(action)
+	case contracts.ACTION_MOVE == action.Type && contracts.SIDE_REMOTE == action.Side:
+		// the moved folder must not be planned to be removed
+		return action.FileId, s.fr.SetRemovedLocally(action.FileId, false)
+	case contracts.ACTION_MKDIR == action.Type && contracts.SIDE_REMOTE == action.Side:
+		return "planned:" + action.Path, nil
+	}
+	return "", nil
 }
 
 // SyncRemoteWithLocal synchronize remote metadata saved in a local database
 // to the actual files saved locally
 func (s *Synchronizer) SyncRemoteWithLocal() error {
+	if nil != s.ignoreRules {
+		s.ignoreRules.Reload()
+	}
+	// the local files must be in place before the local changes are looked for,
+	// so all the downloads are finished here
+	return s.withTransfers(s.downloadRemoteChanges)
+}
+
+// downloadRemoteChanges goes through the remote files and synchronizes the local ones with them
+func (s *Synchronizer) downloadRemoteChanges() error {
 	var filesChan = make(contracts.FilesChan)
 
-	// fileSyncDoneChan is a channel for synchronization. Sqlite is used as a metadata storage
-	// and it does not work well with multiple threads
+	// fileSyncDoneChan is a channel for synchronization. The files are read from the database
+	// while the previous file is not synchronized yet, so that the reads and the writes take turns
 	var fileSyncDoneChan = make(contracts.SyncChan)
 	go s.traverseFiles(filesChan, fileSyncDoneChan)
 
-	var syncRemoteWithLocalErr error
 	for f := range filesChan {
-		s.log.Debug("traversing over remote files", struct {
-			path string
-			mime string
-		}{
-			path: f.CurPath,
-			mime: f.MimeType,
-		})
-		syncRemoteWithLocalErr = s.rd.SyncRemoteWithLocal(f)
+		s.log.With(contracts.Fields{
+			contracts.FieldFileId: f.Id,
+			contracts.FieldPath:   f.CurPath,
+			"mime_type":           f.MimeType,
+		}).Debug("traversing over remote files")
+		if err := s.syncRemoteWithLocal(f); err != nil {
+			// the traversal is stopped, so it does not wait for the next file to be taken
+			close(fileSyncDoneChan)
+			for range filesChan {
+			}
+			return errors.Wrap(err, "synchronization remote with local error")
+		}
 		fileSyncDoneChan <- true
-		if syncRemoteWithLocalErr != nil {
-			return errors.Wrap(syncRemoteWithLocalErr, "synchronization remote with local error")
+	}
+	return nil
+}
+
+// withTransfers runs the synchronization step with the downloads and uploads performed by
+// a pool of workers. The step is over, when all the transfers are finished
+func (s *Synchronizer) withTransfers(step func() error) error {
+	if s.transferWorkers <= 1 {
+		return step()
+	}
+	s.transfers = newTransferPool(s.rd, s.sessions, s.transferWorkers)
+	err := step()
+	if stopErr := s.transfers.stop(); nil == err {
+		err = stopErr
+	}
+	s.transfers = nil
+	return err
+}
+
+func (s *Synchronizer) syncRemoteWithLocal(f contracts.File) error {
+	actions, err := s.rd.PlanSyncRemoteWithLocal(f)
+	if err != nil {
+		return err
+	}
+	for _, action := range actions {
+		if _, err = s.apply(action); err != nil {
+			return err
 		}
 	}
 	return nil
@@ -53,36 +188,48 @@ func (s *Synchronizer) SyncRemoteWithLocal() error {
 
 // traverseFiles goes through files in hierarchical order. So, first goes the
 // root directory (My Drive), then all the children of the root, then the children of
-// the children and so on.
+// the children and so on. Then the same happens for each shared drive. The traversal
+// is stopped, when sync is closed
 func (s *Synchronizer) traverseFiles(filesChan contracts.FilesChan, sync contracts.SyncChan) {
-	root, err := s.fr.GetRootFolder()
+	defer close(filesChan)
+	roots, err := s.fr.GetRootFolders()
 	if nil != err {
-		s.log.Error("Error getting root folder.", err)
-		close(filesChan)
+		s.log.Error("Error getting root folders.", err)
 		return
 	}
-	root.PrevPath = root.PrevRemoteName
-	root.CurPath = root.CurRemoteName
-	filesChan <- root
-	<-sync
+	for _, root := range roots {
+		filesChan <- root
+		if _, ok := <-sync; !ok {
+			return
+		}
 
-	if err = s.getFilesByParentRecursively(root.Id, filesChan, sync); err != nil {
-		s.log.Error("Error getting files by parent", err)
-		close(filesChan)
-		return
+		err = s.getFilesByParentRecursively(root.Id, filesChan, sync)
+		if errTraversalStopped == err {
+			return
+		} else if err != nil {
+			s.log.Error("Error getting files by parent", err)
+			return
+		}
 	}
-	close(filesChan)
 }
 
+// errTraversalStopped is returned by getFilesByParentRecursively, when sync is closed
+var errTraversalStopped = errors.New("traversal stopped")
+
 func (s *Synchronizer) getFilesByParentRecursively(parentId string, filesChan contracts.FilesChan, sync contracts.SyncChan) error {
-	filesList, err := s.fr.GetCurFilesListByParent(parentId)
+	filesList, err := s.getNotIgnoredFilesByParent(parentId)
 	if err != nil {
-		return errors.Wrapf(err, "could not get files list for %s", parentId)
+		return err
 	}
 	for _, f := range filesList {
 		filesChan <- f
-		<-sync
+		if _, ok := <-sync; !ok {
+			return errTraversalStopped
+		}
 		if err := s.getFilesByParentRecursively(f.Id, filesChan, sync); err != nil {
+			if errTraversalStopped == err {
+				return err
+			}
 			return errors.Wrap(err, "could not get files by parent")
 		}
 	}
@@ -90,31 +237,81 @@ func (s *Synchronizer) getFilesByParentRecursively(parentId string, filesChan co
 	return nil
 }
 
+// getNotIgnoredFilesByParent gets the children of the folder, except the ignored ones, the ones
+// out of the scope and the symbolic links, if they are not stored. The children of an ignored
+// folder are never asked for, so they are ignored as well
+func (s *Synchronizer) getNotIgnoredFilesByParent(parentId string) ([]contracts.File, error) {
+	filesList, err := s.fr.GetCurFilesListByParent(parentId)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not get files list for %s", parentId)
+	}
+	notIgnored := filesList[:0]
+	for _, f := range filesList {
+		if s.rd.IsSymlinkSkipped(f) {
+			s.log.Debug("skipping remote symbolic link", f.CurPath)
+			continue
+		}
+		isFolder := specification.IsFolder(f)
+		if !s.inScope(s.rd.GetLocalPath(f), isFolder) && !s.inScope(s.rd.GetPrevLocalPath(f), isFolder) {
+			continue
+		}
+		ignored, err := s.IsIgnored(s.rd.GetLocalPath(f), isFolder)
+		if err != nil {
+			return nil, err
+		}
+		if ignored {
+			s.log.Debug("ignoring remote file", f.CurPath)
+			continue
+		}
+		notIgnored = append(notIgnored, f)
+	}
+	return notIgnored, nil
+}
+
 // removeLocallyRemoved goes through locally removed files in hierarchical order. So, first goes the
 // root directory (My Drive), then all the children of the root, then the children of
 // the children and so on. Removes just locally removed parents.
 func (s *Synchronizer) RemoveLocallyRemoved() error {
-	root, err := s.fr.GetRootFolder()
-	filesChan := make(contracts.FilesChan)
-	sync := make(contracts.SyncChan)
-
+	roots, err := s.fr.GetRootFolders()
 	if nil != err {
-		close(filesChan)
-		return errors.Wrap(err, "error getting root folder")
+		return errors.Wrap(err, "error getting root folders")
 	}
-	go func(fChan contracts.FilesChan, s contracts.SyncChan, rd rdrive.Drive, l contracts.Logger) {
+	for _, root := range roots {
+		if err = s.removeLocallyRemoved(root); nil != err {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Synchronizer) removeLocallyRemoved(root contracts.File) error {
+	filesChan := make(contracts.FilesChan)
+	sync := make(contracts.SyncChan)
+	var deleteErr error
+	go func(fChan contracts.FilesChan, syncChan contracts.SyncChan, l contracts.Logger) {
 		for f := range fChan {
-			l.Info("removing remotely", f)
-			if err := rd.Delete(f); err != nil {
-				l.Error("error removing remotely", err)
-				os.Exit(1) //todo: do it more gracefully
+			_, deleteErr = s.apply(contracts.Action{
+				Type:   contracts.ACTION_DELETE_REMOTE,
+				Side:   contracts.SIDE_REMOTE,
+				Path:   s.rd.GetLocalPath(f),
+				FileId: f.Id,
+				Reason: "local file was deleted",
+				File:   f,
+			})
+			if deleteErr != nil {
+				l.Error("error removing remotely", deleteErr)
+				close(syncChan)
+				return
 			}
-			s <- true
+			syncChan <- true
 		}
-	}(filesChan, sync, s.rd, s.log)
+	}(filesChan, sync, s.log)
 
-	if err = s.getLocallyRemovedFilesByParentRecursively(root.Id, filesChan, sync); err != nil {
+	if err := s.getLocallyRemovedFilesByParentRecursively(root.Id, filesChan, sync); err != nil {
 		close(filesChan)
+		if nil != deleteErr {
+			return errors.Wrap(deleteErr, "error removing remotely")
+		}
 		return errors.Wrap(err, "error getting files by parent")
 	}
 	close(filesChan)
@@ -125,15 +322,17 @@ func (s *Synchronizer) RemoveLocallyRemoved() error {
 // but not removes remotely to look for moved files, folders later, eventually they need to be deleted if
 // they were not moved to somewhere else
 func (s *Synchronizer) getLocallyRemovedFilesByParentRecursively(parentId string, filesChan contracts.FilesChan, sync contracts.SyncChan) error {
-	filesList, err := s.fr.GetCurFilesListByParent(parentId)
+	filesList, err := s.getNotIgnoredFilesByParent(parentId)
 	if err != nil {
-		return errors.Wrapf(err, "could not get files list for %s", parentId)
+		return err
 	}
 	for _, f := range filesList {
 		// we don't remove children of removed because we do not need to
 		if f.RemovedLocally == 1 {
 			filesChan <- f
-			<-sync
+			if _, ok := <-sync; !ok {
+				return errors.New("removing files stopped")
+			}
 		} else if err := s.getLocallyRemovedFilesByParentRecursively(f.Id, filesChan, sync); err != nil {
 			return errors.Wrap(err, "could not get files by parent")
 		}
@@ -144,7 +343,7 @@ func (s *Synchronizer) getLocallyRemovedFilesByParentRecursively(parentId string
 
 // AreFoldersTheSame compares two folders if they have the same structure, files and
 // hashes of the files
-func (s *Synchronizer) AreFoldersTheSame(fullFolderPath string, remoteFolderId string) (bool, error) {
+func (s *Synchronizer) AreFoldersTheSame(drivePath string, fullFolderPath string, remoteFolderId string) (bool, error) {
 	var dbFilesChan = make(contracts.FilesChan)
 	var localFilesChan = make(chan contracts.ExtendedFileInfo)
 	var syncChan = make(contracts.SyncChan)
@@ -157,12 +356,28 @@ func (s *Synchronizer) AreFoldersTheSame(fullFolderPath string, remoteFolderId s
 		close(dbFilesChan)
 	}()
 	go func() {
-		err := filepath.Walk(
+		err := walk.Walk(
 			fullFolderPath,
+			drivePath,
+			s.symlinks,
+			s.log,
 			func(path string, info os.FileInfo, err error) error {
-				if path != fullFolderPath {
-					localFilesChan <- contracts.ExtendedFileInfo{FileInfo: info, FullPath: path}
+				if path == fullFolderPath || nil != err {
+					return nil
+				}
+				relativeFilePath, err := relativePath(drivePath, path)
+				if nil != err {
+					return err
+				}
+				if ignored, ignoreErr := s.IsIgnored(relativeFilePath, info.IsDir()); nil != ignoreErr {
+					return ignoreErr
+				} else if ignored {
+					if info.IsDir() {
+						return filepath.SkipDir
+					}
+					return nil
 				}
+				localFilesChan <- contracts.ExtendedFileInfo{FileInfo: info, FullPath: path}
 				return nil
 			},
 		)
@@ -185,7 +400,7 @@ func (s *Synchronizer) AreFoldersTheSame(fullFolderPath string, remoteFolderId s
 			if !dbChanOpened || !localChanOpened {
 				break
 			}
-			if dbFile.CurRemoteName != localFile.FileInfo.Name() {
+			if s.rd.GetLocalName(dbFile) != localFile.FileInfo.Name() {
 				isDirTheSame = false
 				break
 			}
@@ -193,11 +408,11 @@ func (s *Synchronizer) AreFoldersTheSame(fullFolderPath string, remoteFolderId s
 				isDirTheSame = false
 				break
 			}
-			if localFile.FileInfo.IsDir() || specification.IsFolder(dbFile) {
+			if localFile.FileInfo.IsDir() || specification.IsFolder(dbFile) || s.rd.IsExported(dbFile) {
 				continue
 			}
 			var hash string
-			hash, err = lfileHash.CalcCachedHash(localFile.FullPath)
+			hash, err = s.rd.CalcHash(localFile.FullPath, 1 == dbFile.Encrypted)
 			if nil != err {
 				isDirTheSame = false
 				err = errors.Wrap(err, "hash calculation error while comparing folders")
@@ -0,0 +1,53 @@
+package synchronization
+
+import (
+	"github.com/svetlyi/gdriveapp/contracts"
+	"path/filepath"
+	"strings"
+)
+
+// SetScope limits the synchronization to the files and folders with the paths (relative
+// to the drive path, like "My Drive/docs") and to everything inside them. The folders on
+// the way to them are gone through as well. Without paths everything is synchronized
+func (s *Synchronizer) SetScope(paths []string) {
+	s.scope = s.scope[:0]
+	for _, path := range paths {
+		s.scope = append(s.scope, filepath.Clean(path))
+	}
+}
+
+// inScope says if the file or folder with the path relative to the drive path is synchronized
+func (s *Synchronizer) inScope(relativePath string, isDir bool) bool {
+	if 0 == len(s.scope) {
+		return true
+	}
+	relativePath = filepath.Clean(relativePath)
+	for _, scopePath := range s.scope {
+		if isInside(relativePath, scopePath) || (isDir && isInside(scopePath, relativePath)) {
+			return true
+		}
+	}
+	return false
+}
+
+// isInside says if the path is the folder itself or is inside it
+func isInside(path string, folder string) bool {
+	return path == folder || strings.HasPrefix(path, folder+string(filepath.Separator))
+}
+
+// SetOnLocalWrite sets the function, that is called with the paths (relative to the drive path)
+// of the local files and folders before the synchronizer changes them
+func (s *Synchronizer) SetOnLocalWrite(fn func(relativePath string)) {
+	s.onLocalWrite = fn
+}
+
+// notifyLocalWrite calls the function set by SetOnLocalWrite for the paths the action changes locally
+func (s *Synchronizer) notifyLocalWrite(action contracts.Action) {
+	if nil == s.onLocalWrite || nil != s.plan || contracts.SIDE_LOCAL != action.Side || action.IsBookkeeping() {
+		return
+	}
+	s.onLocalWrite(action.Path)
+	if "" != action.PrevPath && action.PrevPath != action.Path {
+		s.onLocalWrite(action.PrevPath)
+	}
+}
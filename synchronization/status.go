@@ -0,0 +1,91 @@
+package synchronization
+
+import (
+	"fmt"
+	"github.com/svetlyi/gdriveapp/contracts"
+	"io"
+)
+
+// Status is the plan of a synchronization grouped by the changes the actions come from
+type Status struct {
+	NewLocal      []contracts.Action
+	ModifiedLocal []contracts.Action
+	DeletedLocal  []contracts.Action
+	RemoteUpdated []contracts.Action
+	RemoteMoved   []contracts.Action
+	RemoteDeleted []contracts.Action
+	Conflicts     []contracts.Action
+}
+
+// NewStatus groups the actions of the plan
+func NewStatus(plan Plan) Status {
+	var st Status
+	for _, action := range plan.Actions {
+		switch {
+		case contracts.ACTION_CONFLICT == action.Type:
+			st.Conflicts = append(st.Conflicts, action)
+		case contracts.FILE_MOVED == action.RemoteChange:
+			st.RemoteMoved = append(st.RemoteMoved, action)
+		case contracts.FILE_DELETED == action.RemoteChange:
+			st.RemoteDeleted = append(st.RemoteDeleted, action)
+		case contracts.ACTION_DELETE_REMOTE == action.Type || contracts.FILE_DELETED == action.LocalChange:
+			st.DeletedLocal = append(st.DeletedLocal, action)
+		case contracts.SIDE_LOCAL == action.Side:
+			// the new remote files are downloaded as well as the updated ones
+			st.RemoteUpdated = append(st.RemoteUpdated, action)
+		case "" == action.FileId || contracts.ACTION_MOVE == action.Type:
+			// a folder moved locally is new in its new place
+			st.NewLocal = append(st.NewLocal, action)
+		default:
+			st.ModifiedLocal = append(st.ModifiedLocal, action)
+		}
+	}
+	return st
+}
+
+// IsEmpty says if there is nothing to synchronize
+func (st Status) IsEmpty() bool {
+	return 0 == len(st.NewLocal)+len(st.ModifiedLocal)+len(st.DeletedLocal)+
+		len(st.RemoteUpdated)+len(st.RemoteMoved)+len(st.RemoteDeleted)+len(st.Conflicts)
+}
+
+// Print prints the paths of the files of the non-empty groups
+func (st Status) Print(w io.Writer) error {
+	if st.IsEmpty() {
+		_, err := fmt.Fprintln(w, "nothing to synchronize")
+		return err
+	}
+	groups := []struct {
+		name    string
+		actions []contracts.Action
+	}{
+		{"new local", st.NewLocal},
+		{"modified local", st.ModifiedLocal},
+		{"deleted local", st.DeletedLocal},
+		{"remote updated", st.RemoteUpdated},
+		{"remote moved", st.RemoteMoved},
+		{"remote deleted", st.RemoteDeleted},
+		{"conflicts", st.Conflicts},
+	}
+	for _, group := range groups {
+		if 0 == len(group.actions) {
+			continue
+		}
+		if _, err := fmt.Fprintf(w, "%s (%d):\n", group.name, len(group.actions)); err != nil {
+			return err
+		}
+		for _, action := range group.actions {
+			path := action.Path
+			if action.PrevPath != "" && action.PrevPath != action.Path {
+				path = fmt.Sprintf("%s -> %s", action.PrevPath, action.Path)
+			}
+			if contracts.ACTION_CONFLICT == action.Type {
+				path = fmt.Sprintf("%s (%s)", path, action.Reason)
+			}
+			if _, err := fmt.Fprintf(w, "  %s\n", path); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
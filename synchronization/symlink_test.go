@@ -0,0 +1,120 @@
+//go:build !windows
+// +build !windows
+
+package synchronization_test
+
+import (
+	"github.com/svetlyi/gdriveapp/config"
+	"golang.org/x/sys/unix"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func symlink(t *testing.T, target string, path string) {
+	if err := os.Symlink(target, path); nil != err {
+		t.Fatal(err)
+	}
+}
+
+func assertSymlink(t *testing.T, path string, expected string) {
+	if target, err := os.Readlink(path); nil != err {
+		t.Errorf("%s must be a symbolic link: %v", path, err)
+	} else if expected != target {
+		t.Errorf("%s: expected a link to %q, got %q", path, expected, target)
+	}
+}
+
+func TestSymlinks(t *testing.T) {
+	ts := newTestSynchronizer(t, testOptions{})
+	remote := ts.remote
+	var err error
+	// the computers synchronize the same drive with different policies
+	newSymlinksConfig := func(name string, symlinks string) (config.Cfg, string) {
+		return ts.computer(name, func(cfg *config.Cfg) {
+			cfg.Symlinks = symlinks
+		})
+	}
+	storing, storingLocal := newSymlinksConfig("storing", config.SymlinksStore)
+	copying, copyingLocal := newSymlinksConfig("copying", config.SymlinksStore)
+	skipping, skippingLocal := newSymlinksConfig("skipping", config.SymlinksSkip)
+	following, followingLocal := newSymlinksConfig("following", config.SymlinksFollow)
+	followingAll, followingAllLocal := newSymlinksConfig("following-all", config.SymlinksFollowAll)
+
+	ts.syncOnce(storing)
+	writeFile(t, filepath.Join(storingLocal, "docs", "report.txt"), "report")
+	symlink(t, filepath.Join("docs", "report.txt"), filepath.Join(storingLocal, "report-link.txt"))
+	symlink(t, "docs", filepath.Join(storingLocal, "docs-link"))
+	symlink(t, "..", filepath.Join(storingLocal, "docs", "loop"))
+	symlink(t, filepath.Join("..", ".."), filepath.Join(storingLocal, "escaping"))
+	symlink(t, ts.tmp, filepath.Join(storingLocal, "docs", "absolute"))
+	ts.syncOnce(storing)
+	ts.syncOnce(storing)
+	assertContent(t, filepath.Join(remote, "docs", "report.txt"), "report")
+	assertContent(t, filepath.Join(remote, "report-link.txt"), filepath.Join("docs", "report.txt"))
+	assertContent(t, filepath.Join(remote, "docs-link"), "docs")
+	assertContent(t, filepath.Join(remote, "docs", "loop"), "..")
+
+	ts.syncOnce(copying)
+	assertSymlink(t, filepath.Join(copyingLocal, "report-link.txt"), filepath.Join("docs", "report.txt"))
+	assertSymlink(t, filepath.Join(copyingLocal, "docs-link"), "docs")
+	assertSymlink(t, filepath.Join(copyingLocal, "docs", "loop"), "..")
+	assertContent(t, filepath.Join(copyingLocal, "docs-link", "report.txt"), "report")
+	// the links pointing outside the drive path are not created, so nothing is written through them
+	for _, path := range []string{"escaping", filepath.Join("docs", "absolute")} {
+		if _, err = os.Lstat(filepath.Join(copyingLocal, path)); !os.IsNotExist(err) {
+			t.Errorf("%s must not be created", path)
+		}
+	}
+
+	// the links are neither downloaded nor uploaded, a link to a folder is not a file
+	ts.syncOnce(skipping)
+	symlink(t, "docs", filepath.Join(skippingLocal, "skipped-link"))
+	ts.syncOnce(skipping)
+	assertContent(t, filepath.Join(skippingLocal, "docs", "report.txt"), "report")
+	for _, path := range []string{"report-link.txt", "docs-link", filepath.Join("docs", "loop")} {
+		if _, err = os.Lstat(filepath.Join(skippingLocal, path)); !os.IsNotExist(err) {
+			t.Errorf("%s must not be downloaded", path)
+		}
+	}
+	assertNotExist(t, filepath.Join(remote, "skipped-link"))
+
+	// the changed link is found by its own modification time
+	changedLink := filepath.Join(storingLocal, "report-link.txt")
+	writeFile(t, filepath.Join(storingLocal, "docs", "other.txt"), "other")
+	if err = os.Remove(changedLink); nil != err {
+		t.Fatal(err)
+	}
+	symlink(t, filepath.Join("docs", "other.txt"), changedLink)
+	later := unix.NsecToTimeval(time.Now().Add(time.Minute).UnixNano())
+	if err = unix.Lutimes(changedLink, []unix.Timeval{later, later}); nil != err {
+		t.Fatal(err)
+	}
+	ts.syncOnce(storing)
+	assertContent(t, filepath.Join(remote, "report-link.txt"), filepath.Join("docs", "other.txt"))
+	ts.syncOnce(copying)
+	assertSymlink(t, filepath.Join(copyingLocal, "report-link.txt"), filepath.Join("docs", "other.txt"))
+	assertContent(t, filepath.Join(copyingLocal, "report-link.txt"), "other")
+
+	// the files outside the drive path are followed, the folders are not
+	outside := filepath.Join(ts.tmp, "outside")
+	writeFile(t, filepath.Join(outside, "a.txt"), "a")
+	symlink(t, outside, filepath.Join(outside, "loop"))
+	ts.syncOnce(following)
+	symlink(t, outside, filepath.Join(followingLocal, "outside-link"))
+	symlink(t, filepath.Join(outside, "a.txt"), filepath.Join(followingLocal, "outside-file-link.txt"))
+	ts.syncOnce(following)
+	ts.syncOnce(following)
+	assertContent(t, filepath.Join(remote, "outside-file-link.txt"), "a")
+	assertNotExist(t, filepath.Join(remote, "outside-link"))
+	assertNotExist(t, filepath.Join(followingLocal, "docs-link"))
+
+	// the followed folder is uploaded as a regular one, the link inside it to itself is skipped
+	ts.syncOnce(followingAll)
+	symlink(t, outside, filepath.Join(followingAllLocal, "outside-all-link"))
+	ts.syncOnce(followingAll)
+	ts.syncOnce(followingAll)
+	assertContent(t, filepath.Join(remote, "outside-all-link", "a.txt"), "a")
+	assertNotExist(t, filepath.Join(remote, "outside-all-link", "loop"))
+}
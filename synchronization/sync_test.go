@@ -0,0 +1,573 @@
+package synchronization_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"database/sql"
+	"fmt"
+	"github.com/svetlyi/gdriveapp/app"
+	"github.com/svetlyi/gdriveapp/config"
+	"github.com/svetlyi/gdriveapp/contracts"
+	"github.com/svetlyi/gdriveapp/ldrive/ignore"
+	"github.com/svetlyi/gdriveapp/ldrive/recycle"
+	"github.com/svetlyi/gdriveapp/logger"
+	"github.com/svetlyi/gdriveapp/rdrive"
+	"github.com/svetlyi/gdriveapp/rdrive/crypt"
+	"github.com/svetlyi/gdriveapp/rdrive/db"
+	"github.com/svetlyi/gdriveapp/rdrive/db/file"
+	"github.com/svetlyi/gdriveapp/rdrive/fakedrive"
+	"github.com/svetlyi/gdriveapp/rdrive/localdir"
+	"github.com/svetlyi/gdriveapp/runner"
+	"github.com/svetlyi/gdriveapp/synchronization"
+	"google.golang.org/api/drive/v3"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testOptions are what a test changes in the synchronization, the rest is the same in all the tests
+type testOptions struct {
+	// google synchronizes with the fake Google Drive instead of a local directory
+	google bool
+	// configure changes the configuration of the computer
+	configure func(cfg *config.Cfg)
+}
+
+// testSynchronizer synchronizes a local folder with the remote drive of a test with the runner,
+// as the commands do. The computers made by computer synchronize the same drive. Each computer
+// is a profile in the configuration folder of the test, the global ignore patterns and the
+// database are in the profile folder
+type testSynchronizer struct {
+	t   *testing.T
+	tmp string
+	// cfg is the configuration of the first computer and local is its "My Drive"
+	cfg   config.Cfg
+	local string
+	// remote is the directory of the local-dir backend. server is the fake Google Drive
+	remote string
+	server *fakedrive.Server
+	srv    *drive.Service
+}
+
+func newTestSynchronizer(t *testing.T, opts testOptions) *testSynchronizer {
+	tmp, err := ioutil.TempDir("", "gdriveapp-sync-")
+	if nil != err {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(tmp) })
+	xdgConfigHome := os.Getenv("XDG_CONFIG_HOME")
+	os.Setenv("XDG_CONFIG_HOME", filepath.Join(tmp, "config"))
+	t.Cleanup(func() {
+		os.Setenv("XDG_CONFIG_HOME", xdgConfigHome)
+		config.SetProfile(config.DefaultProfile)
+	})
+	ts := &testSynchronizer{t: t, tmp: tmp, remote: filepath.Join(tmp, "remote")}
+	if opts.google {
+		ts.server = fakedrive.New()
+		t.Cleanup(ts.server.Close)
+		if ts.srv, err = ts.server.Service(context.Background()); nil != err {
+			t.Fatal(err)
+		}
+	} else if err = os.Mkdir(ts.remote, 0755); nil != err {
+		t.Fatal(err)
+	}
+	ts.cfg, ts.local = ts.computer("first", opts.configure)
+	return ts
+}
+
+// computer makes the configuration of one more computer, that synchronizes the drive of the test
+// to the folder with the name, and returns it with the computer's "My Drive"
+func (ts *testSynchronizer) computer(name string, configure func(cfg *config.Cfg)) (config.Cfg, string) {
+	cfgDir := ts.profileDir(name)
+	cfg := config.Cfg{
+		DBPath:           filepath.Join(cfgDir, "sync.db"),
+		PageSizeToQuery:  2,
+		DrivePath:        filepath.Join(ts.tmp, name, "local") + string(os.PathSeparator),
+		ConflictPolicy:   config.ConflictKeepBoth,
+		TransferWorkers:  2,
+		Backend:          config.BackendLocalDir,
+		BackendDir:       ts.remote,
+		RetryMaxAttempts: 3,
+		Symlinks:         config.SymlinksSkip,
+	}
+	if nil != ts.server {
+		cfg.Backend = config.BackendGoogle
+	}
+	if nil != configure {
+		configure(&cfg)
+	}
+	for _, dir := range []string{cfgDir, cfg.DrivePath} {
+		if err := os.MkdirAll(dir, 0755); nil != err {
+			ts.t.Fatal(err)
+		}
+	}
+	return cfg, filepath.Join(cfg.DrivePath, localdir.RootFolderName)
+}
+
+// profileDir returns the configuration folder of the computer
+func (ts *testSynchronizer) profileDir(name string) string {
+	if err := config.SetProfile(name); nil != err {
+		ts.t.Fatal(err)
+	}
+	dir, err := config.GetDir()
+	if nil != err {
+		ts.t.Fatal(err)
+	}
+	return dir
+}
+
+func (ts *testSynchronizer) newLogger() contracts.Logger {
+	log, err := logger.New("gdriveapp_test", 1e7, uint8(contracts.LogErrorLevel), false)
+	if nil != err {
+		ts.t.Fatal(err)
+	}
+	return log
+}
+
+// runner creates the runner of the computer with the configuration. The caller closes it
+func (ts *testSynchronizer) runner(cfg config.Cfg) *runner.Runner {
+	ts.profileDir(filepath.Base(filepath.Dir(cfg.DBPath)))
+	r := runner.New(cfg, ts.newLogger())
+	if nil != ts.server {
+		r.SetService(ts.srv, ts.server.Client())
+	}
+	return r
+}
+
+// open opens the database, the local-dir backend and the synchronizer of the computer
+// for the tests of the synchronizer itself
+func (ts *testSynchronizer) open(cfg config.Cfg) (*sql.DB, file.Repository, *synchronization.Synchronizer) {
+	t := ts.t
+	log := ts.newLogger()
+	dbInstance := db.New(cfg.DBPath, log)
+	backend, err := localdir.New(ts.remote)
+	if nil != err {
+		dbInstance.Close()
+		t.Fatal(err)
+	}
+	ignoreRules, err := ignore.New(cfg.DrivePath, filepath.Join(filepath.Dir(cfg.DBPath), ignore.FileName))
+	if nil != err {
+		dbInstance.Close()
+		t.Fatal(err)
+	}
+	repository := file.NewRepository(dbInstance, log)
+	rd := rdrive.New(backend, repository, log, app.New(dbInstance, log), cfg, nil)
+	s := synchronization.New(repository, log, dbInstance, rd, ignoreRules, int(cfg.TransferWorkers), cfg.Symlinks)
+	return dbInstance, repository, &s
+}
+
+// syncOnce runs a two-way synchronization of the computer
+func (ts *testSynchronizer) syncOnce(cfg config.Cfg) {
+	ts.syncSide(cfg, "")
+}
+
+// syncSide runs a synchronization of the computer, that changes just the files on the side
+// (or on both sides, if it is empty), as the sync, pull and push commands do
+func (ts *testSynchronizer) syncSide(cfg config.Cfg, side contracts.ActionSide) {
+	r := ts.runner(cfg)
+	defer r.Close()
+	if err := r.Sync(runner.SyncOptions{Side: side}); nil != err {
+		ts.t.Fatal("Sync", err)
+	}
+}
+
+// restoreFromTrash restores the file as the "trash restore" command does
+func (ts *testSynchronizer) restoreFromTrash(cfg config.Cfg, pathOrId string) {
+	r := ts.runner(cfg)
+	defer r.Close()
+	if err := r.RestoreFromTrash(pathOrId); nil != err {
+		ts.t.Fatal("RestoreFromTrash", err)
+	}
+}
+
+func writeFile(t *testing.T, path string, content string) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); nil != err {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte(content), 0644); nil != err {
+		t.Fatal(err)
+	}
+}
+
+func assertContent(t *testing.T, path string, expected string) {
+	content, err := ioutil.ReadFile(path)
+	if nil != err {
+		t.Errorf("could not read %s: %v", path, err)
+	} else if expected != string(content) {
+		t.Errorf("%s: expected %q, got %q", path, expected, content)
+	}
+}
+
+func assertNotExist(t *testing.T, path string) {
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("%s must not exist", path)
+	}
+}
+
+// setLocalModTime moves the modification time of the file forward, as the local changes
+// are found by the modification time, which has a one second precision
+func setLocalModTime(t *testing.T, path string) {
+	if err := os.Chtimes(path, time.Now(), time.Now().Add(time.Minute)); nil != err {
+		t.Fatal(err)
+	}
+}
+
+func TestTwoWaySync(t *testing.T) {
+	ts := newTestSynchronizer(t, testOptions{})
+	cfg, local, remote := ts.cfg, ts.local, ts.remote
+	var err error
+
+	writeFile(t, filepath.Join(remote, "docs", "remote.txt"), "from remote")
+	ts.syncOnce(cfg)
+	assertContent(t, filepath.Join(local, "docs", "remote.txt"), "from remote")
+
+	writeFile(t, filepath.Join(local, "docs", "local.txt"), "from local")
+	writeFile(t, filepath.Join(local, "new", "nested.txt"), "nested")
+	ts.syncOnce(cfg)
+	assertContent(t, filepath.Join(remote, "docs", "local.txt"), "from local")
+	assertContent(t, filepath.Join(remote, "new", "nested.txt"), "nested")
+
+	writeFile(t, filepath.Join(remote, "docs", "remote.txt"), "changed remotely")
+	if err = os.Remove(filepath.Join(local, "docs", "local.txt")); nil != err {
+		t.Fatal(err)
+	}
+	ts.syncOnce(cfg)
+	assertContent(t, filepath.Join(local, "docs", "remote.txt"), "changed remotely")
+	assertNotExist(t, filepath.Join(remote, "docs", "local.txt"))
+
+	changedLocally := filepath.Join(local, "docs", "remote.txt")
+	writeFile(t, changedLocally, "changed locally")
+	setLocalModTime(t, changedLocally)
+	ts.syncOnce(cfg)
+	assertContent(t, filepath.Join(remote, "docs", "remote.txt"), "changed locally")
+
+	if err = os.RemoveAll(filepath.Join(remote, "new")); nil != err {
+		t.Fatal(err)
+	}
+	ts.syncOnce(cfg)
+	assertNotExist(t, filepath.Join(local, "new"))
+	recycled := filepath.Join(cfg.DrivePath, recycle.DirName, time.Now().Format("2006-01-02"), localdir.RootFolderName)
+	assertContent(t, filepath.Join(recycled, "new", "nested.txt"), "nested")
+
+	if err = os.RemoveAll(filepath.Join(local, "docs")); nil != err {
+		t.Fatal(err)
+	}
+	ts.syncOnce(cfg)
+	assertNotExist(t, filepath.Join(remote, "docs"))
+	ts.restoreFromTrash(cfg, filepath.Join(localdir.RootFolderName, "docs"))
+	ts.syncOnce(cfg)
+	assertContent(t, filepath.Join(remote, "docs", "remote.txt"), "changed locally")
+	assertContent(t, filepath.Join(local, "docs", "remote.txt"), "changed locally")
+}
+
+func TestPullAndPush(t *testing.T) {
+	ts := newTestSynchronizer(t, testOptions{})
+	cfg, local, remote := ts.cfg, ts.local, ts.remote
+	var err error
+
+	writeFile(t, filepath.Join(remote, "docs", "remote.txt"), "from remote")
+	writeFile(t, filepath.Join(remote, "docs", "deleted.txt"), "deleted remotely")
+	ts.syncOnce(cfg)
+
+	writeFile(t, filepath.Join(local, "docs", "local.txt"), "from local")
+	writeFile(t, filepath.Join(remote, "docs", "pulled.txt"), "pulled")
+	if err = os.Remove(filepath.Join(remote, "docs", "deleted.txt")); nil != err {
+		t.Fatal(err)
+	}
+	ts.syncSide(cfg, contracts.SIDE_LOCAL)
+	assertContent(t, filepath.Join(local, "docs", "pulled.txt"), "pulled")
+	assertNotExist(t, filepath.Join(local, "docs", "deleted.txt"))
+	assertNotExist(t, filepath.Join(remote, "docs", "local.txt"))
+
+	// the file moved remotely is not uploaded again from its old place
+	backend, err := localdir.New(remote)
+	if nil != err {
+		t.Fatal(err)
+	}
+	dbInstance, repository, _ := ts.open(cfg)
+	root, err := repository.GetRootFolder("")
+	if nil != err {
+		t.Fatal(err)
+	}
+	fileId, err := repository.GetFileIdByCurPath(filepath.Join(localdir.RootFolderName, "docs", "remote.txt"), root)
+	dbInstance.Close()
+	if nil != err {
+		t.Fatal(err)
+	}
+	remoteFile, err := backend.Get(fileId)
+	if nil != err {
+		t.Fatal(err)
+	}
+	if _, err = backend.Update(fileId, "moved.txt", root.Id, remoteFile.Parents[0]); nil != err {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(remote, "pushed-later.txt"), "not pulled by push")
+	ts.syncSide(cfg, contracts.SIDE_REMOTE)
+	assertContent(t, filepath.Join(remote, "docs", "local.txt"), "from local")
+	assertNotExist(t, filepath.Join(remote, "docs", "remote.txt"))
+	assertNotExist(t, filepath.Join(local, "pushed-later.txt"))
+	assertContent(t, filepath.Join(local, "docs", "remote.txt"), "from remote")
+
+	ts.syncOnce(cfg)
+	assertContent(t, filepath.Join(local, "moved.txt"), "from remote")
+	assertNotExist(t, filepath.Join(local, "docs", "remote.txt"))
+	assertContent(t, filepath.Join(local, "pushed-later.txt"), "not pulled by push")
+}
+
+func TestIgnoredFiles(t *testing.T) {
+	// the anchored patterns are matched from the root folder, even if the drive path
+	// does not end with the separator
+	ts := newTestSynchronizer(t, testOptions{configure: func(cfg *config.Cfg) {
+		cfg.DrivePath = strings.TrimSuffix(cfg.DrivePath, string(os.PathSeparator))
+	}})
+	cfg, local, remote := ts.cfg, ts.local, ts.remote
+
+	writeFile(t, filepath.Join(filepath.Dir(cfg.DBPath), ignore.FileName), "/cache\n")
+	writeFile(t, filepath.Join(local, ignore.FileName), "/build\nlogs/\n")
+	writeFile(t, filepath.Join(local, "build", "app"), "app")
+	writeFile(t, filepath.Join(local, "cache", "data"), "data")
+	writeFile(t, filepath.Join(local, "logs", "today.log"), "log")
+	writeFile(t, filepath.Join(local, "src", "build", "main.go"), "main")
+	writeFile(t, filepath.Join(local, "src", "logs"), "not a folder")
+	ts.syncOnce(cfg)
+	assertNotExist(t, filepath.Join(remote, "build"))
+	assertNotExist(t, filepath.Join(remote, "cache"))
+	assertNotExist(t, filepath.Join(remote, "logs"))
+	assertContent(t, filepath.Join(remote, "src", "build", "main.go"), "main")
+	assertContent(t, filepath.Join(remote, "src", "logs"), "not a folder")
+}
+
+func TestScope(t *testing.T) {
+	ts := newTestSynchronizer(t, testOptions{})
+	cfg, local, remote := ts.cfg, ts.local, ts.remote
+
+	writeFile(t, filepath.Join(remote, "docs", "remote.txt"), "from remote")
+	ts.syncOnce(cfg)
+	writeFile(t, filepath.Join(local, "docs", "local.txt"), "in scope")
+	writeFile(t, filepath.Join(local, "other", "local.txt"), "out of scope")
+	writeFile(t, filepath.Join(remote, "docs", "remote.txt"), "changed remotely")
+	if err := os.Remove(filepath.Join(local, "docs", "remote.txt")); nil != err {
+		t.Fatal(err)
+	}
+
+	// just the changed paths are synchronized, the folders on the way to them are gone through
+	dbInstance, _, s := ts.open(cfg)
+	defer dbInstance.Close()
+	s.SetScope([]string{filepath.Join(localdir.RootFolderName, "docs", "local.txt")})
+	if err := s.SyncRemoteWithLocal(); nil != err {
+		t.Fatal(err)
+	}
+	if err := s.SyncLocalWithRemote(cfg.DrivePath); nil != err {
+		t.Fatal(err)
+	}
+	if err := s.RemoveLocallyRemoved(); nil != err {
+		t.Fatal(err)
+	}
+	assertContent(t, filepath.Join(remote, "docs", "local.txt"), "in scope")
+	assertNotExist(t, filepath.Join(remote, "other"))
+	assertContent(t, filepath.Join(remote, "docs", "remote.txt"), "changed remotely")
+}
+
+func TestStatus(t *testing.T) {
+	ts := newTestSynchronizer(t, testOptions{})
+	cfg, local, remote := ts.cfg, ts.local, ts.remote
+	var err error
+	for _, name := range []string{"modified.txt", "deleted.txt", "updated.txt", "removed.txt", "conflict.txt"} {
+		writeFile(t, filepath.Join(remote, name), name)
+	}
+	ts.syncOnce(cfg)
+
+	writeFile(t, filepath.Join(local, "new.txt"), "new")
+	for _, name := range []string{"modified.txt", "conflict.txt"} {
+		writeFile(t, filepath.Join(local, name), "changed locally")
+		setLocalModTime(t, filepath.Join(local, name))
+	}
+	if err = os.Remove(filepath.Join(local, "deleted.txt")); nil != err {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(remote, "updated.txt"), "changed remotely")
+	writeFile(t, filepath.Join(remote, "conflict.txt"), "changed remotely")
+	if err = os.Remove(filepath.Join(remote, "removed.txt")); nil != err {
+		t.Fatal(err)
+	}
+
+	var plan synchronization.Plan
+	r := ts.runner(cfg)
+	defer r.Close()
+	if err = r.Sync(runner.SyncOptions{Plan: &plan}); nil != err {
+		t.Fatal("Sync", err)
+	}
+
+	st := synchronization.NewStatus(plan)
+	for group, actions := range map[string][]contracts.Action{
+		"new.txt":      st.NewLocal,
+		"modified.txt": st.ModifiedLocal,
+		"deleted.txt":  st.DeletedLocal,
+		"updated.txt":  st.RemoteUpdated,
+		"removed.txt":  st.RemoteDeleted,
+		"conflict.txt": st.Conflicts,
+	} {
+		if 1 != len(actions) || filepath.Join(localdir.RootFolderName, group) != actions[0].Path {
+			t.Errorf("expected just %s in its group, got %v", group, actions)
+		}
+	}
+	assertContent(t, filepath.Join(local, "modified.txt"), "changed locally")
+	assertContent(t, filepath.Join(remote, "updated.txt"), "changed remotely")
+}
+
+func assertRemoteContent(t *testing.T, server *fakedrive.Server, path string, expected string) {
+	f, ok := server.Find(path)
+	if !ok {
+		t.Errorf("remote file %s not found", path)
+		return
+	}
+	if content, _ := server.Content(f.Id); expected != string(content) {
+		t.Errorf("remote %s: expected %q, got %q", path, expected, content)
+	}
+}
+
+func TestTwoWaySyncWithGoogleDrive(t *testing.T) {
+	ts := newTestSynchronizer(t, testOptions{google: true})
+	cfg, local, server := ts.cfg, ts.local, ts.server
+	var err error
+
+	docs := server.CreateFolder("docs", fakedrive.RootFolderId)
+	report := server.CreateFile("report.txt", docs.Id, []byte("from remote"))
+	server.CreateFile("a.txt", fakedrive.RootFolderId, []byte("a"))
+	server.CreateFile("b.txt", fakedrive.RootFolderId, []byte("b"))
+	ts.syncOnce(cfg)
+	assertContent(t, filepath.Join(local, "docs", "report.txt"), "from remote")
+	assertContent(t, filepath.Join(local, "b.txt"), "b")
+
+	writeFile(t, filepath.Join(local, "docs", "local.txt"), "from local")
+	writeFile(t, filepath.Join(local, "new", "nested.txt"), "nested")
+	ts.syncOnce(cfg)
+	assertRemoteContent(t, server, "docs/local.txt", "from local")
+	assertRemoteContent(t, server, "new/nested.txt", "nested")
+
+	server.SetContent(report.Id, []byte("changed remotely"))
+	a, _ := server.Find("a.txt")
+	server.Modify(a.Id, func(f *drive.File) { f.Trashed = true })
+	b, _ := server.Find("b.txt")
+	if err = os.Remove(filepath.Join(local, "b.txt")); nil != err {
+		t.Fatal(err)
+	}
+	ts.syncOnce(cfg)
+	assertContent(t, filepath.Join(local, "docs", "report.txt"), "changed remotely")
+	assertNotExist(t, filepath.Join(local, "a.txt"))
+	if f, ok := server.Get(b.Id); !ok || !f.Trashed {
+		t.Error("b.txt must be moved to the trash remotely")
+	}
+
+	ts.restoreFromTrash(cfg, filepath.Join(fakedrive.RootFolderName, "b.txt"))
+	ts.syncOnce(cfg)
+	assertContent(t, filepath.Join(local, "b.txt"), "b")
+
+	changedLocally := filepath.Join(local, "docs", "report.txt")
+	writeFile(t, changedLocally, "changed locally")
+	setLocalModTime(t, changedLocally)
+	ts.syncOnce(cfg)
+	assertRemoteContent(t, server, "docs/report.txt", "changed locally")
+
+	// a call failed because of the rate limit is repeated
+	writeFile(t, filepath.Join(local, "limited.txt"), "limited")
+	server.FailNext(1, http.StatusForbidden, "userRateLimitExceeded")
+	ts.syncOnce(cfg)
+	assertRemoteContent(t, server, "limited.txt", "limited")
+}
+
+func TestEncryptedFolderWithGoogleDrive(t *testing.T) {
+	// the two computers synchronize the same drive with the same key
+	encrypt := func(cfg *config.Cfg) {
+		cfg.EncryptedFolders = []string{filepath.Join(fakedrive.RootFolderName, "secret")}
+		cfg.EncryptNames = true
+		cfg.EncryptionKeyFile = filepath.Join(filepath.Dir(cfg.DBPath), "key")
+	}
+	ts := newTestSynchronizer(t, testOptions{google: true, configure: encrypt})
+	first, firstLocal, server := ts.cfg, ts.local, ts.server
+	second, secondLocal := ts.computer("second", encrypt)
+	key := strings.Repeat("k", crypt.KeySize)
+	writeFile(t, first.EncryptionKeyFile, key)
+	writeFile(t, second.EncryptionKeyFile, key)
+	cipher, err := crypt.New([]byte(key))
+	if nil != err {
+		t.Fatal(err)
+	}
+	assertEncrypted := func(path string, expected string) []byte {
+		f, ok := server.Find(path)
+		if !ok {
+			t.Errorf("remote file %s not found", path)
+			return nil
+		}
+		content, _ := server.Content(f.Id)
+		if strings.Contains(string(content), expected) {
+			t.Errorf("remote %s is not encrypted", path)
+		}
+		if fmt.Sprintf("%x", md5.Sum([]byte(expected))) == f.AppProperties[crypt.PropertyHash] {
+			t.Errorf("the hash of remote %s must be keyed", path)
+		}
+		var decrypted bytes.Buffer
+		if err := cipher.Decrypt(&decrypted, bytes.NewReader(content)); nil != err {
+			t.Errorf("could not decrypt remote %s: %v", path, err)
+		} else if expected != decrypted.String() {
+			t.Errorf("remote %s: expected %q, got %q", path, expected, decrypted.String())
+		}
+		return content
+	}
+
+	writeFile(t, filepath.Join(firstLocal, "secret", "contract.txt"), "confidential")
+	writeFile(t, filepath.Join(firstLocal, "secret", "hr", "salary.txt"), "salary")
+	writeFile(t, filepath.Join(firstLocal, "plain.txt"), "public")
+	ts.syncOnce(first)
+	assertRemoteContent(t, server, "plain.txt", "public")
+	if _, ok := server.Find("secret/contract.txt"); ok {
+		t.Error("the name of secret/contract.txt must be encrypted")
+	}
+	contract := assertEncrypted("secret/"+cipher.EncryptName("contract.txt"), "confidential")
+	assertEncrypted("secret/"+cipher.EncryptName("hr")+"/"+cipher.EncryptName("salary.txt"), "salary")
+	if encrypted, _ := ioutil.ReadDir(filepath.Join(filepath.Dir(first.DBPath), "encrypted")); 0 != len(encrypted) {
+		t.Error("the encrypted files must be removed after the upload")
+	}
+
+	// the same plain content in an encrypted folder is copied remotely
+	writeFile(t, filepath.Join(firstLocal, "secret", "copy.txt"), "confidential")
+	ts.syncOnce(first)
+	if copied := assertEncrypted("secret/"+cipher.EncryptName("copy.txt"), "confidential"); !bytes.Equal(contract, copied) {
+		t.Error("secret/copy.txt must be a remote copy of secret/contract.txt")
+	}
+
+	ts.syncOnce(second)
+	assertContent(t, filepath.Join(secondLocal, "secret", "contract.txt"), "confidential")
+	assertContent(t, filepath.Join(secondLocal, "secret", "hr", "salary.txt"), "salary")
+	assertContent(t, filepath.Join(secondLocal, "plain.txt"), "public")
+
+	changedLocally := filepath.Join(secondLocal, "secret", "contract.txt")
+	writeFile(t, changedLocally, "changed")
+	setLocalModTime(t, changedLocally)
+	ts.syncOnce(second)
+	ts.syncOnce(first)
+	contract = assertEncrypted("secret/"+cipher.EncryptName("contract.txt"), "changed")
+	assertContent(t, filepath.Join(firstLocal, "secret", "contract.txt"), "changed")
+
+	// without the database the local files are found the same as the remote ones by the keyed
+	// hashes, so they are neither uploaded again nor downloaded as conflicted copies
+	if err = os.Remove(first.DBPath); nil != err {
+		t.Fatal(err)
+	}
+	ts.syncOnce(first)
+	if !bytes.Equal(contract, assertEncrypted("secret/"+cipher.EncryptName("contract.txt"), "changed")) {
+		t.Error("secret/contract.txt must not be uploaded again")
+	}
+	infos, err := ioutil.ReadDir(filepath.Join(firstLocal, "secret"))
+	if nil != err {
+		t.Fatal(err)
+	}
+	if 3 != len(infos) {
+		t.Errorf("expected contract.txt, copy.txt and hr in the secret folder, got %d files", len(infos))
+	}
+}
@@ -0,0 +1,106 @@
+package synchronization
+
+import (
+	"github.com/svetlyi/gdriveapp/contracts"
+	"github.com/svetlyi/gdriveapp/rdrive"
+	"github.com/svetlyi/gdriveapp/rdrive/db/transfer"
+)
+
+// transferPool performs the transfers (downloads and uploads) with a fixed number
+// of concurrent workers. The workers only transfer the content, the results are saved
+// to the database by the goroutine submitting the transfers, so the database has a single writer.
+// The writes the workers cannot postpone (the upload sessions) are made by that goroutine as well
+type transferPool struct {
+	rd       rdrive.Drive
+	sessions transfer.Repository
+	jobs     chan contracts.Action
+	results  chan transferResult
+	writes   chan transferWrite
+	// pending is the amount of the submitted transfers, which results have not been saved yet
+	pending int
+}
+
+type transferResult struct {
+	commit func() error
+	err    error
+}
+
+// transferWrite is a write of a worker. The worker waits for the result in done
+type transferWrite struct {
+	write func() error
+	done  chan error
+}
+
+func newTransferPool(rd rdrive.Drive, sessions transfer.Repository, workers int) *transferPool {
+	p := &transferPool{
+		rd:       rd,
+		sessions: sessions,
+		jobs:     make(chan contracts.Action),
+		results:  make(chan transferResult),
+		writes:   make(chan transferWrite),
+	}
+	sessions.SetWriter(p.write)
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+	return p
+}
+
+// write is called by a worker. It hands the write over to the goroutine submitting the transfers
+func (p *transferPool) write(write func() error) error {
+	w := transferWrite{write: write, done: make(chan error)}
+	p.writes <- w
+	return <-w.done
+}
+
+func (p *transferPool) work() {
+	for action := range p.jobs {
+		commit, err := p.rd.Transfer(action)
+		p.results <- transferResult{commit: commit, err: err}
+	}
+}
+
+// submit hands the action over to a free worker. While all the workers are busy,
+// it saves the results of the finished transfers
+func (p *transferPool) submit(action contracts.Action) error {
+	for {
+		select {
+		case p.jobs <- action:
+			p.pending++
+			return nil
+		case result := <-p.results:
+			if err := p.save(result); nil != err {
+				return err
+			}
+		case w := <-p.writes:
+			w.done <- w.write()
+		}
+	}
+}
+
+func (p *transferPool) save(result transferResult) error {
+	p.pending--
+	if nil != result.err {
+		return result.err
+	}
+	return result.commit()
+}
+
+// stop waits for all the submitted transfers, saves their results and stops
+// the workers. It returns the first error
+func (p *transferPool) stop() error {
+	var err error
+	for p.pending > 0 {
+		select {
+		case result := <-p.results:
+			if saveErr := p.save(result); nil == err {
+				err = saveErr
+			}
+		case w := <-p.writes:
+			w.done <- w.write()
+		}
+	}
+	close(p.jobs)
+	p.sessions.SetWriter(nil)
+	return err
+}